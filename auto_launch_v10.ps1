@@ -11,7 +11,7 @@ function Start-TurboService {
 }
 
 Start-TurboService -name "CFDI (50051)" -path "C:\dev\turbopos\services\cfdi\cmd"
-Start-TurboService -name "AUTH (50052)" -path "C:\dev\turbopos\services\auth\cmd"
+Start-TurboService -name "AUTH (50052)" -path "C:\dev\turbopos\services\auth\cmd\server"
 Start-TurboService -name "BFF (8080)" -path "C:\dev\turbopos\services\bff\cmd"
 Start-TurboService -name "AUDIT-VAULT" -path "C:\dev\turbopos\services\audit\cmd"
 
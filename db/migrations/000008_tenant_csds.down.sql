@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS tenant_csds_historial;
+DROP INDEX IF EXISTS idx_tenant_csds_valido_hasta;
+ALTER TABLE tenant_csds DROP COLUMN IF EXISTS aviso_vencimiento_at;
+ALTER TABLE tenant_csds DROP COLUMN IF EXISTS valido_hasta;
+ALTER TABLE tenant_csds DROP COLUMN IF EXISTS valido_desde;
+ALTER TABLE tenant_csds DROP COLUMN IF EXISTS no_certificado;
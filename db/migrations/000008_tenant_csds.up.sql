@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS tenant_csds (
+    tenant_id UUID PRIMARY KEY,
+    rfc_emisor VARCHAR(13) NOT NULL,
+    cert_b64 TEXT NOT NULL,
+    key_bytes BYTEA NOT NULL,
+    key_password TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE tenant_csds ADD COLUMN IF NOT EXISTS no_certificado VARCHAR(20);
+ALTER TABLE tenant_csds ADD COLUMN IF NOT EXISTS valido_desde TIMESTAMPTZ;
+ALTER TABLE tenant_csds ADD COLUMN IF NOT EXISTS valido_hasta TIMESTAMPTZ;
+ALTER TABLE tenant_csds ADD COLUMN IF NOT EXISTS aviso_vencimiento_at TIMESTAMPTZ;
+
+-- Certificados reemplazados y certificados nuevos que aún no entran en vigor
+CREATE TABLE IF NOT EXISTS tenant_csds_historial (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL,
+    rfc_emisor VARCHAR(13) NOT NULL,
+    no_certificado VARCHAR(20),
+    cert_b64 TEXT NOT NULL,
+    key_bytes BYTEA NOT NULL,
+    key_password TEXT NOT NULL,
+    valido_desde TIMESTAMPTZ,
+    valido_hasta TIMESTAMPTZ,
+    estado VARCHAR(20) NOT NULL DEFAULT 'reemplazado',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_tenant_csds_historial_tenant ON tenant_csds_historial(tenant_id, estado);
+CREATE INDEX IF NOT EXISTS idx_tenant_csds_valido_hasta ON tenant_csds(valido_hasta);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_sales_cfdi_uuid;
+DROP INDEX IF EXISTS idx_sales_tenant_folio;
+ALTER TABLE IF EXISTS tenants DROP COLUMN IF EXISTS autofactura_dias;
+ALTER TABLE sales DROP COLUMN IF EXISTS cfdi_origen;
+ALTER TABLE sales DROP COLUMN IF EXISTS cfdi_timbrado_at;
+ALTER TABLE sales DROP COLUMN IF EXISTS cfdi_xml;
@@ -0,0 +1,12 @@
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS tenant_id UUID;
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS cfdi_rfc_receptor VARCHAR(13);
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS cfdi_nombre_receptor VARCHAR(255);
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS cfdi_xml TEXT;
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS cfdi_timbrado_at TIMESTAMPTZ;
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS cfdi_origen VARCHAR(20);
+
+-- Días que tiene el cliente para facturar su ticket desde el portal
+ALTER TABLE IF EXISTS tenants ADD COLUMN IF NOT EXISTS autofactura_dias INTEGER NOT NULL DEFAULT 30;
+
+CREATE INDEX IF NOT EXISTS idx_sales_tenant_folio ON sales(tenant_id, (UPPER(LEFT(id::text, 8))));
+CREATE INDEX IF NOT EXISTS idx_sales_cfdi_uuid ON sales(cfdi_uuid);
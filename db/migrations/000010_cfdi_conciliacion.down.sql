@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS cfdi_discrepancias;
+ALTER TABLE sales DROP COLUMN IF EXISTS cfdi_cancelacion_solicitada_at;
+ALTER TABLE sales DROP COLUMN IF EXISTS cfdi_verificado_at;
+ALTER TABLE sales DROP COLUMN IF EXISTS cfdi_estatus_cancelacion;
+ALTER TABLE sales DROP COLUMN IF EXISTS cfdi_estado_sat;
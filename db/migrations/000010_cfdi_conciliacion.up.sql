@@ -0,0 +1,19 @@
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS cfdi_estado_sat VARCHAR(40);
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS cfdi_estatus_cancelacion VARCHAR(60);
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS cfdi_verificado_at TIMESTAMPTZ;
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS cfdi_cancelacion_solicitada_at TIMESTAMPTZ;
+
+-- Diferencias entre el estado guardado y el que reporta el SAT, por corrida de conciliación
+CREATE TABLE IF NOT EXISTS cfdi_discrepancias (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID,
+    sale_id UUID NOT NULL,
+    cfdi_uuid VARCHAR(255) NOT NULL,
+    estado_local VARCHAR(50),
+    estado_nuevo VARCHAR(50) NOT NULL,
+    estado_sat VARCHAR(40),
+    estatus_cancelacion VARCHAR(60),
+    detectado_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_cfdi_discrepancias_tenant ON cfdi_discrepancias(tenant_id, detectado_at);
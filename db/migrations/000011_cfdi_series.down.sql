@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_sales_cfdi_serie_folio;
+ALTER TABLE sales DROP COLUMN IF EXISTS sucursal;
+ALTER TABLE sales DROP COLUMN IF EXISTS cfdi_folio;
+ALTER TABLE sales DROP COLUMN IF EXISTS cfdi_serie;
+DROP TABLE IF EXISTS cfdi_series;
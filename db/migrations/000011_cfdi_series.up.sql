@@ -0,0 +1,23 @@
+-- Series de facturación por tenant, sucursal y tipo de comprobante. folio_actual es el
+-- último folio apartado; los que no llegan a timbrarse se registran en cfdi_folios_sin_usar
+-- (000029), de donde se reutilizan los que se rechazaron sin timbrar.
+CREATE TABLE IF NOT EXISTS cfdi_series (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL,
+    sucursal VARCHAR(50) NOT NULL DEFAULT '',
+    tipo VARCHAR(10) NOT NULL CHECK (tipo IN ('ingreso', 'egreso', 'pago', 'global')),
+    serie VARCHAR(25) NOT NULL,
+    folio_actual BIGINT NOT NULL DEFAULT 0 CHECK (folio_actual >= 0),
+    activa BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (tenant_id, serie)
+);
+
+-- Una sola serie activa por sucursal y tipo
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cfdi_series_activa ON cfdi_series(tenant_id, sucursal, tipo) WHERE activa;
+
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS cfdi_serie VARCHAR(25);
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS cfdi_folio VARCHAR(40);
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS sucursal VARCHAR(50);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sales_cfdi_serie_folio ON sales(tenant_id, cfdi_serie, cfdi_folio) WHERE cfdi_folio IS NOT NULL;
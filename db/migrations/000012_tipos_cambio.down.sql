@@ -0,0 +1,3 @@
+ALTER TABLE sales DROP COLUMN IF EXISTS tipo_cambio;
+ALTER TABLE sales DROP COLUMN IF EXISTS moneda;
+DROP TABLE IF EXISTS tipos_cambio;
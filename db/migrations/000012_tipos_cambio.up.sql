@@ -0,0 +1,17 @@
+-- Tipos de cambio a pesos por día de publicación. tenant_id NULL son los del DOF;
+-- el tipo de cambio capturado por un tenant tiene prioridad sobre el del DOF.
+CREATE TABLE IF NOT EXISTS tipos_cambio (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID,
+    fecha DATE NOT NULL,
+    moneda VARCHAR(3) NOT NULL,
+    tipo_cambio NUMERIC(14,6) NOT NULL CHECK (tipo_cambio > 0),
+    fuente VARCHAR(10) NOT NULL DEFAULT 'manual',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tipos_cambio_dia ON tipos_cambio((COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000'::uuid)), moneda, fecha);
+
+-- Moneda en que se cobró la venta y tipo de cambio a pesos del día de la operación
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS moneda VARCHAR(3) NOT NULL DEFAULT 'MXN';
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS tipo_cambio NUMERIC(14,6) NOT NULL DEFAULT 1;
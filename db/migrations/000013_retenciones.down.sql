@@ -0,0 +1,5 @@
+ALTER TABLE sales DROP COLUMN IF EXISTS cfdi_total_retenido;
+ALTER TABLE sales DROP COLUMN IF EXISTS cfdi_iva_retenido;
+ALTER TABLE sales DROP COLUMN IF EXISTS cfdi_isr_retenido;
+DROP TABLE IF EXISTS tenant_retenciones;
+ALTER TABLE IF EXISTS products DROP COLUMN IF EXISTS perfil_retencion;
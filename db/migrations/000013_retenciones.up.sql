@@ -0,0 +1,15 @@
+-- Perfil de retención por producto (honorarios, arrendamiento, resico, fletes, subcontratacion)
+ALTER TABLE IF EXISTS products ADD COLUMN IF NOT EXISTS perfil_retencion VARCHAR(20);
+
+-- Perfil de retención por régimen fiscal del receptor, para conceptos sin perfil propio
+CREATE TABLE IF NOT EXISTS tenant_retenciones (
+    tenant_id UUID NOT NULL,
+    regimen_receptor VARCHAR(3) NOT NULL,
+    perfil VARCHAR(20) NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (tenant_id, regimen_receptor)
+);
+
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS cfdi_isr_retenido NUMERIC(12,2);
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS cfdi_iva_retenido NUMERIC(12,2);
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS cfdi_total_retenido NUMERIC(12,2);
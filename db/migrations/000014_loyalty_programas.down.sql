@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS loyalty_promociones;
+DROP TABLE IF EXISTS loyalty_multiplicadores;
+DROP TABLE IF EXISTS loyalty_niveles;
+DROP TABLE IF EXISTS loyalty_programas;
@@ -0,0 +1,41 @@
+-- Programa de lealtad por tenant; sin renglón se usa 1 punto por peso y los tiers históricos
+CREATE TABLE IF NOT EXISTS loyalty_programas (
+    tenant_id UUID PRIMARY KEY,
+    puntos_por_peso NUMERIC(10,4) NOT NULL DEFAULT 1,
+    redondeo VARCHAR(10) NOT NULL DEFAULT 'abajo' CHECK (redondeo IN ('abajo','cercano','arriba')),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Tiers por gasto acumulado; la clave es lo que se guarda en loyalty_accounts.tier
+CREATE TABLE IF NOT EXISTS loyalty_niveles (
+    tenant_id UUID NOT NULL,
+    clave VARCHAR(20) NOT NULL,
+    nombre VARCHAR(60) NOT NULL,
+    gasto_minimo NUMERIC(12,2) NOT NULL DEFAULT 0,
+    multiplicador NUMERIC(6,3) NOT NULL DEFAULT 1,
+    PRIMARY KEY (tenant_id, clave)
+);
+
+-- Multiplicadores por categoría o producto; multiplicador 0 excluye
+CREATE TABLE IF NOT EXISTS loyalty_multiplicadores (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL,
+    tipo VARCHAR(10) NOT NULL CHECK (tipo IN ('categoria','producto')),
+    valor VARCHAR(255) NOT NULL,
+    multiplicador NUMERIC(6,3) NOT NULL,
+    UNIQUE (tenant_id, tipo, valor)
+);
+
+-- Promociones de puntos (doble puntos, etc.) por rango de fechas inclusivo
+CREATE TABLE IF NOT EXISTS loyalty_promociones (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL,
+    nombre VARCHAR(100) NOT NULL,
+    desde DATE NOT NULL,
+    hasta DATE NOT NULL,
+    multiplicador NUMERIC(6,3) NOT NULL DEFAULT 2,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (hasta >= desde)
+);
+
+CREATE INDEX IF NOT EXISTS idx_loyalty_promociones_tenant ON loyalty_promociones(tenant_id, hasta);
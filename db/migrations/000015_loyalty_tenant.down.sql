@@ -0,0 +1,12 @@
+ALTER TABLE IF EXISTS tenants DROP COLUMN IF EXISTS programa_lealtad_id;
+
+DROP INDEX IF EXISTS idx_loyalty_rewards_tenant;
+DROP INDEX IF EXISTS idx_loyalty_accounts_tenant_rfc;
+DROP INDEX IF EXISTS idx_loyalty_accounts_tenant_phone;
+-- Falla si el mismo teléfono ya tiene cuenta en más de un tenant
+ALTER TABLE loyalty_accounts ADD CONSTRAINT loyalty_accounts_phone_key UNIQUE (phone);
+CREATE INDEX IF NOT EXISTS idx_loyalty_accounts_phone ON loyalty_accounts(phone);
+
+ALTER TABLE loyalty_rewards DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE loyalty_transactions DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE loyalty_accounts DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,31 @@
+-- Cuentas de lealtad por tenant: el mismo teléfono en dos negocios son dos cuentas
+ALTER TABLE loyalty_accounts ADD COLUMN IF NOT EXISTS tenant_id UUID;
+ALTER TABLE loyalty_transactions ADD COLUMN IF NOT EXISTS tenant_id UUID;
+ALTER TABLE loyalty_rewards ADD COLUMN IF NOT EXISTS tenant_id UUID;
+
+-- Las cuentas existentes quedan en el tenant de su compra más reciente (o el tenant por
+-- defecto si nunca compraron); los movimientos, en el tenant de su venta.
+UPDATE loyalty_accounts a SET tenant_id = (
+    SELECT s.tenant_id FROM loyalty_transactions t JOIN sales s ON s.id = t.sale_id
+    WHERE t.account_id = a.id AND s.tenant_id IS NOT NULL
+    ORDER BY t.created_at DESC LIMIT 1)
+WHERE a.tenant_id IS NULL;
+UPDATE loyalty_accounts SET tenant_id = '00000000-0000-0000-0000-000000000001' WHERE tenant_id IS NULL;
+UPDATE loyalty_transactions t SET tenant_id = COALESCE(
+    (SELECT s.tenant_id FROM sales s WHERE s.id = t.sale_id),
+    (SELECT a.tenant_id FROM loyalty_accounts a WHERE a.id = t.account_id))
+WHERE t.tenant_id IS NULL;
+UPDATE loyalty_rewards SET tenant_id = '00000000-0000-0000-0000-000000000001' WHERE tenant_id IS NULL;
+
+ALTER TABLE loyalty_accounts ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE loyalty_transactions ALTER COLUMN tenant_id SET NOT NULL;
+ALTER TABLE loyalty_rewards ALTER COLUMN tenant_id SET NOT NULL;
+
+ALTER TABLE loyalty_accounts DROP CONSTRAINT IF EXISTS loyalty_accounts_phone_key;
+DROP INDEX IF EXISTS idx_loyalty_accounts_phone;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_loyalty_accounts_tenant_phone ON loyalty_accounts(tenant_id, phone);
+CREATE INDEX IF NOT EXISTS idx_loyalty_accounts_tenant_rfc ON loyalty_accounts(tenant_id, rfc);
+CREATE INDEX IF NOT EXISTS idx_loyalty_rewards_tenant ON loyalty_rewards(tenant_id);
+
+-- Sucursal que usa el programa de lealtad (y las cuentas) de su matriz; NULL = el propio
+ALTER TABLE IF EXISTS tenants ADD COLUMN IF NOT EXISTS programa_lealtad_id UUID;
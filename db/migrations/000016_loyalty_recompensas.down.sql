@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS loyalty_canjes;
+
+ALTER TABLE loyalty_rewards DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE loyalty_rewards DROP COLUMN IF EXISTS descuento_valor;
+ALTER TABLE loyalty_rewards DROP COLUMN IF EXISTS descuento_tipo;
+ALTER TABLE loyalty_rewards DROP COLUMN IF EXISTS product_id;
+ALTER TABLE loyalty_rewards DROP COLUMN IF EXISTS valido_hasta;
+ALTER TABLE loyalty_rewards DROP COLUMN IF EXISTS valido_desde;
+ALTER TABLE loyalty_rewards DROP COLUMN IF EXISTS stock;
@@ -0,0 +1,26 @@
+-- Catálogo de recompensas: stock NULL = sin límite; vigencia por fechas inclusivas;
+-- la recompensa entrega un producto, un descuento (monto o porcentaje) o ambos
+ALTER TABLE loyalty_rewards ADD COLUMN IF NOT EXISTS stock INTEGER CHECK (stock >= 0);
+ALTER TABLE loyalty_rewards ADD COLUMN IF NOT EXISTS valido_desde DATE;
+ALTER TABLE loyalty_rewards ADD COLUMN IF NOT EXISTS valido_hasta DATE;
+ALTER TABLE loyalty_rewards ADD COLUMN IF NOT EXISTS product_id UUID;
+ALTER TABLE loyalty_rewards ADD COLUMN IF NOT EXISTS descuento_tipo VARCHAR(10) CHECK (descuento_tipo IN ('monto','porcentaje'));
+ALTER TABLE loyalty_rewards ADD COLUMN IF NOT EXISTS descuento_valor NUMERIC(10,2);
+ALTER TABLE loyalty_rewards ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- Canjes de recompensas; el código lo aplica la caja una sola vez
+CREATE TABLE IF NOT EXISTS loyalty_canjes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL,
+    account_id UUID NOT NULL REFERENCES loyalty_accounts(id),
+    reward_id UUID NOT NULL REFERENCES loyalty_rewards(id),
+    codigo VARCHAR(12) NOT NULL UNIQUE,
+    puntos INTEGER NOT NULL,
+    estado VARCHAR(12) NOT NULL DEFAULT 'emitido' CHECK (estado IN ('emitido','aplicado','cancelado')),
+    sale_id UUID,
+    expires_at TIMESTAMPTZ NOT NULL,
+    applied_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_loyalty_canjes_account ON loyalty_canjes(account_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS loyalty_lotes;
+ALTER TABLE loyalty_programas DROP COLUMN IF EXISTS vigencia_meses;
@@ -0,0 +1,23 @@
+-- Vigencia de los puntos por tenant, en meses desde la compra; NULL = no vencen
+ALTER TABLE loyalty_programas ADD COLUMN IF NOT EXISTS vigencia_meses INTEGER CHECK (vigencia_meses > 0);
+
+-- Lotes de puntos ganados; los canjes consumen primero los más antiguos.
+-- loyalty_accounts.points sigue siendo el saldo y es la suma de los lotes vigentes.
+CREATE TABLE IF NOT EXISTS loyalty_lotes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    account_id UUID NOT NULL REFERENCES loyalty_accounts(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL,
+    transaction_id UUID REFERENCES loyalty_transactions(id),
+    puntos INTEGER NOT NULL CHECK (puntos > 0),
+    restantes INTEGER NOT NULL CHECK (restantes >= 0),
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_loyalty_lotes_account ON loyalty_lotes(account_id, created_at) WHERE restantes > 0;
+CREATE INDEX IF NOT EXISTS idx_loyalty_lotes_vence ON loyalty_lotes(expires_at) WHERE restantes > 0;
+
+-- El saldo actual de cada cuenta queda como un lote sin vencimiento
+INSERT INTO loyalty_lotes (account_id, tenant_id, puntos, restantes)
+SELECT id, tenant_id, points, points FROM loyalty_accounts a
+WHERE points > 0 AND NOT EXISTS (SELECT 1 FROM loyalty_lotes l WHERE l.account_id = a.id);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS devoluciones;
+DROP INDEX IF EXISTS idx_loyalty_transactions_sale;
+DROP INDEX IF EXISTS idx_loyalty_transactions_reversion;
+ALTER TABLE loyalty_transactions DROP COLUMN IF EXISTS referencia;
+ALTER TABLE loyalty_transactions DROP COLUMN IF EXISTS monto;
+ALTER TABLE loyalty_accounts DROP COLUMN IF EXISTS deuda_puntos;
+ALTER TABLE loyalty_programas DROP COLUMN IF EXISTS politica_reversion;
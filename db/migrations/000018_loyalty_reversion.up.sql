@@ -0,0 +1,30 @@
+-- Qué hacer cuando una cancelación o devolución quita más puntos de los que el cliente tiene:
+-- deuda = saldo en 0 y el faltante se descuenta de compras futuras, negativo = el saldo
+-- queda negativo, absorber = saldo en 0 y el negocio absorbe el faltante
+ALTER TABLE loyalty_programas ADD COLUMN IF NOT EXISTS politica_reversion VARCHAR(10) NOT NULL DEFAULT 'deuda'
+    CHECK (politica_reversion IN ('deuda','negativo','absorber'));
+ALTER TABLE loyalty_accounts ADD COLUMN IF NOT EXISTS deuda_puntos INTEGER NOT NULL DEFAULT 0 CHECK (deuda_puntos >= 0);
+
+-- Monto de la compra (earn) o de la devolución (reverse) y referencia de la reversión
+ALTER TABLE loyalty_transactions ADD COLUMN IF NOT EXISTS monto NUMERIC(12,2);
+ALTER TABLE loyalty_transactions ADD COLUMN IF NOT EXISTS referencia VARCHAR(64);
+
+UPDATE loyalty_transactions t SET monto = s.total
+FROM sales s WHERE s.id = t.sale_id AND t.type = 'earn' AND t.monto IS NULL;
+
+-- Cada cancelación/devolución de una venta se reversa una sola vez
+CREATE UNIQUE INDEX IF NOT EXISTS idx_loyalty_transactions_reversion
+    ON loyalty_transactions(sale_id, referencia) WHERE type = 'reverse';
+CREATE INDEX IF NOT EXISTS idx_loyalty_transactions_sale ON loyalty_transactions(sale_id) WHERE sale_id IS NOT NULL;
+
+-- Devoluciones parciales de una venta; el id es la referencia de la reversión de puntos
+CREATE TABLE IF NOT EXISTS devoluciones (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL,
+    sale_id UUID NOT NULL REFERENCES sales(id),
+    monto NUMERIC(12,2) NOT NULL CHECK (monto > 0),
+    motivo VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_devoluciones_sale ON devoluciones(sale_id);
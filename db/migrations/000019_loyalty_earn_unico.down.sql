@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_loyalty_transactions_earn_unico;
+UPDATE loyalty_transactions SET type = 'earn' WHERE type = 'earn_duplicado';
@@ -0,0 +1,13 @@
+-- Reintentos de la caja o de la cola offline pudieron registrar la misma venta dos veces.
+-- Se conserva el primer earn; los repetidos quedan como 'earn_duplicado' para auditoría
+-- (sus puntos ya están en el saldo y no se tocan aquí).
+UPDATE loyalty_transactions t SET type = 'earn_duplicado'
+WHERE t.type = 'earn' AND t.sale_id IS NOT NULL AND EXISTS (
+    SELECT 1 FROM loyalty_transactions p
+    WHERE p.type = 'earn' AND p.account_id = t.account_id AND p.sale_id = t.sale_id
+      AND (p.created_at, p.id) < (t.created_at, t.id)
+);
+
+-- Una venta da puntos una sola vez por cuenta
+CREATE UNIQUE INDEX IF NOT EXISTS idx_loyalty_transactions_earn_unico
+    ON loyalty_transactions(account_id, sale_id) WHERE type = 'earn';
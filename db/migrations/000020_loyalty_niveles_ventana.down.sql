@@ -0,0 +1,8 @@
+ALTER TABLE sales DROP COLUMN IF EXISTS descuento_lealtad;
+DROP TABLE IF EXISTS loyalty_tier_historial;
+ALTER TABLE loyalty_accounts DROP COLUMN IF EXISTS tier_gracia_hasta;
+ALTER TABLE loyalty_accounts DROP COLUMN IF EXISTS tier_desde;
+ALTER TABLE loyalty_niveles DROP COLUMN IF EXISTS descuento_pct;
+ALTER TABLE loyalty_programas DROP COLUMN IF EXISTS gracia_dias;
+ALTER TABLE loyalty_programas DROP COLUMN IF EXISTS ventana_meses;
+ALTER TABLE loyalty_programas DROP COLUMN IF EXISTS base_nivel;
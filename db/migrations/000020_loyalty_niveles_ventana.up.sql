@@ -0,0 +1,34 @@
+-- Calificación de tiers por ventana móvil: gasto o puntos de los últimos N meses
+-- (NULL = todo el historial) y días de gracia antes de bajar de tier
+ALTER TABLE loyalty_programas ADD COLUMN IF NOT EXISTS base_nivel VARCHAR(10) NOT NULL DEFAULT 'gasto'
+    CHECK (base_nivel IN ('gasto','puntos'));
+ALTER TABLE loyalty_programas ADD COLUMN IF NOT EXISTS ventana_meses INTEGER CHECK (ventana_meses > 0);
+ALTER TABLE loyalty_programas ADD COLUMN IF NOT EXISTS gracia_dias INTEGER NOT NULL DEFAULT 0 CHECK (gracia_dias >= 0);
+
+-- Descuento del tier que la caja aplica al cobrar
+ALTER TABLE loyalty_niveles ADD COLUMN IF NOT EXISTS descuento_pct NUMERIC(5,2) NOT NULL DEFAULT 0
+    CHECK (descuento_pct >= 0 AND descuento_pct <= 100);
+
+-- tier_gracia_hasta: la cuenta ya no califica para su tier y lo pierde en esa fecha
+ALTER TABLE loyalty_accounts ADD COLUMN IF NOT EXISTS tier_desde TIMESTAMPTZ;
+ALTER TABLE loyalty_accounts ADD COLUMN IF NOT EXISTS tier_gracia_hasta TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS loyalty_tier_historial (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    account_id UUID NOT NULL REFERENCES loyalty_accounts(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL,
+    anterior VARCHAR(20) NOT NULL,
+    nuevo VARCHAR(20) NOT NULL,
+    nombre_nuevo VARCHAR(60) NOT NULL,
+    subio BOOLEAN NOT NULL,
+    motivo VARCHAR(20) NOT NULL CHECK (motivo IN ('compra','reversion','recalificacion')),
+    calificacion NUMERIC(12,2) NOT NULL DEFAULT 0,
+    notificado_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_loyalty_tier_historial_account ON loyalty_tier_historial(account_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_loyalty_tier_historial_pendiente ON loyalty_tier_historial(created_at) WHERE notificado_at IS NULL;
+
+-- Descuento de tier aplicado a la venta
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS descuento_lealtad NUMERIC(12,2) NOT NULL DEFAULT 0;
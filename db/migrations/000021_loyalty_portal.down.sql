@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_loyalty_accounts_email;
+DROP TABLE IF EXISTS loyalty_portal_sesiones;
+DROP TABLE IF EXISTS loyalty_portal_codigos;
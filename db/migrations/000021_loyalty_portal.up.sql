@@ -0,0 +1,22 @@
+-- Portal de clientes: el cliente se identifica con un código de un solo uso que le
+-- llega por correo y recibe una sesión temporal. Solo se guarda el hash de ambos.
+CREATE TABLE IF NOT EXISTS loyalty_portal_codigos (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    account_id UUID NOT NULL REFERENCES loyalty_accounts(id) ON DELETE CASCADE,
+    codigo_hash VARCHAR(64) NOT NULL,
+    intentos INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NOT NULL,
+    usado_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_loyalty_portal_codigos_account ON loyalty_portal_codigos(account_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS loyalty_portal_sesiones (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    account_id UUID NOT NULL REFERENCES loyalty_accounts(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_loyalty_accounts_email ON loyalty_accounts(tenant_id, LOWER(email)) WHERE email IS NOT NULL;
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS loyalty_referidos;
+DROP INDEX IF EXISTS idx_loyalty_accounts_codigo_referido;
+ALTER TABLE loyalty_accounts DROP COLUMN IF EXISTS codigo_referido;
+ALTER TABLE loyalty_programas DROP COLUMN IF EXISTS referido_tope_mensual;
+ALTER TABLE loyalty_programas DROP COLUMN IF EXISTS referido_compra_minima;
+ALTER TABLE loyalty_programas DROP COLUMN IF EXISTS referido_puntos_referido;
+ALTER TABLE loyalty_programas DROP COLUMN IF EXISTS referido_puntos_referente;
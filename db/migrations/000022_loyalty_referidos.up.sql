@@ -0,0 +1,37 @@
+-- Programa de referidos: bonos para quien refiere y para el cliente nuevo cuando su
+-- primera compra llega al mínimo; tope de referidos pagados por cliente al mes (0 = sin tope)
+ALTER TABLE loyalty_programas ADD COLUMN IF NOT EXISTS referido_puntos_referente INTEGER NOT NULL DEFAULT 0
+    CHECK (referido_puntos_referente >= 0);
+ALTER TABLE loyalty_programas ADD COLUMN IF NOT EXISTS referido_puntos_referido INTEGER NOT NULL DEFAULT 0
+    CHECK (referido_puntos_referido >= 0);
+ALTER TABLE loyalty_programas ADD COLUMN IF NOT EXISTS referido_compra_minima NUMERIC(12,2) NOT NULL DEFAULT 0
+    CHECK (referido_compra_minima >= 0);
+ALTER TABLE loyalty_programas ADD COLUMN IF NOT EXISTS referido_tope_mensual INTEGER NOT NULL DEFAULT 0
+    CHECK (referido_tope_mensual >= 0);
+
+-- Código que el cliente comparte; se genera la primera vez que se consulta
+ALTER TABLE loyalty_accounts ADD COLUMN IF NOT EXISTS codigo_referido VARCHAR(12);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_loyalty_accounts_codigo_referido
+    ON loyalty_accounts(tenant_id, codigo_referido) WHERE codigo_referido IS NOT NULL;
+
+-- Un cliente solo puede ser referido una vez. Los bonos se pagan (o se rechazan) con su
+-- primera compra.
+CREATE TABLE IF NOT EXISTS loyalty_referidos (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL,
+    referente_id UUID NOT NULL REFERENCES loyalty_accounts(id) ON DELETE CASCADE,
+    referido_id UUID NOT NULL UNIQUE REFERENCES loyalty_accounts(id) ON DELETE CASCADE,
+    codigo VARCHAR(12) NOT NULL,
+    estado VARCHAR(12) NOT NULL DEFAULT 'pendiente' CHECK (estado IN ('pendiente','completado','rechazado')),
+    motivo VARCHAR(30),
+    sale_id UUID,
+    puntos_referente INTEGER NOT NULL DEFAULT 0,
+    puntos_referido INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resuelto_at TIMESTAMPTZ,
+    CHECK (referente_id <> referido_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_loyalty_referidos_referente ON loyalty_referidos(referente_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_loyalty_referidos_pagados ON loyalty_referidos(referente_id, resuelto_at)
+    WHERE estado = 'completado';
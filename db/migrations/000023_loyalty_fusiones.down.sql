@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS loyalty_telefonos_alternos;
+DROP TABLE IF EXISTS loyalty_fusiones;
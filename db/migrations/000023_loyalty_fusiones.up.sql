@@ -0,0 +1,30 @@
+-- Bitácora de fusiones de cuentas duplicadas. fusionada guarda la cuenta absorbida tal
+-- como estaba antes de borrarse.
+CREATE TABLE IF NOT EXISTS loyalty_fusiones (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL,
+    superviviente_id UUID NOT NULL,
+    fusionada_id UUID NOT NULL,
+    fusionada JSONB NOT NULL,
+    puntos INTEGER NOT NULL DEFAULT 0,
+    transacciones INTEGER NOT NULL DEFAULT 0,
+    motivo VARCHAR(255),
+    usuario VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_loyalty_fusiones_superviviente ON loyalty_fusiones(superviviente_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_loyalty_fusiones_tenant ON loyalty_fusiones(tenant_id, created_at DESC);
+
+-- Teléfonos de cuentas fusionadas: una compra con el número viejo suma a la cuenta que
+-- lo absorbió
+CREATE TABLE IF NOT EXISTS loyalty_telefonos_alternos (
+    tenant_id UUID NOT NULL,
+    phone VARCHAR(20) NOT NULL,
+    account_id UUID NOT NULL REFERENCES loyalty_accounts(id) ON DELETE CASCADE,
+    fusion_id UUID REFERENCES loyalty_fusiones(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (tenant_id, phone)
+);
+
+CREATE INDEX IF NOT EXISTS idx_loyalty_telefonos_alternos_account ON loyalty_telefonos_alternos(account_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_sales_customer;
+ALTER TABLE sales DROP COLUMN IF EXISTS customer_id;
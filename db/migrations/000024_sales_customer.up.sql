@@ -0,0 +1,8 @@
+-- Cliente de lealtad de la venta: la caja lo identifica por teléfono, RFC o búsqueda
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS customer_id UUID REFERENCES loyalty_accounts(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_sales_customer ON sales(customer_id, created_at DESC) WHERE customer_id IS NOT NULL;
+
+-- Ventas anteriores: la cuenta que ganó puntos con ellas
+UPDATE sales s SET customer_id = t.account_id
+FROM loyalty_transactions t
+WHERE t.sale_id = s.id AND t.type = 'earn' AND s.customer_id IS NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS credito_movimientos;
+DROP TABLE IF EXISTS creditos_clientes;
@@ -0,0 +1,42 @@
+-- Crédito (fiado) por cliente y tienda. saldo > 0 es lo que el cliente debe; saldo < 0 es
+-- saldo a favor. Con bloqueado no se le vende a crédito aunque tenga disponible.
+CREATE TABLE IF NOT EXISTS creditos_clientes (
+    tenant_id UUID NOT NULL,
+    account_id UUID NOT NULL REFERENCES loyalty_accounts(id) ON DELETE CASCADE,
+    limite NUMERIC(12,2) NOT NULL DEFAULT 0 CHECK (limite >= 0),
+    saldo NUMERIC(12,2) NOT NULL DEFAULT 0,
+    dias_plazo INTEGER NOT NULL DEFAULT 30 CHECK (dias_plazo >= 0),
+    dias_tolerancia INTEGER NOT NULL DEFAULT 0 CHECK (dias_tolerancia >= 0),
+    bloqueado BOOLEAN NOT NULL DEFAULT FALSE,
+    notas TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (tenant_id, account_id)
+);
+
+-- Cargos (ventas a crédito), abonos y cancelaciones de cargos. De los cargos, pendiente es
+-- lo que falta por pagar; los abonos lo van liquidando del cargo más antiguo al más nuevo.
+CREATE TABLE IF NOT EXISTS credito_movimientos (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL,
+    account_id UUID NOT NULL REFERENCES loyalty_accounts(id) ON DELETE CASCADE,
+    tipo VARCHAR(12) NOT NULL CHECK (tipo IN ('cargo','abono','cancelacion')),
+    monto NUMERIC(12,2) NOT NULL CHECK (monto > 0),
+    sale_id UUID REFERENCES sales(id) ON DELETE SET NULL,
+    metodo_pago VARCHAR(20),
+    usuario VARCHAR(100),
+    referencia VARCHAR(120),
+    saldo_despues NUMERIC(12,2) NOT NULL,
+    vence_at DATE,
+    pendiente NUMERIC(12,2) NOT NULL DEFAULT 0 CHECK (pendiente >= 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_credito_movimientos_cuenta ON credito_movimientos(tenant_id, account_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_credito_movimientos_pendientes ON credito_movimientos(tenant_id, account_id, created_at)
+    WHERE tipo = 'cargo' AND pendiente > 0;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_credito_movimientos_cargo_venta ON credito_movimientos(sale_id)
+    WHERE tipo = 'cargo';
+-- Abonos del día para el corte de caja
+CREATE INDEX IF NOT EXISTS idx_credito_movimientos_abonos ON credito_movimientos(tenant_id, created_at)
+    WHERE tipo = 'abono';
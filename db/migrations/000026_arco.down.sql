@@ -0,0 +1,4 @@
+-- Las columnas fiscales de loyalty_accounts se quedan: las bases existentes ya las tenían
+-- antes de esta migración
+DROP TABLE IF EXISTS arco_solicitudes;
+ALTER TABLE loyalty_accounts DROP COLUMN IF EXISTS anonimizado_at;
@@ -0,0 +1,26 @@
+-- Datos fiscales del cliente que el código ya usa (autofactura, perfil fiscal del portal)
+ALTER TABLE loyalty_accounts ADD COLUMN IF NOT EXISTS cp VARCHAR(5);
+ALTER TABLE loyalty_accounts ADD COLUMN IF NOT EXISTS regimen_fiscal VARCHAR(3);
+ALTER TABLE loyalty_accounts ADD COLUMN IF NOT EXISTS nombre_fiscal VARCHAR(255);
+ALTER TABLE loyalty_accounts ADD COLUMN IF NOT EXISTS uso_cfdi VARCHAR(4);
+
+-- Una cuenta cancelada por derechos ARCO conserva su id, puntos y ventas pero ya no sus
+-- datos personales
+ALTER TABLE loyalty_accounts ADD COLUMN IF NOT EXISTS anonimizado_at TIMESTAMPTZ;
+
+-- Bitácora de solicitudes ARCO atendidas: exportaciones (acceso) y anonimizaciones
+-- (cancelación). Solo guarda el id de la cuenta, nunca los datos del titular.
+CREATE TABLE IF NOT EXISTS arco_solicitudes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL,
+    account_id UUID NOT NULL REFERENCES loyalty_accounts(id),
+    tipo VARCHAR(12) NOT NULL CHECK (tipo IN ('acceso','cancelacion')),
+    formato VARCHAR(4),
+    motivo VARCHAR(255),
+    detalle JSONB NOT NULL DEFAULT '{}',
+    usuario VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_arco_solicitudes_tenant ON arco_solicitudes(tenant_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_arco_solicitudes_account ON arco_solicitudes(account_id, created_at DESC);
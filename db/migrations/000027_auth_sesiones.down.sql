@@ -0,0 +1,5 @@
+-- users.tenant_id y password_reset_tokens se quedan: las bases existentes ya los tenían
+-- antes de esta migración
+DROP INDEX IF EXISTS idx_sessions_user;
+DROP INDEX IF EXISTS idx_sessions_refresh_token;
+ALTER TABLE sessions DROP COLUMN IF EXISTS revoked_at;
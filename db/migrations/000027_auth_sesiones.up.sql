@@ -0,0 +1,17 @@
+-- Columnas y tablas que el login y la recuperación de contraseña ya usaban
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id UUID;
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    token VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_token ON password_reset_tokens(token);
+
+-- El AuthService abre una sesión por login. refresh_token guarda la huella SHA-256 del
+-- token, no el token; una sesión cerrada conserva su fila con revoked_at.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMPTZ;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_refresh_token ON sessions(refresh_token);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id, created_at DESC);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_sessions_previous_token;
+ALTER TABLE sessions DROP COLUMN IF EXISTS revoke_reason;
+ALTER TABLE sessions DROP COLUMN IF EXISTS previous_token;
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_used_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS ip;
+ALTER TABLE sessions DROP COLUMN IF EXISTS user_agent;
@@ -0,0 +1,12 @@
+-- Dispositivo e IP de cada sesión para la lista de sesiones activas
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent VARCHAR(512);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip VARCHAR(64);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- Los refresh tokens rotan en cada uso. previous_token guarda la huella del último ya
+-- usado: si vuelve a llegar, alguien tiene una copia y se revoca la sesión.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS previous_token VARCHAR(512);
+-- logout, todas, revocada, password o reuso
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS revoke_reason VARCHAR(16);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_previous_token ON sessions(previous_token);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS cfdi_folios_sin_usar;
@@ -0,0 +1,19 @@
+-- Folios de una serie que se apartaron pero no quedaron en ningún CFDI. El contador de
+-- cfdi_series avanza al apartar el folio, antes de llamar al PAC, así que un folio nunca
+-- se entrega dos veces a la vez. Si el comprobante se rechazó sin timbrarse (datos del
+-- receptor, CSD o validación del PAC) el folio queda reutilizable y se entrega antes que
+-- uno nuevo; si no se sabe si el PAC lo timbró (tiempo agotado, PAC caído) queda como
+-- hueco para reportarlo y nunca se reutiliza.
+CREATE TABLE IF NOT EXISTS cfdi_folios_sin_usar (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL,
+    serie VARCHAR(25) NOT NULL,
+    folio BIGINT NOT NULL,
+    sale_id UUID,
+    motivo TEXT NOT NULL DEFAULT '',
+    reutilizable BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (tenant_id, serie, folio)
+);
+
+CREATE INDEX IF NOT EXISTS idx_cfdi_folios_reutilizables ON cfdi_folios_sin_usar(tenant_id, serie, folio) WHERE reutilizable;
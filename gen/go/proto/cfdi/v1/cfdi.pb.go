@@ -33,12 +33,26 @@ type FacturaRequest struct {
 	Payload               string  `protobuf:"bytes,6,opt,name=payload,proto3" json:"payload,omitempty"`
 	CodigoPostalReceptor  string  `protobuf:"bytes,7,opt,name=codigo_postal_receptor,json=codigoPostalReceptor,proto3" json:"codigo_postal_receptor,omitempty"`
 	CertB64               string  `protobuf:"bytes,8,opt,name=cert_b64,json=certB64,proto3" json:"cert_b64,omitempty"`
-	KeyBytes              []byte  `protobuf:"bytes,9,opt,name=key_bytes,json=keyBytes,proto3" json:"key_bytes,omitempty"`
-	KeyPassword           string  `protobuf:"bytes,10,opt,name=key_password,json=keyPassword,proto3" json:"key_password,omitempty"`
+	KeyBytes              []byte  `protobuf:"bytes,9,opt,name=key_bytes,json=keyBytes,proto3" json:"key_bytes,omitempty"`           // cifrada con la bóveda (ver CSDInfo)
+	KeyPassword           string  `protobuf:"bytes,10,opt,name=key_password,json=keyPassword,proto3" json:"key_password,omitempty"` // cifrada con la bóveda
 	TenantId              string  `protobuf:"bytes,11,opt,name=tenant_id,json=tenantId,proto3" json:"tenant_id,omitempty"`
 	NombreReceptor        string  `protobuf:"bytes,12,opt,name=nombre_receptor,json=nombreReceptor,proto3" json:"nombre_receptor,omitempty"`
 	RegimenFiscalReceptor string  `protobuf:"bytes,13,opt,name=regimen_fiscal_receptor,json=regimenFiscalReceptor,proto3" json:"regimen_fiscal_receptor,omitempty"`
 	UsoCfdi               string  `protobuf:"bytes,14,opt,name=uso_cfdi,json=usoCfdi,proto3" json:"uso_cfdi,omitempty"`
+	// Régimen y CP del emisor cuando se timbra con el CSD del tenant
+	RegimenFiscalEmisor string `protobuf:"bytes,15,opt,name=regimen_fiscal_emisor,json=regimenFiscalEmisor,proto3" json:"regimen_fiscal_emisor,omitempty"`
+	LugarExpedicion     string `protobuf:"bytes,16,opt,name=lugar_expedicion,json=lugarExpedicion,proto3" json:"lugar_expedicion,omitempty"`
+	// Serie y folio asignados por la serie de facturación del tenant
+	Serie string `protobuf:"bytes,17,opt,name=serie,proto3" json:"serie,omitempty"`
+	Folio string `protobuf:"bytes,18,opt,name=folio,proto3" json:"folio,omitempty"`
+	// Moneda c_Moneda (vacía = MXN) y tipo de cambio a pesos del DOF, como decimal exacto
+	Moneda     string `protobuf:"bytes,19,opt,name=moneda,proto3" json:"moneda,omitempty"`
+	TipoCambio string `protobuf:"bytes,20,opt,name=tipo_cambio,json=tipoCambio,proto3" json:"tipo_cambio,omitempty"`
+	// Conceptos de la venta; si no vienen se factura una sola línea por el total
+	Conceptos []*ConceptoFactura `protobuf:"bytes,21,rep,name=conceptos,proto3" json:"conceptos,omitempty"`
+	// Perfil de retención (honorarios, arrendamiento, resico, fletes, subcontratacion) para
+	// los conceptos que no indican uno. Solo aplica si el receptor es persona moral.
+	PerfilRetencion string `protobuf:"bytes,22,opt,name=perfil_retencion,json=perfilRetencion,proto3" json:"perfil_retencion,omitempty"`
 }
 
 func (x *FacturaRequest) Reset() {
@@ -171,6 +185,141 @@ func (x *FacturaRequest) GetUsoCfdi() string {
 	return ""
 }
 
+func (x *FacturaRequest) GetRegimenFiscalEmisor() string {
+	if x != nil {
+		return x.RegimenFiscalEmisor
+	}
+	return ""
+}
+
+func (x *FacturaRequest) GetLugarExpedicion() string {
+	if x != nil {
+		return x.LugarExpedicion
+	}
+	return ""
+}
+
+func (x *FacturaRequest) GetSerie() string {
+	if x != nil {
+		return x.Serie
+	}
+	return ""
+}
+
+func (x *FacturaRequest) GetFolio() string {
+	if x != nil {
+		return x.Folio
+	}
+	return ""
+}
+
+func (x *FacturaRequest) GetMoneda() string {
+	if x != nil {
+		return x.Moneda
+	}
+	return ""
+}
+
+func (x *FacturaRequest) GetTipoCambio() string {
+	if x != nil {
+		return x.TipoCambio
+	}
+	return ""
+}
+
+func (x *FacturaRequest) GetConceptos() []*ConceptoFactura {
+	if x != nil {
+		return x.Conceptos
+	}
+	return nil
+}
+
+func (x *FacturaRequest) GetPerfilRetencion() string {
+	if x != nil {
+		return x.PerfilRetencion
+	}
+	return ""
+}
+
+type ConceptoFactura struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Nombre          string  `protobuf:"bytes,1,opt,name=nombre,proto3" json:"nombre,omitempty"`
+	Cantidad        int32   `protobuf:"varint,2,opt,name=cantidad,proto3" json:"cantidad,omitempty"`
+	PrecioUnitario  float64 `protobuf:"fixed64,3,opt,name=precio_unitario,json=precioUnitario,proto3" json:"precio_unitario,omitempty"` // con IVA, como se cobró
+	Subtotal        float64 `protobuf:"fixed64,4,opt,name=subtotal,proto3" json:"subtotal,omitempty"`
+	PerfilRetencion string  `protobuf:"bytes,5,opt,name=perfil_retencion,json=perfilRetencion,proto3" json:"perfil_retencion,omitempty"`
+}
+
+func (x *ConceptoFactura) Reset() {
+	*x = ConceptoFactura{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ConceptoFactura) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConceptoFactura) ProtoMessage() {}
+
+func (x *ConceptoFactura) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConceptoFactura.ProtoReflect.Descriptor instead.
+func (*ConceptoFactura) Descriptor() ([]byte, []int) {
+	return file_proto_cfdi_v1_cfdi_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ConceptoFactura) GetNombre() string {
+	if x != nil {
+		return x.Nombre
+	}
+	return ""
+}
+
+func (x *ConceptoFactura) GetCantidad() int32 {
+	if x != nil {
+		return x.Cantidad
+	}
+	return 0
+}
+
+func (x *ConceptoFactura) GetPrecioUnitario() float64 {
+	if x != nil {
+		return x.PrecioUnitario
+	}
+	return 0
+}
+
+func (x *ConceptoFactura) GetSubtotal() float64 {
+	if x != nil {
+		return x.Subtotal
+	}
+	return 0
+}
+
+func (x *ConceptoFactura) GetPerfilRetencion() string {
+	if x != nil {
+		return x.PerfilRetencion
+	}
+	return ""
+}
+
 type FacturaResponse struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -181,12 +330,17 @@ type FacturaResponse struct {
 	SelloSat  string `protobuf:"bytes,3,opt,name=sello_sat,json=selloSat,proto3" json:"sello_sat,omitempty"`
 	PacUsado  int32  `protobuf:"varint,4,opt,name=pac_usado,json=pacUsado,proto3" json:"pac_usado,omitempty"`
 	Timestamp int64  `protobuf:"varint,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Xml       string `protobuf:"bytes,6,opt,name=xml,proto3" json:"xml,omitempty"` // comprobante timbrado
+	// Impuestos retenidos del comprobante, como decimales exactos
+	TotalRetenido string `protobuf:"bytes,7,opt,name=total_retenido,json=totalRetenido,proto3" json:"total_retenido,omitempty"`
+	IsrRetenido   string `protobuf:"bytes,8,opt,name=isr_retenido,json=isrRetenido,proto3" json:"isr_retenido,omitempty"`
+	IvaRetenido   string `protobuf:"bytes,9,opt,name=iva_retenido,json=ivaRetenido,proto3" json:"iva_retenido,omitempty"`
 }
 
 func (x *FacturaResponse) Reset() {
 	*x = FacturaResponse{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[1]
+		mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[2]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -199,7 +353,7 @@ func (x *FacturaResponse) String() string {
 func (*FacturaResponse) ProtoMessage() {}
 
 func (x *FacturaResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[1]
+	mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[2]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -212,7 +366,7 @@ func (x *FacturaResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use FacturaResponse.ProtoReflect.Descriptor instead.
 func (*FacturaResponse) Descriptor() ([]byte, []int) {
-	return file_proto_cfdi_v1_cfdi_proto_rawDescGZIP(), []int{1}
+	return file_proto_cfdi_v1_cfdi_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *FacturaResponse) GetStatus() string {
@@ -250,6 +404,220 @@ func (x *FacturaResponse) GetTimestamp() int64 {
 	return 0
 }
 
+func (x *FacturaResponse) GetXml() string {
+	if x != nil {
+		return x.Xml
+	}
+	return ""
+}
+
+func (x *FacturaResponse) GetTotalRetenido() string {
+	if x != nil {
+		return x.TotalRetenido
+	}
+	return ""
+}
+
+func (x *FacturaResponse) GetIsrRetenido() string {
+	if x != nil {
+		return x.IsrRetenido
+	}
+	return ""
+}
+
+func (x *FacturaResponse) GetIvaRetenido() string {
+	if x != nil {
+		return x.IvaRetenido
+	}
+	return ""
+}
+
+type TimbrarLoteRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Facturas []*FacturaRequest `protobuf:"bytes,1,rep,name=facturas,proto3" json:"facturas,omitempty"`
+	// Serie del lote y primer folio de los que se apartaron para él: la factura i lleva
+	// folio_inicial + i. Una factura que ya trae serie y folio los conserva.
+	Serie        string `protobuf:"bytes,2,opt,name=serie,proto3" json:"serie,omitempty"`
+	FolioInicial int64  `protobuf:"varint,3,opt,name=folio_inicial,json=folioInicial,proto3" json:"folio_inicial,omitempty"`
+	Concurrencia int32  `protobuf:"varint,4,opt,name=concurrencia,proto3" json:"concurrencia,omitempty"` // comprobantes simultáneos del lote; 0 = 4
+}
+
+func (x *TimbrarLoteRequest) Reset() {
+	*x = TimbrarLoteRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TimbrarLoteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TimbrarLoteRequest) ProtoMessage() {}
+
+func (x *TimbrarLoteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TimbrarLoteRequest.ProtoReflect.Descriptor instead.
+func (*TimbrarLoteRequest) Descriptor() ([]byte, []int) {
+	return file_proto_cfdi_v1_cfdi_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *TimbrarLoteRequest) GetFacturas() []*FacturaRequest {
+	if x != nil {
+		return x.Facturas
+	}
+	return nil
+}
+
+func (x *TimbrarLoteRequest) GetSerie() string {
+	if x != nil {
+		return x.Serie
+	}
+	return ""
+}
+
+func (x *TimbrarLoteRequest) GetFolioInicial() int64 {
+	if x != nil {
+		return x.FolioInicial
+	}
+	return 0
+}
+
+func (x *TimbrarLoteRequest) GetConcurrencia() int32 {
+	if x != nil {
+		return x.Concurrencia
+	}
+	return 0
+}
+
+type TimbrarLoteProgreso struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Indice      int32            `protobuf:"varint,1,opt,name=indice,proto3" json:"indice,omitempty"` // posición de la venta en facturas
+	VentaId     string           `protobuf:"bytes,2,opt,name=venta_id,json=ventaId,proto3" json:"venta_id,omitempty"`
+	Ok          bool             `protobuf:"varint,3,opt,name=ok,proto3" json:"ok,omitempty"`
+	Error       string           `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	Factura     *FacturaResponse `protobuf:"bytes,5,opt,name=factura,proto3" json:"factura,omitempty"`
+	Folio       string           `protobuf:"bytes,6,opt,name=folio,proto3" json:"folio,omitempty"`
+	Completadas int32            `protobuf:"varint,7,opt,name=completadas,proto3" json:"completadas,omitempty"`
+	Total       int32            `protobuf:"varint,8,opt,name=total,proto3" json:"total,omitempty"`
+	// El comprobante se rechazó sin timbrarse (datos inválidos, CSD o validación del PAC):
+	// su folio se puede reutilizar. Con error y sin rechazado no se sabe si el PAC lo timbró.
+	Rechazado bool `protobuf:"varint,9,opt,name=rechazado,proto3" json:"rechazado,omitempty"`
+}
+
+func (x *TimbrarLoteProgreso) Reset() {
+	*x = TimbrarLoteProgreso{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TimbrarLoteProgreso) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TimbrarLoteProgreso) ProtoMessage() {}
+
+func (x *TimbrarLoteProgreso) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TimbrarLoteProgreso.ProtoReflect.Descriptor instead.
+func (*TimbrarLoteProgreso) Descriptor() ([]byte, []int) {
+	return file_proto_cfdi_v1_cfdi_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *TimbrarLoteProgreso) GetIndice() int32 {
+	if x != nil {
+		return x.Indice
+	}
+	return 0
+}
+
+func (x *TimbrarLoteProgreso) GetVentaId() string {
+	if x != nil {
+		return x.VentaId
+	}
+	return ""
+}
+
+func (x *TimbrarLoteProgreso) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *TimbrarLoteProgreso) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *TimbrarLoteProgreso) GetFactura() *FacturaResponse {
+	if x != nil {
+		return x.Factura
+	}
+	return nil
+}
+
+func (x *TimbrarLoteProgreso) GetFolio() string {
+	if x != nil {
+		return x.Folio
+	}
+	return ""
+}
+
+func (x *TimbrarLoteProgreso) GetCompletadas() int32 {
+	if x != nil {
+		return x.Completadas
+	}
+	return 0
+}
+
+func (x *TimbrarLoteProgreso) GetTotal() int32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *TimbrarLoteProgreso) GetRechazado() bool {
+	if x != nil {
+		return x.Rechazado
+	}
+	return false
+}
+
 type CancelRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -268,7 +636,7 @@ type CancelRequest struct {
 func (x *CancelRequest) Reset() {
 	*x = CancelRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[2]
+		mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[5]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -281,7 +649,7 @@ func (x *CancelRequest) String() string {
 func (*CancelRequest) ProtoMessage() {}
 
 func (x *CancelRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[2]
+	mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[5]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -294,7 +662,7 @@ func (x *CancelRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CancelRequest.ProtoReflect.Descriptor instead.
 func (*CancelRequest) Descriptor() ([]byte, []int) {
-	return file_proto_cfdi_v1_cfdi_proto_rawDescGZIP(), []int{2}
+	return file_proto_cfdi_v1_cfdi_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *CancelRequest) GetUuid() string {
@@ -368,7 +736,7 @@ type CancelResponse struct {
 func (x *CancelResponse) Reset() {
 	*x = CancelResponse{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[3]
+		mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[6]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -381,7 +749,7 @@ func (x *CancelResponse) String() string {
 func (*CancelResponse) ProtoMessage() {}
 
 func (x *CancelResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[3]
+	mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[6]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -394,7 +762,7 @@ func (x *CancelResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CancelResponse.ProtoReflect.Descriptor instead.
 func (*CancelResponse) Descriptor() ([]byte, []int) {
-	return file_proto_cfdi_v1_cfdi_proto_rawDescGZIP(), []int{3}
+	return file_proto_cfdi_v1_cfdi_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *CancelResponse) GetStatus() string {
@@ -432,12 +800,188 @@ func (x *CancelResponse) GetTimestamp() int64 {
 	return 0
 }
 
+type ValidarCSDRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	CertB64     string `protobuf:"bytes,1,opt,name=cert_b64,json=certB64,proto3" json:"cert_b64,omitempty"`
+	KeyBytes    []byte `protobuf:"bytes,2,opt,name=key_bytes,json=keyBytes,proto3" json:"key_bytes,omitempty"`
+	KeyPassword string `protobuf:"bytes,3,opt,name=key_password,json=keyPassword,proto3" json:"key_password,omitempty"`
+	RfcEmisor   string `protobuf:"bytes,4,opt,name=rfc_emisor,json=rfcEmisor,proto3" json:"rfc_emisor,omitempty"`
+	TenantId    string `protobuf:"bytes,5,opt,name=tenant_id,json=tenantId,proto3" json:"tenant_id,omitempty"` // la llave se cifra ligada a este tenant
+}
+
+func (x *ValidarCSDRequest) Reset() {
+	*x = ValidarCSDRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ValidarCSDRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ValidarCSDRequest) ProtoMessage() {}
+
+func (x *ValidarCSDRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ValidarCSDRequest.ProtoReflect.Descriptor instead.
+func (*ValidarCSDRequest) Descriptor() ([]byte, []int) {
+	return file_proto_cfdi_v1_cfdi_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ValidarCSDRequest) GetCertB64() string {
+	if x != nil {
+		return x.CertB64
+	}
+	return ""
+}
+
+func (x *ValidarCSDRequest) GetKeyBytes() []byte {
+	if x != nil {
+		return x.KeyBytes
+	}
+	return nil
+}
+
+func (x *ValidarCSDRequest) GetKeyPassword() string {
+	if x != nil {
+		return x.KeyPassword
+	}
+	return ""
+}
+
+func (x *ValidarCSDRequest) GetRfcEmisor() string {
+	if x != nil {
+		return x.RfcEmisor
+	}
+	return ""
+}
+
+func (x *ValidarCSDRequest) GetTenantId() string {
+	if x != nil {
+		return x.TenantId
+	}
+	return ""
+}
+
+type CSDInfo struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Rfc           string `protobuf:"bytes,1,opt,name=rfc,proto3" json:"rfc,omitempty"`
+	Nombre        string `protobuf:"bytes,2,opt,name=nombre,proto3" json:"nombre,omitempty"`
+	NoCertificado string `protobuf:"bytes,3,opt,name=no_certificado,json=noCertificado,proto3" json:"no_certificado,omitempty"`
+	ValidoDesde   int64  `protobuf:"varint,4,opt,name=valido_desde,json=validoDesde,proto3" json:"valido_desde,omitempty"`
+	ValidoHasta   int64  `protobuf:"varint,5,opt,name=valido_hasta,json=validoHasta,proto3" json:"valido_hasta,omitempty"`
+	// Llave y contraseña cifradas con la llave maestra del CFDI service; el BFF
+	// las guarda tal cual y las reenvía en FacturaRequest.
+	KeyBytesCifrada    []byte `protobuf:"bytes,6,opt,name=key_bytes_cifrada,json=keyBytesCifrada,proto3" json:"key_bytes_cifrada,omitempty"`
+	KeyPasswordCifrada string `protobuf:"bytes,7,opt,name=key_password_cifrada,json=keyPasswordCifrada,proto3" json:"key_password_cifrada,omitempty"`
+}
+
+func (x *CSDInfo) Reset() {
+	*x = CSDInfo{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CSDInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CSDInfo) ProtoMessage() {}
+
+func (x *CSDInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_cfdi_v1_cfdi_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CSDInfo.ProtoReflect.Descriptor instead.
+func (*CSDInfo) Descriptor() ([]byte, []int) {
+	return file_proto_cfdi_v1_cfdi_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *CSDInfo) GetRfc() string {
+	if x != nil {
+		return x.Rfc
+	}
+	return ""
+}
+
+func (x *CSDInfo) GetNombre() string {
+	if x != nil {
+		return x.Nombre
+	}
+	return ""
+}
+
+func (x *CSDInfo) GetNoCertificado() string {
+	if x != nil {
+		return x.NoCertificado
+	}
+	return ""
+}
+
+func (x *CSDInfo) GetValidoDesde() int64 {
+	if x != nil {
+		return x.ValidoDesde
+	}
+	return 0
+}
+
+func (x *CSDInfo) GetValidoHasta() int64 {
+	if x != nil {
+		return x.ValidoHasta
+	}
+	return 0
+}
+
+func (x *CSDInfo) GetKeyBytesCifrada() []byte {
+	if x != nil {
+		return x.KeyBytesCifrada
+	}
+	return nil
+}
+
+func (x *CSDInfo) GetKeyPasswordCifrada() string {
+	if x != nil {
+		return x.KeyPasswordCifrada
+	}
+	return ""
+}
+
 var File_proto_cfdi_v1_cfdi_proto protoreflect.FileDescriptor
 
 var file_proto_cfdi_v1_cfdi_proto_rawDesc = []byte{
 	0x0a, 0x18, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x63, 0x66, 0x64, 0x69, 0x2f, 0x76, 0x31, 0x2f,
 	0x63, 0x66, 0x64, 0x69, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x07, 0x63, 0x66, 0x64, 0x69,
-	0x2e, 0x76, 0x31, 0x22, 0xcc, 0x03, 0x0a, 0x0e, 0x46, 0x61, 0x63, 0x74, 0x75, 0x72, 0x61, 0x52,
+	0x2e, 0x76, 0x31, 0x22, 0xf3, 0x05, 0x0a, 0x0e, 0x46, 0x61, 0x63, 0x74, 0x75, 0x72, 0x61, 0x52,
 	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x76, 0x65, 0x6e, 0x74, 0x61, 0x5f,
 	0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x76, 0x65, 0x6e, 0x74, 0x61, 0x49,
 	0x64, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01,
@@ -466,53 +1010,154 @@ var file_proto_cfdi_v1_cfdi_proto_rawDesc = []byte{
 	0x52, 0x15, 0x72, 0x65, 0x67, 0x69, 0x6d, 0x65, 0x6e, 0x46, 0x69, 0x73, 0x63, 0x61, 0x6c, 0x52,
 	0x65, 0x63, 0x65, 0x70, 0x74, 0x6f, 0x72, 0x12, 0x19, 0x0a, 0x08, 0x75, 0x73, 0x6f, 0x5f, 0x63,
 	0x66, 0x64, 0x69, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x75, 0x73, 0x6f, 0x43, 0x66,
-	0x64, 0x69, 0x22, 0x95, 0x01, 0x0a, 0x0f, 0x46, 0x61, 0x63, 0x74, 0x75, 0x72, 0x61, 0x52, 0x65,
+	0x64, 0x69, 0x12, 0x32, 0x0a, 0x15, 0x72, 0x65, 0x67, 0x69, 0x6d, 0x65, 0x6e, 0x5f, 0x66, 0x69,
+	0x73, 0x63, 0x61, 0x6c, 0x5f, 0x65, 0x6d, 0x69, 0x73, 0x6f, 0x72, 0x18, 0x0f, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x13, 0x72, 0x65, 0x67, 0x69, 0x6d, 0x65, 0x6e, 0x46, 0x69, 0x73, 0x63, 0x61, 0x6c,
+	0x45, 0x6d, 0x69, 0x73, 0x6f, 0x72, 0x12, 0x29, 0x0a, 0x10, 0x6c, 0x75, 0x67, 0x61, 0x72, 0x5f,
+	0x65, 0x78, 0x70, 0x65, 0x64, 0x69, 0x63, 0x69, 0x6f, 0x6e, 0x18, 0x10, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0f, 0x6c, 0x75, 0x67, 0x61, 0x72, 0x45, 0x78, 0x70, 0x65, 0x64, 0x69, 0x63, 0x69, 0x6f,
+	0x6e, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x65, 0x72, 0x69, 0x65, 0x18, 0x11, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x05, 0x73, 0x65, 0x72, 0x69, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x66, 0x6f, 0x6c, 0x69, 0x6f,
+	0x18, 0x12, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x66, 0x6f, 0x6c, 0x69, 0x6f, 0x12, 0x16, 0x0a,
+	0x06, 0x6d, 0x6f, 0x6e, 0x65, 0x64, 0x61, 0x18, 0x13, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x6d,
+	0x6f, 0x6e, 0x65, 0x64, 0x61, 0x12, 0x1f, 0x0a, 0x0b, 0x74, 0x69, 0x70, 0x6f, 0x5f, 0x63, 0x61,
+	0x6d, 0x62, 0x69, 0x6f, 0x18, 0x14, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x74, 0x69, 0x70, 0x6f,
+	0x43, 0x61, 0x6d, 0x62, 0x69, 0x6f, 0x12, 0x36, 0x0a, 0x09, 0x63, 0x6f, 0x6e, 0x63, 0x65, 0x70,
+	0x74, 0x6f, 0x73, 0x18, 0x15, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x63, 0x66, 0x64, 0x69,
+	0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6f, 0x6e, 0x63, 0x65, 0x70, 0x74, 0x6f, 0x46, 0x61, 0x63, 0x74,
+	0x75, 0x72, 0x61, 0x52, 0x09, 0x63, 0x6f, 0x6e, 0x63, 0x65, 0x70, 0x74, 0x6f, 0x73, 0x12, 0x29,
+	0x0a, 0x10, 0x70, 0x65, 0x72, 0x66, 0x69, 0x6c, 0x5f, 0x72, 0x65, 0x74, 0x65, 0x6e, 0x63, 0x69,
+	0x6f, 0x6e, 0x18, 0x16, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0f, 0x70, 0x65, 0x72, 0x66, 0x69, 0x6c,
+	0x52, 0x65, 0x74, 0x65, 0x6e, 0x63, 0x69, 0x6f, 0x6e, 0x22, 0xb5, 0x01, 0x0a, 0x0f, 0x43, 0x6f,
+	0x6e, 0x63, 0x65, 0x70, 0x74, 0x6f, 0x46, 0x61, 0x63, 0x74, 0x75, 0x72, 0x61, 0x12, 0x16, 0x0a,
+	0x06, 0x6e, 0x6f, 0x6d, 0x62, 0x72, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x6e,
+	0x6f, 0x6d, 0x62, 0x72, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x61, 0x6e, 0x74, 0x69, 0x64, 0x61,
+	0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x63, 0x61, 0x6e, 0x74, 0x69, 0x64, 0x61,
+	0x64, 0x12, 0x27, 0x0a, 0x0f, 0x70, 0x72, 0x65, 0x63, 0x69, 0x6f, 0x5f, 0x75, 0x6e, 0x69, 0x74,
+	0x61, 0x72, 0x69, 0x6f, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0e, 0x70, 0x72, 0x65, 0x63,
+	0x69, 0x6f, 0x55, 0x6e, 0x69, 0x74, 0x61, 0x72, 0x69, 0x6f, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x75,
+	0x62, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x73, 0x75,
+	0x62, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x12, 0x29, 0x0a, 0x10, 0x70, 0x65, 0x72, 0x66, 0x69, 0x6c,
+	0x5f, 0x72, 0x65, 0x74, 0x65, 0x6e, 0x63, 0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0f, 0x70, 0x65, 0x72, 0x66, 0x69, 0x6c, 0x52, 0x65, 0x74, 0x65, 0x6e, 0x63, 0x69, 0x6f,
+	0x6e, 0x22, 0x94, 0x02, 0x0a, 0x0f, 0x46, 0x61, 0x63, 0x74, 0x75, 0x72, 0x61, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x12, 0x0a,
+	0x04, 0x75, 0x75, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x75, 0x75, 0x69,
+	0x64, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x65, 0x6c, 0x6c, 0x6f, 0x5f, 0x73, 0x61, 0x74, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x65, 0x6c, 0x6c, 0x6f, 0x53, 0x61, 0x74, 0x12, 0x1b,
+	0x0a, 0x09, 0x70, 0x61, 0x63, 0x5f, 0x75, 0x73, 0x61, 0x64, 0x6f, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x08, 0x70, 0x61, 0x63, 0x55, 0x73, 0x61, 0x64, 0x6f, 0x12, 0x1c, 0x0a, 0x09, 0x74,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09,
+	0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x10, 0x0a, 0x03, 0x78, 0x6d, 0x6c,
+	0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x78, 0x6d, 0x6c, 0x12, 0x25, 0x0a, 0x0e, 0x74,
+	0x6f, 0x74, 0x61, 0x6c, 0x5f, 0x72, 0x65, 0x74, 0x65, 0x6e, 0x69, 0x64, 0x6f, 0x18, 0x07, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0d, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x52, 0x65, 0x74, 0x65, 0x6e, 0x69,
+	0x64, 0x6f, 0x12, 0x21, 0x0a, 0x0c, 0x69, 0x73, 0x72, 0x5f, 0x72, 0x65, 0x74, 0x65, 0x6e, 0x69,
+	0x64, 0x6f, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x69, 0x73, 0x72, 0x52, 0x65, 0x74,
+	0x65, 0x6e, 0x69, 0x64, 0x6f, 0x12, 0x21, 0x0a, 0x0c, 0x69, 0x76, 0x61, 0x5f, 0x72, 0x65, 0x74,
+	0x65, 0x6e, 0x69, 0x64, 0x6f, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x69, 0x76, 0x61,
+	0x52, 0x65, 0x74, 0x65, 0x6e, 0x69, 0x64, 0x6f, 0x22, 0xa8, 0x01, 0x0a, 0x12, 0x54, 0x69, 0x6d,
+	0x62, 0x72, 0x61, 0x72, 0x4c, 0x6f, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
+	0x33, 0x0a, 0x08, 0x66, 0x61, 0x63, 0x74, 0x75, 0x72, 0x61, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x17, 0x2e, 0x63, 0x66, 0x64, 0x69, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x61, 0x63, 0x74,
+	0x75, 0x72, 0x61, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x52, 0x08, 0x66, 0x61, 0x63, 0x74,
+	0x75, 0x72, 0x61, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x65, 0x72, 0x69, 0x65, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x05, 0x73, 0x65, 0x72, 0x69, 0x65, 0x12, 0x23, 0x0a, 0x0d, 0x66, 0x6f,
+	0x6c, 0x69, 0x6f, 0x5f, 0x69, 0x6e, 0x69, 0x63, 0x69, 0x61, 0x6c, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x0c, 0x66, 0x6f, 0x6c, 0x69, 0x6f, 0x49, 0x6e, 0x69, 0x63, 0x69, 0x61, 0x6c, 0x12,
+	0x22, 0x0a, 0x0c, 0x63, 0x6f, 0x6e, 0x63, 0x75, 0x72, 0x72, 0x65, 0x6e, 0x63, 0x69, 0x61, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0c, 0x63, 0x6f, 0x6e, 0x63, 0x75, 0x72, 0x72, 0x65, 0x6e,
+	0x63, 0x69, 0x61, 0x22, 0x8e, 0x02, 0x0a, 0x13, 0x54, 0x69, 0x6d, 0x62, 0x72, 0x61, 0x72, 0x4c,
+	0x6f, 0x74, 0x65, 0x50, 0x72, 0x6f, 0x67, 0x72, 0x65, 0x73, 0x6f, 0x12, 0x16, 0x0a, 0x06, 0x69,
+	0x6e, 0x64, 0x69, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x69, 0x6e, 0x64,
+	0x69, 0x63, 0x65, 0x12, 0x19, 0x0a, 0x08, 0x76, 0x65, 0x6e, 0x74, 0x61, 0x5f, 0x69, 0x64, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x76, 0x65, 0x6e, 0x74, 0x61, 0x49, 0x64, 0x12, 0x0e,
+	0x0a, 0x02, 0x6f, 0x6b, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x02, 0x6f, 0x6b, 0x12, 0x14,
+	0x0a, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65,
+	0x72, 0x72, 0x6f, 0x72, 0x12, 0x32, 0x0a, 0x07, 0x66, 0x61, 0x63, 0x74, 0x75, 0x72, 0x61, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x63, 0x66, 0x64, 0x69, 0x2e, 0x76, 0x31, 0x2e,
+	0x46, 0x61, 0x63, 0x74, 0x75, 0x72, 0x61, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x52,
+	0x07, 0x66, 0x61, 0x63, 0x74, 0x75, 0x72, 0x61, 0x12, 0x14, 0x0a, 0x05, 0x66, 0x6f, 0x6c, 0x69,
+	0x6f, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x66, 0x6f, 0x6c, 0x69, 0x6f, 0x12, 0x20,
+	0x0a, 0x0b, 0x63, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74, 0x61, 0x64, 0x61, 0x73, 0x18, 0x07, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x0b, 0x63, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x74, 0x61, 0x64, 0x61, 0x73,
+	0x12, 0x14, 0x0a, 0x05, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x18, 0x08, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x05, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x12, 0x1c, 0x0a, 0x09, 0x72, 0x65, 0x63, 0x68, 0x61, 0x7a,
+	0x61, 0x64, 0x6f, 0x18, 0x09, 0x20, 0x01, 0x28, 0x08, 0x52, 0x09, 0x72, 0x65, 0x63, 0x68, 0x61,
+	0x7a, 0x61, 0x64, 0x6f, 0x22, 0xec, 0x01, 0x0a, 0x0d, 0x43, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x75, 0x75, 0x69, 0x64, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x75, 0x75, 0x69, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x72, 0x66,
+	0x63, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x72, 0x66, 0x63, 0x12, 0x16, 0x0a, 0x06,
+	0x6d, 0x6f, 0x74, 0x69, 0x76, 0x6f, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x6d, 0x6f,
+	0x74, 0x69, 0x76, 0x6f, 0x12, 0x25, 0x0a, 0x0e, 0x75, 0x75, 0x69, 0x64, 0x5f, 0x72, 0x65, 0x65,
+	0x6d, 0x70, 0x6c, 0x61, 0x7a, 0x6f, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x75, 0x75,
+	0x69, 0x64, 0x52, 0x65, 0x65, 0x6d, 0x70, 0x6c, 0x61, 0x7a, 0x6f, 0x12, 0x19, 0x0a, 0x08, 0x63,
+	0x65, 0x72, 0x74, 0x5f, 0x62, 0x36, 0x34, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x63,
+	0x65, 0x72, 0x74, 0x42, 0x36, 0x34, 0x12, 0x1b, 0x0a, 0x09, 0x6b, 0x65, 0x79, 0x5f, 0x62, 0x79,
+	0x74, 0x65, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x08, 0x6b, 0x65, 0x79, 0x42, 0x79,
+	0x74, 0x65, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x6b, 0x65, 0x79, 0x5f, 0x70, 0x61, 0x73, 0x73, 0x77,
+	0x6f, 0x72, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x6b, 0x65, 0x79, 0x50, 0x61,
+	0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74,
+	0x5f, 0x69, 0x64, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x74, 0x65, 0x6e, 0x61, 0x6e,
+	0x74, 0x49, 0x64, 0x22, 0x8a, 0x01, 0x0a, 0x0e, 0x43, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x52, 0x65,
 	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73,
 	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x12,
 	0x0a, 0x04, 0x75, 0x75, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x75, 0x75,
-	0x69, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x65, 0x6c, 0x6c, 0x6f, 0x5f, 0x73, 0x61, 0x74, 0x18,
-	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x65, 0x6c, 0x6c, 0x6f, 0x53, 0x61, 0x74, 0x12,
-	0x1b, 0x0a, 0x09, 0x70, 0x61, 0x63, 0x5f, 0x75, 0x73, 0x61, 0x64, 0x6f, 0x18, 0x04, 0x20, 0x01,
-	0x28, 0x05, 0x52, 0x08, 0x70, 0x61, 0x63, 0x55, 0x73, 0x61, 0x64, 0x6f, 0x12, 0x1c, 0x0a, 0x09,
-	0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52,
-	0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x22, 0xec, 0x01, 0x0a, 0x0d, 0x43,
-	0x61, 0x6e, 0x63, 0x65, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04,
-	0x75, 0x75, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x75, 0x75, 0x69, 0x64,
-	0x12, 0x10, 0x0a, 0x03, 0x72, 0x66, 0x63, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x72,
-	0x66, 0x63, 0x12, 0x16, 0x0a, 0x06, 0x6d, 0x6f, 0x74, 0x69, 0x76, 0x6f, 0x18, 0x03, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x06, 0x6d, 0x6f, 0x74, 0x69, 0x76, 0x6f, 0x12, 0x25, 0x0a, 0x0e, 0x75, 0x75,
-	0x69, 0x64, 0x5f, 0x72, 0x65, 0x65, 0x6d, 0x70, 0x6c, 0x61, 0x7a, 0x6f, 0x18, 0x04, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x0d, 0x75, 0x75, 0x69, 0x64, 0x52, 0x65, 0x65, 0x6d, 0x70, 0x6c, 0x61, 0x7a,
-	0x6f, 0x12, 0x19, 0x0a, 0x08, 0x63, 0x65, 0x72, 0x74, 0x5f, 0x62, 0x36, 0x34, 0x18, 0x05, 0x20,
-	0x01, 0x28, 0x09, 0x52, 0x07, 0x63, 0x65, 0x72, 0x74, 0x42, 0x36, 0x34, 0x12, 0x1b, 0x0a, 0x09,
-	0x6b, 0x65, 0x79, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0c, 0x52,
-	0x08, 0x6b, 0x65, 0x79, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x6b, 0x65, 0x79,
-	0x5f, 0x70, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52,
-	0x0b, 0x6b, 0x65, 0x79, 0x50, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x12, 0x1b, 0x0a, 0x09,
-	0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52,
-	0x08, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x49, 0x64, 0x22, 0x8a, 0x01, 0x0a, 0x0e, 0x43, 0x61,
-	0x6e, 0x63, 0x65, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x16, 0x0a, 0x06,
-	0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x73, 0x74,
-	0x61, 0x74, 0x75, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x75, 0x75, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x04, 0x75, 0x75, 0x69, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x61, 0x63, 0x75, 0x73,
-	0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x61, 0x63, 0x75, 0x73, 0x65, 0x12, 0x18,
-	0x0a, 0x07, 0x6d, 0x65, 0x6e, 0x73, 0x61, 0x6a, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52,
-	0x07, 0x6d, 0x65, 0x6e, 0x73, 0x61, 0x6a, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65,
-	0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x74, 0x69, 0x6d,
-	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x32, 0x8c, 0x01, 0x0a, 0x0b, 0x43, 0x46, 0x44, 0x49, 0x53,
-	0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x3e, 0x0a, 0x07, 0x54, 0x69, 0x6d, 0x62, 0x72, 0x61,
-	0x72, 0x12, 0x17, 0x2e, 0x63, 0x66, 0x64, 0x69, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x61, 0x63, 0x74,
-	0x75, 0x72, 0x61, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x18, 0x2e, 0x63, 0x66, 0x64,
-	0x69, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x61, 0x63, 0x74, 0x75, 0x72, 0x61, 0x52, 0x65, 0x73, 0x70,
-	0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x3d, 0x0a, 0x08, 0x43, 0x61, 0x6e, 0x63, 0x65, 0x6c,
-	0x61, 0x72, 0x12, 0x16, 0x2e, 0x63, 0x66, 0x64, 0x69, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x61, 0x6e,
-	0x63, 0x65, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x17, 0x2e, 0x63, 0x66, 0x64,
-	0x69, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f,
-	0x6e, 0x73, 0x65, 0x22, 0x00, 0x42, 0x3a, 0x5a, 0x38, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e,
-	0x63, 0x6f, 0x6d, 0x2f, 0x74, 0x75, 0x72, 0x62, 0x6f, 0x70, 0x6f, 0x73, 0x2f, 0x74, 0x75, 0x72,
-	0x62, 0x6f, 0x70, 0x6f, 0x73, 0x2f, 0x67, 0x65, 0x6e, 0x2f, 0x67, 0x6f, 0x2f, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x2f, 0x63, 0x66, 0x64, 0x69, 0x2f, 0x76, 0x31, 0x3b, 0x63, 0x66, 0x64, 0x69, 0x76,
-	0x31, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x69, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x61, 0x63, 0x75, 0x73, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x61, 0x63, 0x75, 0x73, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x65, 0x6e, 0x73,
+	0x61, 0x6a, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65, 0x6e, 0x73, 0x61,
+	0x6a, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x22, 0xaa, 0x01, 0x0a, 0x11, 0x56, 0x61, 0x6c, 0x69, 0x64, 0x61, 0x72, 0x43, 0x53, 0x44, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x63, 0x65, 0x72, 0x74, 0x5f, 0x62,
+	0x36, 0x34, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x63, 0x65, 0x72, 0x74, 0x42, 0x36,
+	0x34, 0x12, 0x1b, 0x0a, 0x09, 0x6b, 0x65, 0x79, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x08, 0x6b, 0x65, 0x79, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x21,
+	0x0a, 0x0c, 0x6b, 0x65, 0x79, 0x5f, 0x70, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x6b, 0x65, 0x79, 0x50, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72,
+	0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x72, 0x66, 0x63, 0x5f, 0x65, 0x6d, 0x69, 0x73, 0x6f, 0x72, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x72, 0x66, 0x63, 0x45, 0x6d, 0x69, 0x73, 0x6f, 0x72,
+	0x12, 0x1b, 0x0a, 0x09, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x08, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x49, 0x64, 0x22, 0xfe, 0x01,
+	0x0a, 0x07, 0x43, 0x53, 0x44, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x10, 0x0a, 0x03, 0x72, 0x66, 0x63,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x72, 0x66, 0x63, 0x12, 0x16, 0x0a, 0x06, 0x6e,
+	0x6f, 0x6d, 0x62, 0x72, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x6e, 0x6f, 0x6d,
+	0x62, 0x72, 0x65, 0x12, 0x25, 0x0a, 0x0e, 0x6e, 0x6f, 0x5f, 0x63, 0x65, 0x72, 0x74, 0x69, 0x66,
+	0x69, 0x63, 0x61, 0x64, 0x6f, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x6e, 0x6f, 0x43,
+	0x65, 0x72, 0x74, 0x69, 0x66, 0x69, 0x63, 0x61, 0x64, 0x6f, 0x12, 0x21, 0x0a, 0x0c, 0x76, 0x61,
+	0x6c, 0x69, 0x64, 0x6f, 0x5f, 0x64, 0x65, 0x73, 0x64, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x0b, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x6f, 0x44, 0x65, 0x73, 0x64, 0x65, 0x12, 0x21, 0x0a,
+	0x0c, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x6f, 0x5f, 0x68, 0x61, 0x73, 0x74, 0x61, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x0b, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x6f, 0x48, 0x61, 0x73, 0x74, 0x61,
+	0x12, 0x2a, 0x0a, 0x11, 0x6b, 0x65, 0x79, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x5f, 0x63, 0x69,
+	0x66, 0x72, 0x61, 0x64, 0x61, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0f, 0x6b, 0x65, 0x79,
+	0x42, 0x79, 0x74, 0x65, 0x73, 0x43, 0x69, 0x66, 0x72, 0x61, 0x64, 0x61, 0x12, 0x30, 0x0a, 0x14,
+	0x6b, 0x65, 0x79, 0x5f, 0x70, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x5f, 0x63, 0x69, 0x66,
+	0x72, 0x61, 0x64, 0x61, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x12, 0x6b, 0x65, 0x79, 0x50,
+	0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x43, 0x69, 0x66, 0x72, 0x61, 0x64, 0x61, 0x32, 0x98,
+	0x02, 0x0a, 0x0b, 0x43, 0x46, 0x44, 0x49, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x3e,
+	0x0a, 0x07, 0x54, 0x69, 0x6d, 0x62, 0x72, 0x61, 0x72, 0x12, 0x17, 0x2e, 0x63, 0x66, 0x64, 0x69,
+	0x2e, 0x76, 0x31, 0x2e, 0x46, 0x61, 0x63, 0x74, 0x75, 0x72, 0x61, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x18, 0x2e, 0x63, 0x66, 0x64, 0x69, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x61, 0x63,
+	0x74, 0x75, 0x72, 0x61, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x3d,
+	0x0a, 0x08, 0x43, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x61, 0x72, 0x12, 0x16, 0x2e, 0x63, 0x66, 0x64,
+	0x69, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x17, 0x2e, 0x63, 0x66, 0x64, 0x69, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x61, 0x6e,
+	0x63, 0x65, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x3c, 0x0a,
+	0x0a, 0x56, 0x61, 0x6c, 0x69, 0x64, 0x61, 0x72, 0x43, 0x53, 0x44, 0x12, 0x1a, 0x2e, 0x63, 0x66,
+	0x64, 0x69, 0x2e, 0x76, 0x31, 0x2e, 0x56, 0x61, 0x6c, 0x69, 0x64, 0x61, 0x72, 0x43, 0x53, 0x44,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x10, 0x2e, 0x63, 0x66, 0x64, 0x69, 0x2e, 0x76,
+	0x31, 0x2e, 0x43, 0x53, 0x44, 0x49, 0x6e, 0x66, 0x6f, 0x22, 0x00, 0x12, 0x4c, 0x0a, 0x0b, 0x54,
+	0x69, 0x6d, 0x62, 0x72, 0x61, 0x72, 0x4c, 0x6f, 0x74, 0x65, 0x12, 0x1b, 0x2e, 0x63, 0x66, 0x64,
+	0x69, 0x2e, 0x76, 0x31, 0x2e, 0x54, 0x69, 0x6d, 0x62, 0x72, 0x61, 0x72, 0x4c, 0x6f, 0x74, 0x65,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x63, 0x66, 0x64, 0x69, 0x2e, 0x76,
+	0x31, 0x2e, 0x54, 0x69, 0x6d, 0x62, 0x72, 0x61, 0x72, 0x4c, 0x6f, 0x74, 0x65, 0x50, 0x72, 0x6f,
+	0x67, 0x72, 0x65, 0x73, 0x6f, 0x22, 0x00, 0x30, 0x01, 0x42, 0x3a, 0x5a, 0x38, 0x67, 0x69, 0x74,
+	0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x74, 0x75, 0x72, 0x62, 0x6f, 0x70, 0x6f, 0x73,
+	0x2f, 0x74, 0x75, 0x72, 0x62, 0x6f, 0x70, 0x6f, 0x73, 0x2f, 0x67, 0x65, 0x6e, 0x2f, 0x67, 0x6f,
+	0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x63, 0x66, 0x64, 0x69, 0x2f, 0x76, 0x31, 0x3b, 0x63,
+	0x66, 0x64, 0x69, 0x76, 0x31, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -527,23 +1172,35 @@ func file_proto_cfdi_v1_cfdi_proto_rawDescGZIP() []byte {
 	return file_proto_cfdi_v1_cfdi_proto_rawDescData
 }
 
-var file_proto_cfdi_v1_cfdi_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_proto_cfdi_v1_cfdi_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_proto_cfdi_v1_cfdi_proto_goTypes = []interface{}{
-	(*FacturaRequest)(nil),  // 0: cfdi.v1.FacturaRequest
-	(*FacturaResponse)(nil), // 1: cfdi.v1.FacturaResponse
-	(*CancelRequest)(nil),   // 2: cfdi.v1.CancelRequest
-	(*CancelResponse)(nil),  // 3: cfdi.v1.CancelResponse
+	(*FacturaRequest)(nil),      // 0: cfdi.v1.FacturaRequest
+	(*ConceptoFactura)(nil),     // 1: cfdi.v1.ConceptoFactura
+	(*FacturaResponse)(nil),     // 2: cfdi.v1.FacturaResponse
+	(*TimbrarLoteRequest)(nil),  // 3: cfdi.v1.TimbrarLoteRequest
+	(*TimbrarLoteProgreso)(nil), // 4: cfdi.v1.TimbrarLoteProgreso
+	(*CancelRequest)(nil),       // 5: cfdi.v1.CancelRequest
+	(*CancelResponse)(nil),      // 6: cfdi.v1.CancelResponse
+	(*ValidarCSDRequest)(nil),   // 7: cfdi.v1.ValidarCSDRequest
+	(*CSDInfo)(nil),             // 8: cfdi.v1.CSDInfo
 }
 var file_proto_cfdi_v1_cfdi_proto_depIdxs = []int32{
-	0, // 0: cfdi.v1.CFDIService.Timbrar:input_type -> cfdi.v1.FacturaRequest
-	2, // 1: cfdi.v1.CFDIService.Cancelar:input_type -> cfdi.v1.CancelRequest
-	1, // 2: cfdi.v1.CFDIService.Timbrar:output_type -> cfdi.v1.FacturaResponse
-	3, // 3: cfdi.v1.CFDIService.Cancelar:output_type -> cfdi.v1.CancelResponse
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1, // 0: cfdi.v1.FacturaRequest.conceptos:type_name -> cfdi.v1.ConceptoFactura
+	0, // 1: cfdi.v1.TimbrarLoteRequest.facturas:type_name -> cfdi.v1.FacturaRequest
+	2, // 2: cfdi.v1.TimbrarLoteProgreso.factura:type_name -> cfdi.v1.FacturaResponse
+	0, // 3: cfdi.v1.CFDIService.Timbrar:input_type -> cfdi.v1.FacturaRequest
+	5, // 4: cfdi.v1.CFDIService.Cancelar:input_type -> cfdi.v1.CancelRequest
+	7, // 5: cfdi.v1.CFDIService.ValidarCSD:input_type -> cfdi.v1.ValidarCSDRequest
+	3, // 6: cfdi.v1.CFDIService.TimbrarLote:input_type -> cfdi.v1.TimbrarLoteRequest
+	2, // 7: cfdi.v1.CFDIService.Timbrar:output_type -> cfdi.v1.FacturaResponse
+	6, // 8: cfdi.v1.CFDIService.Cancelar:output_type -> cfdi.v1.CancelResponse
+	8, // 9: cfdi.v1.CFDIService.ValidarCSD:output_type -> cfdi.v1.CSDInfo
+	4, // 10: cfdi.v1.CFDIService.TimbrarLote:output_type -> cfdi.v1.TimbrarLoteProgreso
+	7, // [7:11] is the sub-list for method output_type
+	3, // [3:7] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_proto_cfdi_v1_cfdi_proto_init() }
@@ -565,7 +1222,7 @@ func file_proto_cfdi_v1_cfdi_proto_init() {
 			}
 		}
 		file_proto_cfdi_v1_cfdi_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*FacturaResponse); i {
+			switch v := v.(*ConceptoFactura); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -577,7 +1234,7 @@ func file_proto_cfdi_v1_cfdi_proto_init() {
 			}
 		}
 		file_proto_cfdi_v1_cfdi_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*CancelRequest); i {
+			switch v := v.(*FacturaResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -589,6 +1246,42 @@ func file_proto_cfdi_v1_cfdi_proto_init() {
 			}
 		}
 		file_proto_cfdi_v1_cfdi_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TimbrarLoteRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_cfdi_v1_cfdi_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TimbrarLoteProgreso); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_cfdi_v1_cfdi_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CancelRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_cfdi_v1_cfdi_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*CancelResponse); i {
 			case 0:
 				return &v.state
@@ -600,6 +1293,30 @@ func file_proto_cfdi_v1_cfdi_proto_init() {
 				return nil
 			}
 		}
+		file_proto_cfdi_v1_cfdi_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ValidarCSDRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_cfdi_v1_cfdi_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CSDInfo); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -607,7 +1324,7 @@ func file_proto_cfdi_v1_cfdi_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_proto_cfdi_v1_cfdi_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   4,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -19,8 +19,10 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	CFDIService_Timbrar_FullMethodName  = "/cfdi.v1.CFDIService/Timbrar"
-	CFDIService_Cancelar_FullMethodName = "/cfdi.v1.CFDIService/Cancelar"
+	CFDIService_Timbrar_FullMethodName     = "/cfdi.v1.CFDIService/Timbrar"
+	CFDIService_Cancelar_FullMethodName    = "/cfdi.v1.CFDIService/Cancelar"
+	CFDIService_ValidarCSD_FullMethodName  = "/cfdi.v1.CFDIService/ValidarCSD"
+	CFDIService_TimbrarLote_FullMethodName = "/cfdi.v1.CFDIService/TimbrarLote"
 )
 
 // CFDIServiceClient is the client API for CFDIService service.
@@ -29,6 +31,9 @@ const (
 type CFDIServiceClient interface {
 	Timbrar(ctx context.Context, in *FacturaRequest, opts ...grpc.CallOption) (*FacturaResponse, error)
 	Cancelar(ctx context.Context, in *CancelRequest, opts ...grpc.CallOption) (*CancelResponse, error)
+	ValidarCSD(ctx context.Context, in *ValidarCSDRequest, opts ...grpc.CallOption) (*CSDInfo, error)
+	// TimbrarLote timbra varias ventas y devuelve el resultado de cada una conforme termina
+	TimbrarLote(ctx context.Context, in *TimbrarLoteRequest, opts ...grpc.CallOption) (CFDIService_TimbrarLoteClient, error)
 }
 
 type cFDIServiceClient struct {
@@ -57,12 +62,56 @@ func (c *cFDIServiceClient) Cancelar(ctx context.Context, in *CancelRequest, opt
 	return out, nil
 }
 
+func (c *cFDIServiceClient) ValidarCSD(ctx context.Context, in *ValidarCSDRequest, opts ...grpc.CallOption) (*CSDInfo, error) {
+	out := new(CSDInfo)
+	err := c.cc.Invoke(ctx, CFDIService_ValidarCSD_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *cFDIServiceClient) TimbrarLote(ctx context.Context, in *TimbrarLoteRequest, opts ...grpc.CallOption) (CFDIService_TimbrarLoteClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CFDIService_ServiceDesc.Streams[0], CFDIService_TimbrarLote_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &cFDIServiceTimbrarLoteClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CFDIService_TimbrarLoteClient interface {
+	Recv() (*TimbrarLoteProgreso, error)
+	grpc.ClientStream
+}
+
+type cFDIServiceTimbrarLoteClient struct {
+	grpc.ClientStream
+}
+
+func (x *cFDIServiceTimbrarLoteClient) Recv() (*TimbrarLoteProgreso, error) {
+	m := new(TimbrarLoteProgreso)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // CFDIServiceServer is the server API for CFDIService service.
 // All implementations must embed UnimplementedCFDIServiceServer
 // for forward compatibility
 type CFDIServiceServer interface {
 	Timbrar(context.Context, *FacturaRequest) (*FacturaResponse, error)
 	Cancelar(context.Context, *CancelRequest) (*CancelResponse, error)
+	ValidarCSD(context.Context, *ValidarCSDRequest) (*CSDInfo, error)
+	// TimbrarLote timbra varias ventas y devuelve el resultado de cada una conforme termina
+	TimbrarLote(*TimbrarLoteRequest, CFDIService_TimbrarLoteServer) error
 	mustEmbedUnimplementedCFDIServiceServer()
 }
 
@@ -76,6 +125,12 @@ func (UnimplementedCFDIServiceServer) Timbrar(context.Context, *FacturaRequest)
 func (UnimplementedCFDIServiceServer) Cancelar(context.Context, *CancelRequest) (*CancelResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Cancelar not implemented")
 }
+func (UnimplementedCFDIServiceServer) ValidarCSD(context.Context, *ValidarCSDRequest) (*CSDInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ValidarCSD not implemented")
+}
+func (UnimplementedCFDIServiceServer) TimbrarLote(*TimbrarLoteRequest, CFDIService_TimbrarLoteServer) error {
+	return status.Errorf(codes.Unimplemented, "method TimbrarLote not implemented")
+}
 func (UnimplementedCFDIServiceServer) mustEmbedUnimplementedCFDIServiceServer() {}
 
 // UnsafeCFDIServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -125,6 +180,45 @@ func _CFDIService_Cancelar_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CFDIService_ValidarCSD_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ValidarCSDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CFDIServiceServer).ValidarCSD(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CFDIService_ValidarCSD_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CFDIServiceServer).ValidarCSD(ctx, req.(*ValidarCSDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CFDIService_TimbrarLote_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TimbrarLoteRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CFDIServiceServer).TimbrarLote(m, &cFDIServiceTimbrarLoteServer{stream})
+}
+
+type CFDIService_TimbrarLoteServer interface {
+	Send(*TimbrarLoteProgreso) error
+	grpc.ServerStream
+}
+
+type cFDIServiceTimbrarLoteServer struct {
+	grpc.ServerStream
+}
+
+func (x *cFDIServiceTimbrarLoteServer) Send(m *TimbrarLoteProgreso) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // CFDIService_ServiceDesc is the grpc.ServiceDesc for CFDIService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -140,7 +234,17 @@ var CFDIService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Cancelar",
 			Handler:    _CFDIService_Cancelar_Handler,
 		},
+		{
+			MethodName: "ValidarCSD",
+			Handler:    _CFDIService_ValidarCSD_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "TimbrarLote",
+			Handler:       _CFDIService_TimbrarLote_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/cfdi/v1/cfdi.proto",
 }
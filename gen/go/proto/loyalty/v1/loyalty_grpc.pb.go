@@ -19,10 +19,23 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	LoyaltyService_GetAccount_FullMethodName   = "/loyalty.v1.LoyaltyService/GetAccount"
-	LoyaltyService_EarnPoints_FullMethodName   = "/loyalty.v1.LoyaltyService/EarnPoints"
-	LoyaltyService_RedeemPoints_FullMethodName = "/loyalty.v1.LoyaltyService/RedeemPoints"
-	LoyaltyService_GetHistory_FullMethodName   = "/loyalty.v1.LoyaltyService/GetHistory"
+	LoyaltyService_GetAccount_FullMethodName        = "/loyalty.v1.LoyaltyService/GetAccount"
+	LoyaltyService_CreateAccount_FullMethodName     = "/loyalty.v1.LoyaltyService/CreateAccount"
+	LoyaltyService_EarnPoints_FullMethodName        = "/loyalty.v1.LoyaltyService/EarnPoints"
+	LoyaltyService_RedeemPoints_FullMethodName      = "/loyalty.v1.LoyaltyService/RedeemPoints"
+	LoyaltyService_GetHistory_FullMethodName        = "/loyalty.v1.LoyaltyService/GetHistory"
+	LoyaltyService_ListRewards_FullMethodName       = "/loyalty.v1.LoyaltyService/ListRewards"
+	LoyaltyService_CreateReward_FullMethodName      = "/loyalty.v1.LoyaltyService/CreateReward"
+	LoyaltyService_UpdateReward_FullMethodName      = "/loyalty.v1.LoyaltyService/UpdateReward"
+	LoyaltyService_RetireReward_FullMethodName      = "/loyalty.v1.LoyaltyService/RetireReward"
+	LoyaltyService_RedeemReward_FullMethodName      = "/loyalty.v1.LoyaltyService/RedeemReward"
+	LoyaltyService_GetExpiringPoints_FullMethodName = "/loyalty.v1.LoyaltyService/GetExpiringPoints"
+	LoyaltyService_ReversePoints_FullMethodName     = "/loyalty.v1.LoyaltyService/ReversePoints"
+	LoyaltyService_GetReferralCode_FullMethodName   = "/loyalty.v1.LoyaltyService/GetReferralCode"
+	LoyaltyService_RegisterReferral_FullMethodName  = "/loyalty.v1.LoyaltyService/RegisterReferral"
+	LoyaltyService_GetReferralStats_FullMethodName  = "/loyalty.v1.LoyaltyService/GetReferralStats"
+	LoyaltyService_FindDuplicates_FullMethodName    = "/loyalty.v1.LoyaltyService/FindDuplicates"
+	LoyaltyService_MergeAccounts_FullMethodName     = "/loyalty.v1.LoyaltyService/MergeAccounts"
 )
 
 // LoyaltyServiceClient is the client API for LoyaltyService service.
@@ -30,9 +43,35 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type LoyaltyServiceClient interface {
 	GetAccount(ctx context.Context, in *GetAccountRequest, opts ...grpc.CallOption) (*AccountResponse, error)
+	// Alta de un cliente sin compra (importaciones, registro en caja); no da puntos
+	CreateAccount(ctx context.Context, in *CreateAccountRequest, opts ...grpc.CallOption) (*AccountResponse, error)
 	EarnPoints(ctx context.Context, in *EarnPointsRequest, opts ...grpc.CallOption) (*AccountResponse, error)
 	RedeemPoints(ctx context.Context, in *RedeemPointsRequest, opts ...grpc.CallOption) (*RedeemResponse, error)
 	GetHistory(ctx context.Context, in *GetHistoryRequest, opts ...grpc.CallOption) (*HistoryResponse, error)
+	// Catálogo de recompensas del programa de lealtad del tenant
+	ListRewards(ctx context.Context, in *ListRewardsRequest, opts ...grpc.CallOption) (*ListRewardsResponse, error)
+	CreateReward(ctx context.Context, in *Reward, opts ...grpc.CallOption) (*Reward, error)
+	UpdateReward(ctx context.Context, in *Reward, opts ...grpc.CallOption) (*Reward, error)
+	RetireReward(ctx context.Context, in *RetireRewardRequest, opts ...grpc.CallOption) (*Reward, error)
+	// Canjea una recompensa: descuenta puntos y stock y devuelve el código que aplica la caja
+	RedeemReward(ctx context.Context, in *RedeemRewardRequest, opts ...grpc.CallOption) (*RedeemRewardResponse, error)
+	// Clientes con puntos que vencen en los próximos días, para campañas de marketing
+	GetExpiringPoints(ctx context.Context, in *ExpiringPointsRequest, opts ...grpc.CallOption) (*ExpiringPointsResponse, error)
+	// Quita los puntos y el gasto que dio una venta cancelada o devuelta. Es idempotente:
+	// repetir la misma referencia no vuelve a descontar.
+	ReversePoints(ctx context.Context, in *ReversePointsRequest, opts ...grpc.CallOption) (*ReversePointsResponse, error)
+	// Programa de referidos. Los bonos se pagan en EarnPoints con la primera compra del
+	// cliente referido, si llega a la compra mínima y el que refiere no pasó su tope mensual.
+	GetReferralCode(ctx context.Context, in *GetReferralCodeRequest, opts ...grpc.CallOption) (*ReferralCodeResponse, error)
+	// Da de alta (o liga, si aún no compra) un cliente con el código de otro
+	RegisterReferral(ctx context.Context, in *RegisterReferralRequest, opts ...grpc.CallOption) (*RegisterReferralResponse, error)
+	GetReferralStats(ctx context.Context, in *ReferralStatsRequest, opts ...grpc.CallOption) (*ReferralStatsResponse, error)
+	// Cuentas que parecen el mismo cliente (mismo RFC, correo o nombre casi igual)
+	FindDuplicates(ctx context.Context, in *FindDuplicatesRequest, opts ...grpc.CallOption) (*FindDuplicatesResponse, error)
+	// Fusiona cuentas en una superviviente: mueve movimientos, ventas, lotes, canjes y referidos,
+	// suma puntos y gasto, completa los datos fiscales vacíos y deja bitácora. Los teléfonos
+	// de las cuentas absorbidas siguen funcionando y apuntan a la superviviente.
+	MergeAccounts(ctx context.Context, in *MergeAccountsRequest, opts ...grpc.CallOption) (*MergeAccountsResponse, error)
 }
 
 type loyaltyServiceClient struct {
@@ -52,6 +91,15 @@ func (c *loyaltyServiceClient) GetAccount(ctx context.Context, in *GetAccountReq
 	return out, nil
 }
 
+func (c *loyaltyServiceClient) CreateAccount(ctx context.Context, in *CreateAccountRequest, opts ...grpc.CallOption) (*AccountResponse, error) {
+	out := new(AccountResponse)
+	err := c.cc.Invoke(ctx, LoyaltyService_CreateAccount_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *loyaltyServiceClient) EarnPoints(ctx context.Context, in *EarnPointsRequest, opts ...grpc.CallOption) (*AccountResponse, error) {
 	out := new(AccountResponse)
 	err := c.cc.Invoke(ctx, LoyaltyService_EarnPoints_FullMethodName, in, out, opts...)
@@ -79,14 +127,148 @@ func (c *loyaltyServiceClient) GetHistory(ctx context.Context, in *GetHistoryReq
 	return out, nil
 }
 
+func (c *loyaltyServiceClient) ListRewards(ctx context.Context, in *ListRewardsRequest, opts ...grpc.CallOption) (*ListRewardsResponse, error) {
+	out := new(ListRewardsResponse)
+	err := c.cc.Invoke(ctx, LoyaltyService_ListRewards_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *loyaltyServiceClient) CreateReward(ctx context.Context, in *Reward, opts ...grpc.CallOption) (*Reward, error) {
+	out := new(Reward)
+	err := c.cc.Invoke(ctx, LoyaltyService_CreateReward_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *loyaltyServiceClient) UpdateReward(ctx context.Context, in *Reward, opts ...grpc.CallOption) (*Reward, error) {
+	out := new(Reward)
+	err := c.cc.Invoke(ctx, LoyaltyService_UpdateReward_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *loyaltyServiceClient) RetireReward(ctx context.Context, in *RetireRewardRequest, opts ...grpc.CallOption) (*Reward, error) {
+	out := new(Reward)
+	err := c.cc.Invoke(ctx, LoyaltyService_RetireReward_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *loyaltyServiceClient) RedeemReward(ctx context.Context, in *RedeemRewardRequest, opts ...grpc.CallOption) (*RedeemRewardResponse, error) {
+	out := new(RedeemRewardResponse)
+	err := c.cc.Invoke(ctx, LoyaltyService_RedeemReward_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *loyaltyServiceClient) GetExpiringPoints(ctx context.Context, in *ExpiringPointsRequest, opts ...grpc.CallOption) (*ExpiringPointsResponse, error) {
+	out := new(ExpiringPointsResponse)
+	err := c.cc.Invoke(ctx, LoyaltyService_GetExpiringPoints_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *loyaltyServiceClient) ReversePoints(ctx context.Context, in *ReversePointsRequest, opts ...grpc.CallOption) (*ReversePointsResponse, error) {
+	out := new(ReversePointsResponse)
+	err := c.cc.Invoke(ctx, LoyaltyService_ReversePoints_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *loyaltyServiceClient) GetReferralCode(ctx context.Context, in *GetReferralCodeRequest, opts ...grpc.CallOption) (*ReferralCodeResponse, error) {
+	out := new(ReferralCodeResponse)
+	err := c.cc.Invoke(ctx, LoyaltyService_GetReferralCode_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *loyaltyServiceClient) RegisterReferral(ctx context.Context, in *RegisterReferralRequest, opts ...grpc.CallOption) (*RegisterReferralResponse, error) {
+	out := new(RegisterReferralResponse)
+	err := c.cc.Invoke(ctx, LoyaltyService_RegisterReferral_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *loyaltyServiceClient) GetReferralStats(ctx context.Context, in *ReferralStatsRequest, opts ...grpc.CallOption) (*ReferralStatsResponse, error) {
+	out := new(ReferralStatsResponse)
+	err := c.cc.Invoke(ctx, LoyaltyService_GetReferralStats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *loyaltyServiceClient) FindDuplicates(ctx context.Context, in *FindDuplicatesRequest, opts ...grpc.CallOption) (*FindDuplicatesResponse, error) {
+	out := new(FindDuplicatesResponse)
+	err := c.cc.Invoke(ctx, LoyaltyService_FindDuplicates_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *loyaltyServiceClient) MergeAccounts(ctx context.Context, in *MergeAccountsRequest, opts ...grpc.CallOption) (*MergeAccountsResponse, error) {
+	out := new(MergeAccountsResponse)
+	err := c.cc.Invoke(ctx, LoyaltyService_MergeAccounts_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // LoyaltyServiceServer is the server API for LoyaltyService service.
 // All implementations must embed UnimplementedLoyaltyServiceServer
 // for forward compatibility
 type LoyaltyServiceServer interface {
 	GetAccount(context.Context, *GetAccountRequest) (*AccountResponse, error)
+	// Alta de un cliente sin compra (importaciones, registro en caja); no da puntos
+	CreateAccount(context.Context, *CreateAccountRequest) (*AccountResponse, error)
 	EarnPoints(context.Context, *EarnPointsRequest) (*AccountResponse, error)
 	RedeemPoints(context.Context, *RedeemPointsRequest) (*RedeemResponse, error)
 	GetHistory(context.Context, *GetHistoryRequest) (*HistoryResponse, error)
+	// Catálogo de recompensas del programa de lealtad del tenant
+	ListRewards(context.Context, *ListRewardsRequest) (*ListRewardsResponse, error)
+	CreateReward(context.Context, *Reward) (*Reward, error)
+	UpdateReward(context.Context, *Reward) (*Reward, error)
+	RetireReward(context.Context, *RetireRewardRequest) (*Reward, error)
+	// Canjea una recompensa: descuenta puntos y stock y devuelve el código que aplica la caja
+	RedeemReward(context.Context, *RedeemRewardRequest) (*RedeemRewardResponse, error)
+	// Clientes con puntos que vencen en los próximos días, para campañas de marketing
+	GetExpiringPoints(context.Context, *ExpiringPointsRequest) (*ExpiringPointsResponse, error)
+	// Quita los puntos y el gasto que dio una venta cancelada o devuelta. Es idempotente:
+	// repetir la misma referencia no vuelve a descontar.
+	ReversePoints(context.Context, *ReversePointsRequest) (*ReversePointsResponse, error)
+	// Programa de referidos. Los bonos se pagan en EarnPoints con la primera compra del
+	// cliente referido, si llega a la compra mínima y el que refiere no pasó su tope mensual.
+	GetReferralCode(context.Context, *GetReferralCodeRequest) (*ReferralCodeResponse, error)
+	// Da de alta (o liga, si aún no compra) un cliente con el código de otro
+	RegisterReferral(context.Context, *RegisterReferralRequest) (*RegisterReferralResponse, error)
+	GetReferralStats(context.Context, *ReferralStatsRequest) (*ReferralStatsResponse, error)
+	// Cuentas que parecen el mismo cliente (mismo RFC, correo o nombre casi igual)
+	FindDuplicates(context.Context, *FindDuplicatesRequest) (*FindDuplicatesResponse, error)
+	// Fusiona cuentas en una superviviente: mueve movimientos, ventas, lotes, canjes y referidos,
+	// suma puntos y gasto, completa los datos fiscales vacíos y deja bitácora. Los teléfonos
+	// de las cuentas absorbidas siguen funcionando y apuntan a la superviviente.
+	MergeAccounts(context.Context, *MergeAccountsRequest) (*MergeAccountsResponse, error)
 	mustEmbedUnimplementedLoyaltyServiceServer()
 }
 
@@ -97,6 +279,9 @@ type UnimplementedLoyaltyServiceServer struct {
 func (UnimplementedLoyaltyServiceServer) GetAccount(context.Context, *GetAccountRequest) (*AccountResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetAccount not implemented")
 }
+func (UnimplementedLoyaltyServiceServer) CreateAccount(context.Context, *CreateAccountRequest) (*AccountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateAccount not implemented")
+}
 func (UnimplementedLoyaltyServiceServer) EarnPoints(context.Context, *EarnPointsRequest) (*AccountResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method EarnPoints not implemented")
 }
@@ -106,6 +291,42 @@ func (UnimplementedLoyaltyServiceServer) RedeemPoints(context.Context, *RedeemPo
 func (UnimplementedLoyaltyServiceServer) GetHistory(context.Context, *GetHistoryRequest) (*HistoryResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetHistory not implemented")
 }
+func (UnimplementedLoyaltyServiceServer) ListRewards(context.Context, *ListRewardsRequest) (*ListRewardsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRewards not implemented")
+}
+func (UnimplementedLoyaltyServiceServer) CreateReward(context.Context, *Reward) (*Reward, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateReward not implemented")
+}
+func (UnimplementedLoyaltyServiceServer) UpdateReward(context.Context, *Reward) (*Reward, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateReward not implemented")
+}
+func (UnimplementedLoyaltyServiceServer) RetireReward(context.Context, *RetireRewardRequest) (*Reward, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RetireReward not implemented")
+}
+func (UnimplementedLoyaltyServiceServer) RedeemReward(context.Context, *RedeemRewardRequest) (*RedeemRewardResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RedeemReward not implemented")
+}
+func (UnimplementedLoyaltyServiceServer) GetExpiringPoints(context.Context, *ExpiringPointsRequest) (*ExpiringPointsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetExpiringPoints not implemented")
+}
+func (UnimplementedLoyaltyServiceServer) ReversePoints(context.Context, *ReversePointsRequest) (*ReversePointsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReversePoints not implemented")
+}
+func (UnimplementedLoyaltyServiceServer) GetReferralCode(context.Context, *GetReferralCodeRequest) (*ReferralCodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetReferralCode not implemented")
+}
+func (UnimplementedLoyaltyServiceServer) RegisterReferral(context.Context, *RegisterReferralRequest) (*RegisterReferralResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterReferral not implemented")
+}
+func (UnimplementedLoyaltyServiceServer) GetReferralStats(context.Context, *ReferralStatsRequest) (*ReferralStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetReferralStats not implemented")
+}
+func (UnimplementedLoyaltyServiceServer) FindDuplicates(context.Context, *FindDuplicatesRequest) (*FindDuplicatesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindDuplicates not implemented")
+}
+func (UnimplementedLoyaltyServiceServer) MergeAccounts(context.Context, *MergeAccountsRequest) (*MergeAccountsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MergeAccounts not implemented")
+}
 func (UnimplementedLoyaltyServiceServer) mustEmbedUnimplementedLoyaltyServiceServer() {}
 
 // UnsafeLoyaltyServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -137,6 +358,24 @@ func _LoyaltyService_GetAccount_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _LoyaltyService_CreateAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateAccountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoyaltyServiceServer).CreateAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LoyaltyService_CreateAccount_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoyaltyServiceServer).CreateAccount(ctx, req.(*CreateAccountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _LoyaltyService_EarnPoints_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(EarnPointsRequest)
 	if err := dec(in); err != nil {
@@ -191,6 +430,222 @@ func _LoyaltyService_GetHistory_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _LoyaltyService_ListRewards_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRewardsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoyaltyServiceServer).ListRewards(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LoyaltyService_ListRewards_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoyaltyServiceServer).ListRewards(ctx, req.(*ListRewardsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LoyaltyService_CreateReward_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Reward)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoyaltyServiceServer).CreateReward(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LoyaltyService_CreateReward_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoyaltyServiceServer).CreateReward(ctx, req.(*Reward))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LoyaltyService_UpdateReward_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Reward)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoyaltyServiceServer).UpdateReward(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LoyaltyService_UpdateReward_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoyaltyServiceServer).UpdateReward(ctx, req.(*Reward))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LoyaltyService_RetireReward_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RetireRewardRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoyaltyServiceServer).RetireReward(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LoyaltyService_RetireReward_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoyaltyServiceServer).RetireReward(ctx, req.(*RetireRewardRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LoyaltyService_RedeemReward_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RedeemRewardRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoyaltyServiceServer).RedeemReward(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LoyaltyService_RedeemReward_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoyaltyServiceServer).RedeemReward(ctx, req.(*RedeemRewardRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LoyaltyService_GetExpiringPoints_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExpiringPointsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoyaltyServiceServer).GetExpiringPoints(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LoyaltyService_GetExpiringPoints_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoyaltyServiceServer).GetExpiringPoints(ctx, req.(*ExpiringPointsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LoyaltyService_ReversePoints_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReversePointsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoyaltyServiceServer).ReversePoints(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LoyaltyService_ReversePoints_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoyaltyServiceServer).ReversePoints(ctx, req.(*ReversePointsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LoyaltyService_GetReferralCode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetReferralCodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoyaltyServiceServer).GetReferralCode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LoyaltyService_GetReferralCode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoyaltyServiceServer).GetReferralCode(ctx, req.(*GetReferralCodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LoyaltyService_RegisterReferral_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterReferralRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoyaltyServiceServer).RegisterReferral(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LoyaltyService_RegisterReferral_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoyaltyServiceServer).RegisterReferral(ctx, req.(*RegisterReferralRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LoyaltyService_GetReferralStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReferralStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoyaltyServiceServer).GetReferralStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LoyaltyService_GetReferralStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoyaltyServiceServer).GetReferralStats(ctx, req.(*ReferralStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LoyaltyService_FindDuplicates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindDuplicatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoyaltyServiceServer).FindDuplicates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LoyaltyService_FindDuplicates_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoyaltyServiceServer).FindDuplicates(ctx, req.(*FindDuplicatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LoyaltyService_MergeAccounts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MergeAccountsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LoyaltyServiceServer).MergeAccounts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LoyaltyService_MergeAccounts_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LoyaltyServiceServer).MergeAccounts(ctx, req.(*MergeAccountsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // LoyaltyService_ServiceDesc is the grpc.ServiceDesc for LoyaltyService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -202,6 +657,10 @@ var LoyaltyService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetAccount",
 			Handler:    _LoyaltyService_GetAccount_Handler,
 		},
+		{
+			MethodName: "CreateAccount",
+			Handler:    _LoyaltyService_CreateAccount_Handler,
+		},
 		{
 			MethodName: "EarnPoints",
 			Handler:    _LoyaltyService_EarnPoints_Handler,
@@ -214,6 +673,54 @@ var LoyaltyService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetHistory",
 			Handler:    _LoyaltyService_GetHistory_Handler,
 		},
+		{
+			MethodName: "ListRewards",
+			Handler:    _LoyaltyService_ListRewards_Handler,
+		},
+		{
+			MethodName: "CreateReward",
+			Handler:    _LoyaltyService_CreateReward_Handler,
+		},
+		{
+			MethodName: "UpdateReward",
+			Handler:    _LoyaltyService_UpdateReward_Handler,
+		},
+		{
+			MethodName: "RetireReward",
+			Handler:    _LoyaltyService_RetireReward_Handler,
+		},
+		{
+			MethodName: "RedeemReward",
+			Handler:    _LoyaltyService_RedeemReward_Handler,
+		},
+		{
+			MethodName: "GetExpiringPoints",
+			Handler:    _LoyaltyService_GetExpiringPoints_Handler,
+		},
+		{
+			MethodName: "ReversePoints",
+			Handler:    _LoyaltyService_ReversePoints_Handler,
+		},
+		{
+			MethodName: "GetReferralCode",
+			Handler:    _LoyaltyService_GetReferralCode_Handler,
+		},
+		{
+			MethodName: "RegisterReferral",
+			Handler:    _LoyaltyService_RegisterReferral_Handler,
+		},
+		{
+			MethodName: "GetReferralStats",
+			Handler:    _LoyaltyService_GetReferralStats_Handler,
+		},
+		{
+			MethodName: "FindDuplicates",
+			Handler:    _LoyaltyService_FindDuplicates_Handler,
+		},
+		{
+			MethodName: "MergeAccounts",
+			Handler:    _LoyaltyService_MergeAccounts_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/loyalty/v1/loyalty.proto",
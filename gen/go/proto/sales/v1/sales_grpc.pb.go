@@ -19,9 +19,13 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	SalesService_CreateSale_FullMethodName = "/sales.v1.SalesService/CreateSale"
-	SalesService_GetSale_FullMethodName    = "/sales.v1.SalesService/GetSale"
-	SalesService_CancelSale_FullMethodName = "/sales.v1.SalesService/CancelSale"
+	SalesService_CreateSale_FullMethodName            = "/sales.v1.SalesService/CreateSale"
+	SalesService_GetSale_FullMethodName               = "/sales.v1.SalesService/GetSale"
+	SalesService_CancelSale_FullMethodName            = "/sales.v1.SalesService/CancelSale"
+	SalesService_UpsertCreditAccount_FullMethodName   = "/sales.v1.SalesService/UpsertCreditAccount"
+	SalesService_ListCreditAccounts_FullMethodName    = "/sales.v1.SalesService/ListCreditAccounts"
+	SalesService_GetCreditStatement_FullMethodName    = "/sales.v1.SalesService/GetCreditStatement"
+	SalesService_RegisterCreditPayment_FullMethodName = "/sales.v1.SalesService/RegisterCreditPayment"
 )
 
 // SalesServiceClient is the client API for SalesService service.
@@ -31,6 +35,11 @@ type SalesServiceClient interface {
 	CreateSale(ctx context.Context, in *CreateSaleRequest, opts ...grpc.CallOption) (*CreateSaleResponse, error)
 	GetSale(ctx context.Context, in *GetSaleRequest, opts ...grpc.CallOption) (*GetSaleResponse, error)
 	CancelSale(ctx context.Context, in *CancelSaleRequest, opts ...grpc.CallOption) (*CancelSaleResponse, error)
+	// Crédito (fiado): una venta con payment_method "credit" es un cargo a la cuenta del cliente
+	UpsertCreditAccount(ctx context.Context, in *UpsertCreditAccountRequest, opts ...grpc.CallOption) (*CreditAccount, error)
+	ListCreditAccounts(ctx context.Context, in *ListCreditAccountsRequest, opts ...grpc.CallOption) (*ListCreditAccountsResponse, error)
+	GetCreditStatement(ctx context.Context, in *CreditStatementRequest, opts ...grpc.CallOption) (*CreditStatement, error)
+	RegisterCreditPayment(ctx context.Context, in *CreditPaymentRequest, opts ...grpc.CallOption) (*CreditPaymentResponse, error)
 }
 
 type salesServiceClient struct {
@@ -68,6 +77,42 @@ func (c *salesServiceClient) CancelSale(ctx context.Context, in *CancelSaleReque
 	return out, nil
 }
 
+func (c *salesServiceClient) UpsertCreditAccount(ctx context.Context, in *UpsertCreditAccountRequest, opts ...grpc.CallOption) (*CreditAccount, error) {
+	out := new(CreditAccount)
+	err := c.cc.Invoke(ctx, SalesService_UpsertCreditAccount_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *salesServiceClient) ListCreditAccounts(ctx context.Context, in *ListCreditAccountsRequest, opts ...grpc.CallOption) (*ListCreditAccountsResponse, error) {
+	out := new(ListCreditAccountsResponse)
+	err := c.cc.Invoke(ctx, SalesService_ListCreditAccounts_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *salesServiceClient) GetCreditStatement(ctx context.Context, in *CreditStatementRequest, opts ...grpc.CallOption) (*CreditStatement, error) {
+	out := new(CreditStatement)
+	err := c.cc.Invoke(ctx, SalesService_GetCreditStatement_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *salesServiceClient) RegisterCreditPayment(ctx context.Context, in *CreditPaymentRequest, opts ...grpc.CallOption) (*CreditPaymentResponse, error) {
+	out := new(CreditPaymentResponse)
+	err := c.cc.Invoke(ctx, SalesService_RegisterCreditPayment_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // SalesServiceServer is the server API for SalesService service.
 // All implementations must embed UnimplementedSalesServiceServer
 // for forward compatibility
@@ -75,6 +120,11 @@ type SalesServiceServer interface {
 	CreateSale(context.Context, *CreateSaleRequest) (*CreateSaleResponse, error)
 	GetSale(context.Context, *GetSaleRequest) (*GetSaleResponse, error)
 	CancelSale(context.Context, *CancelSaleRequest) (*CancelSaleResponse, error)
+	// Crédito (fiado): una venta con payment_method "credit" es un cargo a la cuenta del cliente
+	UpsertCreditAccount(context.Context, *UpsertCreditAccountRequest) (*CreditAccount, error)
+	ListCreditAccounts(context.Context, *ListCreditAccountsRequest) (*ListCreditAccountsResponse, error)
+	GetCreditStatement(context.Context, *CreditStatementRequest) (*CreditStatement, error)
+	RegisterCreditPayment(context.Context, *CreditPaymentRequest) (*CreditPaymentResponse, error)
 	mustEmbedUnimplementedSalesServiceServer()
 }
 
@@ -91,6 +141,18 @@ func (UnimplementedSalesServiceServer) GetSale(context.Context, *GetSaleRequest)
 func (UnimplementedSalesServiceServer) CancelSale(context.Context, *CancelSaleRequest) (*CancelSaleResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CancelSale not implemented")
 }
+func (UnimplementedSalesServiceServer) UpsertCreditAccount(context.Context, *UpsertCreditAccountRequest) (*CreditAccount, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpsertCreditAccount not implemented")
+}
+func (UnimplementedSalesServiceServer) ListCreditAccounts(context.Context, *ListCreditAccountsRequest) (*ListCreditAccountsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCreditAccounts not implemented")
+}
+func (UnimplementedSalesServiceServer) GetCreditStatement(context.Context, *CreditStatementRequest) (*CreditStatement, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCreditStatement not implemented")
+}
+func (UnimplementedSalesServiceServer) RegisterCreditPayment(context.Context, *CreditPaymentRequest) (*CreditPaymentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterCreditPayment not implemented")
+}
 func (UnimplementedSalesServiceServer) mustEmbedUnimplementedSalesServiceServer() {}
 
 // UnsafeSalesServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -158,6 +220,78 @@ func _SalesService_CancelSale_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _SalesService_UpsertCreditAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpsertCreditAccountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SalesServiceServer).UpsertCreditAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SalesService_UpsertCreditAccount_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SalesServiceServer).UpsertCreditAccount(ctx, req.(*UpsertCreditAccountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SalesService_ListCreditAccounts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCreditAccountsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SalesServiceServer).ListCreditAccounts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SalesService_ListCreditAccounts_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SalesServiceServer).ListCreditAccounts(ctx, req.(*ListCreditAccountsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SalesService_GetCreditStatement_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreditStatementRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SalesServiceServer).GetCreditStatement(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SalesService_GetCreditStatement_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SalesServiceServer).GetCreditStatement(ctx, req.(*CreditStatementRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SalesService_RegisterCreditPayment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreditPaymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SalesServiceServer).RegisterCreditPayment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SalesService_RegisterCreditPayment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SalesServiceServer).RegisterCreditPayment(ctx, req.(*CreditPaymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // SalesService_ServiceDesc is the grpc.ServiceDesc for SalesService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -177,6 +311,22 @@ var SalesService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "CancelSale",
 			Handler:    _SalesService_CancelSale_Handler,
 		},
+		{
+			MethodName: "UpsertCreditAccount",
+			Handler:    _SalesService_UpsertCreditAccount_Handler,
+		},
+		{
+			MethodName: "ListCreditAccounts",
+			Handler:    _SalesService_ListCreditAccounts_Handler,
+		},
+		{
+			MethodName: "GetCreditStatement",
+			Handler:    _SalesService_GetCreditStatement_Handler,
+		},
+		{
+			MethodName: "RegisterCreditPayment",
+			Handler:    _SalesService_RegisterCreditPayment_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/sales/v1/sales.proto",
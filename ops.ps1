@@ -24,7 +24,7 @@ switch ($cmd) {
     }
     "run-auth" {
         Write-Host "[>] Iniciando Auth Service..." -ForegroundColor Magenta
-        go run services/auth/cmd/main.go
+        go run services/auth/cmd/server/main.go
     }
     "test" {
         go test -v ./...
@@ -7,17 +7,41 @@ import (
     "log"
     "net"
     "os"
+    "strings"
     "time"
 
     _ "github.com/lib/pq"
     authv1 "github.com/turbopos/turbopos/gen/go/proto/auth/v1"
+    "github.com/turbopos/turbopos/services/auth/internal/dispositivo"
+    "github.com/turbopos/turbopos/services/auth/internal/token"
+    "golang.org/x/crypto/bcrypt"
     "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
     "google.golang.org/grpc/reflection"
+    "google.golang.org/grpc/status"
 )
 
+const (
+    ttlAcceso   = 15 * time.Minute
+    ttlSesion   = 30 * 24 * time.Hour
+    costoBcrypt = 12
+    // users no tiene rol: el único alta es Signup, que crea al administrador del negocio,
+    // así que todo usuario es admin y puede cerrar las sesiones de su negocio. Si se
+    // agregan usuarios con menos permisos, el rol debe salir de users y LogoutAll y
+    // RevokeSession deben revisarlo.
+    rolUsuario = "admin"
+    // Tenant demo para usuarios sin tenant_id y para el administrador de respaldo
+    tenantDemo = "00000000-0000-0000-0000-000000000001"
+)
+
+var precioPlan = map[string]float64{"starter": 1990, "business": 4990, "pro": 9990}
+
+var errCredenciales = status.Error(codes.Unauthenticated, "credenciales incorrectas")
+
 type server struct {
     authv1.UnimplementedAuthServiceServer
-    db *sql.DB
+    db      *sql.DB
+    secreto []byte
 }
 
 func (s *server) Ping(ctx context.Context, req *authv1.PingRequest) (*authv1.PingResponse, error) {
@@ -47,6 +71,489 @@ func (s *server) Ping(ctx context.Context, req *authv1.PingRequest) (*authv1.Pin
     }, nil
 }
 
+// Login valida las credenciales contra users y abre una sesión. Si el correo no existe
+// se acepta el administrador de respaldo (ADMIN_USER/ADMIN_PASS): la primera vez que entra
+// se da de alta en users en el tenant demo, para que su sesión se liste, se refresque y se
+// revoque como cualquier otra. Desde ahí su contraseña es la de users; para volver a la
+// de ADMIN_PASS se borra esa fila.
+func (s *server) Login(ctx context.Context, req *authv1.LoginRequest) (*authv1.AuthTokens, error) {
+    usuario := strings.TrimSpace(req.GetUsername())
+    if usuario == "" || req.GetPassword() == "" {
+        return nil, status.Error(codes.InvalidArgument, "username y password requeridos")
+    }
+    if s.db == nil {
+        return nil, fmt.Errorf("db no disponible")
+    }
+
+    var userID, tenantID, hash string
+    err := s.db.QueryRowContext(ctx,
+        "SELECT id::text, COALESCE(tenant_id::text,$2), password_hash FROM users WHERE email=$1 LIMIT 1",
+        usuario, tenantDemo,
+    ).Scan(&userID, &tenantID, &hash)
+    if err == sql.ErrNoRows {
+        if usuario != getenv("ADMIN_USER", "admin") || req.GetPassword() != getenv("ADMIN_PASS", "turbopos2026") {
+            return nil, errCredenciales
+        }
+        if userID, hash, err = s.altaRespaldo(ctx, usuario, req.GetPassword()); err != nil {
+            log.Printf("ERROR alta del administrador de respaldo %s: %v", usuario, err)
+            return nil, status.Error(codes.Internal, "error abriendo sesión")
+        }
+        tenantID = tenantDemo
+        log.Printf("✓ Login administrador de respaldo: %s", usuario)
+    }
+    if err != nil {
+        log.Printf("ERROR login %s: %v", usuario, err)
+        return nil, status.Error(codes.Internal, "error consultando usuario")
+    }
+    if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.GetPassword())) != nil {
+        return nil, errCredenciales
+    }
+
+    refresh, err := token.NuevoRefresh()
+    if err != nil {
+        return nil, status.Error(codes.Internal, "error generando sesión")
+    }
+    var sesion string
+    if err := s.db.QueryRowContext(ctx, `
+        INSERT INTO sessions (user_id, refresh_token, expires_at, user_agent, ip)
+        VALUES ($1::uuid, $2, $3, NULLIF($4,''), NULLIF($5,'')) RETURNING id::text`,
+        userID, token.Huella(refresh), time.Now().Add(ttlSesion),
+        recortar(req.GetUserAgent(), 512), recortar(req.GetIp(), 64),
+    ).Scan(&sesion); err != nil {
+        log.Printf("ERROR abriendo sesión de %s: %v", usuario, err)
+        return nil, status.Error(codes.Internal, "error abriendo sesión")
+    }
+    resp, err := s.acceso(usuario, rolUsuario, tenantID, sesion)
+    if err != nil {
+        return nil, err
+    }
+    resp.RefreshToken = refresh
+    log.Printf("✓ Login: user=%s tenant=%s sesión=%s ip=%s", usuario, tenantID, sesion, req.GetIp())
+    return resp, nil
+}
+
+// altaRespaldo registra en users al administrador de respaldo. Si otra solicitud lo dio de
+// alta al mismo tiempo se usa esa fila.
+func (s *server) altaRespaldo(ctx context.Context, usuario, password string) (string, string, error) {
+    nuevo, err := bcrypt.GenerateFromPassword([]byte(password), costoBcrypt)
+    if err != nil {
+        return "", "", err
+    }
+    if _, err := s.db.ExecContext(ctx,
+        "INSERT INTO users (email, password_hash, tenant_id) VALUES ($1,$2,$3::uuid) ON CONFLICT (email) DO NOTHING",
+        usuario, string(nuevo), tenantDemo,
+    ); err != nil {
+        return "", "", err
+    }
+    var userID, hash string
+    err = s.db.QueryRowContext(ctx, "SELECT id::text, password_hash FROM users WHERE email=$1", usuario).Scan(&userID, &hash)
+    return userID, hash, err
+}
+
+// acceso firma un JWT nuevo para el usuario. Dura poco (ttlAcceso): el navegador lo
+// renueva con el refresh token y así una sesión revocada se corta en minutos.
+func (s *server) acceso(usuario, rol, tenantID, sesion string) (*authv1.AuthTokens, error) {
+    c := token.Nuevo(usuario, rol, tenantID, sesion, time.Now(), ttlAcceso)
+    jwt, err := token.Firmar(c, s.secreto)
+    if err != nil {
+        return nil, status.Error(codes.Internal, "error generando token")
+    }
+    return &authv1.AuthTokens{
+        AccessToken: jwt,
+        Username:    usuario,
+        Role:        rol,
+        TenantId:    tenantID,
+        ExpiresAt:   time.Unix(c.Exp, 0).Format(time.RFC3339),
+        SessionId:   sesion,
+    }, nil
+}
+
+func recortar(s string, n int) string {
+    s = strings.TrimSpace(s)
+    if len(s) > n {
+        return s[:n]
+    }
+    return s
+}
+
+// Signup da de alta el negocio, su usuario administrador y la suscripción de prueba en
+// una sola transacción
+func (s *server) Signup(ctx context.Context, req *authv1.SignupRequest) (*authv1.SignupResponse, error) {
+    nombre := strings.TrimSpace(req.GetBusinessName())
+    rfc := strings.ToUpper(strings.TrimSpace(req.GetRfc()))
+    email := strings.TrimSpace(req.GetEmail())
+    if nombre == "" || rfc == "" || email == "" || req.GetPassword() == "" {
+        return nil, status.Error(codes.InvalidArgument, "Campos requeridos: nombre, rfc, email, password")
+    }
+    if len(req.GetPassword()) < 8 {
+        return nil, status.Error(codes.InvalidArgument, "La contrasena debe tener al menos 8 caracteres")
+    }
+    if s.db == nil {
+        return nil, fmt.Errorf("db no disponible")
+    }
+    plan := req.GetPlan()
+    monto, ok := precioPlan[plan]
+    if !ok {
+        plan, monto = "starter", precioPlan["starter"]
+    }
+    regimen := req.GetRegimenFiscal()
+    if regimen == "" {
+        regimen = "612"
+    }
+    cp := req.GetCodigoPostal()
+    if cp == "" {
+        cp = "06600"
+    }
+    razonSocial := req.GetRazonSocial()
+    if razonSocial == "" {
+        razonSocial = nombre
+    }
+
+    var n int
+    if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM tenants WHERE rfc=$1", rfc).Scan(&n); err != nil {
+        log.Printf("ERROR signup validando RFC: %v", err)
+        return nil, status.Error(codes.Internal, "Error interno")
+    }
+    if n > 0 {
+        return nil, status.Error(codes.AlreadyExists, "Ya existe una cuenta con ese RFC")
+    }
+    if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE email=$1", email).Scan(&n); err != nil {
+        log.Printf("ERROR signup validando email: %v", err)
+        return nil, status.Error(codes.Internal, "Error interno")
+    }
+    if n > 0 {
+        return nil, status.Error(codes.AlreadyExists, "Ese email ya esta registrado")
+    }
+
+    hash, err := bcrypt.GenerateFromPassword([]byte(req.GetPassword()), costoBcrypt)
+    if err != nil {
+        return nil, status.Error(codes.Internal, "Error procesando contrasena")
+    }
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, status.Error(codes.Internal, "Error interno")
+    }
+    defer tx.Rollback()
+
+    var tenantID string
+    if err := tx.QueryRowContext(ctx,
+        "INSERT INTO tenants (nombre, rfc, razon_social, regimen_fiscal, codigo_postal, plan, email, telefono) "+
+            "VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING id",
+        nombre, rfc, razonSocial, regimen, cp, plan, email, req.GetPhone(),
+    ).Scan(&tenantID); err != nil {
+        log.Printf("ERROR signup creando tenant: %v", err)
+        return nil, status.Error(codes.Internal, "Error creando negocio")
+    }
+    if _, err := tx.ExecContext(ctx,
+        "INSERT INTO users (email, password_hash, tenant_id) VALUES ($1,$2,$3::uuid)",
+        email, string(hash), tenantID,
+    ); err != nil {
+        log.Printf("ERROR signup creando usuario: %v", err)
+        return nil, status.Error(codes.Internal, "Error creando usuario")
+    }
+    if _, err := tx.ExecContext(ctx,
+        "INSERT INTO subscriptions (tenant_id, plan, status, amount) VALUES ($1::uuid,$2,'trial',$3)",
+        tenantID, plan, monto,
+    ); err != nil {
+        log.Printf("ERROR signup creando suscripción: %v", err)
+        return nil, status.Error(codes.Internal, "Error creando suscripción")
+    }
+    if err := tx.Commit(); err != nil {
+        return nil, status.Error(codes.Internal, "Error finalizando registro")
+    }
+
+    log.Printf("✓ Signup: rfc=%s plan=%s email=%s", rfc, plan, email)
+    return &authv1.SignupResponse{TenantId: tenantID, Username: email, Plan: plan}, nil
+}
+
+// Refresh rota el refresh token: el presentado deja de servir y se entrega uno nuevo
+// junto con el JWT. Cada uso extiende la sesión ttlSesion, así que solo vence por
+// inactividad. Un refresh token ya rotado que vuelve a llegar revoca la sesión.
+func (s *server) Refresh(ctx context.Context, req *authv1.RefreshRequest) (*authv1.AuthTokens, error) {
+    if req.GetRefreshToken() == "" {
+        return nil, status.Error(codes.InvalidArgument, "refresh_token requerido")
+    }
+    if s.db == nil {
+        return nil, fmt.Errorf("db no disponible")
+    }
+    nuevo, err := token.NuevoRefresh()
+    if err != nil {
+        return nil, status.Error(codes.Internal, "error generando sesión")
+    }
+    huella := token.Huella(req.GetRefreshToken())
+
+    // Un solo UPDATE: de dos solicitudes con el mismo token solo una encuentra la fila
+    var sesion, usuario, tenantID string
+    err = s.db.QueryRowContext(ctx, `
+        UPDATE sessions s SET previous_token=s.refresh_token, refresh_token=$2, expires_at=$3,
+            last_used_at=NOW(), user_agent=COALESCE(NULLIF($4,''), s.user_agent), ip=COALESCE(NULLIF($5,''), s.ip)
+        FROM users u
+        WHERE u.id = s.user_id AND s.refresh_token=$1 AND s.revoked_at IS NULL AND s.expires_at > NOW()
+        RETURNING s.id::text, u.email, COALESCE(u.tenant_id::text,$6)`,
+        huella, token.Huella(nuevo), time.Now().Add(ttlSesion),
+        recortar(req.GetUserAgent(), 512), recortar(req.GetIp(), 64), tenantDemo,
+    ).Scan(&sesion, &usuario, &tenantID)
+    if err == sql.ErrNoRows {
+        res, err := s.db.ExecContext(ctx,
+            "UPDATE sessions SET revoked_at=NOW(), revoke_reason='reuso' WHERE previous_token=$1 AND revoked_at IS NULL",
+            huella)
+        if err != nil {
+            log.Printf("ERROR revisando reuso de refresh token: %v", err)
+        } else if n, _ := res.RowsAffected(); n > 0 {
+            log.Printf("⚠ Refresh token reutilizado desde ip=%s: sesión revocada", req.GetIp())
+        }
+        return nil, status.Error(codes.Unauthenticated, "sesión expirada")
+    }
+    if err != nil {
+        log.Printf("ERROR refresh: %v", err)
+        return nil, status.Error(codes.Internal, "error consultando sesión")
+    }
+    resp, err := s.acceso(usuario, rolUsuario, tenantID, sesion)
+    if err != nil {
+        return nil, err
+    }
+    resp.RefreshToken = nuevo
+    return resp, nil
+}
+
+func (s *server) Logout(ctx context.Context, req *authv1.LogoutRequest) (*authv1.LogoutResponse, error) {
+    if req.GetRefreshToken() == "" {
+        return &authv1.LogoutResponse{}, nil
+    }
+    if s.db == nil {
+        return nil, fmt.Errorf("db no disponible")
+    }
+    if _, err := s.db.ExecContext(ctx,
+        "UPDATE sessions SET revoked_at=NOW(), revoke_reason='logout' WHERE refresh_token=$1 AND revoked_at IS NULL",
+        token.Huella(req.GetRefreshToken()),
+    ); err != nil {
+        log.Printf("ERROR logout: %v", err)
+        return nil, status.Error(codes.Internal, "error cerrando sesión")
+    }
+    return &authv1.LogoutResponse{}, nil
+}
+
+// RequestPasswordReset deja un token de recuperación que vence en una hora; pedir otro
+// reemplaza al anterior
+func (s *server) RequestPasswordReset(ctx context.Context, req *authv1.PasswordResetRequest) (*authv1.PasswordResetResponse, error) {
+    email := strings.TrimSpace(req.GetEmail())
+    if email == "" {
+        return nil, status.Error(codes.InvalidArgument, "email requerido")
+    }
+    if s.db == nil {
+        return nil, fmt.Errorf("db no disponible")
+    }
+    var userID string
+    err := s.db.QueryRowContext(ctx, "SELECT id::text FROM users WHERE email=$1", email).Scan(&userID)
+    if err == sql.ErrNoRows {
+        return &authv1.PasswordResetResponse{}, nil
+    }
+    if err != nil {
+        log.Printf("ERROR reset password %s: %v", email, err)
+        return nil, status.Error(codes.Internal, "error consultando usuario")
+    }
+    var tok string
+    if err := s.db.QueryRowContext(ctx, `
+        INSERT INTO password_reset_tokens (user_id, token, expires_at)
+        VALUES ($1::uuid, gen_random_uuid()::text, NOW()+INTERVAL '1 hour')
+        ON CONFLICT (user_id) DO UPDATE SET token=EXCLUDED.token, expires_at=EXCLUDED.expires_at
+        RETURNING token`, userID).Scan(&tok); err != nil {
+        log.Printf("ERROR guardando token de recuperación: %v", err)
+        return nil, status.Error(codes.Internal, "error generando token")
+    }
+    log.Printf("✓ Reset password solicitado para %s", email)
+    return &authv1.PasswordResetResponse{Token: tok}, nil
+}
+
+func (s *server) ResetPassword(ctx context.Context, req *authv1.ResetPasswordRequest) (*authv1.ResetPasswordResponse, error) {
+    if req.GetToken() == "" || req.GetPassword() == "" {
+        return nil, status.Error(codes.InvalidArgument, "token y password requeridos")
+    }
+    if len(req.GetPassword()) < 8 {
+        return nil, status.Error(codes.InvalidArgument, "password minimo 8 caracteres")
+    }
+    if s.db == nil {
+        return nil, fmt.Errorf("db no disponible")
+    }
+    hash, err := bcrypt.GenerateFromPassword([]byte(req.GetPassword()), costoBcrypt)
+    if err != nil {
+        return nil, status.Error(codes.Internal, "Error procesando contrasena")
+    }
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, status.Error(codes.Internal, "Error interno")
+    }
+    defer tx.Rollback()
+
+    // Borrar el token al usarlo impide que dos solicitudes lo ocupen a la vez
+    var userID string
+    err = tx.QueryRowContext(ctx,
+        "DELETE FROM password_reset_tokens WHERE token=$1 AND expires_at > NOW() RETURNING user_id::text",
+        req.GetToken(),
+    ).Scan(&userID)
+    if err == sql.ErrNoRows {
+        return nil, status.Error(codes.InvalidArgument, "Token invalido o expirado")
+    }
+    if err != nil {
+        return nil, status.Error(codes.Internal, "Error interno")
+    }
+    if _, err := tx.ExecContext(ctx, "UPDATE users SET password_hash=$1 WHERE id=$2::uuid", string(hash), userID); err != nil {
+        log.Printf("ERROR actualizando password de %s: %v", userID, err)
+        return nil, status.Error(codes.Internal, "Error actualizando contrasena")
+    }
+    // Quien tenga la contraseña anterior no debe seguir dentro en otro dispositivo
+    if _, err := tx.ExecContext(ctx,
+        "UPDATE sessions SET revoked_at=NOW(), revoke_reason='password' WHERE user_id=$1::uuid AND revoked_at IS NULL",
+        userID,
+    ); err != nil {
+        log.Printf("ERROR cerrando sesiones de %s: %v", userID, err)
+        return nil, status.Error(codes.Internal, "Error cerrando sesiones")
+    }
+    if err := tx.Commit(); err != nil {
+        return nil, status.Error(codes.Internal, "Error interno")
+    }
+    log.Printf("✓ Password actualizado para userID=%s", userID)
+    return &authv1.ResetPasswordResponse{}, nil
+}
+
+// ValidateToken revisa la firma, la vigencia y que la sesión del token siga abierta
+func (s *server) ValidateToken(ctx context.Context, req *authv1.ValidateTokenRequest) (*authv1.TokenClaims, error) {
+    c, err := s.claims(ctx, req.GetToken())
+    if err != nil {
+        return nil, err
+    }
+    return &authv1.TokenClaims{Username: c.Sub, Role: c.Role, TenantId: c.TenantID, ExpiresAt: c.Exp, SessionId: c.Sid}, nil
+}
+
+func (s *server) claims(ctx context.Context, jwt string) (*token.Claims, error) {
+    c, err := token.Validar(jwt, s.secreto, time.Now())
+    if err != nil {
+        return nil, status.Error(codes.Unauthenticated, err.Error())
+    }
+    // Todo login abre sesión; un token sin ella no se puede revocar y no se acepta
+    if c.Sid == "" {
+        return nil, status.Error(codes.Unauthenticated, "token sin sesión")
+    }
+    if s.db == nil {
+        return nil, fmt.Errorf("db no disponible")
+    }
+    var abierta bool
+    err = s.db.QueryRowContext(ctx,
+        "SELECT revoked_at IS NULL AND expires_at > NOW() FROM sessions WHERE id=$1::uuid", c.Sid,
+    ).Scan(&abierta)
+    if err == sql.ErrNoRows || (err == nil && !abierta) {
+        return nil, status.Error(codes.Unauthenticated, "sesión revocada")
+    }
+    if err != nil {
+        log.Printf("ERROR validando sesión %s: %v", c.Sid, err)
+        return nil, status.Error(codes.Internal, "error validando sesión")
+    }
+    return c, nil
+}
+
+// LogoutAll cierra las sesiones abiertas del usuario del token o las de otro usuario de
+// su negocio (por ejemplo alguien que ya no trabaja ahí)
+func (s *server) LogoutAll(ctx context.Context, req *authv1.LogoutAllRequest) (*authv1.LogoutAllResponse, error) {
+    c, err := s.claims(ctx, req.GetAccessToken())
+    if err != nil {
+        return nil, err
+    }
+    if s.db == nil {
+        return nil, fmt.Errorf("db no disponible")
+    }
+    usuario := strings.TrimSpace(req.GetUsername())
+    if usuario == "" {
+        usuario = c.Sub
+    }
+    res, err := s.db.ExecContext(ctx, `
+        UPDATE sessions s SET revoked_at=NOW(), revoke_reason='todas'
+        FROM users u
+        WHERE u.id = s.user_id AND u.email=$1 AND COALESCE(u.tenant_id::text,$3)=$2 AND s.revoked_at IS NULL`,
+        usuario, c.TenantID, tenantDemo)
+    if err != nil {
+        log.Printf("ERROR cerrando sesiones de %s: %v", usuario, err)
+        return nil, status.Error(codes.Internal, "error cerrando sesiones")
+    }
+    n, _ := res.RowsAffected()
+    log.Printf("✓ %d sesiones de %s cerradas por %s", n, usuario, c.Sub)
+    return &authv1.LogoutAllResponse{Revoked: int32(n)}, nil
+}
+
+// ListSessions lista las sesiones abiertas de los usuarios del negocio del token,
+// las más recientes primero
+func (s *server) ListSessions(ctx context.Context, req *authv1.ListSessionsRequest) (*authv1.ListSessionsResponse, error) {
+    c, err := s.claims(ctx, req.GetAccessToken())
+    if err != nil {
+        return nil, err
+    }
+    if s.db == nil {
+        return nil, fmt.Errorf("db no disponible")
+    }
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT s.id::text, u.email, COALESCE(s.user_agent,''), COALESCE(s.ip,''), s.created_at, s.last_used_at, s.expires_at
+        FROM sessions s JOIN users u ON u.id = s.user_id
+        WHERE COALESCE(u.tenant_id::text,$2)=$1 AND s.revoked_at IS NULL AND s.expires_at > NOW()
+        ORDER BY s.last_used_at DESC`, c.TenantID, tenantDemo)
+    if err != nil {
+        log.Printf("ERROR listando sesiones: %v", err)
+        return nil, status.Error(codes.Internal, "error listando sesiones")
+    }
+    defer rows.Close()
+    resp := &authv1.ListSessionsResponse{}
+    for rows.Next() {
+        var ses authv1.Session
+        var creada, usada, vence time.Time
+        if err := rows.Scan(&ses.Id, &ses.Username, &ses.UserAgent, &ses.Ip, &creada, &usada, &vence); err != nil {
+            return nil, status.Error(codes.Internal, "error leyendo sesiones")
+        }
+        ses.Device = dispositivo.Describir(ses.UserAgent)
+        ses.CreatedAt = creada.Format(time.RFC3339)
+        ses.LastUsedAt = usada.Format(time.RFC3339)
+        ses.ExpiresAt = vence.Format(time.RFC3339)
+        ses.Current = ses.Id == c.Sid
+        resp.Sessions = append(resp.Sessions, &ses)
+    }
+    return resp, rows.Err()
+}
+
+// RevokeSession cierra una sesión de cualquier usuario del negocio del token
+func (s *server) RevokeSession(ctx context.Context, req *authv1.RevokeSessionRequest) (*authv1.LogoutResponse, error) {
+    c, err := s.claims(ctx, req.GetAccessToken())
+    if err != nil {
+        return nil, err
+    }
+    if s.db == nil {
+        return nil, fmt.Errorf("db no disponible")
+    }
+    if req.GetSessionId() == "" {
+        return nil, status.Error(codes.InvalidArgument, "session_id requerido")
+    }
+    var usuario string
+    err = s.db.QueryRowContext(ctx, `
+        SELECT u.email FROM sessions s JOIN users u ON u.id = s.user_id
+        WHERE s.id::text=$1 AND COALESCE(u.tenant_id::text,$3)=$2 AND s.revoked_at IS NULL`,
+        req.GetSessionId(), c.TenantID, tenantDemo,
+    ).Scan(&usuario)
+    if err == sql.ErrNoRows {
+        return nil, status.Error(codes.NotFound, "sesión no encontrada")
+    }
+    if err != nil {
+        log.Printf("ERROR consultando sesión %s: %v", req.GetSessionId(), err)
+        return nil, status.Error(codes.Internal, "error revocando sesión")
+    }
+    if _, err := s.db.ExecContext(ctx,
+        "UPDATE sessions SET revoked_at=NOW(), revoke_reason='revocada' WHERE id::text=$1 AND revoked_at IS NULL",
+        req.GetSessionId(),
+    ); err != nil {
+        log.Printf("ERROR revocando sesión %s: %v", req.GetSessionId(), err)
+        return nil, status.Error(codes.Internal, "error revocando sesión")
+    }
+    log.Printf("✓ Sesión %s de %s revocada por %s", req.GetSessionId(), usuario, c.Sub)
+    return &authv1.LogoutResponse{}, nil
+}
+
 func main() {
     dbHost := getenv("DB_HOST", "localhost")
     dbPort := getenv("DB_PORT", "5432")
@@ -82,7 +589,7 @@ func main() {
     }
 
     grpcServer := grpc.NewServer()
-    authv1.RegisterAuthServiceServer(grpcServer, &server{db: db})
+    authv1.RegisterAuthServiceServer(grpcServer, &server{db: db, secreto: []byte(getenv("JWT_SECRET", "turbopos-secret-2026-cambiar-en-produccion"))})
     reflection.Register(grpcServer)
 
     log.Printf("✓ Auth gRPC server escuchando en :%s", grpcPort)
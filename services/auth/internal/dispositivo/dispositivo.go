@@ -0,0 +1,56 @@
+// Package dispositivo resume el user agent de una sesión en algo que el dueño del
+// negocio reconozca en la lista de sesiones ("Chrome en Android").
+package dispositivo
+
+import "strings"
+
+// El orden importa: Edge y Opera también dicen Chrome, y Chrome también dice Safari
+var navegadores = []struct{ marca, nombre string }{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+}
+
+// iPhone e iPad antes que Mac OS X, que aparece en ambos; Android antes que Linux
+var sistemas = []struct{ marca, nombre string }{
+	{"iPhone", "iPhone"},
+	{"iPad", "iPad"},
+	{"Android", "Android"},
+	{"Windows", "Windows"},
+	{"Mac OS X", "Mac"},
+	{"CrOS", "ChromeOS"},
+	{"Linux", "Linux"},
+}
+
+// Describir devuelve "Navegador en Sistema", solo la parte que se reconozca, o
+// "Desconocido"
+func Describir(ua string) string {
+	nav := ""
+	for _, n := range navegadores {
+		if strings.Contains(ua, n.marca) {
+			nav = n.nombre
+			break
+		}
+	}
+	so := ""
+	for _, s := range sistemas {
+		if strings.Contains(ua, s.marca) {
+			so = s.nombre
+			break
+		}
+	}
+	switch {
+	case nav != "" && so != "":
+		return nav + " en " + so
+	case nav != "":
+		return nav
+	case so != "":
+		return so
+	}
+	return "Desconocido"
+}
@@ -0,0 +1,20 @@
+package dispositivo
+
+import "testing"
+
+func TestDescribir(t *testing.T) {
+	casos := map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36":                         "Chrome en Windows",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36 Edg/129.0":               "Edge en Windows",
+		"Mozilla/5.0 (Linux; Android 14; SM-A546E) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/128.0.0.0 Mobile Safari/537.36":                  "Chrome en Android",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1": "Safari en iPhone",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.6; rv:130.0) Gecko/20100101 Firefox/130.0":                                                     "Firefox en Mac",
+		"TurboPOS-Terminal/1.0": "Desconocido",
+		"":                      "Desconocido",
+	}
+	for ua, want := range casos {
+		if got := Describir(ua); got != want {
+			t.Errorf("Describir(%q) = %q, want %q", ua, got, want)
+		}
+	}
+}
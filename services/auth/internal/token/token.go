@@ -0,0 +1,99 @@
+// Package token firma y valida los JWT (HS256) de TurboPOS y genera los refresh tokens
+// de las sesiones. En la base de datos solo se guarda la huella del refresh token, así
+// que una copia de la tabla sessions no sirve para abrir sesión.
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Motivos por los que se rechaza un JWT
+var (
+	ErrFormato  = errors.New("token inválido")
+	ErrFirma    = errors.New("firma inválida")
+	ErrExpirado = errors.New("token expirado")
+)
+
+// Claims es el payload del JWT. Sub es el usuario (su correo) y Sid la sesión que lo
+// emitió; al revocar la sesión el token deja de valer aunque no haya vencido.
+type Claims struct {
+	Sub      string `json:"sub"`
+	Role     string `json:"role"`
+	TenantID string `json:"tid"`
+	Sid      string `json:"sid,omitempty"`
+	Exp      int64  `json:"exp"`
+	Iat      int64  `json:"iat"`
+}
+
+var encabezado = b64url([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func firma(datos string, secreto []byte) string {
+	mac := hmac.New(sha256.New, secreto)
+	mac.Write([]byte(datos))
+	return b64url(mac.Sum(nil))
+}
+
+// Nuevo arma los claims de un token emitido ahora que vence en ttl
+func Nuevo(usuario, rol, tenantID, sesion string, ahora time.Time, ttl time.Duration) Claims {
+	return Claims{Sub: usuario, Role: rol, TenantID: tenantID, Sid: sesion, Iat: ahora.Unix(), Exp: ahora.Add(ttl).Unix()}
+}
+
+// Firmar serializa y firma los claims
+func Firmar(c Claims, secreto []byte) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	datos := encabezado + "." + b64url(payload)
+	return datos + "." + firma(datos, secreto), nil
+}
+
+// Validar revisa la firma y la vigencia del token y devuelve sus claims
+func Validar(tok string, secreto []byte, ahora time.Time) (*Claims, error) {
+	partes := strings.Split(tok, ".")
+	if len(partes) != 3 {
+		return nil, ErrFormato
+	}
+	if !hmac.Equal([]byte(firma(partes[0]+"."+partes[1], secreto)), []byte(partes[2])) {
+		return nil, ErrFirma
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(partes[1])
+	if err != nil {
+		return nil, ErrFormato
+	}
+	var c Claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, ErrFormato
+	}
+	if ahora.Unix() > c.Exp {
+		return nil, ErrExpirado
+	}
+	return &c, nil
+}
+
+// NuevoRefresh genera un refresh token aleatorio de 256 bits
+func NuevoRefresh() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b64url(b), nil
+}
+
+// Huella es lo que se guarda en sessions.refresh_token
+func Huella(refresh string) string {
+	h := sha256.Sum256([]byte(refresh))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,59 @@
+package token
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var secreto = []byte("secreto-de-prueba")
+
+func TestFirmarValidar(t *testing.T) {
+	ahora := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	tok, err := Firmar(Nuevo("caja@tienda.mx", "admin", "t1", "s1", ahora, time.Hour), secreto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Validar(tok, secreto, ahora.Add(59*time.Minute))
+	if err != nil {
+		t.Fatalf("Validar: %v", err)
+	}
+	if c.Sub != "caja@tienda.mx" || c.Role != "admin" || c.TenantID != "t1" || c.Sid != "s1" || c.Exp != ahora.Add(time.Hour).Unix() {
+		t.Errorf("claims = %+v", c)
+	}
+	if _, err := Validar(tok, secreto, ahora.Add(61*time.Minute)); !errors.Is(err, ErrExpirado) {
+		t.Errorf("vencido: %v", err)
+	}
+}
+
+func TestValidar_Rechazos(t *testing.T) {
+	ahora := time.Now()
+	tok, _ := Firmar(Nuevo("a@b.mx", "admin", "t1", "", ahora, time.Hour), secreto)
+	if _, err := Validar(tok, []byte("otro"), ahora); !errors.Is(err, ErrFirma) {
+		t.Errorf("otro secreto: %v", err)
+	}
+	partes := strings.Split(tok, ".")
+	otro, _ := Firmar(Nuevo("a@b.mx", "admin", "t2", "", ahora, time.Hour), secreto)
+	alterado := partes[0] + "." + strings.Split(otro, ".")[1] + "." + partes[2]
+	if _, err := Validar(alterado, secreto, ahora); !errors.Is(err, ErrFirma) {
+		t.Errorf("payload alterado: %v", err)
+	}
+	if _, err := Validar("abc", secreto, ahora); !errors.Is(err, ErrFormato) {
+		t.Errorf("sin partes: %v", err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	a, err := NuevoRefresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NuevoRefresh()
+	if a == b || len(a) < 40 {
+		t.Errorf("refresh tokens %q %q", a, b)
+	}
+	if Huella(a) == a || Huella(a) != Huella(a) || len(Huella(a)) != 64 {
+		t.Errorf("Huella(%q) = %q", a, Huella(a))
+	}
+}
@@ -2,23 +2,34 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	firebase "firebase.google.com/go/v4"
 	"firebase.google.com/go/v4/messaging"
 	"google.golang.org/api/option"
+	"html"
 	"io"
 	"log"
+	"math"
+	"math/big"
+	"net"
 	"net/http"
 	"net/smtp"
 	"os"
+	"regexp"
+	"sort"
 	"sync"
 		"github.com/joho/godotenv"
 	"strconv"
@@ -31,8 +42,9 @@ import (
 	pb_loyalty "github.com/turbopos/turbopos/gen/go/proto/loyalty/v1"
 	pb_sales   "github.com/turbopos/turbopos/gen/go/proto/sales/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
-	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/status"
 )
 
 // loginAttempts tracks failed login attempts per IP for rate limiting
@@ -96,6 +108,234 @@ func startTrialCron(db *sql.DB) {
 }
 
 
+// startCSDCron revisa una vez al día los CSD de los tenants: activa los certificados
+// pendientes que ya entraron en vigor y avisa 30 días antes de que venza el actual.
+func (gw *Gateway) startCSDCron() {
+	go func() {
+		for {
+			gw.promoverCSDsPendientes()
+			gw.avisarCSDsPorVencer()
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+	log.Println("[Cron] Revisión de CSD iniciada (cada 24h)")
+}
+
+func (gw *Gateway) promoverCSDsPendientes() {
+	rows, err := gw.db.Query(`
+		SELECT id, tenant_id::text, rfc_emisor, COALESCE(no_certificado,''), cert_b64, key_bytes, key_password, valido_desde, valido_hasta
+		FROM tenant_csds_historial WHERE estado='pendiente' AND valido_desde <= NOW()`)
+	if err != nil { log.Printf("[Cron] Error CSD pendientes: %v", err); return }
+	type pendiente struct {
+		id, tid, rfc, noCert, certB64, keyPass string
+		keyBytes                               []byte
+		desde, hasta                           time.Time
+	}
+	var pendientes []pendiente
+	for rows.Next() {
+		var p pendiente
+		if err := rows.Scan(&p.id, &p.tid, &p.rfc, &p.noCert, &p.certB64, &p.keyBytes, &p.keyPass, &p.desde, &p.hasta); err == nil {
+			pendientes = append(pendientes, p)
+		}
+	}
+	rows.Close()
+	for _, p := range pendientes {
+		tx, err := gw.db.Begin()
+		if err != nil { log.Printf("[Cron] Error CSD tx: %v", err); continue }
+		err = reemplazarCSD(tx, p.tid, p.rfc, p.noCert, p.certB64, p.keyBytes, p.keyPass, p.desde, p.hasta)
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM tenant_csds_historial WHERE id=$1`, p.id)
+		}
+		if err == nil { err = tx.Commit() } else { tx.Rollback() }
+		if err != nil { log.Printf("[Cron] Error activando CSD tenant=%s: %v", p.tid, err); continue }
+		log.Printf("[Cron] CSD %s activado tenant=%s", p.noCert, p.tid)
+	}
+}
+
+func (gw *Gateway) avisarCSDsPorVencer() {
+	rows, err := gw.db.Query(`
+		SELECT c.tenant_id::text, t.nombre, COALESCE(t.email,''), COALESCE(c.no_certificado,''), c.valido_hasta
+		FROM tenant_csds c JOIN tenants t ON t.id=c.tenant_id
+		WHERE c.valido_hasta BETWEEN NOW() AND NOW() + INTERVAL '30 days'
+		  AND c.aviso_vencimiento_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM tenant_csds_historial h WHERE h.tenant_id=c.tenant_id AND h.estado='pendiente')`)
+	if err != nil { log.Printf("[Cron] Error CSD por vencer: %v", err); return }
+	defer rows.Close()
+	for rows.Next() {
+		var tid, nombre, email, noCert string
+		var hasta time.Time
+		if err := rows.Scan(&tid, &nombre, &email, &noCert, &hasta); err != nil { continue }
+		dias := int(time.Until(hasta).Hours()/24) + 1
+		if email != "" { emailCSDVenciendo(email, nombre, noCert, hasta, dias) }
+		gw.sendPushNotification(tid, "Tu CSD vence pronto", fmt.Sprintf("El certificado %s vence en %d días. Sube el nuevo para seguir facturando.", noCert, dias))
+		gw.db.Exec(`UPDATE tenant_csds SET aviso_vencimiento_at=NOW() WHERE tenant_id=$1::uuid`, tid)
+		log.Printf("[Cron] Aviso CSD a tenant=%s (%d dias)", tid, dias)
+	}
+}
+
+// startConciliacionCron compara cada noche (3:00 hora de Monterrey) el estado de los CFDI
+// recientes y de las cancelaciones pendientes contra el SAT.
+func (gw *Gateway) startConciliacionCron() {
+	go func() {
+		for {
+			time.Sleep(hastaLaHora(3))
+			porTenant, err := gw.conciliarCFDIs(context.Background(), "")
+			if err != nil { log.Printf("[Cron] Error conciliación CFDI: %v", err); continue }
+			for tid, n := range porTenant {
+				log.Printf("[Cron] Conciliación CFDI tenant=%s: %d discrepancias", tid, n)
+				var nombre, email string
+				if gw.db.QueryRow(`SELECT nombre, COALESCE(email,'') FROM tenants WHERE id::text=$1`, tid).Scan(&nombre, &email) == nil && email != "" {
+					emailConciliacion(email, nombre, n)
+				}
+			}
+		}
+	}()
+	log.Println("[Cron] Conciliación CFDI iniciada (diaria 03:00)")
+}
+
+// startAvisosNivelCron avisa cada hora por correo a los clientes que cambiaron de tier
+func (gw *Gateway) startAvisosNivelCron() {
+	go func() {
+		for {
+			time.Sleep(1 * time.Hour)
+			gw.avisarCambiosNivel()
+		}
+	}()
+	log.Println("[Cron] Avisos de cambio de tier iniciados (cada hora)")
+}
+
+// avisarCambiosNivel manda los avisos pendientes del historial de tiers. Las cuentas sin
+// correo se marcan como notificadas para no revisarlas otra vez.
+func (gw *Gateway) avisarCambiosNivel() {
+	rows, err := gw.db.Query(`
+		SELECT h.id::text, COALESCE(a.email,''), COALESCE(a.name,''), h.nombre_nuevo, h.subio, COALESCE(t.nombre,'')
+		FROM loyalty_tier_historial h
+		JOIN loyalty_accounts a ON a.id = h.account_id
+		LEFT JOIN tenants t ON t.id = h.tenant_id
+		WHERE h.notificado_at IS NULL
+		ORDER BY h.created_at LIMIT 500`)
+	if err != nil { log.Printf("[Cron] Error avisos de tier: %v", err); return }
+	type aviso struct {
+		id, email, cliente, nivel, negocio string
+		subio                              bool
+	}
+	var avisos []aviso
+	for rows.Next() {
+		var a aviso
+		if rows.Scan(&a.id, &a.email, &a.cliente, &a.nivel, &a.subio, &a.negocio) == nil { avisos = append(avisos, a) }
+	}
+	rows.Close()
+	enviados := 0
+	for _, a := range avisos {
+		if a.email != "" {
+			emailCambioNivel(a.email, a.cliente, a.negocio, a.nivel, a.subio)
+			enviados++
+		}
+		gw.db.Exec(`UPDATE loyalty_tier_historial SET notificado_at=NOW() WHERE id=$1::uuid`, a.id)
+	}
+	if enviados > 0 { log.Printf("[Cron] %d avisos de cambio de tier enviados", enviados) }
+}
+
+// hastaLaHora calcula cuánto falta para la próxima vez que sean las hora:00 en Monterrey
+func hastaLaHora(hora int) time.Duration {
+	loc, err := time.LoadLocation("America/Monterrey")
+	if err != nil { loc = time.Local }
+	now := time.Now().In(loc)
+	next := time.Date(now.Year(), now.Month(), now.Day(), hora, 0, 0, 0, loc)
+	if !next.After(now) { next = next.AddDate(0, 0, 1) }
+	return next.Sub(now)
+}
+
+// estadoCFDILocal traduce el estado que reporta el SAT al cfdi_status que guardamos en sales
+func estadoCFDILocal(estadoSAT, estatusCancelacion string) string {
+	switch {
+	case estadoSAT == "Cancelado":
+		return "cancelado"
+	case estadoSAT == "No Encontrado":
+		return "no_encontrado"
+	case estatusCancelacion == "En proceso":
+		return "cancelacion_pendiente"
+	case estatusCancelacion == "Solicitud rechazada":
+		return "cancelacion_rechazada"
+	default:
+		return "timbrado"
+	}
+}
+
+// conciliarCFDIs consulta en el SAT los CFDI timbrados en los últimos 7 días y las
+// cancelaciones pendientes (de un tenant o de todos si tid es ""), actualiza sales y
+// registra en cfdi_discrepancias cada diferencia. Devuelve las discrepancias por tenant.
+func (gw *Gateway) conciliarCFDIs(ctx context.Context, tid string) (map[string]int, error) {
+	rows, err := gw.db.QueryContext(ctx, `
+		SELECT s.id, COALESCE(s.tenant_id::text,''), s.cfdi_uuid, COALESCE(s.cfdi_status,''),
+		       COALESCE(NULLIF(s.cfdi_rfc_receptor,''), 'XAXX010101000'), s.total - COALESCE(s.cfdi_total_retenido,0),
+		       COALESCE(s.cfdi_timbrado_at, s.created_at), COALESCE(c.rfc_emisor, 'EKU9003173C9')
+		FROM sales s LEFT JOIN tenant_csds c ON c.tenant_id = s.tenant_id
+		WHERE COALESCE(s.cfdi_uuid,'') <> ''
+		  AND ($1 = '' OR s.tenant_id::text = $1)
+		  AND (s.cfdi_status = 'cancelacion_pendiente' OR COALESCE(s.cfdi_timbrado_at, s.created_at) > NOW() - INTERVAL '7 days')
+		ORDER BY s.created_at
+		LIMIT 5000`, tid)
+	if err != nil { return nil, err }
+	type cfdiPendiente struct {
+		saleID, tid, uuid, estado, rfcReceptor, rfcEmisor string
+		total                                             float64
+		timbrado                                          time.Time
+	}
+	var pendientes []cfdiPendiente
+	for rows.Next() {
+		var p cfdiPendiente
+		if err := rows.Scan(&p.saleID, &p.tid, &p.uuid, &p.estado, &p.rfcReceptor, &p.total, &p.timbrado, &p.rfcEmisor); err == nil {
+			pendientes = append(pendientes, p)
+		}
+	}
+	rows.Close()
+
+	cfdiHTTP := getenv("CFDI_HTTP_ADDR", "http://localhost:50055")
+	client := &http.Client{Timeout: 15 * time.Second}
+	porTenant := map[string]int{}
+	revisados := 0
+	for _, p := range pendientes {
+		body, _ := json.Marshal(map[string]string{
+			"uuid": p.uuid, "rfc_emisor": p.rfcEmisor, "rfc_receptor": p.rfcReceptor, "total": fmt.Sprintf("%.2f", p.total),
+		})
+		resp, err := client.Post(cfdiHTTP+"/estatus", "application/json", bytes.NewReader(body))
+		if err != nil { return porTenant, fmt.Errorf("CFDI server no disponible: %w", err) }
+		var st struct {
+			Estado             string `json:"estado"`
+			EstatusCancelacion string `json:"estatus_cancelacion"`
+			Error              string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&st)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Printf("[Conciliación] Error estatus uuid=%s: %s", p.uuid, st.Error)
+			continue
+		}
+		revisados++
+		nuevo := estadoCFDILocal(st.Estado, st.EstatusCancelacion)
+		// El SAT puede tardar hasta 72 h en registrar un CFDI recién timbrado
+		if nuevo == "no_encontrado" && time.Since(p.timbrado) < 72*time.Hour {
+			gw.db.Exec(`UPDATE sales SET cfdi_verificado_at=NOW() WHERE id=$1::uuid`, p.saleID)
+			continue
+		}
+		if nuevo != p.estado {
+			gw.db.Exec(`
+				INSERT INTO cfdi_discrepancias (tenant_id, sale_id, cfdi_uuid, estado_local, estado_nuevo, estado_sat, estatus_cancelacion)
+				VALUES (NULLIF($1,'')::uuid, $2::uuid, $3, $4, $5, $6, $7)`,
+				p.tid, p.saleID, p.uuid, p.estado, nuevo, st.Estado, st.EstatusCancelacion)
+			porTenant[p.tid]++
+			log.Printf("[Conciliación] uuid=%s %s -> %s (SAT: %s %s)", p.uuid, p.estado, nuevo, st.Estado, st.EstatusCancelacion)
+		}
+		gw.db.Exec(`
+			UPDATE sales SET cfdi_status=$1, cfdi_estado_sat=$2, cfdi_estatus_cancelacion=NULLIF($3,''), cfdi_verificado_at=NOW()
+			WHERE id=$4::uuid`, nuevo, st.Estado, st.EstatusCancelacion, p.saleID)
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Printf("[Conciliación] %d CFDI revisados, %d tenants con discrepancias", revisados, len(porTenant))
+	return porTenant, nil
+}
+
 // ── RATE LIMITER para login ─────────────────────────────────────
 
 func checkRateLimit(ip string) bool {
@@ -130,21 +370,9 @@ func (gw *Gateway) handleAIQuery(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Obtener tenant del token
-	tokenStr := r.Header.Get("Authorization")
 	tenantID := ""
-	// Extraer tenant_id del JWT
-	if tokenStr != "" {
-		parts := strings.Split(strings.TrimPrefix(tokenStr, "Bearer "), ".")
-		if len(parts) == 3 {
-			if payload, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil {
-				var claims map[string]interface{}
-				if json.Unmarshal(payload, &claims) == nil {
-					if tid, ok := claims["tenant_id"].(string); ok {
-						tenantID = tid
-					}
-				}
-			}
-		}
+	if claims, err := gw.validarToken(r.Context(), strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")); err == nil {
+		tenantID = claims.TenantId
 	}
 
 	var req struct {
@@ -279,11 +507,18 @@ func main() {
 		loyaltyClient: pb_loyalty.NewLoyaltyServiceClient(loyaltyConn),
 		db:            db,
 	}
+	gw.startCSDCron()
+	gw.startConciliacionCron()
+	gw.startAvisosNivelCron()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/v1/products",   gw.handleProducts)
 	mux.HandleFunc("/api/v1/products/",  gw.handleProductByID)
 	mux.HandleFunc("/api/v1/login",           gw.handleLogin)
+	mux.HandleFunc("/api/v1/refresh",         gw.handleRefresh)
+	mux.HandleFunc("/api/v1/logout",          gw.handleLogout)
+	mux.HandleFunc("/api/v1/sesiones",        gw.handleSesiones)
+	mux.HandleFunc("/api/v1/sesiones/",       gw.handleSesiones)
 	mux.HandleFunc("/api/v1/signup",            gw.handleSignup)
 	mux.HandleFunc("/api/v1/forgot-password",  gw.handleForgotPassword)
 	mux.HandleFunc("/api/v1/reset-password",   gw.handleResetPassword)
@@ -293,6 +528,7 @@ func main() {
 	mux.HandleFunc("/api/v1/cobrar",          gw.handleCobrar)
 	mux.HandleFunc("/api/v1/timbrar",         gw.handleTimbrar)
 	mux.HandleFunc("/api/v1/cancelar",        gw.handleCancelar)
+	mux.HandleFunc("/api/v1/ventas/",         gw.handleVenta)
 	mux.HandleFunc("/api/v1/status",          gw.handleStatus)
 	mux.HandleFunc("/api/v1/logs",            gw.handleLogs)
 	mux.HandleFunc("/api/v1/corte",           gw.handleCorte)
@@ -302,16 +538,42 @@ func main() {
 	mux.HandleFunc("/api/v1/loyalty/cp",      gw.handleLoyaltyCp)
     mux.HandleFunc("/api/v1/loyalty/fiscal",   gw.handleLoyaltyFiscal)
 	mux.HandleFunc("/api/v1/loyalty/cliente",  gw.handleLoyaltyCliente)
+	mux.HandleFunc("/api/v1/loyalty/programa", gw.handleLoyaltyPrograma)
+	mux.HandleFunc("/api/v1/loyalty/programa/", gw.handleLoyaltyPrograma)
+	mux.HandleFunc("/api/v1/loyalty/compartir", gw.handleLoyaltyCompartir)
+	mux.HandleFunc("/api/v1/loyalty/por-vencer", gw.handleLoyaltyPorVencer)
+	mux.HandleFunc("/api/v1/loyalty/recompensas", gw.handleLoyaltyRecompensas)
+	mux.HandleFunc("/api/v1/loyalty/canjes/", gw.handleLoyaltyCanje)
 	mux.HandleFunc("/api/v1/customers", gw.handleCustomers)
 	mux.HandleFunc("/api/v1/customers/", gw.handleCustomerByID)
+	mux.HandleFunc("/api/v1/customers/duplicados", gw.handleCustomerDuplicados)
+	mux.HandleFunc("/api/v1/customers/fusionar", gw.handleCustomerDuplicados)
+	mux.HandleFunc("/api/v1/credito", gw.handleCreditos)
+	mux.HandleFunc("/api/v1/arco", gw.handleARCO)
 	mux.HandleFunc("/api/v1/migrate/preview", gw.handleMigratePreview)
 	mux.HandleFunc("/api/v1/migrate",         gw.handleMigrate)
 	mux.HandleFunc("/api/v1/reportes", gw.handleReportes)
 	mux.HandleFunc("/api/v1/reportes/cfdi", gw.handleReportesCFDI)
+	mux.HandleFunc("/api/v1/reportes/cfdi/conciliacion", gw.handleConciliacionCFDI)
 	mux.HandleFunc("/api/v1/admin/tenants",  gw.handleAdminTenants)
 	mux.HandleFunc("/api/v1/admin/tenants/", gw.handleAdminTenantByID)
     mux.HandleFunc("/api/v1/csd",        gw.handleCSDUpload)
     mux.HandleFunc("/api/v1/csd/info",   gw.handleCSDInfo)
+    mux.HandleFunc("/api/v1/cfdi/series", gw.handleCFDISeries)
+    mux.HandleFunc("/api/v1/cfdi/lote", gw.handleTimbrarLote)
+    mux.HandleFunc("/api/v1/cfdi/retenciones", gw.handleRetenciones)
+    mux.HandleFunc("/api/v1/tipos-cambio", gw.handleTiposCambio)
+    mux.HandleFunc("/api/v1/tipos-cambio/dof", gw.handleImportarDOF)
+	mux.HandleFunc("/api/v1/autofactura/", gw.handleAutofactura)
+	mux.HandleFunc("/api/v1/portal-lealtad/", gw.handlePortalLealtad)
+	mux.HandleFunc("/lealtad/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		http.ServeFile(w, r, "web/lealtad.html")
+	})
+	mux.HandleFunc("/factura/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		http.ServeFile(w, r, "web/factura.html")
+	})
     mux.HandleFunc("/api/v1/push/register", gw.handlePushRegister)
     mux.HandleFunc("/api/v1/config/negocio", gw.handleConfigNegocio)
 	// Archivos estaticos PWA
@@ -368,105 +630,23 @@ func (gw *Gateway) handleSignup(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]string{"error": "JSON invalido"})
 		return
 	}
-	if req.Nombre == "" || req.RFC == "" || req.Email == "" || req.Password == "" {
-		w.WriteHeader(400)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Campos requeridos: nombre, rfc, email, password"})
-		return
-	}
-	if len(req.Password) < 8 {
-		w.WriteHeader(400)
-		json.NewEncoder(w).Encode(map[string]string{"error": "La contrasena debe tener al menos 8 caracteres"})
-		return
-	}
-	if req.Plan == "" { req.Plan = "starter" }
-	if req.RegimenFiscal == "" { req.RegimenFiscal = "612" }
-	if req.CodigoPostal == "" { req.CodigoPostal = "06600" }
-	rfc := strings.ToUpper(strings.TrimSpace(req.RFC))
-
-	planAmount := map[string]float64{"starter": 1990, "business": 4990, "pro": 9990}
-	amount, ok := planAmount[req.Plan]
-	if !ok { amount = 1990; req.Plan = "starter" }
-
-	ctx := r.Context()
-
-	// Validar RFC único
-	var count int
-	if err := gw.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM tenants WHERE rfc=$1", rfc).Scan(&count); err != nil {
-		log.Printf("[Signup] Error validando RFC: %v", err)
-		w.WriteHeader(500); json.NewEncoder(w).Encode(map[string]string{"error": "Error interno"}); return
-	}
-	if count > 0 {
-		w.WriteHeader(409)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Ya existe una cuenta con ese RFC"})
-		return
-	}
-
-	// Validar email único
-	if err := gw.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE email=$1", req.Email).Scan(&count); err != nil {
-		log.Printf("[Signup] Error validando email: %v", err)
-		w.WriteHeader(500); json.NewEncoder(w).Encode(map[string]string{"error": "Error interno"}); return
-	}
-	if count > 0 {
-		w.WriteHeader(409)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Ese email ya esta registrado"})
-		return
-	}
-
-	// Hash password
-	pwHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), 12)
-	if err != nil {
-		w.WriteHeader(500); json.NewEncoder(w).Encode(map[string]string{"error": "Error procesando contrasena"}); return
-	}
-
-	tx, err := gw.db.BeginTx(ctx, nil)
-	if err != nil {
-		w.WriteHeader(500); json.NewEncoder(w).Encode(map[string]string{"error": "Error interno"}); return
-	}
-	defer tx.Rollback()
-
-	razonSocial := req.RazonSocial
-	if razonSocial == "" { razonSocial = req.Nombre }
-
-	// Crear tenant
-	var tenantID string
-	err = tx.QueryRowContext(ctx,
-		"INSERT INTO tenants (nombre, rfc, razon_social, regimen_fiscal, codigo_postal, plan, email, telefono) "+
-		"VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING id",
-		req.Nombre, rfc, razonSocial, req.RegimenFiscal,
-		req.CodigoPostal, req.Plan, req.Email, req.Telefono,
-	).Scan(&tenantID)
-	if err != nil {
-		log.Printf("[Signup] Error creando tenant: %v", err)
-		w.WriteHeader(500); json.NewEncoder(w).Encode(map[string]string{"error": "Error creando negocio"}); return
-	}
-
-	// Crear usuario admin (tabla usa email como identificador)
-	_, err = tx.ExecContext(ctx,
-		"INSERT INTO users (email, password_hash, tenant_id) VALUES ($1,$2,$3::uuid)",
-		req.Email, string(pwHash), tenantID,
-	)
+	resp, err := gw.authClient.Signup(r.Context(), &pb_auth.SignupRequest{
+		BusinessName: req.Nombre, Rfc: req.RFC, RazonSocial: req.RazonSocial,
+		CodigoPostal: req.CodigoPostal, RegimenFiscal: req.RegimenFiscal, Plan: req.Plan,
+		Email: req.Email, Password: req.Password, Phone: req.Telefono,
+	})
 	if err != nil {
-		log.Printf("[Signup] Error creando usuario: %v", err)
-		w.WriteHeader(500); json.NewEncoder(w).Encode(map[string]string{"error": "Error creando usuario"}); return
-	}
-
-	// Suscripcion trial
-	gw.db.ExecContext(ctx,
-		"INSERT INTO subscriptions (tenant_id, plan, status, amount) VALUES ($1::uuid,$2,'trial',$3)",
-		tenantID, req.Plan, amount,
-	)
-
-	if err := tx.Commit(); err != nil {
-		w.WriteHeader(500); json.NewEncoder(w).Encode(map[string]string{"error": "Error finalizando registro"}); return
+		log.Printf("[Signup] %v", err)
+		errorAuth(w, err)
+		return
 	}
 
-	log.Printf("[Signup] Nuevo tenant: rfc=%s plan=%s email=%s", rfc, req.Plan, req.Email)
-	emailBienvenida(req.Email, req.Nombre, req.Plan, req.Email)
+	emailBienvenida(resp.Username, req.Nombre, resp.Plan, resp.Username)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"ok":        true,
-		"tenant_id": tenantID,
-		"username":  req.Email,
-		"plan":      req.Plan,
+		"tenant_id": resp.TenantId,
+		"username":  resp.Username,
+		"plan":      resp.Plan,
 		"url":       "/",
 		"message":   "Cuenta creada. Tu prueba de 14 dias comienza ahora.",
 	})
@@ -832,6 +1012,55 @@ func emailTrialVenciendo(to, nombre string, diasRestantes int) {
 	go sendEmail(to, fmt.Sprintf("TurboPOS — Tu prueba vence en %d días", diasRestantes), body)
 }
 
+func emailCSDVenciendo(to, nombre, noCert string, vence time.Time, diasRestantes int) {
+	body := `<!DOCTYPE html><html><body style="font-family:sans-serif;background:#07090F;color:#EDF2FF;padding:40px">
+	<div style="max-width:520px;margin:0 auto;background:#0D1018;border:1px solid #1C2535;border-radius:16px;padding:40px">
+	<h1 style="color:#FFB547;font-size:24px">Tu certificado de sello digital vence pronto</h1>
+	<p style="color:#8896B0">Hola ` + nombre + `, el CSD <strong>` + noCert + `</strong> con el que TurboPOS timbra tus facturas vence el <strong style="color:#FFB547">` + vence.Format("02/01/2006") + `</strong> (` + fmt.Sprintf("%d días", diasRestantes) + `).</p>
+	<p style="color:#8896B0">Tramita uno nuevo en el portal del SAT y súbelo en Configuración → Facturación. Puedes subirlo antes de que entre en vigor; lo activaremos automáticamente.</p>
+	<a href="https://turbopos.mx/config" style="display:inline-block;padding:14px 28px;background:#FFB547;color:#000;text-decoration:none;border-radius:10px;font-weight:700;margin:24px 0">Subir nuevo CSD →</a>
+	</div></body></html>`
+	go sendEmail(to, fmt.Sprintf("TurboPOS — Tu CSD vence en %d días", diasRestantes), body)
+}
+
+func emailConciliacion(to, nombre string, discrepancias int) {
+	body := `<!DOCTYPE html><html><body style="font-family:sans-serif;background:#07090F;color:#EDF2FF;padding:40px">
+	<div style="max-width:520px;margin:0 auto;background:#0D1018;border:1px solid #1C2535;border-radius:16px;padding:40px">
+	<h1 style="color:#FFB547;font-size:24px">Cambios en tus facturas</h1>
+	<p style="color:#8896B0">Hola ` + nombre + `, al comparar tus facturas con el SAT encontramos <strong style="color:#FFB547">` + fmt.Sprintf("%d", discrepancias) + `</strong> con un estado distinto al registrado en TurboPOS (cancelaciones aceptadas, rechazadas por el cliente o vencidas).</p>
+	<a href="https://turbopos.mx/reportes" style="display:inline-block;padding:14px 28px;background:#FFB547;color:#000;text-decoration:none;border-radius:10px;font-weight:700;margin:24px 0">Ver reporte →</a>
+	</div></body></html>`
+	go sendEmail(to, fmt.Sprintf("TurboPOS — %d facturas cambiaron de estado en el SAT", discrepancias), body)
+}
+
+func emailCambioNivel(to, cliente, negocio, nivel string, subio bool) {
+	cliente, negocio, nivel = html.EscapeString(cliente), html.EscapeString(negocio), html.EscapeString(nivel)
+	titulo, texto := "¡Subiste a "+nivel+"!", "Gracias a tus compras ahora eres cliente <strong style=\"color:#FFB547\">"+nivel+"</strong> en "+negocio+". Pregunta en caja por tus nuevos beneficios."
+	if !subio {
+		titulo, texto = "Tu nivel cambió a "+nivel, "Tu nivel en "+negocio+" ahora es <strong style=\"color:#FFB547\">"+nivel+"</strong>. Sigue comprando para recuperar tus beneficios."
+	}
+	if cliente == "" { cliente = "cliente" }
+	body := `<!DOCTYPE html><html><body style="font-family:sans-serif;background:#07090F;color:#EDF2FF;padding:40px">
+	<div style="max-width:520px;margin:0 auto;background:#0D1018;border:1px solid #1C2535;border-radius:16px;padding:40px">
+	<h1 style="color:#FFB547;font-size:24px">` + titulo + `</h1>
+	<p style="color:#8896B0">Hola ` + cliente + `, ` + texto + `</p>
+	</div></body></html>`
+	go sendEmail(to, negocio+" — "+titulo, body)
+}
+
+func emailCodigoPortal(to, cliente, negocio, codigo string) {
+	cliente, negocio = html.EscapeString(cliente), html.EscapeString(negocio)
+	if cliente == "" { cliente = "cliente" }
+	body := `<!DOCTYPE html><html><body style="font-family:sans-serif;background:#07090F;color:#EDF2FF;padding:40px">
+	<div style="max-width:520px;margin:0 auto;background:#0D1018;border:1px solid #1C2535;border-radius:16px;padding:40px">
+	<h1 style="color:#FFB547;font-size:24px">Tu código de acceso</h1>
+	<p style="color:#8896B0">Hola ` + cliente + `, usa este código para consultar tus puntos en ` + negocio + `:</p>
+	<p style="font-size:36px;font-weight:800;letter-spacing:8px;color:#FFB547;margin:24px 0">` + codigo + `</p>
+	<p style="color:#8896B0;font-size:13px">Vence en 10 minutos. Si no lo pediste, ignora este correo.</p>
+	</div></body></html>`
+	go sendEmail(to, negocio+" — tu código es "+codigo, body)
+}
+
 // handleForgotPassword — solicitar recuperacion de contrasena
 func (gw *Gateway) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -840,25 +1069,17 @@ func (gw *Gateway) handleForgotPassword(w http.ResponseWriter, r *http.Request)
 	json.NewDecoder(r.Body).Decode(&req)
 	if req.Email == "" { w.WriteHeader(400); json.NewEncoder(w).Encode(map[string]string{"error": "email requerido"}); return }
 
-	// Verificar que el email existe
-	var userID string
-	err := gw.db.QueryRowContext(r.Context(), "SELECT id FROM users WHERE email=$1", req.Email).Scan(&userID)
+	resp, err := gw.authClient.RequestPasswordReset(r.Context(), &pb_auth.PasswordResetRequest{Email: req.Email})
 	if err != nil {
-		// No revelar si el email existe o no
-		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "message": "Si el email existe recibirás instrucciones"})
+		log.Printf("[Email] Error solicitando reset para %s: %v", req.Email, err)
+		errorAuth(w, err)
 		return
 	}
-
-	// Crear token de recuperacion (simple UUID)
-	var token string
-	gw.db.QueryRowContext(r.Context(), "SELECT gen_random_uuid()::text").Scan(&token)
-
-	// Guardar token en DB (expira en 1 hora)
-	// Guardar token en DB (expira en 1 hora)
-	gw.db.ExecContext(r.Context(), "INSERT INTO password_reset_tokens (user_id, token, expires_at) VALUES ($1::uuid, $2, NOW()+INTERVAL '1 hour') ON CONFLICT (user_id) DO UPDATE SET token=$2, expires_at=NOW()+INTERVAL '1 hour'", userID, token)
-
-	emailRecuperacion(req.Email, token)
-	log.Printf("[Email] Reset password solicitado para %s", req.Email)
+	// No revelar si el email existe o no
+	if resp.Token != "" {
+		emailRecuperacion(req.Email, resp.Token)
+		log.Printf("[Email] Reset password solicitado para %s", req.Email)
+	}
 	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "message": "Si el email existe recibirás instrucciones"})
 }
 
@@ -871,18 +1092,10 @@ func (gw *Gateway) handleResetPassword(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}
 	json.NewDecoder(r.Body).Decode(&req)
-	if req.Token == "" || req.Password == "" { w.WriteHeader(400); json.NewEncoder(w).Encode(map[string]string{"error": "token y password requeridos"}); return }
-	if len(req.Password) < 8 { w.WriteHeader(400); json.NewEncoder(w).Encode(map[string]string{"error": "password minimo 8 caracteres"}); return }
-
-	var userID string
-	err := gw.db.QueryRowContext(r.Context(),
-		"SELECT user_id FROM password_reset_tokens WHERE token=$1 AND expires_at > NOW()", req.Token).Scan(&userID)
-	if err != nil { w.WriteHeader(400); json.NewEncoder(w).Encode(map[string]string{"error": "Token invalido o expirado"}); return }
-
-	hash, _ := bcrypt.GenerateFromPassword([]byte(req.Password), 12)
-	gw.db.ExecContext(r.Context(), "UPDATE users SET password_hash=$1 WHERE id=$2::uuid", string(hash), userID)
-	gw.db.ExecContext(r.Context(), "DELETE FROM password_reset_tokens WHERE token=$1", req.Token)
-	log.Printf("[Email] Password actualizado para userID=%s", userID)
+	if _, err := gw.authClient.ResetPassword(r.Context(), &pb_auth.ResetPasswordRequest{Token: req.Token, Password: req.Password}); err != nil {
+		errorAuth(w, err)
+		return
+	}
 	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "message": "Contraseña actualizada"})
 }
 
@@ -893,6 +1106,11 @@ func (gw *Gateway) handleCobrar(w http.ResponseWriter, r *http.Request) {
 		Total         float64 `json:"total"`
 		PaymentMethod string  `json:"payment_method"`
 		CustomerName  string  `json:"customer_name"`
+		// Cliente elegido en la búsqueda de clientes; sin él se usa ?phone= o ?rfc=
+		CustomerID    string  `json:"customer_id"`
+		Moneda        string  `json:"moneda"`
+		// Descuento por el tier del cliente (?phone=), ya restado de Total
+		DescuentoNivel float64 `json:"descuento_nivel"`
 		Items []struct {
 			ProductID string  `json:"product_id"`
 			Name      string  `json:"name"`
@@ -908,39 +1126,104 @@ func (gw *Gateway) handleCobrar(w http.ResponseWriter, r *http.Request) {
 	}
 	if req.PaymentMethod == "" { req.PaymentMethod = "cash" }
 	if req.CashierID == ""    { req.CashierID = "00000000-0000-0000-0000-000000000001" }
+	req.Moneda = strings.ToUpper(strings.TrimSpace(req.Moneda))
+	if req.Moneda == "" { req.Moneda = "MXN" }
+	tipoCambio, err := gw.tipoCambio(r.Context(), tenantID(r), req.Moneda, time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	// La caja calcula el descuento con tier_discount_pct; aquí se valida contra el tier
+	// vigente del cliente antes de registrar la venta
+	if req.DescuentoNivel > 0 {
+		phone := r.URL.Query().Get("phone")
+		fail := func(msg string) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": msg})
+		}
+		if phone == "" { fail("descuento_nivel requiere el teléfono del cliente"); return }
+		ctxA, cancelA := context.WithTimeout(r.Context(), 3*time.Second)
+		acc, err := gw.loyaltyClient.GetAccount(ctxA, &pb_loyalty.GetAccountRequest{Phone: phone, TenantId: tenantID(r)})
+		cancelA()
+		if err != nil { fail("no se pudo validar el tier del cliente"); return }
+		var bruto float64
+		for _, it := range req.Items { bruto += it.Subtotal }
+		if maximo := math.Round(bruto*acc.GetTierDiscountPct()) / 100; req.DescuentoNivel > maximo+0.01 {
+			fail(fmt.Sprintf("el tier %s permite hasta $%.2f de descuento", acc.GetTierName(), maximo))
+			return
+		}
+	}
+
+	// Cliente de la venta. Un cliente elegido en la búsqueda también gana puntos aunque la
+	// caja no mande su teléfono.
+	phone := strings.TrimSpace(r.URL.Query().Get("phone"))
+	customerID, customerPhone := gw.clienteVenta(r.Context(), tenantID(r), req.CustomerID, phone, r.URL.Query().Get("rfc"))
+	if req.CustomerID != "" && customerID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "cliente no encontrado"})
+		return
+	}
+	if phone == "" { phone = customerPhone }
+	// A crédito (fiado) el cargo va a la cuenta del cliente; el servicio de ventas revisa
+	// límite, atraso y bloqueo antes de registrar la venta
+	if req.PaymentMethod == "credit" && customerID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "la venta a crédito requiere un cliente"})
+		return
+	}
 
 	var items []*pb_sales.SaleItem
+	var puntosItems []*pb_loyalty.EarnItem
 	for _, it := range req.Items {
 		items = append(items, &pb_sales.SaleItem{
 			ProductId: it.ProductID, Name: it.Name,
 			Quantity: it.Quantity, UnitPrice: it.UnitPrice, Subtotal: it.Subtotal,
 		})
+		puntosItems = append(puntosItems, &pb_loyalty.EarnItem{ProductId: it.ProductID, Subtotal: it.Subtotal})
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
 	defer cancel()
 	res, err := gw.salesClient.CreateSale(ctx, &pb_sales.CreateSaleRequest{
 		CashierId: req.CashierID, Total: req.Total,
 		PaymentMethod: req.PaymentMethod, Items: items,
+		TenantId: tenantID(r), CustomerId: customerID,
 	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		code := http.StatusInternalServerError
+		if c := status.Code(err); c == codes.FailedPrecondition || c == codes.InvalidArgument { code = http.StatusBadRequest }
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": status.Convert(err).Message()})
 		return
 	}
 	// Marcar la venta con el tenant_id
 	tid := tenantID(r)
-	gw.db.Exec(`UPDATE sales SET tenant_id=$1 WHERE id=$2::uuid`, tid, res.GetSaleId())
-	if phone := r.URL.Query().Get("phone"); phone != "" {
+	sucursal := strings.TrimSpace(r.URL.Query().Get("sucursal"))
+	gw.db.Exec(`UPDATE sales SET tenant_id=$1, sucursal=NULLIF($2,''), moneda=$3, tipo_cambio=$4::numeric, descuento_lealtad=$5,
+		customer_id=NULLIF($7,'')::uuid WHERE id=$6::uuid`,
+		tid, sucursal, req.Moneda, tipoCambio, req.DescuentoNivel, res.GetSaleId(), customerID)
+	if phone != "" {
 		go func() {
 			ctxL, cancelL := context.WithTimeout(context.Background(), 3*time.Second)
 			defer cancelL()
 			acc, err := gw.loyaltyClient.EarnPoints(ctxL, &pb_loyalty.EarnPointsRequest{
 				Phone: phone, SaleId: res.GetSaleId(), Total: req.Total, Name: req.CustomerName,
+				TenantId: tid, Items: puntosItems,
 			})
 			if err != nil {
 				log.Printf("[BFF] Loyalty error: %v", err)
+				return
+			}
+			// Un teléfono nuevo no tenía cuenta al cobrar; EarnPoints la acaba de crear
+			if customerID == "" {
+				gw.db.Exec(`UPDATE sales SET customer_id=$1::uuid WHERE id=$2::uuid AND customer_id IS NULL`, acc.GetAccountId(), res.GetSaleId())
+			}
+			if acc.GetAlreadyEarned() {
+				log.Printf("[BFF] Loyalty: la venta %s ya había dado %dpts a %s", res.GetSaleId(), acc.GetPointsEarned(), phone)
 			} else {
-				log.Printf("[BFF] Loyalty +%.0fpts para %s — total: %d tier: %s", req.Total, phone, acc.GetPoints(), acc.GetTier())
+				log.Printf("[BFF] Loyalty +%dpts para %s — total: %d tier: %s", acc.GetPointsEarned(), phone, acc.GetPoints(), acc.GetTier())
+				if acc.GetReferralBonus() > 0 { log.Printf("[BFF] Loyalty: %s recibió %dpts de bono por referido", phone, acc.GetReferralBonus()) }
 			}
 		}()
 	}
@@ -948,44 +1231,41 @@ func (gw *Gateway) handleCobrar(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Query().Get("factura") == "1" || r.URL.Query().Get("rfc") != "" {
 		rfcTimbrar := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("rfc")))
         if rfcTimbrar == "" { rfcTimbrar = "XAXX010101000" }
-        nombreTimbrar := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("nombre")))
-        regimenTimbrar := r.URL.Query().Get("regimen")
-        if regimenTimbrar == "" {
-                if len(rfcTimbrar) == 13 { regimenTimbrar = "612" } else { regimenTimbrar = "601" }
-        }
-        if rfcTimbrar != "XAXX010101000" && nombreTimbrar == "" {
-                var nombre, regimen string
-                row := gw.db.QueryRow(
-                        `SELECT COALESCE(nombre,''), COALESCE(regimen_fiscal,'') FROM loyalty_accounts WHERE rfc = $1 LIMIT 1`,
-                        rfcTimbrar)
-                if err := row.Scan(&nombre, &regimen); err == nil {
-                        if nombreTimbrar == "" { nombreTimbrar = nombre }
-                        if regimenTimbrar == "" { regimenTimbrar = regimen }
-                }
-        }
-        go func(saleID string, total float64, rfc, cp, nombre, regimen, tID string) {
+        receptor := gw.completarReceptor(r.Context(), tid, receptorFiscal{
+                RFC:     rfcTimbrar,
+                Nombre:  strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("nombre"))),
+                Regimen: r.URL.Query().Get("regimen"),
+                Uso:     r.URL.Query().Get("uso"),
+                CP:      r.URL.Query().Get("cp"),
+        })
+        go func(saleID string, total float64, rec receptorFiscal, tID string) {
                 ctxT, cancelT := context.WithTimeout(context.Background(), 30*time.Second)
                 defer cancelT()
-                treq := &pb_cfdi.FacturaRequest{VentaId: saleID, Total: total, Rfc: rfc,
-                        CodigoPostalReceptor: cp, NombreReceptor: nombre, RegimenFiscalReceptor: regimen}
+                treq := &pb_cfdi.FacturaRequest{VentaId: saleID, Total: total, Rfc: rec.RFC,
+                        CodigoPostalReceptor: rec.CP, NombreReceptor: rec.Nombre,
+                        RegimenFiscalReceptor: rec.Regimen, UsoCfdi: rec.Uso,
+                        Moneda: req.Moneda, TipoCambio: tipoCambio, TenantId: tID}
                 if cB64, kBytes, kPass, _, csdOK := gw.loadTenantCSD(tID); csdOK {
                     treq.CertB64 = cB64; treq.KeyBytes = kBytes; treq.KeyPassword = kPass
                 }
-                tRes, err := gw.cfdiClient.Timbrar(ctxT, treq)
+                tRes, err := gw.timbrarVenta(ctxT, tID, sucursal, treq)
                 if err != nil {
                     log.Printf("[BFF] Auto-timbrado error sale=%s: %v", saleID, err)
                     return
                 }
-                gw.db.Exec(`UPDATE sales SET cfdi_uuid=$1, cfdi_status=$2 WHERE id=$3::uuid`,
-                    tRes.GetUuid(), "timbrado", saleID)
+                gw.db.Exec(`UPDATE sales SET cfdi_uuid=$1, cfdi_status=$2, cfdi_rfc_receptor=$3, cfdi_nombre_receptor=$4, cfdi_xml=$5, cfdi_timbrado_at=NOW() WHERE id=$6::uuid`,
+                    tRes.GetUuid(), "timbrado", rec.RFC, rec.Nombre, tRes.GetXml(), saleID)
                 log.Printf("[BFF] Auto-timbrado OK sale=%s uuid=%s", saleID, tRes.GetUuid())
-        }(res.GetSaleId(), req.Total, rfcTimbrar, r.URL.Query().Get("cp"), nombreTimbrar, regimenTimbrar, tid)
+        }(res.GetSaleId(), req.Total, receptor, tid)
 	}
 	go gw.sendPushNotification(tid, "Nueva venta $"+fmt.Sprintf("%.2f", req.Total), fmt.Sprintf("%d producto(s) · %s", len(req.Items), req.PaymentMethod))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"sale_id": res.GetSaleId(), "status": res.GetStatus(),
 		"total": res.GetTotal(), "created_at": res.GetCreatedAt(),
+		"moneda": req.Moneda, "tipo_cambio": tipoCambio, "customer_id": customerID,
+		"autofactura_url": getenv("APP_URL", "https://turbopos.mx") + "/factura/" + tid,
+		"portal_lealtad_url": getenv("APP_URL", "https://turbopos.mx") + "/lealtad/" + tid,
 	})
 }
 
@@ -1007,6 +1287,8 @@ func (gw *Gateway) handleCustomers(w http.ResponseWriter, r *http.Request) {
 		token = r.URL.Query().Get("token")
 	}
 
+	programaID := gw.tenantLealtad(r.Context(), tenantID(r))
+
 	switch r.Method {
 	case http.MethodGet:
 		search := r.URL.Query().Get("search")
@@ -1016,13 +1298,14 @@ func (gw *Gateway) handleCustomers(w http.ResponseWriter, r *http.Request) {
 			SELECT id, phone, COALESCE(rfc,''), name, COALESCE(email,''),
 			       points, total_spent, tier, created_at
 			FROM   loyalty_accounts
-			WHERE  ($1 = '' OR name ILIKE '%' || $1 || '%'
+			WHERE  tenant_id = $3::uuid AND anonimizado_at IS NULL
+			  AND  ($1 = '' OR name ILIKE '%' || $1 || '%'
 			        OR COALESCE(rfc,'') ILIKE '%' || $1 || '%'
 			        OR phone ILIKE '%' || $1 || '%')
 			  AND  ($2 = '' OR tier = $2)
 			ORDER  BY total_spent DESC
 			LIMIT  100`,
-			search, tier)
+			search, tier, programaID)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
@@ -1064,23 +1347,29 @@ func (gw *Gateway) handleCustomers(w http.ResponseWriter, r *http.Request) {
 			Name    string `json:"name"`
 			RFC     string `json:"rfc"`
 			Email   string `json:"email"`
+			// Código de referido de otro cliente; solo para clientes sin compras
+			CodigoReferido string `json:"codigo_referido"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Phone == "" || req.Name == "" {
 			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(map[string]string{"error": "phone y name son requeridos"})
 			return
 		}
+		if strings.TrimSpace(req.CodigoReferido) != "" {
+			gw.registrarReferido(w, r, req.Phone, req.Name, req.Email, req.RFC, req.CodigoReferido)
+			return
+		}
 		var id string
 		err := gw.db.QueryRowContext(r.Context(), `
-			INSERT INTO loyalty_accounts (phone, name, rfc, email, points, total_spent, tier)
-			VALUES ($1, $2, NULLIF($3,''), NULLIF($4,''), 0, 0, 'bronze')
-			ON CONFLICT (phone) DO UPDATE SET
+			INSERT INTO loyalty_accounts (tenant_id, phone, name, rfc, email, points, total_spent, tier)
+			VALUES ($5::uuid, $1, $2, NULLIF($3,''), NULLIF($4,''), 0, 0, 'bronze')
+			ON CONFLICT (tenant_id, phone) DO UPDATE SET
 			    name       = EXCLUDED.name,
 			    rfc        = COALESCE(EXCLUDED.rfc, loyalty_accounts.rfc),
 			    email      = COALESCE(EXCLUDED.email, loyalty_accounts.email),
 			    updated_at = now()
 			RETURNING id`,
-			req.Phone, req.Name, req.RFC, req.Email).Scan(&id)
+			req.Phone, req.Name, req.RFC, req.Email, programaID).Scan(&id)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
@@ -1094,591 +1383,2118 @@ func (gw *Gateway) handleCustomers(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (gw *Gateway) handleCustomerByID(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, DELETE, OPTIONS")
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
+// registrarReferido da de alta un cliente con el código de referido de otro. Los errores
+// de las reglas anti-abuso (propio código, correo repetido, tope mensual, cliente con
+// compras) se devuelven tal cual para que la caja pueda registrarlo sin código.
+func (gw *Gateway) registrarReferido(w http.ResponseWriter, r *http.Request, phone, name, email, rfc, codigo string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	res, err := gw.loyaltyClient.RegisterReferral(ctx, &pb_loyalty.RegisterReferralRequest{
+		TenantId: tenantID(r), Code: codigo, Phone: phone, Name: name, Email: email,
+	})
+	if err != nil {
+		code := http.StatusBadGateway
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.FailedPrecondition, codes.NotFound, codes.AlreadyExists:
+			code = http.StatusBadRequest
+		}
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": status.Convert(err).Message()})
 		return
 	}
+	if rfc = strings.ToUpper(strings.TrimSpace(rfc)); rfc != "" {
+		gw.db.ExecContext(r.Context(), `UPDATE loyalty_accounts SET rfc = $1 WHERE id = $2::uuid AND COALESCE(rfc,'') = ''`,
+			rfc, res.GetAccount().GetAccountId())
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id": res.GetAccount().GetAccountId(), "status": "created", "referido_por": res.GetReferrerName(),
+		"bono": res.GetBonusPoints(), "compra_minima": res.GetMinimumPurchase(), "message": res.GetMessage(),
+	})
+}
 
-	id := strings.TrimPrefix(r.URL.Path, "/api/v1/customers/")
-	if id == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "ID requerido"})
-		return
+// handleCustomerDuplicados detecta y fusiona clientes duplicados del programa de lealtad.
+//   GET  /api/v1/customers/duplicados[?account_id=] — grupos sugeridos por mismo RFC,
+//        mismo correo o nombre casi igual
+//   POST /api/v1/customers/fusionar {superviviente_id, fusionar_ids, motivo} — pasa
+//        movimientos, puntos, canjes, referidos y datos fiscales a la superviviente
+func (gw *Gateway) handleCustomerDuplicados(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
 	}
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		token = r.URL.Query().Get("token")
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	cuentaJSON := func(a *pb_loyalty.AccountResponse) map[string]interface{} {
+		return map[string]interface{}{
+			"id": a.GetAccountId(), "phone": a.GetPhone(), "name": a.GetName(), "rfc": a.GetRfc(),
+			"points": a.GetPoints(), "total_spent": a.GetTotalSpent(), "tier": a.GetTier(), "tier_name": a.GetTierName(),
+		}
 	}
-	_ = token
 
-	switch r.Method {
-	case http.MethodGet:
-		var c struct {
-			ID         string  `json:"id"`
-			Phone      string  `json:"phone"`
-			RFC        string  `json:"rfc"`
-			Name       string  `json:"name"`
-			Email      string  `json:"email"`
-			Points     int     `json:"points"`
-			TotalSpent float64 `json:"total_spent"`
-			Tier       string  `json:"tier"`
-		}
-		err := gw.db.QueryRowContext(r.Context(), `
-			SELECT id, phone, COALESCE(rfc,''), name, COALESCE(email,''),
-			       points, total_spent, tier
-			FROM   loyalty_accounts WHERE id = $1`, id).Scan(
-			&c.ID, &c.Phone, &c.RFC, &c.Name, &c.Email,
-			&c.Points, &c.TotalSpent, &c.Tier)
-		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(map[string]string{"error": "cliente no encontrado"})
-			return
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/duplicados") && r.Method == http.MethodGet:
+		res, err := gw.loyaltyClient.FindDuplicates(ctx, &pb_loyalty.FindDuplicatesRequest{
+			TenantId: tenantID(r), AccountId: r.URL.Query().Get("account_id"),
+		})
+		if err != nil { fail(http.StatusBadGateway, status.Convert(err).Message()); return }
+		grupos := []map[string]interface{}{}
+		for _, g := range res.GetGroups() {
+			cuentas := []map[string]interface{}{}
+			for _, a := range g.GetAccounts() { cuentas = append(cuentas, cuentaJSON(a)) }
+			grupos = append(grupos, map[string]interface{}{"cuentas": cuentas, "motivos": g.GetReasons()})
 		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"grupos": grupos, "total": len(grupos)})
 
-		rows, _ := gw.db.QueryContext(r.Context(), `
-			SELECT type, points, COALESCE(description,''), created_at
-			FROM   loyalty_transactions
-			WHERE  account_id = $1
-			ORDER  BY created_at DESC LIMIT 20`, id)
-
-		type TxRow struct {
-			Type        string `json:"type"`
-			Points      int    `json:"points"`
-			Description string `json:"description"`
-			Date        string `json:"date"`
+	case strings.HasSuffix(r.URL.Path, "/fusionar") && r.Method == http.MethodPost:
+		var req struct {
+			SupervivienteID string   `json:"superviviente_id"`
+			FusionarIDs     []string `json:"fusionar_ids"`
+			Motivo          string   `json:"motivo"`
 		}
-		var txs []TxRow
-		if rows != nil {
-			defer rows.Close()
-			for rows.Next() {
-				var tx TxRow
-				var createdAt interface{}
-				rows.Scan(&tx.Type, &tx.Points, &tx.Description, &createdAt)
-				if createdAt != nil {
-					tx.Date = fmt.Sprintf("%v", createdAt)
-				}
-				txs = append(txs, tx)
-			}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { fail(400, "JSON invalido"); return }
+		if req.SupervivienteID == "" || len(req.FusionarIDs) == 0 {
+			fail(400, "superviviente_id y fusionar_ids requeridos")
+			return
 		}
-		if txs == nil {
-			txs = []TxRow{}
+		if len(req.FusionarIDs) > 20 { fail(400, "máximo 20 cuentas por fusión"); return }
+		res, err := gw.loyaltyClient.MergeAccounts(ctx, &pb_loyalty.MergeAccountsRequest{
+			TenantId: tenantID(r), SurvivorId: req.SupervivienteID, MergedIds: req.FusionarIDs,
+			User: gw.usuarioJWT(r), Reason: req.Motivo,
+		})
+		if err != nil {
+			code := http.StatusBadGateway
+			switch status.Code(err) {
+			case codes.InvalidArgument:
+				code = http.StatusBadRequest
+			case codes.NotFound:
+				code = http.StatusNotFound
+			}
+			fail(code, status.Convert(err).Message())
+			return
 		}
-
+		log.Printf("[BFF] Fusión de clientes tenant=%s superviviente=%s absorbidas=%d por %s",
+			tenantID(r), req.SupervivienteID, len(req.FusionarIDs), gw.usuarioJWT(r))
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"id": c.ID, "phone": c.Phone, "rfc": c.RFC,
-			"name": c.Name, "email": c.Email,
-			"points": c.Points, "total_spent": c.TotalSpent,
-			"tier": c.Tier, "transactions": txs,
+			"ok": true, "cliente": cuentaJSON(res.GetAccount()), "fusiones": res.GetMergeIds(),
+			"puntos_movidos": res.GetPointsMoved(), "movimientos_movidos": res.GetTransactionsMoved(),
+			"telefonos_alternos": res.GetPhones(),
 		})
 
-	case http.MethodPut:
-		var req struct {
-			Name  string `json:"name"`
-			RFC   string `json:"rfc"`
-			Email string `json:"email"`
-		}
-		json.NewDecoder(r.Body).Decode(&req)
-		gw.db.ExecContext(r.Context(), `
-			UPDATE loyalty_accounts
-			SET    name       = COALESCE(NULLIF($1,''), name),
-			       rfc        = COALESCE(NULLIF($2,''), rfc),
-			       email      = COALESCE(NULLIF($3,''), email),
-			       updated_at = now()
-			WHERE  id = $4`, req.Name, req.RFC, req.Email, id)
-		json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
-
-	case http.MethodDelete:
-		gw.db.ExecContext(r.Context(), `DELETE FROM loyalty_accounts WHERE id = $1`, id)
-		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
-
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-func (gw *Gateway) handleLoyalty(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet { w.WriteHeader(http.StatusMethodNotAllowed); return }
-	phone := r.URL.Query().Get("phone")
-	if phone == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "phone requerido"})
-		return
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
-	defer cancel()
-	acc, err := gw.loyaltyClient.GetAccount(ctx, &pb_loyalty.GetAccountRequest{Phone: phone})
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]string{"error": "cliente no encontrado"})
-		return
-	}
-	hist, _ := gw.loyaltyClient.GetHistory(ctx, &pb_loyalty.GetHistoryRequest{Phone: phone, Limit: 20})
-	type txItem struct {
-		Type        string `json:"type"`
-		Points      int32  `json:"points"`
-		Description string `json:"description"`
-		Date        string `json:"date"`
-	}
-	var history []txItem
-	if hist != nil {
-		for _, tx := range hist.GetTransactions() {
-			history = append(history, txItem{
-				Type: tx.GetType(), Points: tx.GetPoints(),
-				Description: tx.GetDescription(), Date: tx.GetCreatedAt(),
-			})
-		}
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"account": map[string]interface{}{
-                "phone": acc.GetPhone(), "customer_name": acc.GetName(), "rfc": acc.GetRfc(), "cp": acc.GetCp(),
-			"points": acc.GetPoints(), "tier": acc.GetTier(),
-			"total_spent": acc.GetTotalSpent(),
-                "regimen_fiscal": acc.GetRegimenFiscal(), "nombre_fiscal": acc.GetNombreFiscal(),
-		},
-		"history": history,
-	})
+// usuarioJWT es el usuario (sub) del token de la solicitud, para las bitácoras
+func (gw *Gateway) usuarioJWT(r *http.Request) string {
+	claims, err := gw.validarToken(r.Context(), strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err != nil { return "" }
+	return claims.Username
 }
 
-func (gw *Gateway) handleLoyaltyCp(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
-    var req struct {
-        Phone string `json:"phone"`
-        CP    string `json:"cp"`
-    }
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        w.WriteHeader(http.StatusBadRequest); return
-    }
-    if req.Phone == "" || req.CP == "" {
-        w.WriteHeader(http.StatusBadRequest)
-        json.NewEncoder(w).Encode(map[string]string{"error": "phone y cp requeridos"})
-        return
-    }
-    _, err := gw.db.Exec(`UPDATE loyalty_accounts SET cp=$1, updated_at=now() WHERE phone=$2`, req.CP, req.Phone)
-    if err != nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-        return
-    }
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]string{"status": "ok", "cp": req.CP})
-}
+// ── DERECHOS ARCO ─────────────────────────────────────────────────────────────
 
-func (gw *Gateway) handleLoyaltyRfc(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
-    var req struct {
-        Phone string `json:"phone"`
-        RFC   string `json:"rfc"`
-    }
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        w.WriteHeader(http.StatusBadRequest); return
-    }
-    if req.Phone == "" || req.RFC == "" {
-        w.WriteHeader(http.StatusBadRequest)
-        json.NewEncoder(w).Encode(map[string]string{"error": "phone y rfc requeridos"})
-        return
-    }
-    req.RFC = strings.ToUpper(strings.TrimSpace(req.RFC))
-    _, err := gw.db.Exec(
-        `UPDATE loyalty_accounts SET rfc=$1, updated_at=now() WHERE phone=$2`,
-        req.RFC, req.Phone,
-    )
-    if err != nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-        return
-    }
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]string{"status": "ok", "rfc": req.RFC})
+var (
+	errYaAnonimizado  = errors.New("el cliente ya fue anonimizado")
+	errCreditoVigente = errors.New("el cliente tiene saldo de crédito pendiente; la cancelación procede cuando lo liquide")
+)
+
+// jsonConsulta devuelve las filas de la consulta como un arreglo JSON armado por Postgres
+func (gw *Gateway) jsonConsulta(ctx context.Context, query string, args ...interface{}) (json.RawMessage, error) {
+	var raw []byte
+	err := gw.db.QueryRowContext(ctx, `SELECT COALESCE(json_agg(t), '[]'::json) FROM (`+query+`) t`, args...).Scan(&raw)
+	return json.RawMessage(raw), err
 }
-func (gw *Gateway) handleLoyaltyFiscal(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
-    var req struct {
-        Phone         string `json:"phone"`
-        RegimenFiscal string `json:"regimen_fiscal"`
-        NombreFiscal  string `json:"nombre_fiscal"`
-    }
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        w.WriteHeader(http.StatusBadRequest); return
-    }
-    if req.Phone == "" {
-        w.WriteHeader(http.StatusBadRequest)
-        json.NewEncoder(w).Encode(map[string]string{"error": "phone requerido"})
-        return
-    }
-    req.NombreFiscal = strings.ToUpper(strings.TrimSpace(req.NombreFiscal))
-    _, err := gw.db.Exec(
-        `UPDATE loyalty_accounts SET regimen_fiscal=$1, nombre_fiscal=$2, updated_at=now() WHERE phone=$3`,
-        req.RegimenFiscal, req.NombreFiscal, req.Phone)
-    if err != nil {
-        w.WriteHeader(http.StatusInternalServerError)
-        json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-        return
-    }
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+
+// facturaXML es un CFDI emitido al titular, para la exportación en ZIP
+type facturaXML struct {
+	UUID string
+	XML  string
 }
-func (gw *Gateway) handleLoyaltyCliente(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
+
+// exportarCliente reúne los datos del titular: perfil, lealtad, compras, crédito y las
+// facturas emitidas a su nombre (por venta ligada o por su RFC en la tienda). De los
+// referidos solo sale el rol y el estado; los datos del otro cliente no son del titular.
+func (gw *Gateway) exportarCliente(ctx context.Context, tid, id string) (map[string]interface{}, []facturaXML, error) {
+	var perfil []byte
+	var rfc string
+	err := gw.db.QueryRowContext(ctx, `SELECT row_to_json(a), COALESCE(a.rfc,'') FROM loyalty_accounts a WHERE a.id = $1::uuid`, id).Scan(&perfil, &rfc)
+	if err != nil { return nil, nil, err }
+	if rfc == "XAXX010101000" || rfc == "XEXX010101000" { rfc = "" }
+
+	datos := map[string]interface{}{
+		"generado_at": time.Now().Format(time.RFC3339),
+		"perfil":      json.RawMessage(perfil),
 	}
-	var req struct {
-		Phone   string `json:"phone"`
-		Tel     string `json:"tel"`
-		Email   string `json:"email"`
-		Nombre  string `json:"nombre"`
-		Rfc     string `json:"rfc"`
-		Cp      string `json:"cp"`
-		Regimen string `json:"regimen"`
-		Uso     string `json:"uso"`
+	secciones := []struct {
+		nombre, query string
+		args          []interface{}
+	}{
+		{"telefonos_alternos", `SELECT phone, created_at FROM loyalty_telefonos_alternos WHERE account_id = $1::uuid ORDER BY created_at`, []interface{}{id}},
+		{"movimientos_puntos", `SELECT type AS tipo, points AS puntos, monto, description AS descripcion, sale_id, created_at
+			FROM loyalty_transactions WHERE account_id = $1::uuid ORDER BY created_at`, []interface{}{id}},
+		{"canjes", `SELECT c.codigo, r.name AS recompensa, c.puntos, c.estado, c.sale_id, c.expires_at, c.applied_at, c.created_at
+			FROM loyalty_canjes c LEFT JOIN loyalty_rewards r ON r.id = c.reward_id WHERE c.account_id = $1::uuid ORDER BY c.created_at`, []interface{}{id}},
+		{"historial_nivel", `SELECT anterior, nuevo, nombre_nuevo, motivo, created_at FROM loyalty_tier_historial
+			WHERE account_id = $1::uuid ORDER BY created_at`, []interface{}{id}},
+		{"referidos", `SELECT CASE WHEN referente_id = $1::uuid THEN 'referente' ELSE 'referido' END AS rol, estado,
+			CASE WHEN referente_id = $1::uuid THEN puntos_referente ELSE puntos_referido END AS puntos, created_at, resuelto_at
+			FROM loyalty_referidos WHERE referente_id = $1::uuid OR referido_id = $1::uuid ORDER BY created_at`, []interface{}{id}},
+		{"cuentas_fusionadas", `SELECT fusionada AS cuenta, created_at FROM loyalty_fusiones WHERE superviviente_id = $1::uuid ORDER BY created_at`, []interface{}{id}},
+		{"compras", `SELECT s.id, s.created_at, s.total, s.moneda, s.payment_method, s.status, s.descuento_lealtad,
+			       (SELECT json_agg(json_build_object('producto', i.name, 'cantidad', i.quantity, 'precio', i.unit_price, 'subtotal', i.subtotal))
+			          FROM sale_items i WHERE i.sale_id = s.id) AS productos
+			FROM sales s WHERE s.customer_id = $1::uuid ORDER BY s.created_at`, []interface{}{id}},
+		{"facturas", `SELECT id AS sale_id, cfdi_uuid AS uuid, cfdi_serie AS serie, cfdi_folio AS folio, cfdi_timbrado_at AS fecha,
+			       total, moneda, cfdi_rfc_receptor AS rfc_receptor, cfdi_nombre_receptor AS nombre_receptor,
+			       cfdi_status AS estado, cfdi_estado_sat AS estado_sat
+			FROM sales WHERE cfdi_uuid IS NOT NULL
+			  AND (customer_id = $1::uuid OR ($3 <> '' AND tenant_id::text = $2 AND cfdi_rfc_receptor = $3))
+			ORDER BY created_at`, []interface{}{id, tid, rfc}},
+		{"credito", `SELECT tenant_id, limite, saldo, dias_plazo, dias_tolerancia, bloqueado, created_at
+			FROM creditos_clientes WHERE account_id = $1::uuid`, []interface{}{id}},
+		{"movimientos_credito", `SELECT tipo, monto, sale_id, metodo_pago, referencia, saldo_despues, vence_at, created_at
+			FROM credito_movimientos WHERE account_id = $1::uuid ORDER BY created_at`, []interface{}{id}},
+		{"solicitudes_arco", `SELECT tipo, formato, motivo, created_at FROM arco_solicitudes WHERE account_id = $1::uuid ORDER BY created_at`, []interface{}{id}},
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "json invalido", http.StatusBadRequest)
-		return
+	for _, sec := range secciones {
+		raw, err := gw.jsonConsulta(ctx, sec.query, sec.args...)
+		if err != nil { return nil, nil, fmt.Errorf("%s: %w", sec.nombre, err) }
+		datos[sec.nombre] = raw
 	}
-	phone := req.Phone
-	if phone == "" {
-		phone = req.Tel
+
+	var xmls []facturaXML
+	rows, err := gw.db.QueryContext(ctx, `
+		SELECT cfdi_uuid, cfdi_xml FROM sales
+		WHERE cfdi_uuid IS NOT NULL AND COALESCE(cfdi_xml,'') <> ''
+		  AND (customer_id = $1::uuid OR ($3 <> '' AND tenant_id::text = $2 AND cfdi_rfc_receptor = $3))`, id, tid, rfc)
+	if err != nil { return nil, nil, fmt.Errorf("xml de facturas: %w", err) }
+	defer rows.Close()
+	for rows.Next() {
+		var f facturaXML
+		if rows.Scan(&f.UUID, &f.XML) == nil { xmls = append(xmls, f) }
 	}
-	if phone == "" {
-		http.Error(w, "phone requerido", http.StatusBadRequest)
-		return
+	return datos, xmls, nil
+}
+
+// anonimizarCliente atiende una cancelación ARCO: borra los datos personales de la cuenta
+// y de lo que cuelga de ella, y conserva ventas, facturas y saldos. Los CFDI emitidos
+// guardan RFC y nombre del receptor porque el CFF obliga a conservarlos cinco años.
+func (gw *Gateway) anonimizarCliente(ctx context.Context, tid, programaID, id, usuario, motivo string) (map[string]interface{}, error) {
+	tx, err := gw.db.BeginTx(ctx, nil)
+	if err != nil { return nil, err }
+	defer tx.Rollback()
+
+	var anonimizado sql.NullTime
+	var rfc string
+	err = tx.QueryRowContext(ctx, `
+		SELECT anonimizado_at, COALESCE(rfc,'') FROM loyalty_accounts WHERE id::text = $1 AND tenant_id = $2::uuid FOR UPDATE`,
+		id, programaID).Scan(&anonimizado, &rfc)
+	if err != nil { return nil, err }
+	if anonimizado.Valid { return nil, errYaAnonimizado }
+	var deuda float64
+	tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(saldo),0) FROM creditos_clientes WHERE account_id = $1::uuid AND saldo > 0`, id).Scan(&deuda)
+	if deuda > 0 { return nil, errCreditoVigente }
+
+	detalle := map[string]interface{}{}
+	var compras, facturas int
+	tx.QueryRowContext(ctx, `SELECT COUNT(*), COUNT(cfdi_uuid) FROM sales WHERE customer_id = $1::uuid`, id).Scan(&compras, &facturas)
+	detalle["compras_conservadas"], detalle["facturas_conservadas"] = compras, facturas
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE loyalty_accounts
+		SET phone = 'anon-' || replace(left(id::text, 13), '-', ''), name = 'Cliente anonimizado', email = NULL, rfc = NULL,
+		    cp = NULL, regimen_fiscal = NULL, nombre_fiscal = NULL, uso_cfdi = NULL, codigo_referido = NULL,
+		    anonimizado_at = NOW(), updated_at = NOW()
+		WHERE id = $1::uuid`, id)
+	if err != nil { return nil, fmt.Errorf("cuenta: %w", err) }
+	for campo, q := range map[string]string{
+		"telefonos_alternos": `DELETE FROM loyalty_telefonos_alternos WHERE account_id = $1::uuid`,
+		"codigos_portal":     `DELETE FROM loyalty_portal_codigos WHERE account_id = $1::uuid`,
+		"sesiones_portal":    `DELETE FROM loyalty_portal_sesiones WHERE account_id = $1::uuid`,
+		"notas_credito":      `UPDATE creditos_clientes SET notas = NULL, updated_at = NOW() WHERE account_id = $1::uuid AND notas IS NOT NULL`,
+	} {
+		res, err := tx.ExecContext(ctx, q, id)
+		if err != nil { return nil, fmt.Errorf("%s: %w", campo, err) }
+		n, _ := res.RowsAffected()
+		detalle[campo] = n
 	}
-	_, err := gw.db.ExecContext(r.Context(),
-		"INSERT INTO loyalty_accounts (phone, name, rfc, cp, email, regimen_fiscal, nombre_fiscal, uso_cfdi, points, total_spent, tier) "+
-		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 0, 0, 'bronze') "+
-		"ON CONFLICT (phone) DO UPDATE SET "+
-		"name           = CASE WHEN $2 != '' THEN $2 ELSE loyalty_accounts.name END, "+
-		"rfc            = CASE WHEN $3 != '' THEN $3 ELSE loyalty_accounts.rfc END, "+
-		"cp             = CASE WHEN $4 != '' THEN $4 ELSE loyalty_accounts.cp END, "+
-		"email          = CASE WHEN $5 != '' THEN $5 ELSE loyalty_accounts.email END, "+
-		"regimen_fiscal = CASE WHEN $6 != '' THEN $6 ELSE loyalty_accounts.regimen_fiscal END, "+
-		"nombre_fiscal  = CASE WHEN $7 != '' THEN $7 ELSE loyalty_accounts.nombre_fiscal END, "+
-		"uso_cfdi       = CASE WHEN $8 != '' THEN $8 ELSE loyalty_accounts.uso_cfdi END, "+
-		"updated_at     = NOW()",
-		phone, req.Nombre, req.Rfc, req.Cp, req.Email, req.Regimen, req.Nombre, req.Uso,
-	)
-	if err != nil {
-		log.Printf("[BFF] handleLoyaltyCliente error: %v", err)
-		http.Error(w, "error guardando cliente", http.StatusInternalServerError)
-		return
+	// Las cuentas que absorbió en fusiones guardan una copia de sus datos
+	res, err := tx.ExecContext(ctx, `
+		UPDATE loyalty_fusiones
+		SET fusionada = fusionada - ARRAY['phone','name','email','rfc','cp','regimen_fiscal','nombre_fiscal','uso_cfdi','codigo_referido']
+		WHERE superviviente_id = $1::uuid`, id)
+	if err != nil { return nil, fmt.Errorf("fusiones: %w", err) }
+	n, _ := res.RowsAffected()
+	detalle["fusiones"] = n
+
+	detalleJSON, _ := json.Marshal(detalle)
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO arco_solicitudes (tenant_id, account_id, tipo, motivo, detalle, usuario)
+		VALUES ($1::uuid, $2::uuid, 'cancelacion', NULLIF($3,''), $4, NULLIF($5,''))`,
+		tid, id, motivo, string(detalleJSON), usuario); err != nil {
+		return nil, fmt.Errorf("bitácora: %w", err)
 	}
-	log.Printf("[BFF] Cliente guardado phone=%s rfc=%s email=%s", phone, req.Rfc, req.Email)
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"ok":true,"message":"Cliente guardado"}`))
+	if err := tx.Commit(); err != nil { return nil, err }
+	return detalle, nil
 }
 
-func (gw *Gateway) handleLoyaltyRedeem(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
-	var req struct {
-		Phone  string `json:"phone"`
-		Points int64  `json:"points"`
-		Reason string `json:"reason"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "JSON invalido"})
-		return
+// handleCustomerARCO atiende los derechos ARCO de un cliente bajo /api/v1/customers/{id}/arco.
+//   GET  .../arco                          — solicitudes atendidas para el cliente
+//   GET  .../arco/exportar[?formato=zip]   — acceso: todos sus datos en JSON, o ZIP con los XML de sus facturas
+//   POST .../arco/anonimizar {motivo}      — cancelación: borra sus datos personales y conserva lo fiscal
+// DELETE /api/v1/customers/{id} también anonimiza.
+func (gw *Gateway) handleCustomerARCO(w http.ResponseWriter, r *http.Request, id, sub string) {
+	fail := func(code int, msg string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
 	}
-	if req.Reason == "" { req.Reason = "Canje en caja" }
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	tid := tenantID(r)
+	programaID := gw.tenantLealtad(r.Context(), tid)
+	var existe bool
+	gw.db.QueryRowContext(r.Context(), `
+		SELECT EXISTS (SELECT 1 FROM loyalty_accounts WHERE id::text = $1 AND tenant_id = $2::uuid)`, id, programaID).Scan(&existe)
+	if !existe { fail(http.StatusNotFound, "cliente no encontrado"); return }
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
-	acc, err := gw.loyaltyClient.RedeemPoints(ctx, &pb_loyalty.RedeemPointsRequest{
-		Phone: req.Phone, Points: int32(req.Points), RewardId: req.Reason,
-	})
+
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		raw, err := gw.jsonConsulta(ctx, `
+			SELECT id, tipo, formato, motivo, detalle, usuario, created_at FROM arco_solicitudes
+			WHERE account_id = $1::uuid ORDER BY created_at DESC`, id)
+		if err != nil { fail(http.StatusInternalServerError, err.Error()); return }
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"solicitudes": raw})
+
+	case sub == "exportar" && r.Method == http.MethodGet:
+		formato := r.URL.Query().Get("formato")
+		if formato == "" { formato = "json" }
+		if formato != "json" && formato != "zip" { fail(400, "formato debe ser json o zip"); return }
+		datos, xmls, err := gw.exportarCliente(ctx, tid, id)
+		if err != nil { fail(http.StatusInternalServerError, "exportar: "+err.Error()); return }
+		detalle, _ := json.Marshal(map[string]interface{}{"facturas_xml": len(xmls)})
+		if _, err := gw.db.ExecContext(ctx, `
+			INSERT INTO arco_solicitudes (tenant_id, account_id, tipo, formato, detalle, usuario)
+			VALUES ($1::uuid, $2::uuid, 'acceso', $3, $4, NULLIF($5,''))`, tid, id, formato, string(detalle), gw.usuarioJWT(r)); err != nil {
+			fail(http.StatusInternalServerError, "bitácora: "+err.Error())
+			return
+		}
+		log.Printf("[BFF] ARCO acceso cliente=%s formato=%s por %s", id, formato, gw.usuarioJWT(r))
+		nombre := "datos-cliente-" + id[:8]
+		if formato == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Disposition", `attachment; filename="`+nombre+`.json"`)
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.Encode(datos)
+			return
+		}
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		f, _ := zw.Create("datos.json")
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		enc.Encode(datos)
+		for _, x := range xmls {
+			if f, err := zw.Create("facturas/" + x.UUID + ".xml"); err == nil { io.WriteString(f, x.XML) }
+		}
+		f, _ = zw.Create("LEEME.txt")
+		io.WriteString(f, "Datos personales del titular registrados por el negocio (derecho de acceso, LFPDPPP).\r\n"+
+			"datos.json: perfil, puntos, canjes, compras, crédito y facturas.\r\n"+
+			"facturas/: XML de los CFDI emitidos al titular.\r\n")
+		if err := zw.Close(); err != nil { fail(http.StatusInternalServerError, "zip: "+err.Error()); return }
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+nombre+`.zip"`)
+		w.Write(buf.Bytes())
+
+	case (sub == "anonimizar" && r.Method == http.MethodPost) || (sub == "" && r.Method == http.MethodDelete):
+		var req struct {
+			Motivo string `json:"motivo"`
+		}
+		if r.Method == http.MethodPost { json.NewDecoder(r.Body).Decode(&req) }
+		detalle, err := gw.anonimizarCliente(ctx, tid, programaID, id, gw.usuarioJWT(r), strings.TrimSpace(req.Motivo))
+		switch {
+		case errors.Is(err, errYaAnonimizado), errors.Is(err, errCreditoVigente):
+			fail(http.StatusConflict, err.Error())
+			return
+		case err != nil:
+			fail(http.StatusInternalServerError, "anonimizar: "+err.Error())
+			return
+		}
+		log.Printf("[BFF] ARCO cancelación cliente=%s por %s", id, gw.usuarioJWT(r))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "anonimizado", "id": id, "detalle": detalle})
+
+	default:
+		fail(http.StatusNotFound, "ruta no encontrada")
+	}
+}
+
+// handleARCO es la bitácora de solicitudes ARCO de la tienda.
+//   GET /api/v1/arco[?tipo=acceso|cancelacion]
+func (gw *Gateway) handleARCO(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	raw, err := gw.jsonConsulta(r.Context(), `
+		SELECT id, account_id, tipo, formato, motivo, detalle, usuario, created_at FROM arco_solicitudes
+		WHERE tenant_id::text = $1 AND ($2 = '' OR tipo = $2) ORDER BY created_at DESC LIMIT 500`,
+		tenantID(r), r.URL.Query().Get("tipo"))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
 	}
-	log.Printf("[BFF] Canje %d pts para %s — saldo: %d", req.Points, req.Phone, acc.GetPointsRemaining())
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"ok": true, "points": acc.GetPointsRemaining(), "success": acc.GetSuccess(),
-	})
+	json.NewEncoder(w).Encode(map[string]interface{}{"solicitudes": raw})
 }
 
-func (gw *Gateway) handleMigratePreview(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
-	r.ParseMultipartForm(32 << 20)
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "campo 'file' requerido"})
-		return
+// ── CRÉDITO (FIADO) ───────────────────────────────────────────────────────────
+
+// creditoJSON es la cuenta de crédito de un cliente como la ve el panel
+func creditoJSON(c *pb_sales.CreditAccount) map[string]interface{} {
+	a := c.GetAging()
+	return map[string]interface{}{
+		"customer_id": c.GetCustomerId(), "nombre": c.GetCustomerName(), "telefono": c.GetCustomerPhone(),
+		"email": c.GetCustomerEmail(), "limite": c.GetLimit(), "saldo": c.GetBalance(), "disponible": c.GetAvailable(),
+		"saldo_a_favor": math.Max(-c.GetBalance(), 0), "dias_plazo": c.GetTermDays(), "dias_tolerancia": c.GetGraceDays(),
+		"bloqueado": c.GetBlocked(), "notas": c.GetNotes(), "estado": c.GetStatus(), "vencido": c.GetOverdue(),
+		"antiguedad": map[string]float64{
+			"corriente": a.GetCurrent(), "dias_1_30": a.GetDays_1_30(), "dias_31_60": a.GetDays_31_60(),
+			"dias_61_90": a.GetDays_61_90(), "mas_90": a.GetOver_90(),
+		},
 	}
-	defer file.Close()
-	tipo := r.FormValue("tipo")
-	if tipo == "" { tipo = "generic" }
-	products, customers, err := parseMigrationCSV(file, tipo)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-		return
+}
+
+// errorCredito traduce los errores del servicio de ventas: reglas de crédito y datos
+// inválidos son 400, un cliente sin cuenta es 404
+func errorCredito(w http.ResponseWriter, err error) {
+	code := http.StatusBadGateway
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		code = http.StatusBadRequest
+	case codes.NotFound:
+		code = http.StatusNotFound
 	}
-	log.Printf("[BFF] Preview: %s — %d productos, %d clientes", header.Filename, len(products), len(customers))
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": status.Convert(err).Message()})
+}
+
+// handleCreditos lista las cuentas de crédito de la tienda.
+//   GET /api/v1/credito[?vencidos=1] — saldo, disponible, antigüedad y estado por cliente
+func (gw *Gateway) handleCreditos(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	res, err := gw.salesClient.ListCreditAccounts(ctx, &pb_sales.ListCreditAccountsRequest{
+		TenantId: tenantID(r), OverdueOnly: r.URL.Query().Get("vencidos") == "1",
+	})
+	if err != nil { errorCredito(w, err); return }
+	cuentas := []map[string]interface{}{}
+	var saldo, vencido float64
+	for _, c := range res.GetAccounts() {
+		cuentas = append(cuentas, creditoJSON(c))
+		saldo += math.Max(c.GetBalance(), 0)
+		vencido += c.GetOverdue()
+	}
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"archivo": header.Filename, "tipo_detectado": tipo,
-		"total_productos": len(products), "total_clientes": len(customers),
-		"preview_productos": limitSlice(products, 5),
-		"preview_clientes":  limitSlice(customers, 5),
+		"cuentas": cuentas, "total": len(cuentas),
+		"saldo_total": math.Round(saldo*100) / 100, "vencido_total": math.Round(vencido*100) / 100,
 	})
 }
 
-func (gw *Gateway) handleMigrate(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
-	r.ParseMultipartForm(32 << 20)
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "campo 'file' requerido"})
-		return
+// handleCreditoCliente atiende el crédito de un cliente bajo /api/v1/customers/{id}/credito.
+//   GET  .../credito[?desde=&hasta=]             — cuenta, antigüedad y movimientos del periodo
+//   PUT  .../credito {limite, dias_plazo, dias_tolerancia, bloqueado, notas} — abre o ajusta el crédito
+//   POST .../credito/abonos {monto, metodo, referencia} — abono; entra al corte de caja del día
+//   GET  .../credito/estado-de-cuenta[?desde=&hasta=]   — estado de cuenta imprimible (HTML)
+//   POST .../credito/estado-de-cuenta/enviar {email, desde, hasta} — lo manda por correo
+func (gw *Gateway) handleCreditoCliente(w http.ResponseWriter, r *http.Request, id, sub string) {
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
 	}
-	defer file.Close()
-	tipo := r.FormValue("tipo")
-	if tipo == "" { tipo = "generic" }
-	products, customers, err := parseMigrationCSV(file, tipo)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-		return
-	}
-	prodOK, prodErr := 0, 0
-	for _, p := range products {
-		price := parseFloat(p["price"])
-		_, e := gw.db.Exec(
-			`INSERT INTO products (id, name, price, sku, created_at)
-			 VALUES (gen_random_uuid(), $1, $2, $3, NOW())
-			 ON CONFLICT (sku) WHERE sku IS NOT NULL DO UPDATE SET name=EXCLUDED.name, price=EXCLUDED.price`,
-			p["name"], price, p["sku"])
-		if e != nil { log.Printf("[BFF] product insert err: %v", e); prodErr++ } else { prodOK++ }
-	}
-	custOK, custErr := 0, 0
-	for _, c := range customers {
-		if c["phone"] == "" { continue }
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		_, e := gw.loyaltyClient.EarnPoints(ctx, &pb_loyalty.EarnPointsRequest{
-			Phone: c["phone"], Name: c["name"], SaleId: "migration", Total: 0,
+	var existe bool
+	gw.db.QueryRowContext(r.Context(), `
+		SELECT EXISTS (SELECT 1 FROM loyalty_accounts WHERE id::text = $1 AND tenant_id = $2::uuid AND anonimizado_at IS NULL)`,
+		id, gw.tenantLealtad(r.Context(), tenantID(r))).Scan(&existe)
+	if !existe { fail(http.StatusNotFound, "cliente no encontrado"); return }
+	tid := tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		st, err := gw.salesClient.GetCreditStatement(ctx, &pb_sales.CreditStatementRequest{
+			TenantId: tid, CustomerId: id, From: r.URL.Query().Get("desde"), To: r.URL.Query().Get("hasta"),
+		})
+		if err != nil { errorCredito(w, err); return }
+		movimientos := []map[string]interface{}{}
+		for _, m := range st.GetMovements() {
+			movimientos = append(movimientos, map[string]interface{}{
+				"id": m.GetId(), "tipo": m.GetType(), "monto": m.GetAmount(), "sale_id": m.GetSaleId(),
+				"metodo": m.GetPaymentMethod(), "referencia": m.GetReference(), "saldo": m.GetBalanceAfter(),
+				"vence": m.GetDueDate(), "pendiente": m.GetPending(), "fecha": m.GetCreatedAt(),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"cuenta": creditoJSON(st.GetAccount()), "desde": st.GetFrom(), "hasta": st.GetTo(),
+			"saldo_inicial": st.GetOpeningBalance(), "saldo_final": st.GetClosingBalance(),
+			"cargos": st.GetCharges(), "abonos": st.GetPayments(), "cancelaciones": st.GetCancellations(),
+			"movimientos": movimientos,
 		})
-		cancel()
-		if e != nil { custErr++ } else { custOK++ }
-	}
-	log.Printf("[BFF] Migración: %s — prod OK:%d ERR:%d | clientes OK:%d ERR:%d",
-		header.Filename, prodOK, prodErr, custOK, custErr)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"ok": true, "archivo": header.Filename,
-		"productos_ok": prodOK, "productos_error": prodErr,
-		"clientes_ok": custOK, "clientes_error": custErr,
-	})
-}
 
-func (gw *Gateway) handleCancelar(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	case sub == "" && r.Method == http.MethodPut:
+		var req struct {
+			Limite         float64 `json:"limite"`
+			DiasPlazo      int32   `json:"dias_plazo"`
+			DiasTolerancia int32   `json:"dias_tolerancia"`
+			Bloqueado      bool    `json:"bloqueado"`
+			Notas          string  `json:"notas"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { fail(400, "JSON invalido"); return }
+		c, err := gw.salesClient.UpsertCreditAccount(ctx, &pb_sales.UpsertCreditAccountRequest{
+			TenantId: tid, CustomerId: id, Limit: req.Limite, TermDays: req.DiasPlazo,
+			GraceDays: req.DiasTolerancia, Blocked: req.Bloqueado, Notes: strings.TrimSpace(req.Notas),
+		})
+		if err != nil { errorCredito(w, err); return }
+		log.Printf("[BFF] Crédito cliente=%s límite=$%.2f bloqueado=%v por %s", id, req.Limite, req.Bloqueado, gw.usuarioJWT(r))
+		json.NewEncoder(w).Encode(creditoJSON(c))
 
-	defer func() {
-		if rec := recover(); rec != nil {
-			log.Printf("[BFF] PANIC en handleCancelar: %v", rec)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("panic: %v", rec)})
+	case sub == "abonos" && r.Method == http.MethodPost:
+		var req struct {
+			Monto      float64 `json:"monto"`
+			Metodo     string  `json:"metodo"`
+			Referencia string  `json:"referencia"`
 		}
-	}()
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { fail(400, "JSON invalido"); return }
+		res, err := gw.salesClient.RegisterCreditPayment(ctx, &pb_sales.CreditPaymentRequest{
+			TenantId: tid, CustomerId: id, Amount: req.Monto, PaymentMethod: req.Metodo,
+			User: gw.usuarioJWT(r), Reference: strings.TrimSpace(req.Referencia),
+		})
+		if err != nil { errorCredito(w, err); return }
+		aplicado := []map[string]interface{}{}
+		for _, a := range res.GetApplications() {
+			aplicado = append(aplicado, map[string]interface{}{"sale_id": a.GetSaleId(), "monto": a.GetAmount()})
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": res.GetMovementId(), "saldo": res.GetBalance(), "saldo_a_favor": res.GetCreditInFavor(),
+			"aplicado": aplicado,
+		})
 
-	var req struct {
-		UUID          string `json:"uuid"`
-		RFC           string `json:"rfc"`
-		Motivo        string `json:"motivo"`
-		UUIDReemplazo string `json:"uuid_reemplazo"`
+	case (sub == "estado-de-cuenta" && r.Method == http.MethodGet) ||
+		(sub == "estado-de-cuenta/enviar" && r.Method == http.MethodPost):
+		var req struct {
+			Email string `json:"email"`
+			Desde string `json:"desde"`
+			Hasta string `json:"hasta"`
+		}
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil { fail(400, "JSON invalido"); return }
+		} else {
+			req.Desde, req.Hasta = r.URL.Query().Get("desde"), r.URL.Query().Get("hasta")
+		}
+		st, err := gw.salesClient.GetCreditStatement(ctx, &pb_sales.CreditStatementRequest{
+			TenantId: tid, CustomerId: id, From: req.Desde, To: req.Hasta,
+		})
+		if err != nil { errorCredito(w, err); return }
+		var negocio string
+		gw.db.QueryRowContext(r.Context(), `SELECT COALESCE(nombre,'') FROM tenants WHERE id::text = $1`, tid).Scan(&negocio)
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, estadoCuentaHTML(negocio, st, true))
+			return
+		}
+		to := strings.TrimSpace(req.Email)
+		if to == "" { to = st.GetAccount().GetCustomerEmail() }
+		if !strings.Contains(to, "@") { fail(400, "el cliente no tiene correo registrado"); return }
+		go sendEmail(to, negocio+" — estado de cuenta al "+st.GetTo(), estadoCuentaHTML(negocio, st, false))
+		log.Printf("[BFF] Estado de cuenta cliente=%s enviado a %s", id, to)
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "email": to})
+
+	default:
+		fail(http.StatusNotFound, "ruta no encontrada")
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "JSON invalido"})
-		return
+}
+
+// estadoCuentaHTML arma el estado de cuenta del cliente en una página que se imprime
+// igual que se ve en el correo. Con imprimir agrega el botón de imprimir.
+func estadoCuentaHTML(negocio string, st *pb_sales.CreditStatement, imprimir bool) string {
+	c, a := st.GetAccount(), st.GetAccount().GetAging()
+	esc := html.EscapeString
+	pesos := func(v float64) string { return fmt.Sprintf("$%.2f", v) }
+	tipos := map[string]string{"cargo": "Compra a crédito", "abono": "Abono", "cancelacion": "Compra cancelada"}
+	periodo := "al " + st.GetTo()
+	if st.GetFrom() != "" { periodo = "del " + st.GetFrom() + " al " + st.GetTo() }
+
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE html><html lang="es"><head><meta charset="utf-8"><title>Estado de cuenta</title>
+	<style>body{font-family:sans-serif;color:#111;max-width:760px;margin:24px auto;padding:0 16px}
+	table{width:100%;border-collapse:collapse;margin:12px 0;font-size:13px}th,td{padding:6px 8px;border-bottom:1px solid #ddd;text-align:left}
+	td.n,th.n{text-align:right}.k{color:#666;font-size:12px}.vencido{color:#b00020;font-weight:700}
+	@media print{.no-print{display:none}}</style></head><body>`)
+	if imprimir {
+		b.WriteString(`<button class="no-print" onclick="window.print()" style="float:right;padding:8px 16px">Imprimir</button>`)
 	}
-	if req.UUID == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "uuid requerido"})
-		return
+	fmt.Fprintf(&b, `<h2 style="margin-bottom:4px">%s</h2><p class="k">Estado de cuenta %s</p>`, esc(negocio), esc(periodo))
+	fmt.Fprintf(&b, `<p><strong>%s</strong><br>%s %s</p>`, esc(c.GetCustomerName()), esc(c.GetCustomerPhone()), esc(c.GetCustomerEmail()))
+	claseVencido := ""
+	if c.GetOverdue() > 0 { claseVencido = "vencido" }
+	fmt.Fprintf(&b, `<table><tr><th>Límite</th><th>Saldo</th><th>Disponible</th><th>Plazo</th><th>Vencido</th></tr>
+	<tr><td>%s</td><td>%s</td><td>%s</td><td>%d días</td><td class="%s">%s</td></tr></table>`,
+		pesos(c.GetLimit()), pesos(c.GetBalance()), pesos(c.GetAvailable()), c.GetTermDays(), claseVencido, pesos(c.GetOverdue()))
+	fmt.Fprintf(&b, `<p class="k">Antigüedad de saldos</p><table><tr><th class="n">Por vencer</th><th class="n">1-30 días</th>
+	<th class="n">31-60 días</th><th class="n">61-90 días</th><th class="n">Más de 90</th></tr>
+	<tr><td class="n">%s</td><td class="n">%s</td><td class="n">%s</td><td class="n">%s</td><td class="n">%s</td></tr></table>`,
+		pesos(a.GetCurrent()), pesos(a.GetDays_1_30()), pesos(a.GetDays_31_60()), pesos(a.GetDays_61_90()), pesos(a.GetOver_90()))
+
+	b.WriteString(`<table><tr><th>Fecha</th><th>Concepto</th><th>Vence</th><th class="n">Cargo</th><th class="n">Abono</th><th class="n">Saldo</th></tr>`)
+	if st.GetFrom() != "" {
+		fmt.Fprintf(&b, `<tr><td>%s</td><td>Saldo anterior</td><td></td><td></td><td></td><td class="n">%s</td></tr>`,
+			esc(st.GetFrom()), pesos(st.GetOpeningBalance()))
 	}
-	if req.Motivo == "" { req.Motivo = "02" }
-
-	// Llamar al CFDI server via HTTP (evita proto para cancelación)
-	cfdiHTTP := getenv("CFDI_HTTP_ADDR", "http://localhost:50055")
-	body, _ := json.Marshal(req)
-	httpResp, err := http.Post(cfdiHTTP+"/cancelar", "application/json", strings.NewReader(string(body)))
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "CFDI server no disponible: " + err.Error()})
-		return
+	for _, m := range st.GetMovements() {
+		cargo, abono := "", ""
+		if m.GetType() == "cargo" { cargo = pesos(m.GetAmount()) } else { abono = pesos(m.GetAmount()) }
+		concepto := tipos[m.GetType()]
+		if m.GetSaleId() != "" && len(m.GetSaleId()) >= 8 { concepto += " · folio " + strings.ToUpper(m.GetSaleId()[:8]) }
+		if m.GetReference() != "" { concepto += " · " + m.GetReference() }
+		fecha := m.GetCreatedAt()
+		if len(fecha) >= 10 { fecha = fecha[:10] }
+		fmt.Fprintf(&b, `<tr><td>%s</td><td>%s</td><td>%s</td><td class="n">%s</td><td class="n">%s</td><td class="n">%s</td></tr>`,
+			esc(fecha), esc(concepto), esc(m.GetDueDate()), cargo, abono, pesos(m.GetBalanceAfter()))
 	}
-	defer httpResp.Body.Close()
-
-	var cfdiResult map[string]interface{}
-	json.NewDecoder(httpResp.Body).Decode(&cfdiResult)
-
-	if httpResp.StatusCode != http.StatusOK {
-		w.WriteHeader(httpResp.StatusCode)
-		json.NewEncoder(w).Encode(cfdiResult)
-		return
+	fmt.Fprintf(&b, `<tr><th colspan="3">Totales del periodo</th><th class="n">%s</th><th class="n">%s</th><th class="n">%s</th></tr></table>`,
+		pesos(st.GetCharges()), pesos(st.GetPayments()+st.GetCancellations()), pesos(st.GetClosingBalance()))
+	if c.GetBalance() < 0 {
+		fmt.Fprintf(&b, `<p>Saldo a favor: <strong>%s</strong></p>`, pesos(-c.GetBalance()))
 	}
-
-	// Actualizar DB
-	gw.db.Exec(`UPDATE sales SET cfdi_status=$1 WHERE cfdi_uuid=$2`, "cancelado", req.UUID)
-	log.Printf("[BFF] Cancelación: uuid=%s", req.UUID)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(cfdiResult)
+	b.WriteString(`<p class="k">Los abonos se aplican a las compras más antiguas primero.</p></body></html>`)
+	return b.String()
 }
 
-func (gw *Gateway) handleTimbrar(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
-	var req struct {
-		SaleID string  `json:"sale_id"`
-		RFC    string  `json:"rfc"`
-		Total  float64 `json:"total"`
-			CP    string  `json:"cp"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "JSON invalido"})
-		return
+// resumenComprasCliente calcula número de compras, gasto, ticket promedio, primera y
+// última visita y los productos que más compra el cliente. Solo cuentan las ventas
+// completadas, en pesos.
+func (gw *Gateway) resumenComprasCliente(ctx context.Context, accountID string) map[string]interface{} {
+	var compras int
+	var gasto, ticket float64
+	var primera, ultima sql.NullTime
+	gw.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(total * tipo_cambio), 0), COALESCE(AVG(total * tipo_cambio), 0),
+		       MIN(created_at), MAX(created_at)
+		FROM sales WHERE customer_id = $1::uuid AND status = 'completed'`, accountID).
+		Scan(&compras, &gasto, &ticket, &primera, &ultima)
+	resumen := map[string]interface{}{
+		"numero": compras, "gasto": math.Round(gasto*100) / 100, "ticket_promedio": math.Round(ticket*100) / 100,
+		"primera_visita": "", "ultima_visita": "", "dias_sin_visita": nil,
 	}
-	if req.RFC == "" { req.RFC = "XAXX010101000" }
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	res, err := gw.cfdiClient.Timbrar(ctx, &pb_cfdi.FacturaRequest{
-            VentaId: req.SaleID, Total: req.Total, Rfc: req.RFC, CodigoPostalReceptor: req.CP,
-	})
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-		return
+	if primera.Valid { resumen["primera_visita"] = primera.Time.Format(time.RFC3339) }
+	if ultima.Valid {
+		resumen["ultima_visita"] = ultima.Time.Format(time.RFC3339)
+		resumen["dias_sin_visita"] = int(time.Since(ultima.Time).Hours() / 24)
 	}
-	gw.db.ExecContext(ctx,
-		`UPDATE sales SET cfdi_uuid=$1, cfdi_status=$2 WHERE id=$3::uuid`,
-		res.GetUuid(), "timbrado", req.SaleID)
-	log.Printf("[BFF] Timbrado: sale=%s uuid=%s", req.SaleID, res.GetUuid())
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"sale_id": req.SaleID, "cfdi_uuid": res.GetUuid(),
-		"sello_sat": res.GetSelloSat(), "status": res.GetStatus(),
-		"pac_usado": res.GetPacUsado(), "timestamp": res.GetTimestamp(),
-	})
-}
 
-func (gw *Gateway) handleStatus(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-	authOK := "OK"
-	if _, err := gw.authClient.Ping(ctx, &pb_auth.PingRequest{Message: "health"}); err != nil {
-		authOK = "ERROR: " + err.Error()
+	favoritos := []map[string]interface{}{}
+	if rows, err := gw.db.QueryContext(ctx, `
+		SELECT si.product_id::text, si.name, SUM(si.quantity), SUM(si.subtotal * s.tipo_cambio), COUNT(DISTINCT s.id)
+		FROM sale_items si JOIN sales s ON s.id = si.sale_id
+		WHERE s.customer_id = $1::uuid AND s.status = 'completed'
+		GROUP BY si.product_id, si.name
+		ORDER BY COUNT(DISTINCT s.id) DESC, SUM(si.quantity) DESC LIMIT 5`, accountID); err == nil {
+		for rows.Next() {
+			var productID, nombre string
+			var unidades, veces int
+			var total float64
+			if rows.Scan(&productID, &nombre, &unidades, &total, &veces) != nil { continue }
+			favoritos = append(favoritos, map[string]interface{}{
+				"product_id": productID, "nombre": nombre, "unidades": unidades, "total": total, "compras": veces,
+			})
+		}
+		rows.Close()
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"system": "ONLINE",
-		"services": map[string]string{"auth": authOK, "sales": "OK", "cfdi": "OK", "loyalty": "OK"},
-	})
+	resumen["productos_favoritos"] = favoritos
+	return resumen
 }
 
-func (gw *Gateway) handleLogs(w http.ResponseWriter, r *http.Request) {
-	rows, err := gw.db.Query(
-		`SELECT id, total, payment_method, status, cfdi_uuid, created_at
-		 FROM sales ORDER BY created_at DESC LIMIT 15`)
-	if err != nil { w.WriteHeader(http.StatusInternalServerError); return }
+// comprasCliente lista las ventas del cliente, la más reciente primero
+func (gw *Gateway) comprasCliente(ctx context.Context, accountID string, limit, offset int) []map[string]interface{} {
+	compras := []map[string]interface{}{}
+	rows, err := gw.db.QueryContext(ctx, `
+		SELECT s.id, s.created_at, s.total, s.moneda, s.payment_method, s.status, COALESCE(s.sucursal,''),
+		       COALESCE(s.cfdi_uuid,''), (SELECT COUNT(*) FROM sale_items si WHERE si.sale_id = s.id)
+		FROM sales s WHERE s.customer_id = $1::uuid
+		ORDER BY s.created_at DESC LIMIT $2 OFFSET $3`, accountID, limit, offset)
+	if err != nil { return compras }
 	defer rows.Close()
-	var logs []map[string]interface{}
 	for rows.Next() {
-		var id, method, status, cat string
+		var id, moneda, metodo, estado, sucursal, uuid string
+		var fecha time.Time
 		var total float64
-		var cfdiUUID sql.NullString
-		rows.Scan(&id, &total, &method, &status, &cfdiUUID, &cat)
-		entry := map[string]interface{}{
-			"sale_id": id, "total": total, "method": method, "status": status, "time": cat,
-		}
-		if cfdiUUID.Valid { entry["cfdi_uuid"] = cfdiUUID.String }
-		logs = append(logs, entry)
+		var partidas int
+		if rows.Scan(&id, &fecha, &total, &moneda, &metodo, &estado, &sucursal, &uuid, &partidas) != nil { continue }
+		compras = append(compras, map[string]interface{}{
+			"id": id, "fecha": fecha.Format(time.RFC3339), "total": total, "moneda": moneda, "payment_method": metodo,
+			"status": estado, "sucursal": sucursal, "cfdi_uuid": uuid, "partidas": partidas,
+		})
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(logs)
+	return compras
 }
 
-func (gw *Gateway) handleCorte(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet { w.WriteHeader(http.StatusMethodNotAllowed); return }
-	fecha := r.URL.Query().Get("fecha")
-	if fecha == "" { fecha = time.Now().Format("2006-01-02") }
-	tid := tenantID(r)
-	w.Header().Set("Content-Type", "application/json")
-
-	// ── Por método de pago ───────────────────────────────────────────────────────
-	rows, err := gw.db.Query(`
-		SELECT COUNT(*), COALESCE(SUM(total),0),
-			COALESCE(SUM(CASE WHEN status='cancelled' THEN 1 ELSE 0 END),0),
-			COALESCE(SUM(CASE WHEN status='completed' THEN total ELSE 0 END),0),
-			COALESCE(SUM(CASE WHEN cfdi_uuid IS NOT NULL THEN 1 ELSE 0 END),0),
-			payment_method
-		FROM sales
-		WHERE DATE(created_at AT TIME ZONE 'America/Monterrey') = $1::date
-		  AND (tenant_id = $2::uuid OR tenant_id IS NULL)
-		GROUP BY payment_method`, fecha, tid)
+// referidosCliente resume el programa de referidos de una cuenta para el detalle del cliente
+func (gw *Gateway) referidosCliente(ctx context.Context, tid, accountID string) map[string]interface{} {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	res, err := gw.loyaltyClient.GetReferralStats(ctx, &pb_loyalty.ReferralStatsRequest{TenantId: tid, AccountId: accountID})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return map[string]interface{}{"error": status.Convert(err).Message()}
+	}
+	lista := []map[string]interface{}{}
+	for _, ref := range res.GetReferrals() {
+		lista = append(lista, map[string]interface{}{
+			"id": ref.GetId(), "name": ref.GetName(), "phone": ref.GetPhone(), "estado": ref.GetStatus(),
+			"motivo": ref.GetReason(), "puntos": ref.GetPoints(), "fecha": ref.GetCreatedAt(), "completado": ref.GetCompletedAt(),
+		})
+	}
+	return map[string]interface{}{
+		"codigo": res.GetCode(), "referido_por": res.GetReferredBy(), "estado_propio": res.GetReferredStatus(),
+		"pendientes": res.GetPending(), "completados": res.GetCompleted(), "rechazados": res.GetRejected(),
+		"puntos_ganados": res.GetPointsEarned(), "este_mes": res.GetThisMonth(), "tope_mensual": res.GetMonthlyCap(),
+		"referidos": lista,
+	}
+}
+
+func (gw *Gateway) handleCustomerByID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
 		return
 	}
-	defer rows.Close()
-	type M struct {
-		Metodo        string  `json:"metodo"`
-		Transacciones int64   `json:"transacciones"`
-		TotalVendido  float64 `json:"total_vendido"`
-		Canceladas    int64   `json:"canceladas"`
-		Neto          float64 `json:"neto"`
-		Timbradas     int64   `json:"timbradas"`
+
+	id, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/customers/"), "/")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "ID requerido"})
+		return
 	}
-	var metodos []M
-	var totalTx, totalCanceladas, totalTimbradas int64
-	var totalVendido, totalNeto float64
-	for rows.Next() {
-		var m M
-		rows.Scan(&m.Transacciones, &m.TotalVendido, &m.Canceladas, &m.Neto, &m.Timbradas, &m.Metodo)
-		metodos = append(metodos, m)
-		totalTx += m.Transacciones; totalVendido += m.TotalVendido
-		totalCanceladas += m.Canceladas; totalNeto += m.Neto; totalTimbradas += m.Timbradas
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		token = r.URL.Query().Get("token")
 	}
+	_ = token
+	programaID := gw.tenantLealtad(r.Context(), tenantID(r))
 
-	// ── Ventas por hora ──────────────────────────────────────────────────────────
-	rowsHora, _ := gw.db.Query(`
-		SELECT EXTRACT(HOUR FROM created_at AT TIME ZONE 'America/Monterrey')::int as hora,
-		       COUNT(*) as tx,
-		       COALESCE(SUM(CASE WHEN status='completed' THEN total ELSE 0 END),0) as total
-		FROM sales
-		WHERE DATE(created_at AT TIME ZONE 'America/Monterrey') = $1::date
-		  AND (tenant_id = $2::uuid OR tenant_id IS NULL)
-		GROUP BY hora ORDER BY hora ASC`, fecha, tid)
-	type HoraData struct {
-		Hora  int     `json:"hora"`
-		Tx    int64   `json:"tx"`
-		Total float64 `json:"total"`
+	if sub == "arco" || strings.HasPrefix(sub, "arco/") || (sub == "" && r.Method == http.MethodDelete) {
+		gw.handleCustomerARCO(w, r, id, strings.TrimPrefix(strings.TrimPrefix(sub, "arco"), "/"))
+		return
 	}
-	var porHora []HoraData
-	if rowsHora != nil {
-		defer rowsHora.Close()
-		for rowsHora.Next() {
-			var h HoraData
-			rowsHora.Scan(&h.Hora, &h.Tx, &h.Total)
-			porHora = append(porHora, h)
+	if sub == "credito" || strings.HasPrefix(sub, "credito/") {
+		gw.handleCreditoCliente(w, r, id, strings.TrimPrefix(strings.TrimPrefix(sub, "credito"), "/"))
+		return
+	}
+	// /api/v1/customers/{id}/compras?limit=&offset= — historial de compras paginado
+	if sub == "compras" && r.Method == http.MethodGet {
+		var existe bool
+		gw.db.QueryRowContext(r.Context(), `
+			SELECT EXISTS (SELECT 1 FROM loyalty_accounts WHERE id::text = $1 AND tenant_id = $2::uuid)`, id, programaID).Scan(&existe)
+		if !existe {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "cliente no encontrado"})
+			return
 		}
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		if limit <= 0 || limit > 100 { limit = 50 }
+		if offset < 0 { offset = 0 }
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"compras": gw.comprasCliente(r.Context(), id, limit, offset), "limit": limit, "offset": offset,
+		})
+		return
+	}
+	if sub != "" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "ruta no encontrada"})
+		return
 	}
 
-	// ── Top productos del día ────────────────────────────────────────────────────
-	rowsProd, _ := gw.db.Query(`
-		SELECT si.name, SUM(si.quantity) as uds, SUM(si.subtotal) as total
-		FROM sale_items si
+	switch r.Method {
+	case http.MethodGet:
+		var c struct {
+			ID         string  `json:"id"`
+			Phone      string  `json:"phone"`
+			RFC        string  `json:"rfc"`
+			Name       string  `json:"name"`
+			Email      string  `json:"email"`
+			Points     int     `json:"points"`
+			TotalSpent float64 `json:"total_spent"`
+			Tier       string  `json:"tier"`
+		}
+		var anonimizado sql.NullTime
+		err := gw.db.QueryRowContext(r.Context(), `
+			SELECT id, phone, COALESCE(rfc,''), name, COALESCE(email,''),
+			       points, total_spent, tier, anonimizado_at
+			FROM   loyalty_accounts WHERE id::text = $1 AND tenant_id = $2::uuid`, id, programaID).Scan(
+			&c.ID, &c.Phone, &c.RFC, &c.Name, &c.Email,
+			&c.Points, &c.TotalSpent, &c.Tier, &anonimizado)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "cliente no encontrado"})
+			return
+		}
+
+		rows, _ := gw.db.QueryContext(r.Context(), `
+			SELECT type, points, COALESCE(description,''), created_at
+			FROM   loyalty_transactions
+			WHERE  account_id = $1
+			ORDER  BY created_at DESC LIMIT 20`, id)
+
+		type TxRow struct {
+			Type        string `json:"type"`
+			Points      int    `json:"points"`
+			Description string `json:"description"`
+			Date        string `json:"date"`
+		}
+		var txs []TxRow
+		if rows != nil {
+			defer rows.Close()
+			for rows.Next() {
+				var tx TxRow
+				var createdAt interface{}
+				rows.Scan(&tx.Type, &tx.Points, &tx.Description, &createdAt)
+				if createdAt != nil {
+					tx.Date = fmt.Sprintf("%v", createdAt)
+				}
+				txs = append(txs, tx)
+			}
+		}
+		if txs == nil {
+			txs = []TxRow{}
+		}
+
+		// Teléfonos y cuentas que se fusionaron en este cliente
+		alternos, fusiones := []string{}, []map[string]interface{}{}
+		if arows, err := gw.db.QueryContext(r.Context(), `
+			SELECT phone FROM loyalty_telefonos_alternos WHERE account_id = $1::uuid ORDER BY created_at`, id); err == nil {
+			for arows.Next() {
+				var phone string
+				if arows.Scan(&phone) == nil { alternos = append(alternos, phone) }
+			}
+			arows.Close()
+		}
+		if frows, err := gw.db.QueryContext(r.Context(), `
+			SELECT id, fusionada->>'phone', COALESCE(fusionada->>'name',''), puntos, transacciones,
+			       COALESCE(motivo,''), COALESCE(usuario,''), created_at
+			FROM loyalty_fusiones WHERE superviviente_id = $1::uuid ORDER BY created_at DESC`, id); err == nil {
+			for frows.Next() {
+				var fid, phone, nombre, motivo, usuario string
+				var puntos, movimientos int
+				var fecha time.Time
+				if frows.Scan(&fid, &phone, &nombre, &puntos, &movimientos, &motivo, &usuario, &fecha) != nil { continue }
+				fusiones = append(fusiones, map[string]interface{}{
+					"id": fid, "phone": phone, "name": nombre, "puntos": puntos, "movimientos": movimientos,
+					"motivo": motivo, "usuario": usuario, "fecha": fecha.Format(time.RFC3339),
+				})
+			}
+			frows.Close()
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": c.ID, "phone": c.Phone, "rfc": c.RFC,
+			"name": c.Name, "email": c.Email,
+			"points": c.Points, "total_spent": c.TotalSpent,
+			"tier": c.Tier, "transactions": txs,
+			"referidos": gw.referidosCliente(r.Context(), tenantID(r), c.ID),
+			"telefonos_alternos": alternos, "fusiones": fusiones,
+			"compras": gw.resumenComprasCliente(r.Context(), c.ID),
+			"compras_recientes": gw.comprasCliente(r.Context(), c.ID, 10, 0),
+			"anonimizado": anonimizado.Valid,
+		})
+
+	case http.MethodPut:
+		var req struct {
+			Name  string `json:"name"`
+			RFC   string `json:"rfc"`
+			Email string `json:"email"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		gw.db.ExecContext(r.Context(), `
+			UPDATE loyalty_accounts
+			SET    name       = COALESCE(NULLIF($1,''), name),
+			       rfc        = COALESCE(NULLIF($2,''), rfc),
+			       email      = COALESCE(NULLIF($3,''), email),
+			       updated_at = now()
+			WHERE  id::text = $4 AND tenant_id = $5::uuid`, req.Name, req.RFC, req.Email, id, programaID)
+		json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (gw *Gateway) handleLoyalty(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	phone := r.URL.Query().Get("phone")
+	if phone == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "phone requerido"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	defer cancel()
+	tid := tenantID(r)
+	acc, err := gw.loyaltyClient.GetAccount(ctx, &pb_loyalty.GetAccountRequest{Phone: phone, TenantId: tid})
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "cliente no encontrado"})
+		return
+	}
+	hist, _ := gw.loyaltyClient.GetHistory(ctx, &pb_loyalty.GetHistoryRequest{Phone: phone, Limit: 20, TenantId: tid})
+	type txItem struct {
+		Type        string `json:"type"`
+		Points      int32  `json:"points"`
+		Description string `json:"description"`
+		Date        string `json:"date"`
+	}
+	var history []txItem
+	if hist != nil {
+		for _, tx := range hist.GetTransactions() {
+			history = append(history, txItem{
+				Type: tx.GetType(), Points: tx.GetPoints(),
+				Description: tx.GetDescription(), Date: tx.GetCreatedAt(),
+			})
+		}
+	}
+	// Cambios de tier de la cuenta, del más reciente al más antiguo
+	niveles := []map[string]interface{}{}
+	if rows, err := gw.db.QueryContext(ctx, `
+		SELECT h.anterior, h.nuevo, h.nombre_nuevo, h.motivo, h.created_at FROM loyalty_tier_historial h
+		WHERE h.account_id::text=$1 ORDER BY h.created_at DESC LIMIT 20`, acc.GetAccountId()); err == nil {
+		for rows.Next() {
+			var anterior, nuevo, nombre, motivo string
+			var fecha time.Time
+			if rows.Scan(&anterior, &nuevo, &nombre, &motivo, &fecha) != nil { continue }
+			niveles = append(niveles, map[string]interface{}{
+				"anterior": anterior, "nuevo": nuevo, "nombre": nombre, "motivo": motivo, "fecha": fecha.Format(time.RFC3339),
+			})
+		}
+		rows.Close()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"account": map[string]interface{}{
+                "phone": acc.GetPhone(), "customer_name": acc.GetName(), "rfc": acc.GetRfc(), "cp": acc.GetCp(),
+			"points": acc.GetPoints(), "tier": acc.GetTier(),
+			"total_spent": acc.GetTotalSpent(),
+                "regimen_fiscal": acc.GetRegimenFiscal(), "nombre_fiscal": acc.GetNombreFiscal(),
+			"tier_name": acc.GetTierName(), "tier_discount_pct": acc.GetTierDiscountPct(),
+			"tier_multiplier": acc.GetTierMultiplier(), "tier_grace_until": acc.GetTierGraceUntil(),
+			"tier_qualifying": acc.GetTierQualifying(),
+		},
+		"tier_history": niveles,
+		"history": history,
+		"points_expiring": hist.GetPointsExpiring(), "next_expiration": hist.GetNextExpiration(),
+	})
+}
+
+// handleLoyaltyPorVencer — GET ?dias=30: clientes con puntos que vencen pronto, para
+// campañas de marketing (?formato=csv para exportarlos)
+func (gw *Gateway) handleLoyaltyPorVencer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	dias, _ := strconv.Atoi(r.URL.Query().Get("dias"))
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+	res, err := gw.loyaltyClient.GetExpiringPoints(ctx, &pb_loyalty.ExpiringPointsRequest{TenantId: tenantID(r), Days: int32(dias)})
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": status.Convert(err).Message()})
+		return
+	}
+	if r.URL.Query().Get("formato") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="puntos_por_vencer.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"telefono", "nombre", "email", "puntos", "puntos_por_vencer", "vence"})
+		for _, a := range res.GetAccounts() {
+			cw.Write([]string{a.GetPhone(), a.GetName(), a.GetEmail(), strconv.Itoa(int(a.GetPoints())),
+				strconv.Itoa(int(a.GetPointsExpiring())), a.GetExpiresAt()})
+		}
+		cw.Flush()
+		return
+	}
+	clientes := []map[string]interface{}{}
+	var total int64
+	for _, a := range res.GetAccounts() {
+		total += int64(a.GetPointsExpiring())
+		clientes = append(clientes, map[string]interface{}{
+			"account_id": a.GetAccountId(), "phone": a.GetPhone(), "name": a.GetName(), "email": a.GetEmail(),
+			"points": a.GetPoints(), "points_expiring": a.GetPointsExpiring(), "expires_at": a.GetExpiresAt(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"clientes": clientes, "total_clientes": len(clientes), "total_puntos": total})
+}
+
+func (gw *Gateway) handleLoyaltyCp(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+    var req struct {
+        Phone string `json:"phone"`
+        CP    string `json:"cp"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        w.WriteHeader(http.StatusBadRequest); return
+    }
+    if req.Phone == "" || req.CP == "" {
+        w.WriteHeader(http.StatusBadRequest)
+        json.NewEncoder(w).Encode(map[string]string{"error": "phone y cp requeridos"})
+        return
+    }
+    _, err := gw.db.Exec(`UPDATE loyalty_accounts SET cp=$1, updated_at=now() WHERE phone=$2 AND tenant_id=$3::uuid`,
+        req.CP, req.Phone, gw.tenantLealtad(r.Context(), tenantID(r)))
+    if err != nil {
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{"status": "ok", "cp": req.CP})
+}
+
+func (gw *Gateway) handleLoyaltyRfc(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+    var req struct {
+        Phone string `json:"phone"`
+        RFC   string `json:"rfc"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        w.WriteHeader(http.StatusBadRequest); return
+    }
+    if req.Phone == "" || req.RFC == "" {
+        w.WriteHeader(http.StatusBadRequest)
+        json.NewEncoder(w).Encode(map[string]string{"error": "phone y rfc requeridos"})
+        return
+    }
+    req.RFC = strings.ToUpper(strings.TrimSpace(req.RFC))
+    _, err := gw.db.Exec(
+        `UPDATE loyalty_accounts SET rfc=$1, updated_at=now() WHERE phone=$2 AND tenant_id=$3::uuid`,
+        req.RFC, req.Phone, gw.tenantLealtad(r.Context(), tenantID(r)),
+    )
+    if err != nil {
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{"status": "ok", "rfc": req.RFC})
+}
+func (gw *Gateway) handleLoyaltyFiscal(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+    var req struct {
+        Phone         string `json:"phone"`
+        RegimenFiscal string `json:"regimen_fiscal"`
+        NombreFiscal  string `json:"nombre_fiscal"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        w.WriteHeader(http.StatusBadRequest); return
+    }
+    if req.Phone == "" {
+        w.WriteHeader(http.StatusBadRequest)
+        json.NewEncoder(w).Encode(map[string]string{"error": "phone requerido"})
+        return
+    }
+    req.NombreFiscal = strings.ToUpper(strings.TrimSpace(req.NombreFiscal))
+    _, err := gw.db.Exec(
+        `UPDATE loyalty_accounts SET regimen_fiscal=$1, nombre_fiscal=$2, updated_at=now() WHERE phone=$3 AND tenant_id=$4::uuid`,
+        req.RegimenFiscal, req.NombreFiscal, req.Phone, gw.tenantLealtad(r.Context(), tenantID(r)))
+    if err != nil {
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+func (gw *Gateway) handleLoyaltyCliente(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Phone   string `json:"phone"`
+		Tel     string `json:"tel"`
+		Email   string `json:"email"`
+		Nombre  string `json:"nombre"`
+		Rfc     string `json:"rfc"`
+		Cp      string `json:"cp"`
+		Regimen string `json:"regimen"`
+		Uso     string `json:"uso"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "json invalido", http.StatusBadRequest)
+		return
+	}
+	phone := req.Phone
+	if phone == "" {
+		phone = req.Tel
+	}
+	if phone == "" {
+		http.Error(w, "phone requerido", http.StatusBadRequest)
+		return
+	}
+	_, err := gw.db.ExecContext(r.Context(),
+		"INSERT INTO loyalty_accounts (tenant_id, phone, name, rfc, cp, email, regimen_fiscal, nombre_fiscal, uso_cfdi, points, total_spent, tier) "+
+		"VALUES ($9::uuid, $1, $2, $3, $4, $5, $6, $7, $8, 0, 0, 'bronze') "+
+		"ON CONFLICT (tenant_id, phone) DO UPDATE SET "+
+		"name           = CASE WHEN $2 != '' THEN $2 ELSE loyalty_accounts.name END, "+
+		"rfc            = CASE WHEN $3 != '' THEN $3 ELSE loyalty_accounts.rfc END, "+
+		"cp             = CASE WHEN $4 != '' THEN $4 ELSE loyalty_accounts.cp END, "+
+		"email          = CASE WHEN $5 != '' THEN $5 ELSE loyalty_accounts.email END, "+
+		"regimen_fiscal = CASE WHEN $6 != '' THEN $6 ELSE loyalty_accounts.regimen_fiscal END, "+
+		"nombre_fiscal  = CASE WHEN $7 != '' THEN $7 ELSE loyalty_accounts.nombre_fiscal END, "+
+		"uso_cfdi       = CASE WHEN $8 != '' THEN $8 ELSE loyalty_accounts.uso_cfdi END, "+
+		"updated_at     = NOW()",
+		phone, req.Nombre, req.Rfc, req.Cp, req.Email, req.Regimen, req.Nombre, req.Uso,
+		gw.tenantLealtad(r.Context(), tenantID(r)),
+	)
+	if err != nil {
+		log.Printf("[BFF] handleLoyaltyCliente error: %v", err)
+		http.Error(w, "error guardando cliente", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[BFF] Cliente guardado phone=%s rfc=%s email=%s", phone, req.Rfc, req.Email)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"ok":true,"message":"Cliente guardado"}`))
+}
+
+// planesMultisucursal son los planes cuyas sucursales pueden compartir el programa de
+// lealtad de su matriz
+var planesMultisucursal = map[string]bool{"pro": true}
+
+// tenantLealtad devuelve el tenant dueño del programa de lealtad y de las cuentas de
+// clientes de tid: el propio tid, o su matriz si comparte el programa
+func (gw *Gateway) tenantLealtad(ctx context.Context, tid string) string {
+	var programaID string
+	if err := gw.db.QueryRowContext(ctx, `SELECT COALESCE(programa_lealtad_id, id)::text FROM tenants WHERE id::text=$1`, tid).
+		Scan(&programaID); err != nil {
+		return tid
+	}
+	return programaID
+}
+
+// handleLoyaltyCompartir une las sucursales al programa de lealtad de su matriz.
+// GET: programa que usa el tenant y sucursales que comparten el suyo;
+// POST {matriz_id}: la sucursal se une al programa de la matriz (mismo RFC, plan
+// multisucursal) y sus clientes pasan a la matriz, sumando puntos si el teléfono ya
+// existía allá; DELETE: la sucursal vuelve a un programa propio, sin clientes, y las
+// cuentas se quedan con la matriz.
+func (gw *Gateway) handleLoyaltyCompartir(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	tid := tenantID(r)
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+	switch r.Method {
+	case http.MethodGet:
+		sucursales := []map[string]string{}
+		rows, err := gw.db.QueryContext(r.Context(), `SELECT id::text, nombre FROM tenants WHERE programa_lealtad_id=$1::uuid ORDER BY nombre`, tid)
+		if err == nil {
+			defer rows.Close()
+			for rows.Next() {
+				var id, nombre string
+				if rows.Scan(&id, &nombre) == nil { sucursales = append(sucursales, map[string]string{"id": id, "nombre": nombre}) }
+			}
+		}
+		programaID := gw.tenantLealtad(r.Context(), tid)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"programa_tenant_id": programaID, "compartido": programaID != tid, "sucursales": sucursales,
+		})
+
+	case http.MethodPost:
+		var req struct {
+			MatrizID string `json:"matriz_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MatrizID == "" { fail(400, "matriz_id requerido"); return }
+		if req.MatrizID == tid { fail(400, "la matriz no puede ser el mismo tenant"); return }
+		var rfcMatriz, planMatriz, rfcSucursal string
+		var matrizComparte, tieneSucursales bool
+		err := gw.db.QueryRowContext(r.Context(), `
+			SELECT COALESCE(m.rfc,''), m.plan, m.programa_lealtad_id IS NOT NULL, COALESCE(s.rfc,''),
+			       EXISTS (SELECT 1 FROM tenants x WHERE x.programa_lealtad_id = s.id)
+			FROM tenants m, tenants s
+			WHERE m.id::text=$1 AND m.active AND s.id::text=$2`, req.MatrizID, tid).
+			Scan(&rfcMatriz, &planMatriz, &matrizComparte, &rfcSucursal, &tieneSucursales)
+		if err != nil { fail(404, "tenant no encontrado"); return }
+		switch {
+		case rfcMatriz == "" || rfcMatriz != rfcSucursal:
+			fail(403, "solo se comparte el programa entre negocios del mismo RFC")
+			return
+		case !planesMultisucursal[planMatriz]:
+			fail(403, "el plan de la matriz no incluye programa de lealtad multisucursal")
+			return
+		case matrizComparte || tieneSucursales:
+			fail(409, "la matriz no puede compartir un programa ajeno ni la sucursal tener sucursales propias")
+			return
+		}
+
+		tx, err := gw.db.BeginTx(r.Context(), nil)
+		if err != nil { fail(500, err.Error()); return }
+		defer tx.Rollback()
+		pasos := []string{
+			// Teléfonos que ya son clientes de la matriz: se suman puntos y gasto
+			`UPDATE loyalty_accounts m SET points = m.points + b.points, total_spent = m.total_spent + b.total_spent, updated_at = NOW()
+			 FROM loyalty_accounts b WHERE b.tenant_id=$1::uuid AND m.tenant_id=$2::uuid AND m.phone=b.phone`,
+			`UPDATE loyalty_transactions t SET account_id = m.id
+			 FROM loyalty_accounts b, loyalty_accounts m
+			 WHERE t.account_id=b.id AND b.tenant_id=$1::uuid AND m.tenant_id=$2::uuid AND m.phone=b.phone`,
+			`DELETE FROM loyalty_accounts b USING loyalty_accounts m
+			 WHERE b.tenant_id=$1::uuid AND m.tenant_id=$2::uuid AND m.phone=b.phone`,
+			`UPDATE loyalty_accounts SET tenant_id=$2::uuid WHERE tenant_id=$1::uuid`,
+			`UPDATE tenants SET programa_lealtad_id=$2::uuid WHERE id=$1::uuid`,
+		}
+		for _, q := range pasos {
+			if _, err = tx.ExecContext(r.Context(), q, tid, req.MatrizID); err != nil { break }
+		}
+		if err == nil { err = tx.Commit() }
+		if err != nil { fail(500, err.Error()); return }
+		log.Printf("[BFF] Lealtad: tenant=%s comparte el programa de %s", tid, req.MatrizID)
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "programa_tenant_id": req.MatrizID})
+
+	case http.MethodDelete:
+		if _, err := gw.db.ExecContext(r.Context(), `UPDATE tenants SET programa_lealtad_id=NULL WHERE id::text=$1`, tid); err != nil {
+			fail(500, err.Error())
+			return
+		}
+		log.Printf("[BFF] Lealtad: tenant=%s deja de compartir programa", tid)
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "programa_tenant_id": tid})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// rewardJSON es la recompensa como la recibe y devuelve el BFF
+type rewardJSON struct {
+	ID             string  `json:"id"`
+	Name           string  `json:"name"`
+	Description    string  `json:"description"`
+	PointsRequired int32   `json:"points_required"`
+	Stock          *int32  `json:"stock"` // null = sin límite
+	ValidFrom      string  `json:"valid_from"`
+	ValidUntil     string  `json:"valid_until"`
+	ProductID      string  `json:"product_id"`
+	DiscountType   string  `json:"discount_type"`
+	DiscountValue  float64 `json:"discount_value"`
+	Active         bool    `json:"active"`
+}
+
+func rewardDesdePB(rw *pb_loyalty.Reward) rewardJSON {
+	j := rewardJSON{
+		ID: rw.GetId(), Name: rw.GetName(), Description: rw.GetDescription(), PointsRequired: rw.GetPointsRequired(),
+		ValidFrom: rw.GetValidFrom(), ValidUntil: rw.GetValidUntil(), ProductID: rw.GetProductId(),
+		DiscountType: rw.GetDiscountType(), DiscountValue: rw.GetDiscountValue(), Active: rw.GetActive(),
+	}
+	if rw.GetStock() >= 0 {
+		stock := rw.GetStock()
+		j.Stock = &stock
+	}
+	return j
+}
+
+// handleLoyaltyRecompensas administra el catálogo de recompensas del programa.
+// GET (?todas=1 incluye retiradas, agotadas y vencidas); POST crea; PUT {id, ...}
+// reemplaza; DELETE ?id= retira.
+func (gw *Gateway) handleLoyaltyRecompensas(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	tid := tenantID(r)
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+	failRPC := func(err error) {
+		code := http.StatusInternalServerError
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			code = http.StatusBadRequest
+		case codes.NotFound:
+			code = http.StatusNotFound
+		}
+		fail(code, status.Convert(err).Message())
+	}
+	// Las sucursales que comparten programa ven el catálogo de la matriz pero no lo editan
+	if r.Method != http.MethodGet && gw.tenantLealtad(r.Context(), tid) != tid {
+		fail(http.StatusConflict, "el catálogo de recompensas lo administra la matriz")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		res, err := gw.loyaltyClient.ListRewards(ctx, &pb_loyalty.ListRewardsRequest{
+			TenantId: tid, IncludeInactive: r.URL.Query().Get("todas") == "1",
+		})
+		if err != nil { failRPC(err); return }
+		lista := []rewardJSON{}
+		for _, rw := range res.GetRewards() { lista = append(lista, rewardDesdePB(rw)) }
+		json.NewEncoder(w).Encode(map[string]interface{}{"recompensas": lista})
+
+	case http.MethodPost, http.MethodPut:
+		var req rewardJSON
+		req.Active = true
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { fail(400, "JSON invalido"); return }
+		rw := &pb_loyalty.Reward{
+			Id: req.ID, TenantId: tid, Name: strings.TrimSpace(req.Name), Description: req.Description,
+			PointsRequired: req.PointsRequired, Stock: -1, ValidFrom: req.ValidFrom, ValidUntil: req.ValidUntil,
+			ProductId: req.ProductID, DiscountType: req.DiscountType, DiscountValue: req.DiscountValue, Active: req.Active,
+		}
+		if req.Stock != nil { rw.Stock = *req.Stock }
+		var res *pb_loyalty.Reward
+		var err error
+		if r.Method == http.MethodPost {
+			res, err = gw.loyaltyClient.CreateReward(ctx, rw)
+		} else {
+			res, err = gw.loyaltyClient.UpdateReward(ctx, rw)
+		}
+		if err != nil { failRPC(err); return }
+		json.NewEncoder(w).Encode(rewardDesdePB(res))
+
+	case http.MethodDelete:
+		res, err := gw.loyaltyClient.RetireReward(ctx, &pb_loyalty.RetireRewardRequest{TenantId: tid, RewardId: r.URL.Query().Get("id")})
+		if err != nil { failRPC(err); return }
+		json.NewEncoder(w).Encode(rewardDesdePB(res))
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleLoyaltyCanje — la caja consulta y aplica los códigos de canje.
+// GET /api/v1/loyalty/canjes/{codigo}: recompensa del código (producto y/o descuento) para
+// agregarla al carrito; POST /api/v1/loyalty/canjes/{codigo}/aplicar {sale_id}: lo marca
+// como usado. Un código solo se aplica una vez y en el programa donde se emitió.
+func (gw *Gateway) handleLoyaltyCanje(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+	partes := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/loyalty/canjes/"), "/"), "/")
+	codigo := strings.ToUpper(strings.TrimSpace(partes[0]))
+	programaID := gw.tenantLealtad(r.Context(), tenantID(r))
+
+	switch {
+	case r.Method == http.MethodGet && len(partes) == 1:
+		var estado, rewardName, productID, productName, descuentoTipo, phone string
+		var puntos int
+		var descuentoValor, productPrice float64
+		var expira time.Time
+		err := gw.db.QueryRowContext(r.Context(), `
+			SELECT c.estado, c.puntos, c.expires_at, a.phone, rw.name, COALESCE(rw.product_id::text,''),
+			       COALESCE(p.name,''), COALESCE(p.price,0), COALESCE(rw.descuento_tipo,''), COALESCE(rw.descuento_valor,0)
+			FROM loyalty_canjes c
+			JOIN loyalty_accounts a ON a.id = c.account_id
+			JOIN loyalty_rewards rw ON rw.id = c.reward_id
+			LEFT JOIN products p ON p.id = rw.product_id
+			WHERE c.codigo = $1 AND a.tenant_id = $2::uuid`, codigo, programaID).
+			Scan(&estado, &puntos, &expira, &phone, &rewardName, &productID, &productName, &productPrice, &descuentoTipo, &descuentoValor)
+		if err != nil { fail(404, "código de canje no encontrado"); return }
+		if estado == "emitido" && time.Now().After(expira) { estado = "vencido" }
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"codigo": codigo, "estado": estado, "aplicable": estado == "emitido", "phone": phone,
+			"recompensa": rewardName, "puntos": puntos, "expira": expira.Format(time.RFC3339),
+			"producto": map[string]interface{}{"id": productID, "name": productName, "price": productPrice},
+			"descuento": map[string]interface{}{"tipo": descuentoTipo, "valor": descuentoValor},
+		})
+
+	case r.Method == http.MethodPost && len(partes) == 2 && partes[1] == "aplicar":
+		var req struct {
+			SaleID string `json:"sale_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		res, err := gw.db.ExecContext(r.Context(), `
+			UPDATE loyalty_canjes c SET estado='aplicado', applied_at=NOW(), sale_id=NULLIF($3,'')::uuid
+			FROM loyalty_accounts a
+			WHERE a.id = c.account_id AND c.codigo = $1 AND a.tenant_id = $2::uuid
+			  AND c.estado = 'emitido' AND c.expires_at > NOW()`, codigo, programaID, req.SaleID)
+		if err != nil { fail(500, err.Error()); return }
+		if n, _ := res.RowsAffected(); n == 0 { fail(http.StatusConflict, "el código no existe, ya se usó o venció"); return }
+		log.Printf("[BFF] Canje aplicado codigo=%s sale=%s", codigo, req.SaleID)
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "codigo": codigo, "estado": "aplicado"})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// nivelLealtad es un tier del programa de lealtad de un tenant
+// referidosLealtad son los bonos del programa de referidos; 0 y 0 lo apaga
+type referidosLealtad struct {
+	PuntosReferente int32   `json:"puntos_referente"`
+	PuntosReferido  int32   `json:"puntos_referido"`
+	CompraMinima    float64 `json:"compra_minima"`
+	TopeMensual     int     `json:"tope_mensual"`
+}
+
+type nivelLealtad struct {
+	Clave         string  `json:"clave"`
+	Nombre        string  `json:"nombre"`
+	GastoMinimo   float64 `json:"gasto_minimo"`
+	Multiplicador float64 `json:"multiplicador"`
+	DescuentoPct  float64 `json:"descuento_pct"`
+}
+
+// nivelesLealtadPredeterminados son los tiers que aplica el loyalty service a los
+// negocios sin programa propio
+var nivelesLealtadPredeterminados = []nivelLealtad{
+	{Clave: "bronze", Nombre: "Bronce", GastoMinimo: 0, Multiplicador: 1},
+	{Clave: "silver", Nombre: "Plata", GastoMinimo: 500, Multiplicador: 1},
+	{Clave: "gold", Nombre: "Oro", GastoMinimo: 2000, Multiplicador: 1},
+	{Clave: "platinum", Nombre: "Platino", GastoMinimo: 5000, Multiplicador: 1},
+}
+
+// handleLoyaltyPrograma administra las reglas de puntos del tenant que aplica EarnPoints.
+//   /api/v1/loyalty/programa — GET: programa completo; PUT {puntos_por_peso, redondeo,
+//     niveles, referidos}: reemplaza las reglas base y los tiers; DELETE: vuelve al predeterminado
+//   /api/v1/loyalty/programa/multiplicadores — POST {tipo, valor, multiplicador}
+//     (multiplicador 0 excluye el producto o categoría); DELETE ?id=
+//   /api/v1/loyalty/programa/promociones — POST {nombre, desde, hasta, multiplicador}; DELETE ?id=
+// Una sucursal que comparte el programa de su matriz lo ve pero no lo modifica.
+func (gw *Gateway) handleLoyaltyPrograma(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+	tid := gw.tenantLealtad(r.Context(), tenantID(r))
+	if tid != tenantID(r) && r.Method != http.MethodGet {
+		fail(http.StatusConflict, "el programa de lealtad lo administra la matriz")
+		return
+	}
+	recurso := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/loyalty/programa"), "/")
+
+	switch {
+	case recurso == "" && r.Method == http.MethodGet:
+		gw.responderProgramaLealtad(w, r, tid)
+
+	case recurso == "" && r.Method == http.MethodPut:
+		var req struct {
+			PuntosPorPeso float64        `json:"puntos_por_peso"`
+			Redondeo      string         `json:"redondeo"`
+			VigenciaMeses int            `json:"vigencia_meses"` // 0 = los puntos no vencen
+			// Si una cancelación quita más puntos de los que hay: deuda, negativo o absorber
+			PoliticaReversion string     `json:"politica_reversion"`
+			// Los tiers se califican con el gasto o los puntos de los últimos ventana_meses
+			// (0 = todo el historial); al dejar de calificar se conservan gracia_dias
+			BaseNivel     string         `json:"base_nivel"`
+			VentanaMeses  int            `json:"ventana_meses"`
+			GraciaDias    int            `json:"gracia_dias"`
+			Niveles       []nivelLealtad `json:"niveles"`
+			// Bonos cuando la primera compra de un cliente referido llega a compra_minima
+			Referidos     referidosLealtad `json:"referidos"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { fail(400, "JSON invalido"); return }
+		if req.Redondeo == "" { req.Redondeo = "abajo" }
+		if req.PoliticaReversion == "" { req.PoliticaReversion = "deuda" }
+		if req.BaseNivel == "" { req.BaseNivel = "gasto" }
+		if len(req.Niveles) == 0 { req.Niveles = nivelesLealtadPredeterminados }
+		if req.PuntosPorPeso < 0 || req.PuntosPorPeso > 1000 { fail(400, "puntos_por_peso debe estar entre 0 y 1000"); return }
+		if req.VigenciaMeses < 0 || req.VigenciaMeses > 120 { fail(400, "vigencia_meses debe estar entre 0 (no vencen) y 120"); return }
+		if req.Redondeo != "abajo" && req.Redondeo != "cercano" && req.Redondeo != "arriba" {
+			fail(400, "redondeo debe ser abajo, cercano o arriba")
+			return
+		}
+		if req.PoliticaReversion != "deuda" && req.PoliticaReversion != "negativo" && req.PoliticaReversion != "absorber" {
+			fail(400, "politica_reversion debe ser deuda, negativo o absorber")
+			return
+		}
+		if req.BaseNivel != "gasto" && req.BaseNivel != "puntos" { fail(400, "base_nivel debe ser gasto o puntos"); return }
+		if req.VentanaMeses < 0 || req.VentanaMeses > 120 { fail(400, "ventana_meses debe estar entre 0 (todo el historial) y 120"); return }
+		if req.GraciaDias < 0 || req.GraciaDias > 365 { fail(400, "gracia_dias debe estar entre 0 y 365"); return }
+		ref := req.Referidos
+		if ref.PuntosReferente < 0 || ref.PuntosReferido < 0 || ref.PuntosReferente > 100000 || ref.PuntosReferido > 100000 {
+			fail(400, "los puntos de referidos deben estar entre 0 y 100000")
+			return
+		}
+		if ref.CompraMinima < 0 || ref.TopeMensual < 0 || ref.TopeMensual > 1000 {
+			fail(400, "compra_minima no puede ser negativa y tope_mensual debe estar entre 0 (sin tope) y 1000")
+			return
+		}
+		claves, inicial := map[string]bool{}, false
+		for i, n := range req.Niveles {
+			n.Clave = strings.ToLower(strings.TrimSpace(n.Clave))
+			n.Nombre = strings.TrimSpace(n.Nombre)
+			if n.Multiplicador == 0 { n.Multiplicador = 1 }
+			if n.Clave == "" || len(n.Clave) > 20 || n.Nombre == "" || claves[n.Clave] {
+				fail(400, "cada nivel necesita clave única (máx. 20 caracteres) y nombre")
+				return
+			}
+			if n.GastoMinimo < 0 || n.Multiplicador < 0 { fail(400, "gasto_minimo y multiplicador no pueden ser negativos"); return }
+			if n.DescuentoPct < 0 || n.DescuentoPct > 100 { fail(400, "descuento_pct debe estar entre 0 y 100"); return }
+			if n.GastoMinimo == 0 { inicial = true }
+			claves[n.Clave] = true
+			req.Niveles[i] = n
+		}
+		if !inicial { fail(400, "un nivel debe empezar en gasto_minimo 0"); return }
+
+		tx, err := gw.db.BeginTx(r.Context(), nil)
+		if err != nil { fail(500, err.Error()); return }
+		defer tx.Rollback()
+		_, err = tx.ExecContext(r.Context(), `
+			INSERT INTO loyalty_programas (tenant_id, puntos_por_peso, redondeo, vigencia_meses, politica_reversion,
+			                               base_nivel, ventana_meses, gracia_dias, referido_puntos_referente,
+			                               referido_puntos_referido, referido_compra_minima, referido_tope_mensual)
+			VALUES ($1::uuid, $2, $3, NULLIF($4, 0), $5, $6, NULLIF($7, 0), $8, $9, $10, $11, $12)
+			ON CONFLICT (tenant_id) DO UPDATE SET puntos_por_peso=EXCLUDED.puntos_por_peso, redondeo=EXCLUDED.redondeo,
+			       vigencia_meses=EXCLUDED.vigencia_meses, politica_reversion=EXCLUDED.politica_reversion,
+			       base_nivel=EXCLUDED.base_nivel, ventana_meses=EXCLUDED.ventana_meses, gracia_dias=EXCLUDED.gracia_dias,
+			       referido_puntos_referente=EXCLUDED.referido_puntos_referente, referido_puntos_referido=EXCLUDED.referido_puntos_referido,
+			       referido_compra_minima=EXCLUDED.referido_compra_minima, referido_tope_mensual=EXCLUDED.referido_tope_mensual, updated_at=NOW()`,
+			tid, req.PuntosPorPeso, req.Redondeo, req.VigenciaMeses, req.PoliticaReversion, req.BaseNivel, req.VentanaMeses, req.GraciaDias,
+			ref.PuntosReferente, ref.PuntosReferido, ref.CompraMinima, ref.TopeMensual)
+		// La vigencia nueva aplica a los puntos que se ganen de aquí en adelante. Al activarla,
+		// los puntos que no vencían empiezan a contar desde hoy; al quitarla, ya no vence nada.
+		if err == nil && req.VigenciaMeses > 0 {
+			_, err = tx.ExecContext(r.Context(), `
+				UPDATE loyalty_lotes SET expires_at = date_trunc('day', NOW() AT TIME ZONE 'America/Monterrey')
+				       AT TIME ZONE 'America/Monterrey' + make_interval(months => $2) + INTERVAL '1 day - 1 second'
+				WHERE tenant_id=$1::uuid AND restantes > 0 AND expires_at IS NULL`, tid, req.VigenciaMeses)
+		} else if err == nil {
+			_, err = tx.ExecContext(r.Context(), `UPDATE loyalty_lotes SET expires_at=NULL WHERE tenant_id=$1::uuid AND restantes > 0`, tid)
+		}
+		if err == nil {
+			_, err = tx.ExecContext(r.Context(), `DELETE FROM loyalty_niveles WHERE tenant_id=$1::uuid`, tid)
+		}
+		for _, n := range req.Niveles {
+			if err != nil { break }
+			_, err = tx.ExecContext(r.Context(), `
+				INSERT INTO loyalty_niveles (tenant_id, clave, nombre, gasto_minimo, multiplicador, descuento_pct)
+				VALUES ($1::uuid, $2, $3, $4, $5, $6)`,
+				tid, n.Clave, n.Nombre, n.GastoMinimo, n.Multiplicador, n.DescuentoPct)
+		}
+		if err == nil { err = tx.Commit() }
+		if err != nil { fail(500, err.Error()); return }
+		log.Printf("[BFF] Programa de lealtad tenant=%s: %.4f pts/peso, redondeo %s, %d niveles", tid, req.PuntosPorPeso, req.Redondeo, len(req.Niveles))
+		gw.responderProgramaLealtad(w, r, tid)
+
+	case recurso == "" && r.Method == http.MethodDelete:
+		gw.db.ExecContext(r.Context(), `DELETE FROM loyalty_niveles WHERE tenant_id=$1::uuid`, tid)
+		gw.db.ExecContext(r.Context(), `DELETE FROM loyalty_programas WHERE tenant_id=$1::uuid`, tid)
+		gw.responderProgramaLealtad(w, r, tid)
+
+	case recurso == "multiplicadores" && r.Method == http.MethodPost:
+		var req struct {
+			Tipo          string   `json:"tipo"`
+			Valor         string   `json:"valor"`
+			Multiplicador *float64 `json:"multiplicador"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { fail(400, "JSON invalido"); return }
+		req.Valor = strings.TrimSpace(req.Valor)
+		if (req.Tipo != "categoria" && req.Tipo != "producto") || req.Valor == "" {
+			fail(400, "tipo (categoria o producto) y valor requeridos")
+			return
+		}
+		if req.Multiplicador == nil || *req.Multiplicador < 0 || *req.Multiplicador > 100 {
+			fail(400, "multiplicador requerido entre 0 (excluir) y 100")
+			return
+		}
+		var id string
+		err := gw.db.QueryRowContext(r.Context(), `
+			INSERT INTO loyalty_multiplicadores (tenant_id, tipo, valor, multiplicador) VALUES ($1::uuid, $2, $3, $4)
+			ON CONFLICT (tenant_id, tipo, valor) DO UPDATE SET multiplicador=EXCLUDED.multiplicador
+			RETURNING id`, tid, req.Tipo, req.Valor, *req.Multiplicador).Scan(&id)
+		if err != nil { fail(500, err.Error()); return }
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "id": id})
+
+	case recurso == "promociones" && r.Method == http.MethodPost:
+		var req struct {
+			Nombre        string  `json:"nombre"`
+			Desde         string  `json:"desde"`
+			Hasta         string  `json:"hasta"`
+			Multiplicador float64 `json:"multiplicador"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { fail(400, "JSON invalido"); return }
+		if req.Multiplicador == 0 { req.Multiplicador = 2 }
+		desde, errD := time.Parse("2006-01-02", req.Desde)
+		hasta, errH := time.Parse("2006-01-02", req.Hasta)
+		if strings.TrimSpace(req.Nombre) == "" || errD != nil || errH != nil || hasta.Before(desde) {
+			fail(400, "nombre, desde y hasta (YYYY-MM-DD, hasta >= desde) requeridos")
+			return
+		}
+		if req.Multiplicador < 1 || req.Multiplicador > 100 { fail(400, "multiplicador debe estar entre 1 y 100"); return }
+		var id string
+		err := gw.db.QueryRowContext(r.Context(), `
+			INSERT INTO loyalty_promociones (tenant_id, nombre, desde, hasta, multiplicador)
+			VALUES ($1::uuid, $2, $3::date, $4::date, $5) RETURNING id`,
+			tid, strings.TrimSpace(req.Nombre), req.Desde, req.Hasta, req.Multiplicador).Scan(&id)
+		if err != nil { fail(500, err.Error()); return }
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "id": id})
+
+	case (recurso == "multiplicadores" || recurso == "promociones") && r.Method == http.MethodDelete:
+		tabla := "loyalty_multiplicadores"
+		if recurso == "promociones" { tabla = "loyalty_promociones" }
+		res, err := gw.db.ExecContext(r.Context(), `DELETE FROM `+tabla+` WHERE id::text=$1 AND tenant_id=$2::uuid`, r.URL.Query().Get("id"), tid)
+		if err != nil { fail(500, err.Error()); return }
+		if n, _ := res.RowsAffected(); n == 0 { fail(404, "no encontrado"); return }
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// responderProgramaLealtad escribe el programa vigente del tenant, con los valores
+// predeterminados en lo que no haya configurado
+func (gw *Gateway) responderProgramaLealtad(w http.ResponseWriter, r *http.Request, tid string) {
+	ctx := r.Context()
+	puntosPorPeso, redondeo, personalizado := 1.0, "abajo", true
+	vigenciaMeses, politicaReversion := 0, "deuda"
+	baseNivel, ventanaMeses, graciaDias := "gasto", 0, 0
+	var referidos referidosLealtad
+	if gw.db.QueryRowContext(ctx, `
+		SELECT puntos_por_peso, redondeo, COALESCE(vigencia_meses, 0), politica_reversion, base_nivel, COALESCE(ventana_meses, 0), gracia_dias,
+		       referido_puntos_referente, referido_puntos_referido, referido_compra_minima, referido_tope_mensual
+		FROM loyalty_programas WHERE tenant_id=$1::uuid`, tid).
+		Scan(&puntosPorPeso, &redondeo, &vigenciaMeses, &politicaReversion, &baseNivel, &ventanaMeses, &graciaDias,
+			&referidos.PuntosReferente, &referidos.PuntosReferido, &referidos.CompraMinima, &referidos.TopeMensual) == sql.ErrNoRows {
+		personalizado = false
+	}
+	niveles := []nivelLealtad{}
+	if rows, err := gw.db.QueryContext(ctx, `
+		SELECT clave, nombre, gasto_minimo, multiplicador, descuento_pct FROM loyalty_niveles
+		WHERE tenant_id=$1::uuid ORDER BY gasto_minimo`, tid); err == nil {
+		for rows.Next() {
+			var n nivelLealtad
+			if rows.Scan(&n.Clave, &n.Nombre, &n.GastoMinimo, &n.Multiplicador, &n.DescuentoPct) == nil { niveles = append(niveles, n) }
+		}
+		rows.Close()
+	}
+	if len(niveles) == 0 { niveles = nivelesLealtadPredeterminados }
+
+	multiplicadores := []map[string]interface{}{}
+	if rows, err := gw.db.QueryContext(ctx, `
+		SELECT id, tipo, valor, multiplicador FROM loyalty_multiplicadores
+		WHERE tenant_id=$1::uuid ORDER BY tipo, valor`, tid); err == nil {
+		for rows.Next() {
+			var id, tipo, valor string
+			var mult float64
+			if rows.Scan(&id, &tipo, &valor, &mult) != nil { continue }
+			multiplicadores = append(multiplicadores, map[string]interface{}{
+				"id": id, "tipo": tipo, "valor": valor, "multiplicador": mult, "excluido": mult == 0,
+			})
+		}
+		rows.Close()
+	}
+
+	promociones := []map[string]interface{}{}
+	if rows, err := gw.db.QueryContext(ctx, `
+		SELECT id, nombre, desde, hasta, multiplicador FROM loyalty_promociones
+		WHERE tenant_id=$1::uuid AND hasta >= CURRENT_DATE ORDER BY desde`, tid); err == nil {
+		for rows.Next() {
+			var id, nombre string
+			var desde, hasta time.Time
+			var mult float64
+			if rows.Scan(&id, &nombre, &desde, &hasta, &mult) != nil { continue }
+			promociones = append(promociones, map[string]interface{}{
+				"id": id, "nombre": nombre, "desde": desde.Format("2006-01-02"), "hasta": hasta.Format("2006-01-02"), "multiplicador": mult,
+			})
+		}
+		rows.Close()
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"personalizado": personalizado, "puntos_por_peso": puntosPorPeso, "redondeo": redondeo, "vigencia_meses": vigenciaMeses,
+		"politica_reversion": politicaReversion, "base_nivel": baseNivel, "ventana_meses": ventanaMeses, "gracia_dias": graciaDias,
+		"niveles": niveles, "multiplicadores": multiplicadores, "promociones": promociones, "referidos": referidos,
+	})
+}
+
+// handleLoyaltyRedeem — POST {phone, reward_id}: canjea una recompensa del catálogo y
+// devuelve el código para la caja; POST {phone, points, reason}: canje libre de puntos
+func (gw *Gateway) handleLoyaltyRedeem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	var req struct {
+		Phone    string `json:"phone"`
+		Points   int64  `json:"points"`
+		Reason   string `json:"reason"`
+		RewardID string `json:"reward_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "JSON invalido"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	defer cancel()
+	if req.RewardID != "" {
+		res, err := gw.loyaltyClient.RedeemReward(ctx, &pb_loyalty.RedeemRewardRequest{
+			TenantId: tenantID(r), Phone: req.Phone, RewardId: req.RewardID,
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": status.Convert(err).Message()})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !res.GetSuccess() { w.WriteHeader(http.StatusConflict) }
+		log.Printf("[BFF] Canje recompensa %s para %s — ok=%v saldo: %d", req.RewardID, req.Phone, res.GetSuccess(), res.GetPointsRemaining())
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok": res.GetSuccess(), "success": res.GetSuccess(), "message": res.GetMessage(),
+			"codigo": res.GetCode(), "expira": res.GetExpiresAt(), "points": res.GetPointsRemaining(),
+			"points_used": res.GetPointsUsed(), "recompensa": rewardDesdePB(res.GetReward()),
+		})
+		return
+	}
+	if req.Reason == "" { req.Reason = "Canje en caja" }
+	acc, err := gw.loyaltyClient.RedeemPoints(ctx, &pb_loyalty.RedeemPointsRequest{
+		Phone: req.Phone, Points: int32(req.Points), Reason: req.Reason, TenantId: tenantID(r),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("[BFF] Canje %d pts para %s — saldo: %d", req.Points, req.Phone, acc.GetPointsRemaining())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok": true, "points": acc.GetPointsRemaining(), "success": acc.GetSuccess(),
+	})
+}
+
+func (gw *Gateway) handleMigratePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	r.ParseMultipartForm(32 << 20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "campo 'file' requerido"})
+		return
+	}
+	defer file.Close()
+	tipo := r.FormValue("tipo")
+	if tipo == "" { tipo = "generic" }
+	products, customers, err := parseMigrationCSV(file, tipo)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("[BFF] Preview: %s — %d productos, %d clientes", header.Filename, len(products), len(customers))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"archivo": header.Filename, "tipo_detectado": tipo,
+		"total_productos": len(products), "total_clientes": len(customers),
+		"preview_productos": limitSlice(products, 5),
+		"preview_clientes":  limitSlice(customers, 5),
+	})
+}
+
+func (gw *Gateway) handleMigrate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	r.ParseMultipartForm(32 << 20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "campo 'file' requerido"})
+		return
+	}
+	defer file.Close()
+	tipo := r.FormValue("tipo")
+	if tipo == "" { tipo = "generic" }
+	products, customers, err := parseMigrationCSV(file, tipo)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	prodOK, prodErr := 0, 0
+	for _, p := range products {
+		price := parseFloat(p["price"])
+		_, e := gw.db.Exec(
+			`INSERT INTO products (id, name, price, sku, created_at)
+			 VALUES (gen_random_uuid(), $1, $2, $3, NOW())
+			 ON CONFLICT (sku) WHERE sku IS NOT NULL DO UPDATE SET name=EXCLUDED.name, price=EXCLUDED.price`,
+			p["name"], price, p["sku"])
+		if e != nil { log.Printf("[BFF] product insert err: %v", e); prodErr++ } else { prodOK++ }
+	}
+	custOK, custErr := 0, 0
+	for _, c := range customers {
+		if c["phone"] == "" { continue }
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		_, e := gw.loyaltyClient.CreateAccount(ctx, &pb_loyalty.CreateAccountRequest{
+			Phone: c["phone"], Name: c["name"], TenantId: tenantID(r),
+		})
+		cancel()
+		if e != nil { custErr++ } else { custOK++ }
+	}
+	log.Printf("[BFF] Migración: %s — prod OK:%d ERR:%d | clientes OK:%d ERR:%d",
+		header.Filename, prodOK, prodErr, custOK, custErr)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok": true, "archivo": header.Filename,
+		"productos_ok": prodOK, "productos_error": prodErr,
+		"clientes_ok": custOK, "clientes_error": custErr,
+	})
+}
+
+func (gw *Gateway) handleCancelar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("[BFF] PANIC en handleCancelar: %v", rec)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("panic: %v", rec)})
+		}
+	}()
+
+	var req struct {
+		UUID          string `json:"uuid"`
+		RFC           string `json:"rfc"`
+		Motivo        string `json:"motivo"`
+		UUIDReemplazo string `json:"uuid_reemplazo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "JSON invalido"})
+		return
+	}
+	if req.UUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "uuid requerido"})
+		return
+	}
+	if req.Motivo == "" { req.Motivo = "02" }
+
+	// Llamar al CFDI server via HTTP (evita proto para cancelación)
+	cfdiHTTP := getenv("CFDI_HTTP_ADDR", "http://localhost:50055")
+	body, _ := json.Marshal(req)
+	httpResp, err := http.Post(cfdiHTTP+"/cancelar", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "CFDI server no disponible: " + err.Error()})
+		return
+	}
+	defer httpResp.Body.Close()
+
+	var cfdiResult map[string]interface{}
+	json.NewDecoder(httpResp.Body).Decode(&cfdiResult)
+
+	if httpResp.StatusCode != http.StatusOK {
+		w.WriteHeader(httpResp.StatusCode)
+		json.NewEncoder(w).Encode(cfdiResult)
+		return
+	}
+
+	// Actualizar DB. Si el receptor debe aceptar, queda pendiente hasta que la conciliación la confirme
+	estatusCancelacion, _ := cfdiResult["status"].(string)
+	estado := "cancelado"
+	if strings.Contains(strings.ToLower(estatusCancelacion), "proceso") { estado = "cancelacion_pendiente" }
+	gw.db.Exec(`UPDATE sales SET cfdi_status=$1, cfdi_estatus_cancelacion=$2, cfdi_cancelacion_solicitada_at=NOW() WHERE cfdi_uuid=$3`,
+		estado, estatusCancelacion, req.UUID)
+	log.Printf("[BFF] Cancelación: uuid=%s", req.UUID)
+
+	// Motivo 03: la operación no se llevó a cabo, así que la venta también se cancela y
+	// el cliente pierde los puntos que le dio
+	if req.Motivo == "03" && estado == "cancelado" {
+		var saleID, tid, estadoVenta string
+		if gw.db.QueryRow(`SELECT id::text, COALESCE(tenant_id::text,''), COALESCE(status,'') FROM sales WHERE cfdi_uuid=$1`, req.UUID).
+			Scan(&saleID, &tid, &estadoVenta) == nil && tid != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if estadoVenta != "cancelled" {
+				if _, err := gw.salesClient.CancelSale(ctx, &pb_sales.CancelSaleRequest{SaleId: saleID, Reason: "CFDI cancelado (03)"}); err != nil {
+					log.Printf("[BFF] Cancelar venta %s: %v", saleID, err)
+				}
+			}
+			cfdiResult["lealtad"] = gw.reversarPuntos(ctx, tid, saleID, 0, "", "CFDI cancelado (03)")
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfdiResult)
+}
+
+// handleVenta — POST /api/v1/ventas/{id}/cancelar {motivo} cancela la venta;
+// POST /api/v1/ventas/{id}/devolucion {monto, motivo, devolucion_id} registra una devolución
+// parcial. En ambos casos se quitan los puntos de lealtad que dio la venta; repetir la
+// llamada (o mandar el mismo devolucion_id) no descuenta dos veces.
+func (gw *Gateway) handleVenta(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	tid := tenantID(r)
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+	if r.Method != http.MethodPost { fail(http.StatusMethodNotAllowed, "método no permitido"); return }
+	partes := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/ventas/"), "/"), "/")
+	if len(partes) != 2 || partes[0] == "" { fail(404, "ruta no encontrada"); return }
+	saleID, accion := partes[0], partes[1]
+
+	var req struct {
+		Motivo       string  `json:"motivo"`
+		Monto        float64 `json:"monto"`
+		DevolucionID string  `json:"devolucion_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF { fail(400, "JSON invalido"); return }
+	req.Motivo = strings.TrimSpace(req.Motivo)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	var total float64
+	var estado, cfdiUUID string
+	err := gw.db.QueryRowContext(ctx, `
+		SELECT total, COALESCE(status,''), COALESCE(cfdi_uuid,'') FROM sales WHERE id::text=$1 AND tenant_id=$2::uuid`, saleID, tid).
+		Scan(&total, &estado, &cfdiUUID)
+	if err == sql.ErrNoRows { fail(404, "venta no encontrada"); return }
+	if err != nil { fail(500, err.Error()); return }
+
+	switch accion {
+	case "cancelar":
+		if req.Motivo == "" { fail(400, "motivo requerido"); return }
+		// Si la venta ya estaba cancelada solo se reintenta la reversión de puntos
+		if estado != "cancelled" {
+			if _, err := gw.salesClient.CancelSale(ctx, &pb_sales.CancelSaleRequest{SaleId: saleID, Reason: req.Motivo}); err != nil {
+				fail(500, status.Convert(err).Message())
+				return
+			}
+		}
+		resp := map[string]interface{}{
+			"sale_id": saleID, "status": "cancelled", "lealtad": gw.reversarPuntos(ctx, tid, saleID, 0, "", req.Motivo),
+		}
+		// La factura se cancela aparte ante el SAT (/api/v1/cancelar, motivo 03)
+		if cfdiUUID != "" { resp["cfdi_uuid"] = cfdiUUID }
+		json.NewEncoder(w).Encode(resp)
+
+	case "devolucion":
+		if estado == "cancelled" { fail(http.StatusConflict, "la venta está cancelada"); return }
+		if req.Monto <= 0 { fail(400, "monto debe ser mayor a 0"); return }
+		tx, err := gw.db.BeginTx(ctx, nil)
+		if err != nil { fail(500, err.Error()); return }
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, `SELECT 1 FROM sales WHERE id::text=$1 FOR UPDATE`, saleID); err != nil { fail(500, err.Error()); return }
+		var devolucionID string
+		var monto float64
+		if req.DevolucionID != "" {
+			err = tx.QueryRowContext(ctx, `SELECT id::text, monto FROM devoluciones WHERE id::text=$1 AND sale_id::text=$2`,
+				req.DevolucionID, saleID).Scan(&devolucionID, &monto)
+			if err != nil && err != sql.ErrNoRows { fail(500, err.Error()); return }
+		}
+		if devolucionID == "" {
+			var devuelto float64
+			if err := tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(monto), 0) FROM devoluciones WHERE sale_id::text=$1`, saleID).Scan(&devuelto); err != nil {
+				fail(500, err.Error())
+				return
+			}
+			if devuelto+req.Monto > total+0.005 {
+				fail(400, fmt.Sprintf("la devolución excede el total de la venta: quedan $%.2f", total-devuelto))
+				return
+			}
+			err = tx.QueryRowContext(ctx, `
+				INSERT INTO devoluciones (id, tenant_id, sale_id, monto, motivo)
+				VALUES (COALESCE(NULLIF($1,'')::uuid, gen_random_uuid()), $2::uuid, $3::uuid, $4, NULLIF($5,''))
+				RETURNING id::text, monto`, req.DevolucionID, tid, saleID, req.Monto, req.Motivo).Scan(&devolucionID, &monto)
+			if err != nil { fail(400, "registrar devolución: "+err.Error()); return }
+		}
+		if err := tx.Commit(); err != nil { fail(500, err.Error()); return }
+		log.Printf("[BFF] Devolución %s: sale=%s monto=%.2f", devolucionID, saleID, monto)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"devolucion_id": devolucionID, "sale_id": saleID, "monto": monto,
+			"lealtad": gw.reversarPuntos(ctx, tid, saleID, monto, devolucionID, req.Motivo),
+		})
+
+	default:
+		fail(404, "ruta no encontrada")
+	}
+}
+
+// reversarPuntos quita los puntos que dio una venta cancelada (monto 0) o devuelta. Un
+// error no deshace la cancelación: se reporta y la caja puede reintentar, porque
+// ReversePoints no repite una referencia ya aplicada.
+func (gw *Gateway) reversarPuntos(ctx context.Context, tid, saleID string, monto float64, referencia, motivo string) map[string]interface{} {
+	res, err := gw.loyaltyClient.ReversePoints(ctx, &pb_loyalty.ReversePointsRequest{
+		TenantId: tid, SaleId: saleID, Amount: monto, Reference: referencia, Reason: motivo,
+	})
+	if err != nil {
+		log.Printf("[BFF] Loyalty reversión sale=%s: %v", saleID, err)
+		return map[string]interface{}{"error": status.Convert(err).Message()}
+	}
+	if res.GetReversed() {
+		log.Printf("[BFF] Loyalty -%dpts para %s por sale=%s — total: %d deuda: %d tier: %s",
+			res.GetPointsReversed(), res.GetPhone(), saleID, res.GetPoints(), res.GetPointsDebt(), res.GetTier())
+	}
+	return map[string]interface{}{
+		"reversed": res.GetReversed(), "message": res.GetMessage(), "phone": res.GetPhone(),
+		"points_reversed": res.GetPointsReversed(), "spent_reversed": res.GetSpentReversed(),
+		"points": res.GetPoints(), "points_debt": res.GetPointsDebt(), "tier": res.GetTier(), "tier_name": res.GetTierName(),
+	}
+}
+
+func (gw *Gateway) handleTimbrar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	var req struct {
+		SaleID  string  `json:"sale_id"`
+		RFC     string  `json:"rfc"`
+		Total   float64 `json:"total"`
+		CP      string  `json:"cp"`
+		Nombre  string  `json:"nombre"`
+		Regimen string  `json:"regimen"`
+		Uso     string  `json:"uso"`
+		Sucursal string `json:"sucursal"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "JSON invalido"})
+		return
+	}
+	if req.RFC == "" { req.RFC = "XAXX010101000" }
+	var sucursalVenta, moneda, tipoCambio string
+	gw.db.QueryRowContext(r.Context(), `SELECT COALESCE(sucursal,''), moneda, tipo_cambio::text FROM sales WHERE id=$1::uuid`, req.SaleID).
+		Scan(&sucursalVenta, &moneda, &tipoCambio)
+	if req.Sucursal == "" { req.Sucursal = sucursalVenta }
+	receptor := gw.completarReceptor(r.Context(), tenantID(r), receptorFiscal{
+		RFC: strings.ToUpper(strings.TrimSpace(req.RFC)), Nombre: strings.ToUpper(strings.TrimSpace(req.Nombre)),
+		Regimen: req.Regimen, Uso: req.Uso, CP: req.CP,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	treq := &pb_cfdi.FacturaRequest{
+		VentaId: req.SaleID, Total: req.Total, Rfc: receptor.RFC, CodigoPostalReceptor: receptor.CP,
+		NombreReceptor: receptor.Nombre, RegimenFiscalReceptor: receptor.Regimen, UsoCfdi: receptor.Uso,
+		Moneda: moneda, TipoCambio: tipoCambio,
+	}
+	res, err := gw.timbrarVenta(ctx, tenantID(r), strings.TrimSpace(req.Sucursal), treq)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	gw.db.ExecContext(ctx,
+		`UPDATE sales SET cfdi_uuid=$1, cfdi_status=$2, cfdi_rfc_receptor=$3, cfdi_nombre_receptor=$4, cfdi_xml=$5, cfdi_timbrado_at=NOW() WHERE id=$6::uuid`,
+		res.GetUuid(), "timbrado", receptor.RFC, receptor.Nombre, res.GetXml(), req.SaleID)
+	log.Printf("[BFF] Timbrado: sale=%s uuid=%s", req.SaleID, res.GetUuid())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sale_id": req.SaleID, "cfdi_uuid": res.GetUuid(),
+		"sello_sat": res.GetSelloSat(), "status": res.GetStatus(),
+		"pac_usado": res.GetPacUsado(), "timestamp": res.GetTimestamp(),
+		"serie": treq.GetSerie(), "folio": treq.GetFolio(),
+	})
+}
+
+func (gw *Gateway) handleStatus(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	authOK := "OK"
+	if _, err := gw.authClient.Ping(ctx, &pb_auth.PingRequest{Message: "health"}); err != nil {
+		authOK = "ERROR: " + err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"system": "ONLINE",
+		"services": map[string]string{"auth": authOK, "sales": "OK", "cfdi": "OK", "loyalty": "OK"},
+	})
+}
+
+func (gw *Gateway) handleLogs(w http.ResponseWriter, r *http.Request) {
+	rows, err := gw.db.Query(
+		`SELECT id, total, payment_method, status, cfdi_uuid, created_at
+		 FROM sales ORDER BY created_at DESC LIMIT 15`)
+	if err != nil { w.WriteHeader(http.StatusInternalServerError); return }
+	defer rows.Close()
+	var logs []map[string]interface{}
+	for rows.Next() {
+		var id, method, status, cat string
+		var total float64
+		var cfdiUUID sql.NullString
+		rows.Scan(&id, &total, &method, &status, &cfdiUUID, &cat)
+		entry := map[string]interface{}{
+			"sale_id": id, "total": total, "method": method, "status": status, "time": cat,
+		}
+		if cfdiUUID.Valid { entry["cfdi_uuid"] = cfdiUUID.String }
+		logs = append(logs, entry)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logs)
+}
+
+func (gw *Gateway) handleCorte(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	fecha := r.URL.Query().Get("fecha")
+	if fecha == "" { fecha = time.Now().Format("2006-01-02") }
+	tid := tenantID(r)
+	w.Header().Set("Content-Type", "application/json")
+
+	// ── Por método de pago ───────────────────────────────────────────────────────
+	rows, err := gw.db.Query(`
+		SELECT COUNT(*), COALESCE(SUM(total),0),
+			COALESCE(SUM(CASE WHEN status='cancelled' THEN 1 ELSE 0 END),0),
+			COALESCE(SUM(CASE WHEN status='completed' THEN total ELSE 0 END),0),
+			COALESCE(SUM(CASE WHEN cfdi_uuid IS NOT NULL THEN 1 ELSE 0 END),0),
+			payment_method
+		FROM sales
+		WHERE DATE(created_at AT TIME ZONE 'America/Monterrey') = $1::date
+		  AND (tenant_id = $2::uuid OR tenant_id IS NULL)
+		GROUP BY payment_method`, fecha, tid)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	type M struct {
+		Metodo        string  `json:"metodo"`
+		Transacciones int64   `json:"transacciones"`
+		TotalVendido  float64 `json:"total_vendido"`
+		Canceladas    int64   `json:"canceladas"`
+		Neto          float64 `json:"neto"`
+		Timbradas     int64   `json:"timbradas"`
+	}
+	var metodos []M
+	var totalTx, totalCanceladas, totalTimbradas int64
+	var totalVendido, totalNeto float64
+	for rows.Next() {
+		var m M
+		rows.Scan(&m.Transacciones, &m.TotalVendido, &m.Canceladas, &m.Neto, &m.Timbradas, &m.Metodo)
+		metodos = append(metodos, m)
+		totalTx += m.Transacciones; totalVendido += m.TotalVendido
+		totalCanceladas += m.Canceladas; totalNeto += m.Neto; totalTimbradas += m.Timbradas
+	}
+
+	// ── Ventas por hora ──────────────────────────────────────────────────────────
+	rowsHora, _ := gw.db.Query(`
+		SELECT EXTRACT(HOUR FROM created_at AT TIME ZONE 'America/Monterrey')::int as hora,
+		       COUNT(*) as tx,
+		       COALESCE(SUM(CASE WHEN status='completed' THEN total ELSE 0 END),0) as total
+		FROM sales
+		WHERE DATE(created_at AT TIME ZONE 'America/Monterrey') = $1::date
+		  AND (tenant_id = $2::uuid OR tenant_id IS NULL)
+		GROUP BY hora ORDER BY hora ASC`, fecha, tid)
+	type HoraData struct {
+		Hora  int     `json:"hora"`
+		Tx    int64   `json:"tx"`
+		Total float64 `json:"total"`
+	}
+	var porHora []HoraData
+	if rowsHora != nil {
+		defer rowsHora.Close()
+		for rowsHora.Next() {
+			var h HoraData
+			rowsHora.Scan(&h.Hora, &h.Tx, &h.Total)
+			porHora = append(porHora, h)
+		}
+	}
+
+	// ── Top productos del día ────────────────────────────────────────────────────
+	rowsProd, _ := gw.db.Query(`
+		SELECT si.name, SUM(si.quantity) as uds, SUM(si.subtotal) as total
+		FROM sale_items si
 		JOIN sales s ON s.id = si.sale_id
 		WHERE s.status = 'completed'
 		  AND DATE(s.created_at AT TIME ZONE 'America/Monterrey') = $1::date
@@ -1689,782 +3505,2521 @@ func (gw *Gateway) handleCorte(w http.ResponseWriter, r *http.Request) {
 		Uds    int64   `json:"uds"`
 		Total  float64 `json:"total"`
 	}
-	var topProds []ProdData
-	if rowsProd != nil {
-		defer rowsProd.Close()
-		for rowsProd.Next() {
-			var p ProdData
-			rowsProd.Scan(&p.Nombre, &p.Uds, &p.Total)
-			topProds = append(topProds, p)
-		}
+	var topProds []ProdData
+	if rowsProd != nil {
+		defer rowsProd.Close()
+		for rowsProd.Next() {
+			var p ProdData
+			rowsProd.Scan(&p.Nombre, &p.Uds, &p.Total)
+			topProds = append(topProds, p)
+		}
+	}
+
+	// ── Abonos a crédito (fiado) ─────────────────────────────────────────────────
+	// Entran a caja aunque no son ventas del día. Las ventas a crédito ya salen en
+	// por_metodo como "credit" y no son dinero cobrado.
+	rowsAb, _ := gw.db.Query(`
+		SELECT metodo_pago, COUNT(*), COALESCE(SUM(monto),0)
+		FROM credito_movimientos
+		WHERE tipo = 'abono' AND tenant_id = $2::uuid
+		  AND DATE(created_at AT TIME ZONE 'America/Monterrey') = $1::date
+		GROUP BY metodo_pago ORDER BY metodo_pago`, fecha, tid)
+	type AbonoData struct {
+		Metodo string  `json:"metodo"`
+		Abonos int64   `json:"abonos"`
+		Total  float64 `json:"total"`
+	}
+	abonos := []AbonoData{}
+	var totalAbonos float64
+	if rowsAb != nil {
+		defer rowsAb.Close()
+		for rowsAb.Next() {
+			var a AbonoData
+			rowsAb.Scan(&a.Metodo, &a.Abonos, &a.Total)
+			abonos = append(abonos, a)
+			totalAbonos += a.Total
+		}
+	}
+	totalCobrado := totalNeto + totalAbonos
+	for _, m := range metodos {
+		if m.Metodo == "credit" { totalCobrado -= m.Neto }
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"fecha": fecha, "total_transacciones": totalTx,
+		"total_vendido": totalVendido, "total_canceladas": totalCanceladas,
+		"total_neto": totalNeto, "total_timbradas": totalTimbradas,
+		"por_metodo": metodos, "por_hora": porHora, "top_prods": topProds,
+		"abonos_credito": abonos, "total_abonos_credito": totalAbonos, "total_cobrado": totalCobrado,
+		"generado_at": time.Now().Format(time.RFC3339),
+	})
+}
+
+// ─── CRUD Productos ───────────────────────────────────────────────────────────
+// GET  /api/v1/products         — listar productos del tenant
+// POST /api/v1/products         — crear producto
+// PUT  /api/v1/products/{id}    — actualizar producto
+// DELETE /api/v1/products/{id}  — eliminar producto
+
+func (gw *Gateway) handleProducts(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    tid := tenantID(r)
+
+    switch r.Method {
+    case http.MethodGet:
+        rows, err := gw.db.Query(`
+            SELECT id, COALESCE(name,''), COALESCE(price,0),
+                   COALESCE(sku,''), COALESCE(category,''), COALESCE(unit,'pza'),
+                   COALESCE(barcode,''), active, COALESCE(image_url,''), COALESCE(perfil_retencion,'')
+            FROM products
+            WHERE (tenant_id = $1::uuid OR tenant_id IS NULL)
+              AND COALESCE(active, true) = true
+            ORDER BY name`, tid)
+        if err != nil {
+            w.WriteHeader(http.StatusInternalServerError)
+            json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+            return
+        }
+        defer rows.Close()
+        var prods []map[string]interface{}
+        for rows.Next() {
+            var id, name, sku, category, unit, barcode, imageURL, perfil string
+            var price float64
+            var active bool
+            rows.Scan(&id, &name, &price, &sku, &category, &unit, &barcode, &active, &imageURL, &perfil)
+            prods = append(prods, map[string]interface{}{
+                "id": id, "name": name, "price": price,
+                "sku": sku, "category": category, "unit": unit,
+                "barcode": barcode, "active": active, "image_url": imageURL,
+                "perfil_retencion": perfil,
+            })
+        }
+        if prods == nil { prods = []map[string]interface{}{} }
+        json.NewEncoder(w).Encode(prods)
+
+    case http.MethodPost:
+        var req struct {
+            Name     string  `json:"name"`
+            Price    float64 `json:"price"`
+            SKU      string  `json:"sku"`
+            Category string  `json:"category"`
+            Unit     string  `json:"unit"`
+            Barcode  string  `json:"barcode"`
+            ImageURL string  `json:"image_url"`
+            PerfilRetencion string `json:"perfil_retencion"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            w.WriteHeader(http.StatusBadRequest)
+            json.NewEncoder(w).Encode(map[string]string{"error": "JSON invalido"})
+            return
+        }
+        if req.Name == "" || req.Price <= 0 {
+            w.WriteHeader(http.StatusBadRequest)
+            json.NewEncoder(w).Encode(map[string]string{"error": "name y price requeridos"})
+            return
+        }
+        if _, ok := perfilesRetencion[req.PerfilRetencion]; req.PerfilRetencion != "" && !ok {
+            w.WriteHeader(http.StatusBadRequest)
+            json.NewEncoder(w).Encode(map[string]string{"error": "perfil de retención desconocido"})
+            return
+        }
+        if req.Unit == "" { req.Unit = "pza" }
+        if req.Category == "" { req.Category = "General" }
+        if req.SKU == "" { req.SKU = fmt.Sprintf("PROD-%d", time.Now().UnixMilli()) }
+
+        var id string
+        err := gw.db.QueryRow(`
+            INSERT INTO products (id, name, price, sku, category, unit, barcode, image_url, tenant_id, active, created_at, perfil_retencion)
+            VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7, $8::uuid, true, NOW(), NULLIF($9,''))
+            ON CONFLICT (sku) WHERE sku IS NOT NULL
+            DO UPDATE SET name=EXCLUDED.name, price=EXCLUDED.price,
+                          category=EXCLUDED.category, unit=EXCLUDED.unit, image_url=EXCLUDED.image_url,
+                          perfil_retencion=EXCLUDED.perfil_retencion
+            RETURNING id`,
+            req.Name, req.Price, req.SKU, req.Category, req.Unit, req.Barcode, req.ImageURL, tid, req.PerfilRetencion,
+        ).Scan(&id)
+        if err != nil {
+            w.WriteHeader(http.StatusInternalServerError)
+            json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+            return
+        }
+        log.Printf("[BFF] Producto creado: %s ? %s $%.2f", id, req.Name, req.Price)
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "id": id, "name": req.Name, "price": req.Price, "sku": req.SKU,
+        })
+
+    default:
+        w.WriteHeader(http.StatusMethodNotAllowed)
+    }
+}
+func (gw *Gateway) handleProductByID(w http.ResponseWriter, r *http.Request) {
+	// Extraer ID del path: /api/v1/products/UUID
+	productID := strings.TrimPrefix(r.URL.Path, "/api/v1/products/")
+	if productID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "id requerido"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var req struct {
+			Name     string  `json:"name"`
+			Price    float64 `json:"price"`
+			SKU      string  `json:"sku"`
+			Category string  `json:"category"`
+			Unit     string  `json:"unit"`
+			Barcode  string  `json:"barcode"`
+			Active   *bool   `json:"active"`
+			ImageURL string  `json:"image_url"`
+			// nil conserva el perfil actual; "" lo quita
+			PerfilRetencion *string `json:"perfil_retencion"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "JSON invalido"})
+			return
+		}
+		active := true
+		if req.Active != nil { active = *req.Active }
+		var perfil sql.NullString
+		if req.PerfilRetencion != nil {
+			if _, ok := perfilesRetencion[*req.PerfilRetencion]; *req.PerfilRetencion != "" && !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "perfil de retención desconocido"})
+				return
+			}
+			perfil = sql.NullString{String: *req.PerfilRetencion, Valid: true}
+		}
+
+		res, err := gw.db.Exec(`
+			UPDATE products SET
+				name      = COALESCE(NULLIF($1,''), name),
+				price     = CASE WHEN $2 > 0 THEN $2 ELSE price END,
+				sku       = COALESCE(NULLIF($3,''), sku),
+				category  = COALESCE(NULLIF($4,''), category),
+				unit      = COALESCE(NULLIF($5,''), unit),
+				barcode   = COALESCE(NULLIF($6,''), barcode),
+				image_url = CASE WHEN $7 != '' THEN $7 ELSE image_url END,
+				active    = $8,
+				perfil_retencion = CASE WHEN $10::text IS NULL THEN perfil_retencion ELSE NULLIF($10,'') END
+			WHERE id = $9::uuid`,
+			req.Name, req.Price, req.SKU, req.Category, req.Unit, req.Barcode, req.ImageURL, active, productID, perfil)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "producto no encontrado"})
+			return
+		}
+		log.Printf("[BFF] Producto actualizado: %s — %s $%.2f", productID, req.Name, req.Price)
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": productID})
+
+	case http.MethodDelete:
+		// Soft delete — marcar inactive
+		res, err := gw.db.Exec(`UPDATE products SET active=false WHERE id=$1::uuid`, productID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "producto no encontrado"})
+			return
+		}
+		log.Printf("[BFF] Producto eliminado (soft): %s", productID)
+		json.NewEncoder(w).Encode(map[string]string{"status": "eliminado", "id": productID})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func parseMigrationCSV(r io.Reader, tipo string) (products, customers []map[string]string, err error) {
+	reader := csv.NewReader(r)
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil { return nil, nil, err }
+	if len(rows) < 2 { return nil, nil, nil }
+
+	headers := make([]string, len(rows[0]))
+	for i, h := range rows[0] { headers[i] = strings.ToLower(strings.TrimSpace(h)) }
+
+	headersStr := strings.Join(headers, ",")
+	if tipo == "generic" {
+		switch {
+		case strings.Contains(headersStr, "clave") && strings.Contains(headersStr, "existencia"):
+			tipo = "aspel_pos"
+		case strings.Contains(headersStr, "codigo_producto"):
+			tipo = "bind"
+		case strings.Contains(headersStr, "cvenumero"):
+			tipo = "contpaq"
+		}
+	}
+
+	colMap := map[string]map[string][]string{
+		"aspel_pos": {
+			"sku":   {"clave", "codigo", "cve"},
+			"name":  {"descripcion", "nombre", "producto"},
+			"price": {"precio", "precio1", "pventa"},
+			"phone": {"telefono", "tel", "celular"},
+			"cname": {"nombre", "razon_social", "cliente"},
+		},
+		"bind": {
+			"sku":   {"codigo_producto", "sku", "codigo"},
+			"name":  {"nombre_producto", "nombre", "descripcion"},
+			"price": {"precio_venta", "precio"},
+			"phone": {"telefono", "celular"},
+			"cname": {"nombre_cliente", "nombre"},
+		},
+		"contpaq": {
+			"sku":   {"cvenumero", "sku", "clave"},
+			"name":  {"cvenom", "nombre", "descripcion"},
+			"price": {"cveprecio", "precio"},
+			"phone": {"telefono", "tel"},
+			"cname": {"cvenom", "nombre"},
+		},
+		"generic": {
+			"sku":   {"sku", "codigo", "clave", "code"},
+			"name":  {"nombre", "name", "descripcion", "producto"},
+			"price": {"precio", "price", "precio_venta"},
+			"phone": {"telefono", "phone", "celular"},
+			"cname": {"nombre", "name", "cliente"},
+		},
+	}
+
+	cm, ok := colMap[tipo]
+	if !ok { cm = colMap["generic"] }
+
+	idx := func(variants []string) int {
+		for _, v := range variants {
+			for i, h := range headers {
+				if strings.Contains(h, v) { return i }
+			}
+		}
+		return -1
+	}
+
+	iSku := idx(cm["sku"]); iName := idx(cm["name"]); iPrice := idx(cm["price"])
+	iPhone := idx(cm["phone"]); iCname := idx(cm["cname"])
+
+	for _, row := range rows[1:] {
+		if len(row) == 0 { continue }
+		get := func(i int) string {
+			if i < 0 || i >= len(row) { return "" }
+			return strings.TrimSpace(row[i])
+		}
+		if iName >= 0 && get(iName) != "" {
+			sku := get(iSku)
+			if sku == "" { sku = get(iName) }
+			price := strings.ReplaceAll(strings.ReplaceAll(get(iPrice), "$", ""), ",", "")
+			products = append(products, map[string]string{"sku": sku, "name": get(iName), "price": price})
+		}
+		if iPhone >= 0 && get(iPhone) != "" {
+			phone := strings.ReplaceAll(strings.ReplaceAll(get(iPhone), " ", ""), "-", "")
+			if len(phone) >= 10 {
+				customers = append(customers, map[string]string{"phone": phone, "name": get(iCname)})
+			}
+		}
+	}
+	return products, customers, nil
+}
+
+// ─── JWT ─────────────────────────────────────────────────────────────────────
+// Los tokens los emite y valida el AuthService; el BFF no conoce el secreto.
+func (gw *Gateway) validarToken(ctx context.Context, token string) (*pb_auth.TokenClaims, error) {
+	if token == "" { return nil, fmt.Errorf("token requerido") }
+	claims, err := gw.authClient.ValidateToken(ctx, &pb_auth.ValidateTokenRequest{Token: token})
+	if err != nil { return nil, errors.New(status.Convert(err).Message()) }
+	return claims, nil
+}
+
+// errorAuth traduce los errores del AuthService a HTTP
+func errorAuth(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	code := http.StatusInternalServerError
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		code = http.StatusBadRequest
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+	case codes.AlreadyExists:
+		code = http.StatusConflict
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.Unavailable:
+		code = http.StatusBadGateway
+	}
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": status.Convert(err).Message()})
+}
+
+// jwtMiddleware valida el token en todas las rutas excepto /api/v1/login y /
+func (gw *Gateway) handlePushRegister(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions { w.WriteHeader(http.StatusOK); return }
+	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	var body struct {
+		Token    string `json:"token"`
+		TenantID string `json:"tenant_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "token requerido"})
+		return
+	}
+	// Guardar token en DB
+	_, err := gw.db.Exec(`INSERT INTO push_tokens (tenant_id, token, created_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (token) DO UPDATE SET updated_at = NOW()`,
+		body.TenantID, body.Token)
+	if err != nil {
+		log.Printf("[Push] Error guardando token: %v", err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+func (gw *Gateway) handleConfigNegocio(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions { w.WriteHeader(http.StatusOK); return }
+	// Solo acepta POST, guarda en memoria por ahora
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+func (gw *Gateway) sendPushNotification(tenantID, title, body string) {
+	rows, err := gw.db.Query(`SELECT token FROM push_tokens WHERE tenant_id=$1`, tenantID)
+	if err != nil { log.Printf("[Push] DB error: %v", err); return }
+	defer rows.Close()
+	var tokens []string
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err == nil { tokens = append(tokens, token) }
+	}
+	if len(tokens) == 0 { return }
+	go func() {
+		ctx := context.Background()
+		app, err := firebase.NewApp(ctx, nil, option.WithCredentialsFile("secrets/firebase-sa.json"))
+		if err != nil { log.Printf("[Push] Firebase init error: %v", err); return }
+		client, err := app.Messaging(ctx)
+		if err != nil { log.Printf("[Push] Messaging error: %v", err); return }
+		for _, token := range tokens {
+			msg := &messaging.Message{
+				Token: token,
+				Notification: &messaging.Notification{Title: title, Body: body},
+				Android: &messaging.AndroidConfig{Priority: "high"},
+				APNS: &messaging.APNSConfig{
+					Payload: &messaging.APNSPayload{
+						Aps: &messaging.Aps{Sound: "default"},
+					},
+				},
+			}
+			_, err := client.Send(ctx, msg)
+			if err != nil {
+				log.Printf("[Push] Send error token=%s... %v", token[:15], err)
+			} else {
+				log.Printf("[Push] OK enviado a %s... titulo: %s", token[:15], title)
+			}
+		}
+	}()
+}
+
+func (gw *Gateway) jwtMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Rutas públicas
+		if r.URL.Path == "/" || r.URL.Path == "/index.html" ||
+			r.URL.Path == "/manifest.json" || r.URL.Path == "/firebase-messaging-sw.js" ||
+			r.URL.Path == "/icon-192.png" || r.URL.Path == "/icon-512.png" ||
+			r.URL.Path == "/api/v1/push/register" ||
+			strings.HasPrefix(r.URL.Path, "/factura/") || strings.HasPrefix(r.URL.Path, "/api/v1/autofactura/") ||
+			strings.HasPrefix(r.URL.Path, "/lealtad/") || strings.HasPrefix(r.URL.Path, "/api/v1/portal-lealtad/") ||
+			r.URL.Path == "/api/v1/login" || r.URL.Path == "/api/v1/status" ||
+			r.URL.Path == "/api/v1/refresh" || r.URL.Path == "/api/v1/logout" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Preflight CORS
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Extraer token
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "token requerido"})
+			return
+		}
+		claims, err := gw.validarToken(r.Context(), strings.TrimPrefix(auth, "Bearer "))
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		// Inyectar tenant del token si no viene header explícito
+		if r.Header.Get("X-Tenant-ID") == "" && claims.TenantId != "" {
+			r.Header.Set("X-Tenant-ID", claims.TenantId)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// POST /api/v1/login — autentica usuario y devuelve JWT
+func (gw *Gateway) handleLogin(w http.ResponseWriter, r *http.Request) {
+	// Rate limiting
+	ip := clientIP(r)
+	if !checkRateLimit(ip) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Demasiados intentos. Espera 15 minutos."})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	// Rate limiting: max 5 intentos por IP en 5 minutos
+	loginAttempts.Lock()
+	now := time.Now()
+	attempts := loginAttempts.counts[ip]
+	var recent []time.Time
+	for _, t := range attempts { if now.Sub(t) < 5*time.Minute { recent = append(recent, t) } }
+	loginAttempts.counts[ip] = recent
+	loginAttempts.Unlock()
+	if len(recent) >= 5 {
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Demasiados intentos. Espera 5 minutos."})
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "JSON inválido"})
+		return
+	}
+	if req.Username == "" || req.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "username y password requeridos"})
+		return
+	}
+
+	resp, err := gw.authClient.Login(r.Context(), &pb_auth.LoginRequest{
+		Username: req.Username, Password: req.Password, UserAgent: r.UserAgent(), Ip: clientIP(r),
+	})
+	if err != nil {
+		errorAuth(w, err)
+		return
+	}
+	log.Printf("[BFF] Login: user=%s role=%s tenant=%s", resp.Username, resp.Role, resp.TenantId)
+	json.NewEncoder(w).Encode(tokensJSON(resp))
+}
+
+func tokensJSON(t *pb_auth.AuthTokens) map[string]interface{} {
+	return map[string]interface{}{
+		"token":         t.AccessToken,
+		"refresh_token": t.RefreshToken,
+		"user":          t.Username,
+		"role":          t.Role,
+		"tenant_id":     t.TenantId,
+		"expires":       t.ExpiresAt,
+		"session_id":    t.SessionId,
+	}
+}
+
+// POST /api/v1/refresh {refresh_token} — JWT y refresh token nuevos para una sesión
+// abierta. El refresh token enviado ya no sirve después.
+func (gw *Gateway) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	var req struct { RefreshToken string `json:"refresh_token"` }
+	json.NewDecoder(r.Body).Decode(&req)
+	resp, err := gw.authClient.Refresh(r.Context(), &pb_auth.RefreshRequest{
+		RefreshToken: req.RefreshToken, UserAgent: r.UserAgent(), Ip: clientIP(r),
+	})
+	if err != nil {
+		errorAuth(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(tokensJSON(resp))
+}
+
+// POST /api/v1/logout {refresh_token} — cierra la sesión
+func (gw *Gateway) handleLogout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	var req struct { RefreshToken string `json:"refresh_token"` }
+	json.NewDecoder(r.Body).Decode(&req)
+	if _, err := gw.authClient.Logout(r.Context(), &pb_auth.LogoutRequest{RefreshToken: req.RefreshToken}); err != nil {
+		errorAuth(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+}
+
+// handleSesiones administra las sesiones abiertas del negocio.
+//   GET    /api/v1/sesiones                — sesiones activas con dispositivo e IP
+//   DELETE /api/v1/sesiones/{id}           — cierra una sesión
+//   POST   /api/v1/sesiones/cerrar-todas   — {username?} cierra todas las del usuario (propias si va vacío)
+func (gw *Gateway) handleSesiones(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions { w.WriteHeader(http.StatusOK); return }
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	resto := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/sesiones"), "/")
+
+	switch {
+	case resto == "" && r.Method == http.MethodGet:
+		resp, err := gw.authClient.ListSessions(r.Context(), &pb_auth.ListSessionsRequest{AccessToken: token})
+		if err != nil { errorAuth(w, err); return }
+		sesiones := make([]map[string]interface{}, 0, len(resp.Sessions))
+		for _, ses := range resp.Sessions {
+			sesiones = append(sesiones, map[string]interface{}{
+				"id": ses.Id, "usuario": ses.Username, "dispositivo": ses.Device, "user_agent": ses.UserAgent,
+				"ip": ses.Ip, "inicio": ses.CreatedAt, "ultimo_uso": ses.LastUsedAt, "vence": ses.ExpiresAt,
+				"actual": ses.Current,
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"sesiones": sesiones})
+
+	case resto == "cerrar-todas" && r.Method == http.MethodPost:
+		var req struct { Username string `json:"username"` }
+		json.NewDecoder(r.Body).Decode(&req)
+		// Antes de cerrar: si son las propias, el token deja de valer
+		quien := gw.usuarioJWT(r)
+		resp, err := gw.authClient.LogoutAll(r.Context(), &pb_auth.LogoutAllRequest{AccessToken: token, Username: req.Username})
+		if err != nil { errorAuth(w, err); return }
+		if req.Username == "" { req.Username = quien }
+		log.Printf("[BFF] %d sesiones cerradas de %s por %s", resp.Revoked, req.Username, quien)
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "cerradas": resp.Revoked})
+
+	case resto != "" && !strings.Contains(resto, "/") && r.Method == http.MethodDelete:
+		if _, err := gw.authClient.RevokeSession(r.Context(), &pb_auth.RevokeSessionRequest{AccessToken: token, SessionId: resto}); err != nil {
+			errorAuth(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// ─── Tenant middleware ────────────────────────────────────────────────────────
+// tenantIDFromRequest extrae el tenant_id del header X-Tenant-ID
+// Si no viene, usa el tenant demo por defecto
+const defaultTenantID = "00000000-0000-0000-0000-000000000001"
+
+func tenantID(r *http.Request) string {
+	if t := r.Header.Get("X-Tenant-ID"); t != "" {
+		return t
+	}
+	return defaultTenantID
+}
+
+// tenantInfo contiene los datos del tenant para CFDI. La llave del CSD no está aquí:
+// vive cifrada en tenant_csds y solo el CFDI service la descifra.
+type tenantInfo struct {
+	ID            string
+	RFC           string
+	RazonSocial   string
+	RegimenFiscal string
+	CodigoPostal  string
+	CertDERb64    string
+}
+
+func (gw *Gateway) getTenant(r *http.Request) (*tenantInfo, error) {
+	tid := tenantID(r)
+	row := gw.db.QueryRow(`
+		SELECT id, rfc, razon_social, regimen_fiscal, codigo_postal, COALESCE(cert_der_b64,'')
+		FROM tenants WHERE id=$1 AND active=true`, tid)
+	t := &tenantInfo{}
+	err := row.Scan(&t.ID, &t.RFC, &t.RazonSocial, &t.RegimenFiscal, &t.CodigoPostal, &t.CertDERb64)
+	if err != nil {
+		return nil, fmt.Errorf("tenant no encontrado: %v", err)
+	}
+	return t, nil
+}
+
+
+func (gw *Gateway) handleReportes(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    q := r.URL.Query()
+    periodo := q.Get("periodo")
+    desde := q.Get("desde")
+    hasta := q.Get("hasta")
+    now := time.Now()
+    switch periodo {
+    case "semana": desde = now.AddDate(0,0,-6).Format("2006-01-02"); hasta = now.Format("2006-01-02")
+    case "mes":    desde = now.AddDate(0,-1,0).Format("2006-01-02"); hasta = now.Format("2006-01-02")
+    case "año":   desde = now.AddDate(-1,0,0).Format("2006-01-02"); hasta = now.Format("2006-01-02")
+    default:       if desde=="" { desde=now.AddDate(0,0,-6).Format("2006-01-02") }; if hasta=="" { hasta=now.Format("2006-01-02") }
+    }
+    tid := tenantID(r)
+    cliente := q.Get("cliente") // ?cliente= id de la cuenta de lealtad
+    // Ventas por día
+    rowsDia, err := gw.db.Query(`SELECT DATE(created_at AT TIME ZONE 'America/Monterrey') as dia, COUNT(*) as tx, COALESCE(SUM(CASE WHEN status='completed' THEN total * tipo_cambio ELSE 0 END),0) as total, COALESCE(SUM(CASE WHEN status='cancelled' THEN 1 ELSE 0 END),0) as canceladas, COALESCE(SUM(CASE WHEN cfdi_uuid IS NOT NULL THEN 1 ELSE 0 END),0) as timbradas FROM sales WHERE DATE(created_at AT TIME ZONE 'America/Monterrey') BETWEEN $1::date AND $2::date AND (tenant_id=$3::uuid OR tenant_id IS NULL) AND ($4 = '' OR customer_id::text = $4) GROUP BY dia ORDER BY dia ASC`, desde, hasta, tid, cliente)
+    if err != nil { w.WriteHeader(500); json.NewEncoder(w).Encode(map[string]string{"error":err.Error()}); return }
+    defer rowsDia.Close()
+    type DiaData struct { Dia string `json:"dia"`; Transacciones int64 `json:"transacciones"`; Total float64 `json:"total"`; Canceladas int64 `json:"canceladas"`; Timbradas int64 `json:"timbradas"` }
+    var porDia []DiaData
+    for rowsDia.Next() { var d DiaData; rowsDia.Scan(&d.Dia,&d.Transacciones,&d.Total,&d.Canceladas,&d.Timbradas); porDia=append(porDia,d) }
+    // Top productos
+    rowsProd, _ := gw.db.Query(`SELECT si.name, SUM(si.quantity) as uds, SUM(si.subtotal * s.tipo_cambio) as total FROM sale_items si JOIN sales s ON s.id=si.sale_id WHERE s.status='completed' AND DATE(s.created_at AT TIME ZONE 'America/Monterrey') BETWEEN $1::date AND $2::date AND (s.tenant_id=$3::uuid OR s.tenant_id IS NULL) AND ($4 = '' OR s.customer_id::text = $4) GROUP BY si.name ORDER BY total DESC LIMIT 10`, desde, hasta, tid, cliente)
+    type ProdData struct { Nombre string `json:"nombre"`; Unidades int64 `json:"unidades"`; Total float64 `json:"total"` }
+    var topProds []ProdData
+    if rowsProd!=nil { defer rowsProd.Close(); for rowsProd.Next() { var p ProdData; rowsProd.Scan(&p.Nombre,&p.Unidades,&p.Total); topProds=append(topProds,p) } }
+    // Métodos de pago
+    rowsMet, _ := gw.db.Query(`SELECT payment_method, COUNT(*) as tx, COALESCE(SUM(CASE WHEN status='completed' THEN total * tipo_cambio ELSE 0 END),0) as total FROM sales WHERE DATE(created_at AT TIME ZONE 'America/Monterrey') BETWEEN $1::date AND $2::date AND (tenant_id=$3::uuid OR tenant_id IS NULL) AND ($4 = '' OR customer_id::text = $4) GROUP BY payment_method`, desde, hasta, tid, cliente)
+    type MetData struct { Metodo string `json:"metodo"`; Transacciones int64 `json:"transacciones"`; Total float64 `json:"total"` }
+    var metodos []MetData; var grandTotal float64; var grandTx int64
+    if rowsMet!=nil { defer rowsMet.Close(); for rowsMet.Next() { var m MetData; rowsMet.Scan(&m.Metodo,&m.Transacciones,&m.Total); metodos=append(metodos,m); grandTotal+=m.Total; grandTx+=m.Transacciones } }
+    json.NewEncoder(w).Encode(map[string]interface{}{"desde":desde,"hasta":hasta,"cliente":cliente,"por_dia":porDia,"top_prods":topProds,"metodos":metodos,"total":grandTotal,"transacciones":grandTx,"moneda":"MXN"})
+}
+
+func limitSlice(s []map[string]string, n int) []map[string]string {
+	if len(s) <= n { return s }
+	return s[:n]
+}
+
+func parseFloat(s string) float64 {
+	s = strings.ReplaceAll(strings.ReplaceAll(strings.TrimSpace(s), "$", ""), ",", "")
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
+
+// ordenReporteCFDI traduce ?orden= a un ORDER BY fijo (nunca se interpola la entrada).
+// Los folios se ordenan por longitud y luego por texto para que 10 vaya después de 9.
+func ordenReporteCFDI(orden string) string {
+	switch orden {
+	case "serie", "folio":
+		return "s.cfdi_serie NULLS LAST, LENGTH(s.cfdi_folio), s.cfdi_folio, s.created_at"
+	case "-serie", "-folio":
+		return "s.cfdi_serie DESC NULLS LAST, LENGTH(s.cfdi_folio) DESC, s.cfdi_folio DESC, s.created_at DESC"
+	case "fecha":
+		return "s.created_at"
+	default:
+		return "s.created_at DESC"
+	}
+}
+
+func (gw *Gateway) handleReportesCFDI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	q := r.URL.Query()
+	desde := q.Get("desde")
+	hasta := q.Get("hasta")
+	periodo := q.Get("periodo")
+	now := time.Now()
+	switch periodo {
+	case "semana": desde = now.AddDate(0,0,-6).Format("2006-01-02"); hasta = now.Format("2006-01-02")
+	case "mes":    desde = now.AddDate(0,-1,0).Format("2006-01-02"); hasta = now.Format("2006-01-02")
+	default:       if desde == "" { desde = now.AddDate(0,0,-6).Format("2006-01-02") }; if hasta == "" { hasta = now.Format("2006-01-02") }
+	}
+	tid := tenantID(r)
+	rows, err := gw.db.QueryContext(r.Context(), `
+		SELECT
+			s.id,
+			DATE(s.created_at AT TIME ZONE 'America/Monterrey') as fecha,
+			s.created_at AT TIME ZONE 'America/Monterrey' as fecha_hora,
+			s.total,
+			s.payment_method,
+			COALESCE(s.cfdi_uuid,'') as uuid,
+			COALESCE(s.cfdi_rfc_receptor, '') as rfc_receptor,
+			COALESCE(s.cfdi_nombre_receptor,'') as nombre_receptor,
+			s.status,
+			COALESCE(s.cfdi_serie,'') as serie,
+			COALESCE(s.cfdi_folio,'') as folio,
+			s.moneda,
+			s.tipo_cambio,
+			s.total * s.tipo_cambio as total_mxn,
+			COALESCE(s.cfdi_isr_retenido,0) as isr_retenido,
+			COALESCE(s.cfdi_iva_retenido,0) as iva_retenido
+		FROM sales s
+		WHERE DATE(s.created_at AT TIME ZONE 'America/Monterrey') BETWEEN $1::date AND $2::date
+		  AND (s.tenant_id = $3::uuid OR s.tenant_id IS NULL)
+		  AND s.cfdi_uuid IS NOT NULL
+		  AND ($4 = '' OR s.cfdi_serie = $4)
+		  AND ($5 = '' OR s.customer_id::text = $5)
+		ORDER BY `+ordenReporteCFDI(q.Get("orden"))+`
+		LIMIT 500
+	`, desde, hasta, tid, q.Get("serie"), q.Get("cliente"))
+	if err != nil {
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	type CFDIRow struct {
+		ID            string  `json:"id"`
+		Fecha         string  `json:"fecha"`
+		FechaHora     string  `json:"fecha_hora"`
+		Total         float64 `json:"total"`
+		Metodo        string  `json:"metodo"`
+		UUID          string  `json:"uuid"`
+		RFCReceptor   string  `json:"rfc_receptor"`
+		NombreReceptor string `json:"nombre_receptor"`
+		Status        string  `json:"status"`
+		Serie         string  `json:"serie"`
+		Folio         string  `json:"folio"`
+		Moneda        string  `json:"moneda"`
+		TipoCambio    float64 `json:"tipo_cambio"`
+		TotalMXN      float64 `json:"total_mxn"`
+		ISRRetenido   float64 `json:"isr_retenido"`
+		IVARetenido   float64 `json:"iva_retenido"`
+	}
+	var cfdis []CFDIRow
+	var totalTimbrado float64
+	porMoneda := map[string]float64{}
+	var isrRetenido, ivaRetenido float64
+	for rows.Next() {
+		var c CFDIRow
+		rows.Scan(&c.ID, &c.Fecha, &c.FechaHora, &c.Total, &c.Metodo,
+			&c.UUID, &c.RFCReceptor, &c.NombreReceptor, &c.Status, &c.Serie, &c.Folio,
+			&c.Moneda, &c.TipoCambio, &c.TotalMXN, &c.ISRRetenido, &c.IVARetenido)
+		isrRetenido += c.ISRRetenido * c.TipoCambio
+		ivaRetenido += c.IVARetenido * c.TipoCambio
+		cfdis = append(cfdis, c)
+		totalTimbrado += c.TotalMXN
+		porMoneda[c.Moneda] += c.Total
 	}
+	if cfdis == nil { cfdis = []CFDIRow{} }
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"fecha": fecha, "total_transacciones": totalTx,
-		"total_vendido": totalVendido, "total_canceladas": totalCanceladas,
-		"total_neto": totalNeto, "total_timbradas": totalTimbradas,
-		"por_metodo": metodos, "por_hora": porHora, "top_prods": topProds,
-		"generado_at": time.Now().Format(time.RFC3339),
+		"desde":          desde,
+		"hasta":          hasta,
+		"cfdis":          cfdis,
+		"total_timbrado": totalTimbrado,
+		"por_moneda":     porMoneda,
+		"isr_retenido":   isrRetenido,
+		"iva_retenido":   ivaRetenido,
+		"count":          len(cfdis),
+	})
+}
+
+// handleConciliacionCFDI — GET: discrepancias con el SAT del tenant (?desde=&hasta=);
+// POST: concilia en ese momento los CFDI del tenant y devuelve el reporte
+func (gw *Gateway) handleConciliacionCFDI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	tid := tenantID(r)
+	if r.Method == http.MethodPost {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+		defer cancel()
+		if _, err := gw.conciliarCFDIs(ctx, tid); err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+	} else if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	desde := r.URL.Query().Get("desde")
+	hasta := r.URL.Query().Get("hasta")
+	if desde == "" { desde = time.Now().AddDate(0, 0, -30).Format("2006-01-02") }
+	if hasta == "" { hasta = time.Now().Format("2006-01-02") }
+	rows, err := gw.db.QueryContext(r.Context(), `
+		SELECT d.sale_id, d.cfdi_uuid, COALESCE(d.estado_local,''), d.estado_nuevo, COALESCE(d.estado_sat,''),
+		       COALESCE(d.estatus_cancelacion,''), d.detectado_at, s.total, COALESCE(s.cfdi_rfc_receptor,'')
+		FROM cfdi_discrepancias d JOIN sales s ON s.id = d.sale_id
+		WHERE d.tenant_id = $1::uuid
+		  AND DATE(d.detectado_at AT TIME ZONE 'America/Monterrey') BETWEEN $2::date AND $3::date
+		ORDER BY d.detectado_at DESC
+		LIMIT 500`, tid, desde, hasta)
+	if err != nil {
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	type Discrepancia struct {
+		SaleID             string  `json:"sale_id"`
+		UUID               string  `json:"uuid"`
+		EstadoAnterior     string  `json:"estado_anterior"`
+		EstadoActual       string  `json:"estado_actual"`
+		EstadoSAT          string  `json:"estado_sat"`
+		EstatusCancelacion string  `json:"estatus_cancelacion"`
+		Detectado          string  `json:"detectado"`
+		Total              float64 `json:"total"`
+		RFCReceptor        string  `json:"rfc_receptor"`
+	}
+	lista := []Discrepancia{}
+	porEstado := map[string]int{}
+	for rows.Next() {
+		var d Discrepancia
+		var detectado time.Time
+		if err := rows.Scan(&d.SaleID, &d.UUID, &d.EstadoAnterior, &d.EstadoActual, &d.EstadoSAT,
+			&d.EstatusCancelacion, &detectado, &d.Total, &d.RFCReceptor); err != nil { continue }
+		d.Detectado = detectado.Format("2006-01-02 15:04")
+		lista = append(lista, d)
+		porEstado[d.EstadoActual]++
+	}
+	var pendientes int
+	gw.db.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM sales WHERE tenant_id=$1::uuid AND cfdi_status='cancelacion_pendiente'`, tid).Scan(&pendientes)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"desde": desde, "hasta": hasta, "discrepancias": lista, "count": len(lista),
+		"por_estado": porEstado, "cancelaciones_pendientes": pendientes,
+	})
+}
+
+// ─── CSD Upload ────────────────────────────────────────────────────────────
+func (gw *Gateway) handleCSDUpload(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodGet { gw.handleCSDInfo(w, r); return }
+    if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+    if err := r.ParseMultipartForm(10 << 20); err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        json.NewEncoder(w).Encode(map[string]string{"error": "form invalido"})
+        return
+    }
+    tid := tenantID(r)
+    rfc := r.FormValue("rfc")
+    keyPass := r.FormValue("key_password")
+    if rfc == "" || keyPass == "" {
+        w.WriteHeader(http.StatusBadRequest)
+        json.NewEncoder(w).Encode(map[string]string{"error": "rfc y key_password requeridos"})
+        return
+    }
+
+    // Leer .cer
+    cerFile, _, err := r.FormFile("cer")
+    if err != nil { w.WriteHeader(400); json.NewEncoder(w).Encode(map[string]string{"error": "archivo .cer requerido"}); return }
+    defer cerFile.Close()
+    cerBytes, _ := io.ReadAll(cerFile)
+    certB64 := base64.StdEncoding.EncodeToString(cerBytes)
+
+    // Leer .key
+    keyFile, _, err := r.FormFile("key")
+    if err != nil { w.WriteHeader(400); json.NewEncoder(w).Encode(map[string]string{"error": "archivo .key requerido"}); return }
+    defer keyFile.Close()
+    keyBytes, _ := io.ReadAll(keyFile)
+
+    // El RFC debe ser el del tenant, no el que venga en el formulario
+    var rfcTenant string
+    if err := gw.db.QueryRowContext(r.Context(), `SELECT COALESCE(rfc,'') FROM tenants WHERE id=$1::uuid`, tid).Scan(&rfcTenant); err == nil && rfcTenant != "" {
+        rfc = rfcTenant
+    }
+
+    // Parsear y verificar el par en el CFDI service
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    info, err := gw.cfdiClient.ValidarCSD(ctx, &pb_cfdi.ValidarCSDRequest{
+        CertB64: certB64, KeyBytes: keyBytes, KeyPassword: keyPass, RfcEmisor: rfc, TenantId: tid,
+    })
+    if err != nil {
+        w.WriteHeader(400)
+        json.NewEncoder(w).Encode(map[string]string{"error": "certificado o llave invalidos: " + status.Convert(err).Message()})
+        return
+    }
+    desde := time.Unix(info.ValidoDesde, 0)
+    hasta := time.Unix(info.ValidoHasta, 0)
+    // Se guardan la llave y contraseña cifradas que devuelve el CFDI service; nunca en claro
+    keyBytes, keyPass = info.KeyBytesCifrada, info.KeyPasswordCifrada
+
+    // Guardar en DB. Si el nuevo CSD aún no entra en vigor queda pendiente y el
+    // actual sigue sellando hasta que startCSDCron lo promueva.
+    tx, err := gw.db.BeginTx(r.Context(), nil)
+    if err != nil { w.WriteHeader(500); json.NewEncoder(w).Encode(map[string]string{"error": "error guardando certificado"}); return }
+    defer tx.Rollback()
+    estado := "activo"
+    if _, err = tx.Exec(`DELETE FROM tenant_csds_historial WHERE tenant_id=$1::uuid AND estado='pendiente'`, tid); err == nil {
+        if desde.After(time.Now()) {
+            estado = "pendiente"
+            _, err = tx.Exec(`
+                INSERT INTO tenant_csds_historial (tenant_id, rfc_emisor, no_certificado, cert_b64, key_bytes, key_password, valido_desde, valido_hasta, estado)
+                VALUES ($1::uuid, $2, $3, $4, $5, $6, $7, $8, 'pendiente')
+            `, tid, rfc, info.NoCertificado, certB64, keyBytes, keyPass, desde, hasta)
+        } else {
+            err = reemplazarCSD(tx, tid, rfc, info.NoCertificado, certB64, keyBytes, keyPass, desde, hasta)
+        }
+    }
+    if err == nil { err = tx.Commit() }
+    if err != nil {
+        log.Printf("[BFF] Error guardando CSD: %v", err)
+        w.WriteHeader(500)
+        json.NewEncoder(w).Encode(map[string]string{"error": "error guardando certificado"})
+        return
+    }
+    log.Printf("[BFF] CSD registrado tenant=%s rfc=%s noCert=%s estado=%s", tid, rfc, info.NoCertificado, estado)
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "ok": true, "rfc": rfc, "no_certificado": info.NoCertificado, "estado": estado,
+        "valido_desde": desde.Format("2006-01-02"), "valido_hasta": hasta.Format("2006-01-02"),
+        "mensaje": "Certificado registrado correctamente",
+    })
+}
+
+// reemplazarCSD archiva el certificado vigente del tenant y activa el nuevo dentro de tx
+func reemplazarCSD(tx *sql.Tx, tid, rfc, noCert, certB64 string, keyBytes []byte, keyPass string, desde, hasta time.Time) error {
+    _, err := tx.Exec(`
+        INSERT INTO tenant_csds_historial (tenant_id, rfc_emisor, no_certificado, cert_b64, key_bytes, key_password, valido_desde, valido_hasta, estado)
+        SELECT tenant_id, rfc_emisor, no_certificado, cert_b64, key_bytes, key_password, valido_desde, valido_hasta, 'reemplazado'
+        FROM tenant_csds WHERE tenant_id=$1::uuid
+    `, tid)
+    if err != nil { return err }
+    _, err = tx.Exec(`
+        INSERT INTO tenant_csds (tenant_id, rfc_emisor, cert_b64, key_bytes, key_password, no_certificado, valido_desde, valido_hasta, aviso_vencimiento_at, updated_at)
+        VALUES ($1::uuid, $2, $3, $4, $5, $6, $7, $8, NULL, NOW())
+        ON CONFLICT (tenant_id) DO UPDATE SET
+            rfc_emisor=EXCLUDED.rfc_emisor, cert_b64=EXCLUDED.cert_b64,
+            key_bytes=EXCLUDED.key_bytes, key_password=EXCLUDED.key_password,
+            no_certificado=EXCLUDED.no_certificado, valido_desde=EXCLUDED.valido_desde,
+            valido_hasta=EXCLUDED.valido_hasta, aviso_vencimiento_at=NULL, updated_at=NOW()
+    `, tid, rfc, certB64, keyBytes, keyPass, noCert, desde, hasta)
+    return err
+}
+
+func (gw *Gateway) handleCSDInfo(w http.ResponseWriter, r *http.Request) {
+    tid := tenantID(r)
+    var rfc, noCert string
+    var updatedAt time.Time
+    var desde, hasta sql.NullTime
+    err := gw.db.QueryRow(`SELECT rfc_emisor, COALESCE(no_certificado,''), valido_desde, valido_hasta, updated_at FROM tenant_csds WHERE tenant_id=$1::uuid`, tid).
+        Scan(&rfc, &noCert, &desde, &hasta, &updatedAt)
+    w.Header().Set("Content-Type", "application/json")
+    if err != nil {
+        json.NewEncoder(w).Encode(map[string]interface{}{"tiene_csd": false})
+        return
+    }
+    resp := map[string]interface{}{"tiene_csd": true, "rfc": rfc, "no_certificado": noCert, "actualizado": updatedAt.Format("2006-01-02 15:04")}
+    if desde.Valid { resp["valido_desde"] = desde.Time.Format("2006-01-02") }
+    if hasta.Valid {
+        resp["valido_hasta"] = hasta.Time.Format("2006-01-02")
+        resp["dias_restantes"] = int(time.Until(hasta.Time).Hours() / 24)
+        resp["vigente"] = time.Now().Before(hasta.Time)
+    }
+    var pendienteDesde time.Time
+    if err := gw.db.QueryRow(`SELECT valido_desde FROM tenant_csds_historial WHERE tenant_id=$1::uuid AND estado='pendiente'`, tid).Scan(&pendienteDesde); err == nil {
+        resp["pendiente_desde"] = pendienteDesde.Format("2006-01-02")
+    }
+    json.NewEncoder(w).Encode(resp)
+}
+
+// monedasPermitidas son las monedas en que se puede cobrar (c_Moneda)
+var monedasPermitidas = map[string]bool{"MXN": true, "USD": true, "EUR": true, "CAD": true}
+
+// tipoCambio devuelve, como decimal exacto, el tipo de cambio a pesos para la fecha de
+// la operación: el último publicado en o antes de esa fecha, prefiriendo el capturado
+// por el tenant sobre el del DOF. Rechaza tipos de cambio de más de 7 días.
+func (gw *Gateway) tipoCambio(ctx context.Context, tid, moneda string, fecha time.Time) (string, error) {
+	if moneda == "MXN" { return "1", nil }
+	if !monedasPermitidas[moneda] { return "", fmt.Errorf("moneda no soportada: %s", moneda) }
+	loc, err := time.LoadLocation("America/Monterrey")
+	if err != nil { loc = time.Local }
+	dia := fecha.In(loc).Format("2006-01-02")
+	var tc string
+	err = gw.db.QueryRowContext(ctx, `
+		SELECT tipo_cambio::text FROM tipos_cambio
+		WHERE moneda=$1 AND fecha <= $2::date AND fecha > $2::date - 7
+		  AND (tenant_id=$3::uuid OR tenant_id IS NULL)
+		ORDER BY fecha DESC, (tenant_id IS NOT NULL) DESC
+		LIMIT 1`, moneda, dia, tid).Scan(&tc)
+	if err != nil {
+		return "", fmt.Errorf("no hay tipo de cambio %s vigente al %s; captúralo o importa el del DOF", moneda, dia)
+	}
+	return tc, nil
+}
+
+// handleTiposCambio — GET ?moneda=&desde=&hasta=: tipos de cambio del tenant y del DOF;
+// POST {fecha, moneda, tipo_cambio}: captura manual del tenant para ese día
+func (gw *Gateway) handleTiposCambio(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	tid := tenantID(r)
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		moneda := strings.ToUpper(q.Get("moneda"))
+		desde, hasta := q.Get("desde"), q.Get("hasta")
+		if desde == "" { desde = time.Now().AddDate(0, 0, -30).Format("2006-01-02") }
+		if hasta == "" { hasta = time.Now().Format("2006-01-02") }
+		rows, err := gw.db.QueryContext(r.Context(), `
+			SELECT fecha::text, moneda, tipo_cambio::text, fuente, tenant_id IS NOT NULL
+			FROM tipos_cambio
+			WHERE (tenant_id=$1::uuid OR tenant_id IS NULL) AND ($2 = '' OR moneda=$2)
+			  AND fecha BETWEEN $3::date AND $4::date
+			ORDER BY fecha DESC, moneda`, tid, moneda, desde, hasta)
+		if err != nil { fail(500, err.Error()); return }
+		defer rows.Close()
+		lista := []map[string]interface{}{}
+		for rows.Next() {
+			var fecha, mon, tc, fuente string
+			var propio bool
+			if rows.Scan(&fecha, &mon, &tc, &fuente, &propio) != nil { continue }
+			lista = append(lista, map[string]interface{}{"fecha": fecha, "moneda": mon, "tipo_cambio": tc, "fuente": fuente, "propio": propio})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"desde": desde, "hasta": hasta, "tipos_cambio": lista})
+
+	case http.MethodPost:
+		var req struct {
+			Fecha      string  `json:"fecha"`
+			Moneda     string  `json:"moneda"`
+			TipoCambio float64 `json:"tipo_cambio"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { fail(400, "JSON invalido"); return }
+		req.Moneda = strings.ToUpper(strings.TrimSpace(req.Moneda))
+		if req.Fecha == "" { req.Fecha = time.Now().Format("2006-01-02") }
+		if _, err := time.Parse("2006-01-02", req.Fecha); err != nil { fail(400, "fecha debe ser AAAA-MM-DD"); return }
+		if req.Moneda == "MXN" || !monedasPermitidas[req.Moneda] { fail(400, "moneda no soportada"); return }
+		if req.TipoCambio <= 0 { fail(400, "tipo_cambio debe ser mayor a 0"); return }
+		_, err := gw.db.ExecContext(r.Context(), `
+			INSERT INTO tipos_cambio (tenant_id, fecha, moneda, tipo_cambio, fuente)
+			VALUES ($1::uuid, $2::date, $3, ROUND($4::numeric, 6), 'manual')
+			ON CONFLICT ((COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000'::uuid)), moneda, fecha)
+			DO UPDATE SET tipo_cambio=EXCLUDED.tipo_cambio, fuente='manual', created_at=NOW()`,
+			tid, req.Fecha, req.Moneda, req.TipoCambio)
+		if err != nil { fail(500, err.Error()); return }
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "fecha": req.Fecha, "moneda": req.Moneda, "tipo_cambio": req.TipoCambio})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// tipoCambioDia es un renglón del archivo de tipos de cambio del DOF
+type tipoCambioDia struct {
+	Fecha      string
+	TipoCambio string
+}
+
+var tipoCambioPattern = regexp.MustCompile(`^\d{1,4}\.\d{1,6}$`)
+
+// parseArchivoDOF lee el CSV de la serie del tipo de cambio publicado en el DOF (Banxico
+// SIE, serie SF60653): renglones "fecha,tipo de cambio" con fecha DD/MM/AAAA o AAAA-MM-DD.
+// Los encabezados y los días sin publicación (N/E) se omiten.
+func parseArchivoDOF(r io.Reader) ([]tipoCambioDia, error) {
+	reader := csv.NewReader(r)
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	var dias []tipoCambioDia
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF { break }
+		if err != nil { return nil, err }
+		if len(rec) < 2 { continue }
+		var fecha time.Time
+		var errFecha error
+		campo := strings.TrimSpace(rec[0])
+		if fecha, errFecha = time.Parse("02/01/2006", campo); errFecha != nil {
+			if fecha, errFecha = time.Parse("2006-01-02", campo); errFecha != nil { continue }
+		}
+		tc := strings.TrimSpace(rec[1])
+		if !tipoCambioPattern.MatchString(tc) { continue }
+		dias = append(dias, tipoCambioDia{Fecha: fecha.Format("2006-01-02"), TipoCambio: tc})
+	}
+	if len(dias) == 0 { return nil, fmt.Errorf("el archivo no contiene tipos de cambio") }
+	return dias, nil
+}
+
+// handleImportarDOF — POST (cuerpo: CSV o multipart "archivo") ?moneda=USD: importa los
+// tipos de cambio publicados en el DOF. Con X-Admin-Key quedan comunes a todos los
+// tenants; sin ella solo se guardan para el tenant que los importa.
+func (gw *Gateway) handleImportarDOF(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+	moneda := strings.ToUpper(r.URL.Query().Get("moneda"))
+	if moneda == "" { moneda = "USD" }
+	if moneda == "MXN" || !monedasPermitidas[moneda] { fail(400, "moneda no soportada"); return }
+	var cuerpo io.Reader = io.LimitReader(r.Body, 2<<20)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		f, _, err := r.FormFile("archivo")
+		if err != nil { fail(400, "archivo requerido"); return }
+		defer f.Close()
+		cuerpo = io.LimitReader(f, 2<<20)
+	}
+	dias, err := parseArchivoDOF(cuerpo)
+	if err != nil { fail(400, err.Error()); return }
+	// Los renglones globales (tenant_id NULL) los usan todos los tenants
+	var destino interface{}
+	alcance := "global"
+	if !isAdmin(r) {
+		destino, alcance = tenantID(r), "tenant "+tenantID(r)
+	}
+
+	tx, err := gw.db.BeginTx(r.Context(), nil)
+	if err != nil { fail(500, err.Error()); return }
+	defer tx.Rollback()
+	for _, d := range dias {
+		// Un tipo de cambio capturado a mano en el mismo renglón no se sobreescribe
+		if _, err := tx.ExecContext(r.Context(), `
+			INSERT INTO tipos_cambio (tenant_id, fecha, moneda, tipo_cambio, fuente)
+			VALUES ($4::uuid, $1::date, $2, $3::numeric, 'dof')
+			ON CONFLICT ((COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000'::uuid)), moneda, fecha)
+			DO UPDATE SET tipo_cambio=EXCLUDED.tipo_cambio, created_at=NOW() WHERE tipos_cambio.fuente='dof'`,
+			d.Fecha, moneda, d.TipoCambio, destino); err != nil {
+			fail(500, err.Error())
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil { fail(500, err.Error()); return }
+	log.Printf("[BFF] DOF: %d tipos de cambio %s importados (%s a %s, %s)", len(dias), moneda, dias[0].Fecha, dias[len(dias)-1].Fecha, alcance)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok": true, "moneda": moneda, "importados": len(dias), "desde": dias[0].Fecha, "hasta": dias[len(dias)-1].Fecha,
+		"global": destino == nil,
 	})
 }
 
-// ─── CRUD Productos ───────────────────────────────────────────────────────────
-// GET  /api/v1/products         — listar productos del tenant
-// POST /api/v1/products         — crear producto
-// PUT  /api/v1/products/{id}    — actualizar producto
-// DELETE /api/v1/products/{id}  — eliminar producto
+var tiposSerie = map[string]bool{"ingreso": true, "egreso": true, "pago": true, "global": true}
 
-func (gw *Gateway) handleProducts(w http.ResponseWriter, r *http.Request) {
-    w.Header().Set("Content-Type", "application/json")
-    tid := tenantID(r)
+// handleCFDISeries — GET: series del tenant con su último folio y cuántos folios quedaron
+// sin usar; POST: crea o reactiva una
+// serie {serie, tipo, sucursal, folio_inicial} y desactiva la que ocupaba esa sucursal y
+// tipo; DELETE ?serie=: la desactiva. Los contadores nunca retroceden.
+func (gw *Gateway) handleCFDISeries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	tid := tenantID(r)
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := gw.db.QueryContext(r.Context(), `
+			SELECT s.serie, s.tipo, s.sucursal, s.folio_actual, s.activa, s.created_at,
+			       (SELECT COUNT(*) FROM cfdi_folios_sin_usar f WHERE f.tenant_id=s.tenant_id AND f.serie=s.serie AND NOT f.reutilizable)
+			FROM cfdi_series s WHERE s.tenant_id=$1::uuid
+			ORDER BY s.activa DESC, s.sucursal, s.tipo, s.serie`, tid)
+		if err != nil { fail(500, err.Error()); return }
+		defer rows.Close()
+		series := []map[string]interface{}{}
+		for rows.Next() {
+			var serie, tipo, sucursal string
+			var folio, sinUsar int64
+			var activa bool
+			var creada time.Time
+			if rows.Scan(&serie, &tipo, &sucursal, &folio, &activa, &creada, &sinUsar) != nil { continue }
+			series = append(series, map[string]interface{}{
+				"serie": serie, "tipo": tipo, "sucursal": sucursal, "ultimo_folio": folio,
+				"siguiente_folio": folio + 1, "activa": activa, "creada": creada.Format("2006-01-02"),
+				"folios_sin_usar": sinUsar,
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"series": series})
 
-    switch r.Method {
-    case http.MethodGet:
-        rows, err := gw.db.Query(`
-            SELECT id, COALESCE(name,''), COALESCE(price,0),
-                   COALESCE(sku,''), COALESCE(category,''), COALESCE(unit,'pza'),
-                   COALESCE(barcode,''), active, COALESCE(image_url,'')
-            FROM products
-            WHERE (tenant_id = $1::uuid OR tenant_id IS NULL)
-              AND COALESCE(active, true) = true
-            ORDER BY name`, tid)
-        if err != nil {
-            w.WriteHeader(http.StatusInternalServerError)
-            json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-            return
-        }
-        defer rows.Close()
-        var prods []map[string]interface{}
-        for rows.Next() {
-            var id, name, sku, category, unit, barcode, imageURL string
-            var price float64
-            var active bool
-            rows.Scan(&id, &name, &price, &sku, &category, &unit, &barcode, &active, &imageURL)
-            prods = append(prods, map[string]interface{}{
-                "id": id, "name": name, "price": price,
-                "sku": sku, "category": category, "unit": unit,
-                "barcode": barcode, "active": active, "image_url": imageURL,
-            })
-        }
-        if prods == nil { prods = []map[string]interface{}{} }
-        json.NewEncoder(w).Encode(prods)
+	case http.MethodPost:
+		var req struct {
+			Serie        string `json:"serie"`
+			Tipo         string `json:"tipo"`
+			Sucursal     string `json:"sucursal"`
+			FolioInicial int64  `json:"folio_inicial"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { fail(400, "JSON invalido"); return }
+		req.Serie = strings.ToUpper(strings.TrimSpace(req.Serie))
+		req.Sucursal = strings.TrimSpace(req.Sucursal)
+		if req.Tipo == "" { req.Tipo = "ingreso" }
+		if req.Serie == "" || len(req.Serie) > 25 || strings.Contains(req.Serie, "|") {
+			fail(400, "serie requerida: máximo 25 caracteres y sin |")
+			return
+		}
+		if !tiposSerie[req.Tipo] { fail(400, "tipo debe ser ingreso, egreso, pago o global"); return }
+		if req.FolioInicial < 1 { req.FolioInicial = 1 }
+
+		tx, err := gw.db.BeginTx(r.Context(), nil)
+		if err != nil { fail(500, err.Error()); return }
+		defer tx.Rollback()
+		tx.ExecContext(r.Context(), `
+			UPDATE cfdi_series SET activa=FALSE
+			WHERE tenant_id=$1::uuid AND sucursal=$2 AND tipo=$3 AND activa AND serie<>$4`,
+			tid, req.Sucursal, req.Tipo, req.Serie)
+		// Una serie existente conserva su contador; folio_inicial solo aplica a series nuevas
+		var folio int64
+		err = tx.QueryRowContext(r.Context(), `
+			INSERT INTO cfdi_series (tenant_id, sucursal, tipo, serie, folio_actual)
+			VALUES ($1::uuid, $2, $3, $4, $5)
+			ON CONFLICT (tenant_id, serie) DO UPDATE SET sucursal=EXCLUDED.sucursal, tipo=EXCLUDED.tipo, activa=TRUE
+			RETURNING folio_actual`,
+			tid, req.Sucursal, req.Tipo, req.Serie, req.FolioInicial-1).Scan(&folio)
+		if err == nil { err = tx.Commit() }
+		if err != nil { fail(500, err.Error()); return }
+		log.Printf("[BFF] Serie CFDI tenant=%s serie=%s tipo=%s sucursal=%q siguiente=%d", tid, req.Serie, req.Tipo, req.Sucursal, folio+1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok": true, "serie": req.Serie, "tipo": req.Tipo, "sucursal": req.Sucursal, "siguiente_folio": folio + 1,
+		})
 
-    case http.MethodPost:
-        var req struct {
-            Name     string  `json:"name"`
-            Price    float64 `json:"price"`
-            SKU      string  `json:"sku"`
-            Category string  `json:"category"`
-            Unit     string  `json:"unit"`
-            Barcode  string  `json:"barcode"`
-            ImageURL string  `json:"image_url"`
-        }
-        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-            w.WriteHeader(http.StatusBadRequest)
-            json.NewEncoder(w).Encode(map[string]string{"error": "JSON invalido"})
-            return
-        }
-        if req.Name == "" || req.Price <= 0 {
-            w.WriteHeader(http.StatusBadRequest)
-            json.NewEncoder(w).Encode(map[string]string{"error": "name y price requeridos"})
-            return
-        }
-        if req.Unit == "" { req.Unit = "pza" }
-        if req.Category == "" { req.Category = "General" }
-        if req.SKU == "" { req.SKU = fmt.Sprintf("PROD-%d", time.Now().UnixMilli()) }
+	case http.MethodDelete:
+		serie := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("serie")))
+		res, err := gw.db.ExecContext(r.Context(), `UPDATE cfdi_series SET activa=FALSE WHERE tenant_id=$1::uuid AND serie=$2`, tid, serie)
+		if err != nil { fail(500, err.Error()); return }
+		if n, _ := res.RowsAffected(); n == 0 { fail(404, "serie no encontrada"); return }
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true})
 
-        var id string
-        err := gw.db.QueryRow(`
-            INSERT INTO products (id, name, price, sku, category, unit, barcode, image_url, tenant_id, active, created_at)
-            VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7, $8::uuid, true, NOW())
-            ON CONFLICT (sku) WHERE sku IS NOT NULL
-            DO UPDATE SET name=EXCLUDED.name, price=EXCLUDED.price,
-                          category=EXCLUDED.category, unit=EXCLUDED.unit, image_url=EXCLUDED.image_url
-            RETURNING id`,
-            req.Name, req.Price, req.SKU, req.Category, req.Unit, req.Barcode, req.ImageURL, tid,
-        ).Scan(&id)
-        if err != nil {
-            w.WriteHeader(http.StatusInternalServerError)
-            json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-            return
-        }
-        log.Printf("[BFF] Producto creado: %s ? %s $%.2f", id, req.Name, req.Price)
-        w.WriteHeader(http.StatusCreated)
-        json.NewEncoder(w).Encode(map[string]interface{}{
-            "id": id, "name": req.Name, "price": req.Price, "sku": req.SKU,
-        })
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
 
-    default:
-        w.WriteHeader(http.StatusMethodNotAllowed)
-    }
+// perfilesRetencion describe los perfiles de retención que entiende el CFDI service
+var perfilesRetencion = map[string]string{
+	"honorarios":      "Honorarios: ISR 10% e IVA 2/3 (10.6667%)",
+	"arrendamiento":   "Arrendamiento: ISR 10% e IVA 2/3 (10.6667%)",
+	"resico":          "RESICO persona física: ISR 1.25% e IVA 2/3 (10.6667%)",
+	"fletes":          "Autotransporte de carga: IVA 4%",
+	"subcontratacion": "Servicios con personal a disposición: IVA 6%",
 }
-func (gw *Gateway) handleProductByID(w http.ResponseWriter, r *http.Request) {
-	// Extraer ID del path: /api/v1/products/UUID
-	productID := strings.TrimPrefix(r.URL.Path, "/api/v1/products/")
-	if productID == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "id requerido"})
-		return
+
+// timbrarVenta timbra una venta con las retenciones que le tocan y el folio de su serie,
+// y guarda en la venta los impuestos retenidos para los reportes.
+func (gw *Gateway) timbrarVenta(ctx context.Context, tid, sucursal string, req *pb_cfdi.FacturaRequest) (*pb_cfdi.FacturaResponse, error) {
+	gw.aplicarRetenciones(ctx, tid, req)
+	res, err := gw.timbrarConFolio(ctx, tid, sucursal, req)
+	if err != nil { return nil, err }
+	if res.GetTotalRetenido() != "" {
+		gw.db.Exec(`
+			UPDATE sales SET cfdi_isr_retenido=NULLIF($1,'')::numeric, cfdi_iva_retenido=NULLIF($2,'')::numeric,
+			       cfdi_total_retenido=$3::numeric
+			WHERE id=$4::uuid`, res.GetIsrRetenido(), res.GetIvaRetenido(), res.GetTotalRetenido(), req.GetVentaId())
 	}
+	return res, nil
+}
+
+// aplicarRetenciones agrega a la solicitud los conceptos de la venta con su perfil de
+// retención (del producto o, si no tiene, el del régimen del receptor). Solo las personas
+// morales retienen; si nada aplica la solicitud queda igual y se factura una sola línea.
+func (gw *Gateway) aplicarRetenciones(ctx context.Context, tid string, req *pb_cfdi.FacturaRequest) {
+	rfc := strings.ToUpper(strings.TrimSpace(req.GetRfc()))
+	if len([]rune(rfc)) != 12 { return }
+	regimen := req.GetRegimenFiscalReceptor()
+	if regimen == "" { regimen = "601" }
+	var perfilRegimen string
+	gw.db.QueryRowContext(ctx, `SELECT perfil FROM tenant_retenciones WHERE tenant_id=$1::uuid AND regimen_receptor=$2`, tid, regimen).Scan(&perfilRegimen)
+
+	rows, err := gw.db.QueryContext(ctx, `
+		SELECT si.name, si.quantity, si.unit_price, si.subtotal, COALESCE(p.perfil_retencion,'')
+		FROM sale_items si LEFT JOIN products p ON p.id = si.product_id
+		WHERE si.sale_id=$1::uuid
+		ORDER BY si.name`, req.GetVentaId())
+	if err != nil { return }
+	defer rows.Close()
+	var conceptos []*pb_cfdi.ConceptoFactura
+	var suma float64
+	conPerfil := false
+	for rows.Next() {
+		c := &pb_cfdi.ConceptoFactura{}
+		if rows.Scan(&c.Nombre, &c.Cantidad, &c.PrecioUnitario, &c.Subtotal, &c.PerfilRetencion) != nil { return }
+		if c.PerfilRetencion != "" { conPerfil = true }
+		suma += c.Subtotal
+		conceptos = append(conceptos, c)
+	}
+	// Si las partidas no cuadran con el total cobrado se factura una línea por el total
+	if len(conceptos) > 0 && conPerfil && math.Abs(suma-req.GetTotal()) < 0.01 {
+		req.Conceptos = conceptos
+	}
+	req.PerfilRetencion = perfilRegimen
+}
 
+// handleRetenciones — GET: perfiles disponibles y reglas por régimen del receptor;
+// POST {regimen_receptor, perfil}: asigna el perfil a ese régimen ("" lo quita)
+func (gw *Gateway) handleRetenciones(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	tid := tenantID(r)
 	switch r.Method {
-	case http.MethodPut:
+	case http.MethodGet:
+		reglas := map[string]string{}
+		rows, err := gw.db.QueryContext(r.Context(), `SELECT regimen_receptor, perfil FROM tenant_retenciones WHERE tenant_id=$1::uuid`, tid)
+		if err == nil {
+			defer rows.Close()
+			for rows.Next() {
+				var regimen, perfil string
+				if rows.Scan(&regimen, &perfil) == nil { reglas[regimen] = perfil }
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"perfiles": perfilesRetencion, "por_regimen": reglas})
+
+	case http.MethodPost:
 		var req struct {
-			Name     string  `json:"name"`
-			Price    float64 `json:"price"`
-			SKU      string  `json:"sku"`
-			Category string  `json:"category"`
-			Unit     string  `json:"unit"`
-			Barcode  string  `json:"barcode"`
-			Active   *bool   `json:"active"`
-			ImageURL string  `json:"image_url"`
+			RegimenReceptor string `json:"regimen_receptor"`
+			Perfil          string `json:"perfil"`
 		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.RegimenReceptor) != 3 {
 			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{"error": "JSON invalido"})
-			return
-		}
-		active := true
-		if req.Active != nil { active = *req.Active }
-
-		res, err := gw.db.Exec(`
-			UPDATE products SET
-				name      = COALESCE(NULLIF($1,''), name),
-				price     = CASE WHEN $2 > 0 THEN $2 ELSE price END,
-				sku       = COALESCE(NULLIF($3,''), sku),
-				category  = COALESCE(NULLIF($4,''), category),
-				unit      = COALESCE(NULLIF($5,''), unit),
-				barcode   = COALESCE(NULLIF($6,''), barcode),
-				image_url = CASE WHEN $7 != '' THEN $7 ELSE image_url END,
-				active    = $8
-			WHERE id = $9::uuid`,
-			req.Name, req.Price, req.SKU, req.Category, req.Unit, req.Barcode, req.ImageURL, active, productID)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			json.NewEncoder(w).Encode(map[string]string{"error": "regimen_receptor requerido"})
 			return
 		}
-		n, _ := res.RowsAffected()
-		if n == 0 {
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(map[string]string{"error": "producto no encontrado"})
+		var err error
+		if req.Perfil == "" {
+			_, err = gw.db.ExecContext(r.Context(), `DELETE FROM tenant_retenciones WHERE tenant_id=$1::uuid AND regimen_receptor=$2`, tid, req.RegimenReceptor)
+		} else if _, ok := perfilesRetencion[req.Perfil]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "perfil de retención desconocido"})
 			return
+		} else {
+			_, err = gw.db.ExecContext(r.Context(), `
+				INSERT INTO tenant_retenciones (tenant_id, regimen_receptor, perfil) VALUES ($1::uuid, $2, $3)
+				ON CONFLICT (tenant_id, regimen_receptor) DO UPDATE SET perfil=EXCLUDED.perfil, updated_at=NOW()`,
+				tid, req.RegimenReceptor, req.Perfil)
 		}
-		log.Printf("[BFF] Producto actualizado: %s — %s $%.2f", productID, req.Name, req.Price)
-		json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": productID})
-
-	case http.MethodDelete:
-		// Soft delete — marcar inactive
-		res, err := gw.db.Exec(`UPDATE products SET active=false WHERE id=$1::uuid`, productID)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 			return
 		}
-		n, _ := res.RowsAffected()
-		if n == 0 {
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(map[string]string{"error": "producto no encontrado"})
-			return
-		}
-		log.Printf("[BFF] Producto eliminado (soft): %s", productID)
-		json.NewEncoder(w).Encode(map[string]string{"status": "eliminado", "id": productID})
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "regimen_receptor": req.RegimenReceptor, "perfil": req.Perfil})
 
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-func parseMigrationCSV(r io.Reader, tipo string) (products, customers []map[string]string, err error) {
-	reader := csv.NewReader(r)
-	reader.LazyQuotes = true
-	reader.TrimLeadingSpace = true
-	rows, err := reader.ReadAll()
-	if err != nil { return nil, nil, err }
-	if len(rows) < 2 { return nil, nil, nil }
+// timbrarConFolio timbra con el siguiente folio de la serie activa del tenant para la
+// sucursal (o la serie general, sucursal ''). El folio se aparta (el contador avanza y se
+// confirma) antes de llamar al PAC, así ningún renglón queda bloqueado mientras el PAC
+// responde y un folio nunca se repite. Si el comprobante se rechaza sin timbrarse el folio
+// se devuelve para reutilizarse; si no se sabe si el PAC lo timbró queda registrado como
+// hueco. Sin serie configurada se timbra sin Serie/Folio.
+func (gw *Gateway) timbrarConFolio(ctx context.Context, tid, sucursal string, req *pb_cfdi.FacturaRequest) (*pb_cfdi.FacturaResponse, error) {
+	tipo := "ingreso"
+	if req.GetRfc() == "XAXX010101000" { tipo = "global" }
+	serie, folios, err := gw.apartarFolios(ctx, tid, tipo, sucursal, 1)
+	if err == sql.ErrNoRows { return gw.cfdiClient.Timbrar(ctx, req) }
+	if err != nil { return nil, fmt.Errorf("serie de facturación: %w", err) }
+	folio := folios[0]
+
+	req.Serie, req.Folio = serie, strconv.FormatInt(folio, 10)
+	res, err := gw.cfdiClient.Timbrar(ctx, req)
+	if err != nil {
+		gw.registrarFolioSinUsar(tid, serie, folio, req.GetVentaId(), err.Error(), rechazoDefinitivo(err))
+		return nil, err
+	}
+	// Sin ctx: si el PAC ya timbró, el folio se registra aunque la solicitud haya expirado
+	if _, err := gw.db.Exec(`UPDATE sales SET cfdi_serie=$1, cfdi_folio=$2 WHERE id=$3::uuid`, serie, req.Folio, req.GetVentaId()); err != nil {
+		log.Printf("[BFF] ALERTA: folio %s-%d timbrado (uuid=%s) pero no registrado: %v", serie, folio, res.GetUuid(), err)
+	}
+	return res, nil
+}
 
-	headers := make([]string, len(rows[0]))
-	for i, h := range rows[0] { headers[i] = strings.ToLower(strings.TrimSpace(h)) }
+// rechazoDefinitivo indica si el servicio CFDI rechazó el comprobante sin timbrarlo
+// (datos inválidos, CSD o validación del PAC). Un error de comunicación o un tiempo
+// agotado no lo es: el PAC pudo haberlo timbrado.
+func rechazoDefinitivo(err error) bool {
+	c := status.Code(err)
+	return c == codes.InvalidArgument || c == codes.FailedPrecondition
+}
 
-	headersStr := strings.Join(headers, ",")
-	if tipo == "generic" {
-		switch {
-		case strings.Contains(headersStr, "clave") && strings.Contains(headersStr, "existencia"):
-			tipo = "aspel_pos"
-		case strings.Contains(headersStr, "codigo_producto"):
-			tipo = "bind"
-		case strings.Contains(headersStr, "cvenumero"):
-			tipo = "contpaq"
+// apartarFolios aparta n folios de la serie activa que le toca al tipo y la sucursal y
+// devuelve la serie y los folios apartados. Devuelve sql.ErrNoRows si no hay serie.
+func (gw *Gateway) apartarFolios(ctx context.Context, tid, tipo, sucursal string, n int) (string, []int64, error) {
+	if err := ctx.Err(); err != nil { return "", nil, err }
+	var serieID string
+	err := gw.db.QueryRow(`
+		SELECT id FROM cfdi_series
+		WHERE tenant_id=$1::uuid AND activa AND tipo IN ($2, 'ingreso') AND sucursal IN ($3, '')
+		ORDER BY (sucursal = $3) DESC, (tipo = $2) DESC
+		LIMIT 1`, tid, tipo, sucursal).Scan(&serieID)
+	if err != nil { return "", nil, err }
+	return gw.apartarFoliosSerie(serieID, n)
+}
+
+// apartarFoliosSerie aparta n folios de la serie: primero los que quedaron libres por un
+// rechazo y, para el resto, avanza el contador. La transacción es corta y se confirma antes
+// de llamar al PAC, sin dejar bloqueado el renglón de la serie. Sin ctx: cancelarla a medias
+// podría apartar folios sin avisar. Devuelve sql.ErrNoRows si la serie no está activa.
+func (gw *Gateway) apartarFoliosSerie(serieID string, n int) (string, []int64, error) {
+	tx, err := gw.db.Begin()
+	if err != nil { return "", nil, err }
+	defer tx.Rollback()
+	var tid, serie string
+	if err := tx.QueryRow(`SELECT tenant_id::text, serie FROM cfdi_series WHERE id=$1 AND activa FOR UPDATE`, serieID).Scan(&tid, &serie); err != nil {
+		return "", nil, err
+	}
+	rows, err := tx.Query(`
+		DELETE FROM cfdi_folios_sin_usar WHERE id IN (
+			SELECT id FROM cfdi_folios_sin_usar
+			WHERE tenant_id=$1::uuid AND serie=$2 AND reutilizable
+			ORDER BY folio LIMIT $3)
+		RETURNING folio`, tid, serie, n)
+	if err != nil { return "", nil, err }
+	var folios []int64
+	for rows.Next() {
+		var f int64
+		if err := rows.Scan(&f); err != nil { rows.Close(); return "", nil, err }
+		folios = append(folios, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil { return "", nil, err }
+	sort.Slice(folios, func(i, j int) bool { return folios[i] < folios[j] })
+
+	if faltan := n - len(folios); faltan > 0 {
+		var ultimo int64
+		if err := tx.QueryRow(`UPDATE cfdi_series SET folio_actual = folio_actual + $2 WHERE id=$1 RETURNING folio_actual`, serieID, faltan).Scan(&ultimo); err != nil {
+			return "", nil, err
 		}
+		for i := faltan - 1; i >= 0; i-- { folios = append(folios, ultimo-int64(i)) }
 	}
+	if err := tx.Commit(); err != nil { return "", nil, err }
+	return serie, folios, nil
+}
 
-	colMap := map[string]map[string][]string{
-		"aspel_pos": {
-			"sku":   {"clave", "codigo", "cve"},
-			"name":  {"descripcion", "nombre", "producto"},
-			"price": {"precio", "precio1", "pventa"},
-			"phone": {"telefono", "tel", "celular"},
-			"cname": {"nombre", "razon_social", "cliente"},
-		},
-		"bind": {
-			"sku":   {"codigo_producto", "sku", "codigo"},
-			"name":  {"nombre_producto", "nombre", "descripcion"},
-			"price": {"precio_venta", "precio"},
-			"phone": {"telefono", "celular"},
-			"cname": {"nombre_cliente", "nombre"},
-		},
-		"contpaq": {
-			"sku":   {"cvenumero", "sku", "clave"},
-			"name":  {"cvenom", "nombre", "descripcion"},
-			"price": {"cveprecio", "precio"},
-			"phone": {"telefono", "tel"},
-			"cname": {"cvenom", "nombre"},
-		},
-		"generic": {
-			"sku":   {"sku", "codigo", "clave", "code"},
-			"name":  {"nombre", "name", "descripcion", "producto"},
-			"price": {"precio", "price", "precio_venta"},
-			"phone": {"telefono", "phone", "celular"},
-			"cname": {"nombre", "name", "cliente"},
-		},
+// registrarFolioSinUsar deja constancia de un folio apartado que no quedó en ningún CFDI.
+// Un folio reutilizable (el comprobante se rechazó sin timbrarse) lo toma el siguiente
+// timbrado de la serie; los demás quedan como hueco para conciliarse con el PAC.
+func (gw *Gateway) registrarFolioSinUsar(tid, serie string, folio int64, saleID, motivo string, reutilizable bool) {
+	if reutilizable {
+		log.Printf("[BFF] Folio %s-%d devuelto para reutilizarse (venta %s): %s", serie, folio, saleID, motivo)
+	} else {
+		log.Printf("[BFF] ALERTA: folio %s-%d sin usar (venta %s): %s", serie, folio, saleID, motivo)
+	}
+	_, err := gw.db.Exec(`
+		INSERT INTO cfdi_folios_sin_usar (tenant_id, serie, folio, sale_id, motivo, reutilizable)
+		VALUES ($1::uuid, $2, $3, NULLIF($4,'')::uuid, $5, $6)
+		ON CONFLICT (tenant_id, serie, folio) DO NOTHING`, tid, serie, folio, saleID, motivo, reutilizable)
+	if err != nil {
+		log.Printf("[BFF] ALERTA: no se pudo registrar el folio sin usar %s-%d: %v", serie, folio, err)
+	}
+}
+
+// loteVenta es una venta reclamada para el lote con la solicitud que se enviará al PAC
+type loteVenta struct {
+	indice   int
+	req      *pb_cfdi.FacturaRequest
+	receptor receptorFiscal
+}
+
+// handleTimbrarLote — POST {ventas:[{sale_id, rfc, nombre, cp, regimen, uso}], concurrencia}
+// timbra varias ventas con TimbrarLote y reenvía el avance como Server-Sent Events:
+// "omitida" (venta que no se puede facturar), "progreso" (una por venta procesada) y "fin".
+// Las ventas se agrupan por la serie que les toca y los folios de cada grupo se apartan
+// antes de enviarlo al PAC. El lote continúa aunque el navegador se desconecte, para que
+// todo lo timbrado quede registrado.
+func (gw *Gateway) handleTimbrarLote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
+	fail := func(code int, msg string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+	var req struct {
+		Ventas []struct {
+			SaleID  string `json:"sale_id"`
+			RFC     string `json:"rfc"`
+			Nombre  string `json:"nombre"`
+			CP      string `json:"cp"`
+			Regimen string `json:"regimen"`
+			Uso     string `json:"uso"`
+		} `json:"ventas"`
+		Concurrencia int32 `json:"concurrencia"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		fail(http.StatusBadRequest, "JSON invalido")
+		return
+	}
+	if len(req.Ventas) == 0 || len(req.Ventas) > 500 {
+		fail(http.StatusBadRequest, "se requieren entre 1 y 500 ventas")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		fail(http.StatusInternalServerError, "streaming no soportado")
+		return
+	}
+	tid := tenantID(r)
+	var regimenEmisor, cpEmisor string
+	gw.db.QueryRowContext(r.Context(), `SELECT COALESCE(regimen_fiscal,''), COALESCE(codigo_postal,'') FROM tenants WHERE id=$1::uuid`, tid).
+		Scan(&regimenEmisor, &cpEmisor)
+	certB64, keyBytes, keyPass, _, csdOK := gw.loadTenantCSD(tid)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	evento := func(nombre string, v interface{}) {
+		b, _ := json.Marshal(v)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", nombre, b)
+		flusher.Flush()
 	}
 
-	cm, ok := colMap[tipo]
-	if !ok { cm = colMap["generic"] }
+	// El lote no depende de la conexión del navegador
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+	defer cancel()
 
-	idx := func(variants []string) int {
-		for _, v := range variants {
-			for i, h := range headers {
-				if strings.Contains(h, v) { return i }
-			}
+	// Reclamar las ventas facturables; las demás se reportan como omitidas
+	grupos := map[string][]loteVenta{}
+	var ordenGrupos []string
+	omitidas := 0
+	for i, v := range req.Ventas {
+		var total float64
+		var sucursal, moneda, tipoCambio string
+		err := gw.db.QueryRowContext(ctx, `
+			UPDATE sales SET cfdi_status='timbrando'
+			WHERE id::text=$1 AND (tenant_id=$2::uuid OR tenant_id IS NULL) AND status='completed'
+			  AND cfdi_uuid IS NULL AND COALESCE(cfdi_status,'') NOT IN ('timbrando', 'por_conciliar')
+			RETURNING total, COALESCE(sucursal,''), moneda, tipo_cambio::text`, v.SaleID, tid).
+			Scan(&total, &sucursal, &moneda, &tipoCambio)
+		if err != nil {
+			omitidas++
+			evento("omitida", map[string]interface{}{"indice": i, "sale_id": v.SaleID, "error": "venta no encontrada, no completada o ya facturada"})
+			continue
 		}
-		return -1
+		rfc := strings.ToUpper(strings.TrimSpace(v.RFC))
+		if rfc == "" { rfc = "XAXX010101000" }
+		rec := gw.completarReceptor(ctx, tid, receptorFiscal{
+			RFC: rfc, Nombre: strings.ToUpper(strings.TrimSpace(v.Nombre)), Regimen: v.Regimen, Uso: v.Uso, CP: v.CP,
+		})
+		treq := &pb_cfdi.FacturaRequest{
+			VentaId: v.SaleID, Total: total, Rfc: rec.RFC, CodigoPostalReceptor: rec.CP,
+			NombreReceptor: rec.Nombre, RegimenFiscalReceptor: rec.Regimen, UsoCfdi: rec.Uso,
+			Moneda: moneda, TipoCambio: tipoCambio, TenantId: tid,
+			RegimenFiscalEmisor: regimenEmisor, LugarExpedicion: cpEmisor,
+		}
+		if csdOK { treq.CertB64, treq.KeyBytes, treq.KeyPassword = certB64, keyBytes, keyPass }
+		gw.aplicarRetenciones(ctx, tid, treq)
+
+		tipo := "ingreso"
+		if rec.RFC == "XAXX010101000" { tipo = "global" }
+		var serieID string
+		gw.db.QueryRowContext(ctx, `
+			SELECT id FROM cfdi_series
+			WHERE tenant_id=$1::uuid AND activa AND tipo IN ($2, 'ingreso') AND sucursal IN ($3, '')
+			ORDER BY (sucursal = $3) DESC, (tipo = $2) DESC
+			LIMIT 1`, tid, tipo, sucursal).Scan(&serieID)
+		if _, ok := grupos[serieID]; !ok { ordenGrupos = append(ordenGrupos, serieID) }
+		grupos[serieID] = append(grupos[serieID], loteVenta{indice: i, req: treq, receptor: rec})
 	}
 
-	iSku := idx(cm["sku"]); iName := idx(cm["name"]); iPrice := idx(cm["price"])
-	iPhone := idx(cm["phone"]); iCname := idx(cm["cname"])
+	timbradas, fallidas := 0, 0
+	var huecos []string
+	for _, serieID := range ordenGrupos {
+		h := gw.timbrarGrupoLote(ctx, tid, serieID, grupos[serieID], req.Concurrencia, func(p map[string]interface{}) {
+			if p["ok"] == true { timbradas++ } else { fallidas++ }
+			p["completadas"], p["total"] = timbradas+fallidas, len(req.Ventas)-omitidas
+			evento("progreso", p)
+		})
+		huecos = append(huecos, h...)
+	}
+	log.Printf("[BFF] Lote tenant=%s: %d timbradas, %d con error, %d omitidas", tid, timbradas, fallidas, omitidas)
+	evento("fin", map[string]interface{}{
+		"total": len(req.Ventas), "timbradas": timbradas, "fallidas": fallidas, "omitidas": omitidas, "folios_sin_usar": huecos,
+	})
+}
 
-	for _, row := range rows[1:] {
-		if len(row) == 0 { continue }
-		get := func(i int) string {
-			if i < 0 || i >= len(row) { return "" }
-			return strings.TrimSpace(row[i])
-		}
-		if iName >= 0 && get(iName) != "" {
-			sku := get(iSku)
-			if sku == "" { sku = get(iName) }
-			price := strings.ReplaceAll(strings.ReplaceAll(get(iPrice), "$", ""), ",", "")
-			products = append(products, map[string]string{"sku": sku, "name": get(iName), "price": price})
+// timbrarGrupoLote timbra con TimbrarLote las ventas que comparten serie (serieID "" = sin
+// serie), registra cada resultado en sales y llama a avance por cada venta procesada.
+// Los folios del grupo se apartan de una vez antes de llamar al PAC y cada venta lleva el
+// suyo, así la serie no queda bloqueada durante el lote. El folio de una venta rechazada
+// sin timbrarse se devuelve para reutilizarse; el de una que falló sin saberse si el PAC la
+// timbró queda como hueco. Una venta cuyo resultado no llegó porque el lote se interrumpió
+// pudo haberse timbrado, así que queda 'por_conciliar' con su folio en vez de volver a
+// estar disponible. Devuelve los huecos.
+func (gw *Gateway) timbrarGrupoLote(ctx context.Context, tid, serieID string, ventas []loteVenta, concurrencia int32, avance func(map[string]interface{})) []string {
+	pendientes := map[string]loteVenta{}
+	folios := map[string]int64{}
+	lote := &pb_cfdi.TimbrarLoteRequest{Concurrencia: concurrencia}
+	for _, v := range ventas {
+		lote.Facturas = append(lote.Facturas, v.req)
+		pendientes[v.req.GetVentaId()] = v
+	}
+	liberar := func(v loteVenta, msg string) {
+		gw.db.Exec(`UPDATE sales SET cfdi_status=NULL WHERE id=$1::uuid AND cfdi_status='timbrando'`, v.req.GetVentaId())
+		avance(map[string]interface{}{"indice": v.indice, "sale_id": v.req.GetVentaId(), "ok": false, "error": msg})
+	}
+
+	var serie string
+	var huecos []string
+	sinUsar := func(v loteVenta, motivo string, reutilizable bool) {
+		f, ok := folios[v.req.GetVentaId()]
+		if !ok { return }
+		gw.registrarFolioSinUsar(tid, serie, f, v.req.GetVentaId(), motivo, reutilizable)
+		if !reutilizable { huecos = append(huecos, serie+"-"+strconv.FormatInt(f, 10)) }
+	}
+	if serieID != "" {
+		var apartados []int64
+		var err error
+		serie, apartados, err = gw.apartarFoliosSerie(serieID, len(ventas))
+		if err != nil {
+			for _, v := range ventas { liberar(v, "serie de facturación: "+err.Error()) }
+			return nil
 		}
-		if iPhone >= 0 && get(iPhone) != "" {
-			phone := strings.ReplaceAll(strings.ReplaceAll(get(iPhone), " ", ""), "-", "")
-			if len(phone) >= 10 {
-				customers = append(customers, map[string]string{"phone": phone, "name": get(iCname)})
-			}
+		for i, v := range ventas {
+			v.req.Serie, v.req.Folio = serie, strconv.FormatInt(apartados[i], 10)
+			folios[v.req.GetVentaId()] = apartados[i]
 		}
 	}
-	return products, customers, nil
-}
 
-// ─── JWT ─────────────────────────────────────────────────────────────────────
-func jwtSecret() []byte {
-	s := getenv("JWT_SECRET", "turbopos-secret-2026-cambiar-en-produccion")
-	return []byte(s)
+	stream, err := gw.cfdiClient.TimbrarLote(ctx, lote)
+	if err != nil {
+		// Ningún comprobante llegó al PAC
+		for _, v := range ventas {
+			sinUsar(v, "lote no enviado: "+err.Error(), true)
+			liberar(v, err.Error())
+		}
+		return huecos
+	}
+	for {
+		var p *pb_cfdi.TimbrarLoteProgreso
+		p, err = stream.Recv()
+		if err != nil { break }
+		v, ok := pendientes[p.GetVentaId()]
+		if !ok { continue }
+		delete(pendientes, p.GetVentaId())
+		if !p.GetOk() {
+			sinUsar(v, p.GetError(), p.GetRechazado())
+			liberar(v, p.GetError())
+			continue
+		}
+		res := p.GetFactura()
+		if _, errU := gw.db.Exec(`
+			UPDATE sales SET cfdi_uuid=$1, cfdi_status='timbrado', cfdi_rfc_receptor=$2, cfdi_nombre_receptor=$3,
+			       cfdi_xml=$4, cfdi_timbrado_at=NOW(), cfdi_serie=NULLIF($5,''), cfdi_folio=NULLIF($6,''),
+			       cfdi_isr_retenido=NULLIF($7,'')::numeric, cfdi_iva_retenido=NULLIF($8,'')::numeric,
+			       cfdi_total_retenido=COALESCE(NULLIF($9,'')::numeric, cfdi_total_retenido)
+			WHERE id=$10::uuid`,
+			res.GetUuid(), v.receptor.RFC, v.receptor.Nombre, res.GetXml(), serie, p.GetFolio(),
+			res.GetIsrRetenido(), res.GetIvaRetenido(), res.GetTotalRetenido(), p.GetVentaId()); errU != nil {
+			log.Printf("[BFF] ALERTA: venta %s timbrada en lote (uuid=%s, folio %s-%s) pero no registrada: %v",
+				p.GetVentaId(), res.GetUuid(), serie, p.GetFolio(), errU)
+		}
+		avance(map[string]interface{}{
+			"indice": v.indice, "sale_id": p.GetVentaId(), "ok": true, "cfdi_uuid": res.GetUuid(),
+			"serie": serie, "folio": p.GetFolio(), "pac_usado": res.GetPacUsado(),
+		})
+	}
+	if err != io.EOF {
+		log.Printf("[BFF] Lote interrumpido serie=%q: %v", serie, err)
+	}
+	// Lo que el servicio no alcanzó a reportar pudo haberse timbrado: se marca para
+	// conciliar con el PAC, con el folio que llevaba, y no se vuelve a ofrecer
+	for id, v := range pendientes {
+		var folio string
+		if f, ok := folios[id]; ok { folio = strconv.FormatInt(f, 10) }
+		if _, errU := gw.db.Exec(`
+			UPDATE sales SET cfdi_status='por_conciliar', cfdi_serie=NULLIF($2,''), cfdi_folio=NULLIF($3,'')
+			WHERE id=$1::uuid AND cfdi_status='timbrando'`, id, serie, folio); errU != nil {
+			log.Printf("[BFF] ALERTA: venta %s (folio %s-%s) sin resultado del lote y no marcada por conciliar: %v", id, serie, folio, errU)
+		} else {
+			log.Printf("[BFF] ALERTA: venta %s (folio %s-%s) por conciliar: el lote se interrumpió antes de su resultado", id, serie, folio)
+		}
+		avance(map[string]interface{}{
+			"indice": v.indice, "sale_id": id, "ok": false, "por_conciliar": true,
+			"error": "lote interrumpido; la venta queda por conciliar con el PAC",
+		})
+	}
+	if len(huecos) > 0 {
+		log.Printf("[BFF] ALERTA: folios sin usar en la serie %s: %s", serie, strings.Join(huecos, ", "))
+	}
+	return huecos
 }
 
-func b64url(b []byte) string {
-	return base64.RawURLEncoding.EncodeToString(b)
+// loadTenantCSD carga cert/key del tenant desde DB; key y contraseña vienen cifradas
+func (gw *Gateway) loadTenantCSD(tenantID string) (certB64 string, keyBytes []byte, keyPass string, rfc string, ok bool) {
+    err := gw.db.QueryRow(`SELECT rfc_emisor, cert_b64, key_bytes, key_password FROM tenant_csds WHERE tenant_id=$1::uuid`, tenantID).
+        Scan(&rfc, &certB64, &keyBytes, &keyPass)
+    return certB64, keyBytes, keyPass, rfc, err == nil
 }
 
-func generateJWT(username, role, tenantID string) (string, error) {
-	header := b64url([]byte(`{"alg":"HS256","typ":"JWT"}`))
-	payload, _ := json.Marshal(map[string]interface{}{
-		"sub":    username,
-		"role":   role,
-		"tid":    tenantID,
-		"exp":    time.Now().Add(12 * time.Hour).Unix(),
-		"iat":    time.Now().Unix(),
-	})
-	data := header + "." + b64url(payload)
-	mac := hmac.New(sha256.New, jwtSecret())
-	mac.Write([]byte(data))
-	return data + "." + b64url(mac.Sum(nil)), nil
+// clienteVenta identifica la cuenta de lealtad de una venta: por id (búsqueda de
+// clientes), por teléfono (también el de una cuenta fusionada) o por el RFC de la
+// factura. Devuelve el id y el teléfono de la cuenta, vacíos si no hay cliente.
+func (gw *Gateway) clienteVenta(ctx context.Context, tid, id, phone, rfc string) (string, string) {
+	programaID := gw.tenantLealtad(ctx, tid)
+	rfc = strings.ToUpper(strings.TrimSpace(rfc))
+	var cid, cphone string
+	switch {
+	case id != "":
+		gw.db.QueryRowContext(ctx, `
+			SELECT id::text, phone FROM loyalty_accounts WHERE id::text = $1 AND tenant_id = $2::uuid AND anonimizado_at IS NULL`,
+			id, programaID).Scan(&cid, &cphone)
+	case phone != "":
+		gw.db.QueryRowContext(ctx, `
+			SELECT id::text, phone FROM loyalty_accounts
+			WHERE tenant_id = $1::uuid AND (phone = $2 OR id IN (
+			      SELECT account_id FROM loyalty_telefonos_alternos WHERE tenant_id = $1::uuid AND phone = $2))
+			ORDER BY (phone = $2) DESC LIMIT 1`, programaID, phone).Scan(&cid, &cphone)
+	case rfc != "" && rfc != "XAXX010101000" && rfc != "XEXX010101000":
+		gw.db.QueryRowContext(ctx, `
+			SELECT id::text, phone FROM loyalty_accounts WHERE tenant_id = $1::uuid AND rfc = $2
+			ORDER BY (COALESCE(nombre_fiscal,'') <> '' AND COALESCE(regimen_fiscal,'') <> '' AND COALESCE(cp,'') <> '') DESC,
+			         updated_at DESC LIMIT 1`, programaID, rfc).Scan(&cid, &cphone)
+	}
+	return cid, cphone
 }
 
-type jwtClaims struct {
-	Sub      string `json:"sub"`
-	Role     string `json:"role"`
-	TenantID string `json:"tid"`
-	Exp      int64  `json:"exp"`
+// receptorFiscal son los datos del receptor que viajan en FacturaRequest
+type receptorFiscal struct {
+	RFC     string
+	Nombre  string
+	Regimen string
+	Uso     string
+	CP      string
 }
 
-func validateJWT(token string) (*jwtClaims, error) {
-	parts := strings.Split(token, ".")
-	if len(parts) != 3 {
-		return nil, fmt.Errorf("token inválido")
+// completarReceptor rellena nombre, régimen, uso CFDI y CP que no vengan en la solicitud
+// con el perfil fiscal que el cliente guardó en este negocio (loyalty_accounts) y, en último caso, con
+// los valores por defecto según el tipo de RFC. Si el RFC está en varias cuentas (duplicados
+// sin fusionar) gana la que tiene el perfil fiscal completo. El CFDI service valida la combinación.
+func (gw *Gateway) completarReceptor(ctx context.Context, tid string, rec receptorFiscal) receptorFiscal {
+	if rec.RFC == "" || rec.RFC == "XAXX010101000" {
+		return rec
 	}
-	data := parts[0] + "." + parts[1]
-	mac := hmac.New(sha256.New, jwtSecret())
-	mac.Write([]byte(data))
-	expected := b64url(mac.Sum(nil))
-	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
-		return nil, fmt.Errorf("firma inválida")
+	if rec.Nombre == "" || rec.Regimen == "" || rec.Uso == "" || rec.CP == "" {
+		var nombre, regimen, uso, cp string
+		err := gw.db.QueryRowContext(ctx, `
+			SELECT COALESCE(NULLIF(nombre_fiscal,''), name, ''), COALESCE(regimen_fiscal,''),
+			       COALESCE(uso_cfdi,''), COALESCE(cp,'')
+			FROM loyalty_accounts WHERE tenant_id = $2::uuid AND rfc = $1
+			ORDER BY (COALESCE(nombre_fiscal,'') <> '' AND COALESCE(regimen_fiscal,'') <> '' AND COALESCE(cp,'') <> '') DESC,
+			         updated_at DESC LIMIT 1`, rec.RFC, gw.tenantLealtad(ctx, tid)).Scan(&nombre, &regimen, &uso, &cp)
+		if err == nil {
+			if rec.Nombre == ""  { rec.Nombre = strings.ToUpper(strings.TrimSpace(nombre)) }
+			if rec.Regimen == "" { rec.Regimen = regimen }
+			if rec.Uso == ""     { rec.Uso = uso }
+			if rec.CP == ""      { rec.CP = cp }
+		}
 	}
-	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
-	if err != nil {
-		return nil, fmt.Errorf("payload inválido")
+	if rec.Regimen == "" {
+		if len(rec.RFC) == 13 { rec.Regimen = "612" } else { rec.Regimen = "601" }
 	}
-	var claims jwtClaims
-	if err := json.Unmarshal(payloadBytes, &claims); err != nil {
-		return nil, fmt.Errorf("claims inválidos")
+	if rec.Uso == "" { rec.Uso = "G03" }
+	return rec
+}
+
+// ═══════════════════════════════════════════════════════
+// PORTAL DE LEALTAD — el cliente consulta sus puntos sin pasar por la caja
+// ═══════════════════════════════════════════════════════
+
+// limiteIntentos cuenta solicitudes por clave (IP o cuenta) en una ventana de tiempo. Lo
+// usan los portales públicos (lealtad y autofactura).
+type limiteIntentos struct {
+	sync.Mutex
+	counts   map[string][]time.Time
+	max      int
+	ventana  time.Duration
+	limpieza time.Time
+}
+
+func nuevoLimite(max int, ventana time.Duration) *limiteIntentos {
+	return &limiteIntentos{counts: map[string][]time.Time{}, max: max, ventana: ventana, limpieza: time.Now()}
+}
+
+func (l *limiteIntentos) permitir(clave string) bool {
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	// Una vez por ventana se descartan las claves sin intentos recientes, para que el mapa
+	// no crezca con cada IP que alguna vez pasó por el portal
+	if now.Sub(l.limpieza) >= l.ventana {
+		for k, ts := range l.counts {
+			if len(ts) == 0 || now.Sub(ts[len(ts)-1]) >= l.ventana { delete(l.counts, k) }
+		}
+		l.limpieza = now
 	}
-	if time.Now().Unix() > claims.Exp {
-		return nil, fmt.Errorf("token expirado")
+	var recent []time.Time
+	for _, t := range l.counts[clave] {
+		if now.Sub(t) < l.ventana { recent = append(recent, t) }
+	}
+	if len(recent) >= l.max {
+		l.counts[clave] = recent
+		return false
 	}
-	return &claims, nil
+	l.counts[clave] = append(recent, now)
+	return true
 }
 
-// jwtMiddleware valida el token en todas las rutas excepto /api/v1/login y /
-func (gw *Gateway) handlePushRegister(w http.ResponseWriter, r *http.Request) {
+var (
+	portalIPs      = nuevoLimite(20, time.Hour)
+	portalCuentas  = nuevoLimite(3, 15*time.Minute)
+	autofacturaIPs = nuevoLimite(10, time.Hour)
+
+	rfcPattern     = regexp.MustCompile(`^[A-ZÑ&]{3,4}[0-9]{6}[A-Z0-9]{3}$`)
+	regimenPattern = regexp.MustCompile(`^[0-9]{3}$`)
+	usoCFDIPattern = regexp.MustCompile(`^[A-Z]{1,2}[0-9]{2}$`)
+)
+
+const (
+	vigenciaCodigoPortal = 10 * time.Minute
+	vigenciaSesionPortal = 24 * time.Hour
+	maxIntentosCodigo    = 5
+)
+
+func hashPortal(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// handlePortalLealtad atiende /api/v1/portal-lealtad/{tenant}/...:
+//   GET  /{tenant}            nombre del negocio
+//   POST /{tenant}/codigo     {identificador}: manda un código de un solo uso al correo del cliente
+//   POST /{tenant}/verificar  {identificador, codigo}: devuelve el token de sesión
+//   GET  /{tenant}/cuenta     puntos, tier, historial, recompensas y datos fiscales (X-Portal-Token)
+//   PUT  /{tenant}/cuenta     {rfc, nombre_fiscal, cp, regimen, uso}: actualiza los datos fiscales
+//   POST /{tenant}/salir      cierra la sesión
+func (gw *Gateway) handlePortalLealtad(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	if r.Method == http.MethodOptions { w.WriteHeader(http.StatusOK); return }
-	if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
-	var body struct {
-		Token    string `json:"token"`
-		TenantID string `json:"tenant_id"`
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
 	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "token requerido"})
+	partes := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/portal-lealtad/"), "/"), "/")
+	tid, accion := partes[0], ""
+	if len(partes) > 1 { accion = partes[1] }
+
+	var negocio string
+	if err := gw.db.QueryRowContext(r.Context(), `SELECT nombre FROM tenants WHERE id::text=$1 AND active=true`, tid).Scan(&negocio); err != nil {
+		fail(http.StatusNotFound, "negocio no encontrado")
 		return
 	}
-	// Guardar token en DB
-	_, err := gw.db.Exec(`INSERT INTO push_tokens (tenant_id, token, created_at)
-		VALUES ($1, $2, NOW())
-		ON CONFLICT (token) DO UPDATE SET updated_at = NOW()`,
-		body.TenantID, body.Token)
+	programaID := gw.tenantLealtad(r.Context(), tid)
+
+	switch {
+	case r.Method == http.MethodGet && accion == "":
+		json.NewEncoder(w).Encode(map[string]interface{}{"nombre": negocio})
+
+	case r.Method == http.MethodPost && (accion == "codigo" || accion == "verificar"):
+		if !portalIPs.permitir(clientIP(r)) {
+			fail(http.StatusTooManyRequests, "Demasiados intentos. Espera un momento e intenta de nuevo.")
+			return
+		}
+		var req struct {
+			Identificador string `json:"identificador"`
+			Codigo        string `json:"codigo"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<12)).Decode(&req); err != nil { fail(400, "JSON invalido"); return }
+		req.Identificador = strings.TrimSpace(req.Identificador)
+		if req.Identificador == "" { fail(400, "teléfono o correo requerido"); return }
+		// Con correo se busca la cuenta más reciente que lo tenga registrado; el teléfono de
+		// una cuenta fusionada lleva a la cuenta que la absorbió
+		var accountID, email, nombre string
+		err := gw.db.QueryRowContext(r.Context(), `
+			SELECT id::text, COALESCE(email,''), COALESCE(name,'') FROM loyalty_accounts
+			WHERE tenant_id=$1::uuid AND (phone=$2 OR LOWER(email)=LOWER($2) OR id IN (
+			      SELECT account_id FROM loyalty_telefonos_alternos WHERE tenant_id=$1::uuid AND phone=$2))
+			ORDER BY (phone=$2) DESC, updated_at DESC LIMIT 1`, programaID, req.Identificador).Scan(&accountID, &email, &nombre)
+		if accion == "codigo" {
+			gw.enviarCodigoPortal(w, r, accountID, email, nombre, negocio, err == nil)
+		} else {
+			gw.verificarCodigoPortal(w, r, accountID, strings.TrimSpace(req.Codigo), err == nil)
+		}
+
+	case accion == "cuenta" || (accion == "salir" && r.Method == http.MethodPost):
+		token := r.Header.Get("X-Portal-Token")
+		var accountID, phone string
+		err := gw.db.QueryRowContext(r.Context(), `
+			SELECT a.id::text, a.phone FROM loyalty_portal_sesiones s JOIN loyalty_accounts a ON a.id = s.account_id
+			WHERE s.token_hash=$1 AND s.expires_at > NOW() AND a.tenant_id=$2::uuid`, hashPortal(token), programaID).
+			Scan(&accountID, &phone)
+		if token == "" || err != nil { fail(http.StatusUnauthorized, "sesión expirada, solicita un nuevo código"); return }
+		switch {
+		case accion == "salir":
+			gw.db.ExecContext(r.Context(), `DELETE FROM loyalty_portal_sesiones WHERE token_hash=$1`, hashPortal(token))
+			json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+		case r.Method == http.MethodGet:
+			gw.responderCuentaPortal(w, r, tid, accountID, phone)
+		case r.Method == http.MethodPut:
+			gw.actualizarFiscalesPortal(w, r, accountID)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+
+	default:
+		fail(http.StatusNotFound, "ruta no encontrada")
+	}
+}
+
+// enviarCodigoPortal responde lo mismo exista o no la cuenta, para no revelar qué
+// teléfonos o correos están registrados
+func (gw *Gateway) enviarCodigoPortal(w http.ResponseWriter, r *http.Request, accountID, email, nombre, negocio string, existe bool) {
+	respuesta := map[string]interface{}{"ok": true, "message": "Si tus datos están registrados, te enviamos un código a tu correo"}
+	if !existe || email == "" || !portalCuentas.permitir(accountID) {
+		if existe && email == "" { log.Printf("[Portal] Cuenta %s sin correo, no se puede mandar código", accountID) }
+		json.NewEncoder(w).Encode(respuesta)
+		return
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
 	if err != nil {
-		log.Printf("[Push] Error guardando token: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "no fue posible generar el código"})
+		return
 	}
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	codigo := fmt.Sprintf("%06d", n.Int64())
+	// Solo el código más reciente sirve
+	gw.db.ExecContext(r.Context(), `UPDATE loyalty_portal_codigos SET usado_at=NOW() WHERE account_id=$1::uuid AND usado_at IS NULL`, accountID)
+	if _, err := gw.db.ExecContext(r.Context(), `
+		INSERT INTO loyalty_portal_codigos (account_id, codigo_hash, expires_at) VALUES ($1::uuid, $2, $3)`,
+		accountID, hashPortal(accountID+":"+codigo), time.Now().Add(vigenciaCodigoPortal)); err != nil {
+		log.Printf("[Portal] Error guardando código: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "no fue posible generar el código"})
+		return
+	}
+	emailCodigoPortal(email, nombre, negocio, codigo)
+	log.Printf("[Portal] Código enviado a cuenta %s", accountID)
+	json.NewEncoder(w).Encode(respuesta)
 }
 
-func (gw *Gateway) handleConfigNegocio(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	if r.Method == http.MethodOptions { w.WriteHeader(http.StatusOK); return }
-	// Solo acepta POST, guarda en memoria por ahora
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+func (gw *Gateway) verificarCodigoPortal(w http.ResponseWriter, r *http.Request, accountID, codigo string, existe bool) {
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+	var codigoID, guardado string
+	var intentos int
+	if existe {
+		err := gw.db.QueryRowContext(r.Context(), `
+			SELECT id::text, codigo_hash, intentos FROM loyalty_portal_codigos
+			WHERE account_id=$1::uuid AND usado_at IS NULL AND expires_at > NOW()
+			ORDER BY created_at DESC LIMIT 1`, accountID).Scan(&codigoID, &guardado, &intentos)
+		existe = err == nil
+	}
+	if !existe { fail(400, "Código incorrecto o vencido"); return }
+	if intentos >= maxIntentosCodigo {
+		gw.db.ExecContext(r.Context(), `UPDATE loyalty_portal_codigos SET usado_at=NOW() WHERE id=$1::uuid`, codigoID)
+		fail(http.StatusTooManyRequests, "Demasiados intentos, solicita un código nuevo")
+		return
+	}
+	if !hmac.Equal([]byte(hashPortal(accountID+":"+codigo)), []byte(guardado)) {
+		gw.db.ExecContext(r.Context(), `UPDATE loyalty_portal_codigos SET intentos=intentos+1 WHERE id=$1::uuid`, codigoID)
+		fail(400, "Código incorrecto o vencido")
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil { fail(500, "no fue posible iniciar sesión"); return }
+	token := hex.EncodeToString(b)
+	expira := time.Now().Add(vigenciaSesionPortal)
+	tx, err := gw.db.BeginTx(r.Context(), nil)
+	if err != nil { fail(500, err.Error()); return }
+	defer tx.Rollback()
+	res, err := tx.ExecContext(r.Context(), `UPDATE loyalty_portal_codigos SET usado_at=NOW() WHERE id=$1::uuid AND usado_at IS NULL`, codigoID)
+	if err != nil { fail(500, err.Error()); return }
+	if n, _ := res.RowsAffected(); n == 0 { fail(400, "Código incorrecto o vencido"); return }
+	if _, err := tx.ExecContext(r.Context(), `
+		INSERT INTO loyalty_portal_sesiones (token_hash, account_id, expires_at) VALUES ($1, $2::uuid, $3)`,
+		hashPortal(token), accountID, expira); err != nil {
+		fail(500, err.Error())
+		return
+	}
+	tx.ExecContext(r.Context(), `DELETE FROM loyalty_portal_sesiones WHERE account_id=$1::uuid AND expires_at <= NOW()`, accountID)
+	if err := tx.Commit(); err != nil { fail(500, err.Error()); return }
+	json.NewEncoder(w).Encode(map[string]interface{}{"token": token, "expires_at": expira.Format(time.RFC3339)})
 }
 
-func (gw *Gateway) sendPushNotification(tenantID, title, body string) {
-	rows, err := gw.db.Query(`SELECT token FROM push_tokens WHERE tenant_id=$1`, tenantID)
-	if err != nil { log.Printf("[Push] DB error: %v", err); return }
-	defer rows.Close()
-	var tokens []string
-	for rows.Next() {
-		var token string
-		if err := rows.Scan(&token); err == nil { tokens = append(tokens, token) }
+func (gw *Gateway) responderCuentaPortal(w http.ResponseWriter, r *http.Request, tid, accountID, phone string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	acc, err := gw.loyaltyClient.GetAccount(ctx, &pb_loyalty.GetAccountRequest{Phone: phone, TenantId: tid})
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]string{"error": "no fue posible consultar tus puntos"})
+		return
 	}
-	if len(tokens) == 0 { return }
-	go func() {
-		ctx := context.Background()
-		app, err := firebase.NewApp(ctx, nil, option.WithCredentialsFile("secrets/firebase-sa.json"))
-		if err != nil { log.Printf("[Push] Firebase init error: %v", err); return }
-		client, err := app.Messaging(ctx)
-		if err != nil { log.Printf("[Push] Messaging error: %v", err); return }
-		for _, token := range tokens {
-			msg := &messaging.Message{
-				Token: token,
-				Notification: &messaging.Notification{Title: title, Body: body},
-				Android: &messaging.AndroidConfig{Priority: "high"},
-				APNS: &messaging.APNSConfig{
-					Payload: &messaging.APNSPayload{
-						Aps: &messaging.Aps{Sound: "default"},
-					},
-				},
-			}
-			_, err := client.Send(ctx, msg)
-			if err != nil {
-				log.Printf("[Push] Send error token=%s... %v", token[:15], err)
-			} else {
-				log.Printf("[Push] OK enviado a %s... titulo: %s", token[:15], title)
-			}
-		}
-	}()
+	historial := []map[string]interface{}{}
+	hist, _ := gw.loyaltyClient.GetHistory(ctx, &pb_loyalty.GetHistoryRequest{Phone: phone, Limit: 50, TenantId: tid})
+	for _, t := range hist.GetTransactions() {
+		historial = append(historial, map[string]interface{}{
+			"type": t.GetType(), "points": t.GetPoints(), "description": t.GetDescription(), "date": t.GetCreatedAt(),
+		})
+	}
+	recompensas := []rewardJSON{}
+	if res, err := gw.loyaltyClient.ListRewards(ctx, &pb_loyalty.ListRewardsRequest{TenantId: tid}); err == nil {
+		for _, rw := range res.GetRewards() { recompensas = append(recompensas, rewardDesdePB(rw)) }
+	}
+	// Código para invitar amigos, solo si el negocio da bonos por referidos
+	var codigoReferido string
+	if ref, err := gw.loyaltyClient.GetReferralStats(ctx, &pb_loyalty.ReferralStatsRequest{TenantId: tid, AccountId: accountID}); err == nil {
+		codigoReferido = ref.GetCode()
+	}
+	var email, rfc, nombreFiscal, cp, regimen, uso string
+	gw.db.QueryRowContext(ctx, `
+		SELECT COALESCE(email,''), COALESCE(rfc,''), COALESCE(nombre_fiscal,''), COALESCE(cp,''),
+		       COALESCE(regimen_fiscal,''), COALESCE(uso_cfdi,'')
+		FROM loyalty_accounts WHERE id=$1::uuid`, accountID).Scan(&email, &rfc, &nombreFiscal, &cp, &regimen, &uso)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"account": map[string]interface{}{
+			"phone": acc.GetPhone(), "name": acc.GetName(), "email": email, "points": acc.GetPoints(),
+			"tier": acc.GetTier(), "tier_name": acc.GetTierName(), "tier_discount_pct": acc.GetTierDiscountPct(),
+			"tier_grace_until": acc.GetTierGraceUntil(), "codigo_referido": codigoReferido,
+		},
+		"points_expiring": hist.GetPointsExpiring(), "next_expiration": hist.GetNextExpiration(),
+		"history": historial, "rewards": recompensas,
+		"fiscal": map[string]string{"rfc": rfc, "nombre_fiscal": nombreFiscal, "cp": cp, "regimen": regimen, "uso": uso},
+	})
 }
 
-func jwtMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Rutas públicas
-		if r.URL.Path == "/" || r.URL.Path == "/index.html" ||
-			r.URL.Path == "/manifest.json" || r.URL.Path == "/firebase-messaging-sw.js" ||
-			r.URL.Path == "/icon-192.png" || r.URL.Path == "/icon-512.png" ||
-			r.URL.Path == "/api/v1/push/register" ||
-			r.URL.Path == "/api/v1/login" || r.URL.Path == "/api/v1/status" {
-			next.ServeHTTP(w, r)
-			return
-		}
-		// Preflight CORS
-		if r.Method == http.MethodOptions {
-			next.ServeHTTP(w, r)
-			return
-		}
-		// Extraer token
-		auth := r.Header.Get("Authorization")
-		if !strings.HasPrefix(auth, "Bearer ") {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(map[string]string{"error": "token requerido"})
-			return
+func (gw *Gateway) actualizarFiscalesPortal(w http.ResponseWriter, r *http.Request, accountID string) {
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+	var req struct {
+		RFC          string `json:"rfc"`
+		NombreFiscal string `json:"nombre_fiscal"`
+		CP           string `json:"cp"`
+		Regimen      string `json:"regimen"`
+		Uso          string `json:"uso"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<12)).Decode(&req); err != nil { fail(400, "JSON invalido"); return }
+	req.RFC = strings.ToUpper(strings.TrimSpace(req.RFC))
+	req.NombreFiscal = strings.ToUpper(strings.TrimSpace(req.NombreFiscal))
+	req.CP, req.Regimen, req.Uso = strings.TrimSpace(req.CP), strings.TrimSpace(req.Regimen), strings.ToUpper(strings.TrimSpace(req.Uso))
+	switch {
+	case !rfcPattern.MatchString(req.RFC):
+		fail(400, "RFC inválido")
+	case req.NombreFiscal == "" || len(req.NombreFiscal) > 255:
+		fail(400, "nombre o razón social requerido (como en tu constancia)")
+	case !cpPattern.MatchString(req.CP):
+		fail(400, "código postal fiscal inválido")
+	case !regimenPattern.MatchString(req.Regimen):
+		fail(400, "régimen fiscal inválido")
+	case req.Uso != "" && !usoCFDIPattern.MatchString(req.Uso):
+		fail(400, "uso del CFDI inválido")
+	default:
+		_, err := gw.db.ExecContext(r.Context(), `
+			UPDATE loyalty_accounts SET rfc=$1, nombre_fiscal=$2, cp=$3, regimen_fiscal=$4, uso_cfdi=NULLIF($5,''), updated_at=NOW()
+			WHERE id=$6::uuid`, req.RFC, req.NombreFiscal, req.CP, req.Regimen, req.Uso, accountID)
+		if err != nil { fail(500, "no fue posible guardar tus datos"); return }
+		log.Printf("[Portal] Datos fiscales actualizados cuenta=%s rfc=%s", accountID, req.RFC)
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	}
+}
+
+// ═══════════════════════════════════════════════════════
+// AUTOFACTURACIÓN — portal público donde el cliente factura su ticket
+// ═══════════════════════════════════════════════════════
+
+// proxiesConfiables son las IPs o redes (CIDR) de TRUSTED_PROXIES, separadas por comas:
+// los proxies inversos cuyo X-Real-IP se cree. Sin configurar, nadie lo es.
+var proxiesConfiables = parseProxies(os.Getenv("TRUSTED_PROXIES"))
+
+func parseProxies(lista string) []*net.IPNet {
+	var redes []*net.IPNet
+	for _, p := range strings.Split(lista, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" { continue }
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil { p += "/32" } else { p += "/128" }
 		}
-		claims, err := validateJWT(strings.TrimPrefix(auth, "Bearer "))
+		_, red, err := net.ParseCIDR(p)
 		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-			return
-		}
-		// Inyectar tenant del token si no viene header explícito
-		if r.Header.Get("X-Tenant-ID") == "" && claims.TenantID != "" {
-			r.Header.Set("X-Tenant-ID", claims.TenantID)
+			log.Printf("[BFF] TRUSTED_PROXIES: %q ignorado: %v", p, err)
+			continue
 		}
-		next.ServeHTTP(w, r)
-	})
+		redes = append(redes, red)
+	}
+	return redes
 }
 
-// POST /api/v1/login — autentica usuario y devuelve JWT
-func (gw *Gateway) handleLogin(w http.ResponseWriter, r *http.Request) {
-	// Rate limiting
-	ip := r.Header.Get("X-Real-IP")
-	if ip == "" { ip = r.RemoteAddr }
-	if !checkRateLimit(ip) {
-		w.WriteHeader(http.StatusTooManyRequests)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Demasiados intentos. Espera 15 minutos."})
-		return
+// clientIP es la IP del cliente para los límites por IP. X-Real-IP solo se acepta si la
+// conexión viene de un proxy de TRUSTED_PROXIES; de cualquier otro, el encabezado lo
+// escribe el propio cliente y bastaría cambiarlo para saltarse el límite.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil { host = r.RemoteAddr }
+	if ip := net.ParseIP(host); ip != nil {
+		for _, red := range proxiesConfiables {
+			if !red.Contains(ip) { continue }
+			if real := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); real != nil { return real.String() }
+			break
+		}
 	}
-	w.Header().Set("Content-Type", "application/json")
-	// Rate limiting: max 5 intentos por IP en 5 minutos
-	ip = r.RemoteAddr
-	if i := strings.LastIndex(ip, ":"); i != -1 { ip = ip[:i] }
-	loginAttempts.Lock()
-	now := time.Now()
-	attempts := loginAttempts.counts[ip]
-	var recent []time.Time
-	for _, t := range attempts { if now.Sub(t) < 5*time.Minute { recent = append(recent, t) } }
-	loginAttempts.counts[ip] = recent
-	loginAttempts.Unlock()
-	if len(recent) >= 5 {
-		w.WriteHeader(http.StatusTooManyRequests)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Demasiados intentos. Espera 5 minutos."})
+	return host
+}
+
+var (
+	folioTicketPattern = regexp.MustCompile(`^[0-9A-F]{8}$`)
+	cpPattern          = regexp.MustCompile(`^[0-9]{5}$`)
+)
+
+// handleAutofactura atiende /api/v1/autofactura/{tenant}[/xml|/pdf]:
+//   GET  /{tenant}                 datos del negocio para el portal
+//   POST /{tenant}                 timbra el ticket {folio, total, rfc, nombre, cp, regimen, uso, email}
+//   GET  /{tenant}/xml?uuid=...    descarga el XML timbrado
+//   GET  /{tenant}/pdf?uuid=...    descarga la representación impresa
+func (gw *Gateway) handleAutofactura(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == http.MethodOptions { w.WriteHeader(http.StatusOK); return }
+	partes := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/autofactura/"), "/"), "/")
+	tid := partes[0]
+	accion := ""
+	if len(partes) > 1 { accion = partes[1] }
+
+	var nombre, razon, rfcEmisor, regimenEmisor, cpEmisor string
+	var dias int
+	err := gw.db.QueryRowContext(r.Context(), `
+		SELECT nombre, COALESCE(razon_social,''), COALESCE(rfc,''), COALESCE(regimen_fiscal,''),
+		       COALESCE(codigo_postal,''), autofactura_dias
+		FROM tenants WHERE id::text=$1 AND active=true`, tid).
+		Scan(&nombre, &razon, &rfcEmisor, &regimenEmisor, &cpEmisor, &dias)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "negocio no encontrado"})
 		return
 	}
-	if r.Method != http.MethodPost {
+
+	switch {
+	case r.Method == http.MethodGet && accion == "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"nombre": nombre, "razon_social": razon, "rfc": rfcEmisor, "dias_para_facturar": dias,
+		})
+	case r.Method == http.MethodGet && (accion == "xml" || accion == "pdf"):
+		gw.descargarAutofactura(w, r, tid, accion)
+	case r.Method == http.MethodPost && accion == "":
+		gw.timbrarAutofactura(w, r, tid, nombre, regimenEmisor, cpEmisor, dias)
+	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (gw *Gateway) timbrarAutofactura(w http.ResponseWriter, r *http.Request, tid, negocio, regimenEmisor, cpEmisor string, dias int) {
+	w.Header().Set("Content-Type", "application/json")
+	fail := func(code int, msg string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+	if !autofacturaIPs.permitir(clientIP(r)) {
+		fail(http.StatusTooManyRequests, "Demasiados intentos. Espera una hora e intenta de nuevo.")
 		return
 	}
 	var req struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Folio   string  `json:"folio"`
+		Total   float64 `json:"total"`
+		RFC     string  `json:"rfc"`
+		Nombre  string  `json:"nombre"`
+		CP      string  `json:"cp"`
+		Regimen string  `json:"regimen"`
+		Uso     string  `json:"uso"`
+		Email   string  `json:"email"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "JSON inválido"})
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&req); err != nil {
+		fail(http.StatusBadRequest, "JSON invalido")
 		return
 	}
-	if req.Username == "" || req.Password == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "username y password requeridos"})
+	req.Folio = strings.ToUpper(strings.TrimSpace(req.Folio))
+	req.RFC = strings.ToUpper(strings.TrimSpace(req.RFC))
+	if !folioTicketPattern.MatchString(req.Folio) || req.Total <= 0 {
+		fail(http.StatusBadRequest, "folio y total del ticket requeridos")
+		return
+	}
+	if req.RFC == "" || req.RFC == "XAXX010101000" {
+		fail(http.StatusBadRequest, "RFC requerido")
+		return
+	}
+
+	// Buscar el ticket por folio impreso (primeros 8 caracteres del id) y total
+	rows, err := gw.db.QueryContext(r.Context(), `
+		SELECT id, total, status, COALESCE(cfdi_uuid,''), created_at, COALESCE(sucursal,''), moneda, tipo_cambio::text
+		FROM sales
+		WHERE tenant_id=$1::uuid AND UPPER(LEFT(id::text, 8))=$2 AND ABS(total - $3) < 0.005
+		LIMIT 2`, tid, req.Folio, req.Total)
+	if err != nil { fail(http.StatusInternalServerError, "error consultando ticket"); return }
+	var saleID, saleStatus, uuidPrevio, sucursal, moneda, tipoCambio string
+	var total float64
+	var creada time.Time
+	encontrados := 0
+	for rows.Next() {
+		rows.Scan(&saleID, &total, &saleStatus, &uuidPrevio, &creada, &sucursal, &moneda, &tipoCambio)
+		encontrados++
+	}
+	rows.Close()
+	switch {
+	case encontrados == 0:
+		fail(http.StatusNotFound, "No encontramos un ticket con ese folio y total")
+		return
+	case encontrados > 1:
+		fail(http.StatusConflict, "El folio es ambiguo, solicita tu factura en el negocio")
+		return
+	case saleStatus != "completed":
+		fail(http.StatusConflict, "El ticket fue cancelado")
+		return
+	case uuidPrevio != "":
+		fail(http.StatusConflict, "Este ticket ya fue facturado")
+		return
+	case time.Since(creada) > time.Duration(dias)*24*time.Hour:
+		fail(http.StatusGone, fmt.Sprintf("El plazo para facturar este ticket (%d días) ya venció", dias))
+		return
+	}
+
+	if !cpPattern.MatchString(strings.TrimSpace(req.CP)) {
+		// El CP puede venir del perfil fiscal guardado
+		req.CP = ""
+	}
+	receptor := gw.completarReceptor(r.Context(), tid, receptorFiscal{
+		RFC: req.RFC, Nombre: strings.ToUpper(strings.TrimSpace(req.Nombre)),
+		Regimen: req.Regimen, Uso: req.Uso, CP: strings.TrimSpace(req.CP),
+	})
+	if receptor.Nombre == "" || receptor.CP == "" {
+		fail(http.StatusBadRequest, "nombre o razón social y código postal fiscal requeridos")
 		return
 	}
 
-	// Verificar credenciales en la DB
-	// La tabla users tiene: id, username, password_hash, role, tenant_id
-	// Soporte de password en texto plano para simplificar (mejorar a bcrypt en producción)
-	var userID, role, tenantID string
-	var storedHash string
-	err := gw.db.QueryRow(
-		`SELECT id, 'admin', COALESCE(tenant_id::text,$2), password_hash FROM users WHERE email=$1 LIMIT 1`,
-		req.Username, defaultTenantID,
-	).Scan(&userID, &role, &tenantID, &storedHash)
+	certB64, keyBytes, keyPass, _, ok := gw.loadTenantCSD(tid)
+	if !ok {
+		fail(http.StatusServiceUnavailable, negocio+" aún no tiene facturación configurada")
+		return
+	}
 
+	// Apartar el ticket para que dos solicitudes simultáneas no lo timbren dos veces
+	res, err := gw.db.ExecContext(r.Context(), `
+		UPDATE sales SET cfdi_status='timbrando'
+		WHERE id=$1::uuid AND cfdi_uuid IS NULL AND COALESCE(cfdi_status,'') NOT IN ('timbrando', 'por_conciliar')`, saleID)
 	if err != nil {
-		// Si no existe la tabla users o el usuario, permitir credenciales de admin hardcoded
-		adminUser := getenv("ADMIN_USER", "admin")
-		adminPass := getenv("ADMIN_PASS", "turbopos2026")
-		if req.Username != adminUser || req.Password != adminPass {
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(map[string]string{"error": "credenciales incorrectas"})
-			return
-		}
-		userID = "admin"
-		role = "admin"
-		tenantID = defaultTenantID
-	} else if err2 := bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(req.Password)); err2 != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": "credenciales incorrectas"})
+		fail(http.StatusInternalServerError, "error apartando ticket")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		fail(http.StatusConflict, "Este ticket ya se está facturando")
 		return
 	}
 
-	token, err := generateJWT(req.Username, role, tenantID)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tRes, err := gw.timbrarVenta(ctx, tid, sucursal, &pb_cfdi.FacturaRequest{
+		VentaId: saleID, Total: total, Rfc: receptor.RFC, CodigoPostalReceptor: receptor.CP,
+		NombreReceptor: receptor.Nombre, RegimenFiscalReceptor: receptor.Regimen, UsoCfdi: receptor.Uso,
+		CertB64: certB64, KeyBytes: keyBytes, KeyPassword: keyPass, TenantId: tid,
+		RegimenFiscalEmisor: regimenEmisor, LugarExpedicion: cpEmisor,
+		Moneda: moneda, TipoCambio: tipoCambio,
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "error generando token"})
+		gw.db.Exec(`UPDATE sales SET cfdi_status=NULL WHERE id=$1::uuid AND cfdi_status='timbrando'`, saleID)
+		log.Printf("[BFF] Autofactura error tenant=%s sale=%s: %v", tid, saleID, err)
+		if status.Code(err) == codes.InvalidArgument {
+			fail(http.StatusBadRequest, status.Convert(err).Message())
+		} else {
+			fail(http.StatusBadGateway, "No fue posible timbrar en este momento, intenta más tarde")
+		}
 		return
 	}
-	log.Printf("[BFF] Login: user=%s role=%s tenant=%s", req.Username, role, tenantID)
+	// El CFDI ya existe ante el SAT; si no se registra, la venta se queda en 'timbrando'
+	// (no se vuelve a timbrar) y la alerta permite conciliarla a mano
+	if _, err := gw.db.Exec(`
+		UPDATE sales SET cfdi_uuid=$1, cfdi_status='timbrado', cfdi_rfc_receptor=$2, cfdi_nombre_receptor=$3,
+		       cfdi_xml=$4, cfdi_origen='autofactura', cfdi_timbrado_at=NOW()
+		WHERE id=$5::uuid`, tRes.GetUuid(), receptor.RFC, receptor.Nombre, tRes.GetXml(), saleID); err != nil {
+		log.Printf("[BFF] ALERTA: autofactura sale=%s timbrada (uuid=%s) pero no registrada: %v", saleID, tRes.GetUuid(), err)
+	}
+	log.Printf("[BFF] Autofactura OK tenant=%s sale=%s uuid=%s rfc=%s", tid, saleID, tRes.GetUuid(), receptor.RFC)
+
+	base := "/api/v1/autofactura/" + tid
+	xmlURL := base + "/xml?uuid=" + tRes.GetUuid()
+	pdfURL := base + "/pdf?uuid=" + tRes.GetUuid()
+	if req.Email != "" { emailAutofactura(req.Email, negocio, tRes.GetUuid(), xmlURL, pdfURL) }
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"token":     token,
-		"user":      req.Username,
-		"role":      role,
-		"tenant_id": tenantID,
-		"expires":   time.Now().Add(12 * time.Hour).Format(time.RFC3339),
+		"ok": true, "uuid": tRes.GetUuid(), "rfc": receptor.RFC, "nombre": receptor.Nombre,
+		"total": total, "xml_url": xmlURL, "pdf_url": pdfURL,
 	})
 }
 
-// ─── Tenant middleware ────────────────────────────────────────────────────────
-// tenantIDFromRequest extrae el tenant_id del header X-Tenant-ID
-// Si no viene, usa el tenant demo por defecto
-const defaultTenantID = "00000000-0000-0000-0000-000000000001"
-
-func tenantID(r *http.Request) string {
-	if t := r.Header.Get("X-Tenant-ID"); t != "" {
-		return t
+// descargarAutofactura entrega el XML o el PDF de una factura que el cliente timbró en el
+// portal. Las facturas que emitió el negocio no se exponen aquí aunque se conozca su UUID.
+// Las descargas por IP se limitan aparte de los timbrados, con el mismo límite.
+func (gw *Gateway) descargarAutofactura(w http.ResponseWriter, r *http.Request, tid, formato string) {
+	if !autofacturaIPs.permitir("descarga|" + clientIP(r)) {
+		http.Error(w, "Demasiadas descargas. Espera una hora e intenta de nuevo.", http.StatusTooManyRequests)
+		return
 	}
-	return defaultTenantID
-}
-
-// tenantInfo contiene los datos del tenant para CFDI
-type tenantInfo struct {
-	ID            string
-	RFC           string
-	RazonSocial   string
-	RegimenFiscal string
-	CodigoPostal  string
-	FinkokUser    string
-	FinkokPass    string
-	CertDERb64    string
-	KeyPEMb64     string
-	KeyPassword   string
-}
-
-func (gw *Gateway) getTenant(r *http.Request) (*tenantInfo, error) {
-	tid := tenantID(r)
-	row := gw.db.QueryRow(`
-		SELECT id, rfc, razon_social, regimen_fiscal, codigo_postal,
-		       COALESCE(finkok_user,''), COALESCE(finkok_pass,''),
-		       COALESCE(cert_der_b64,''), COALESCE(key_pem_b64,''), COALESCE(key_password,'')
-		FROM tenants WHERE id=$1 AND active=true`, tid)
-	t := &tenantInfo{}
-	err := row.Scan(&t.ID, &t.RFC, &t.RazonSocial, &t.RegimenFiscal, &t.CodigoPostal,
-		&t.FinkokUser, &t.FinkokPass, &t.CertDERb64, &t.KeyPEMb64, &t.KeyPassword)
+	uuid := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("uuid")))
+	var xmlTimbrado string
+	err := gw.db.QueryRowContext(r.Context(), `
+		SELECT COALESCE(cfdi_xml,'') FROM sales
+		WHERE tenant_id=$1::uuid AND UPPER(cfdi_uuid)=$2 AND cfdi_origen='autofactura'`, tid, uuid).Scan(&xmlTimbrado)
+	if uuid == "" || err != nil || xmlTimbrado == "" {
+		http.Error(w, "factura no encontrada", http.StatusNotFound)
+		return
+	}
+	if formato == "xml" {
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+uuid+`.xml"`)
+		io.WriteString(w, xmlTimbrado)
+		return
+	}
+	pdf, err := pdfFactura(xmlTimbrado)
 	if err != nil {
-		return nil, fmt.Errorf("tenant no encontrado: %v", err)
+		log.Printf("[BFF] Error generando PDF uuid=%s: %v", uuid, err)
+		http.Error(w, "error generando PDF", http.StatusInternalServerError)
+		return
 	}
-	return t, nil
-}
-
-
-func (gw *Gateway) handleReportes(w http.ResponseWriter, r *http.Request) {
-    w.Header().Set("Content-Type", "application/json")
-    q := r.URL.Query()
-    periodo := q.Get("periodo")
-    desde := q.Get("desde")
-    hasta := q.Get("hasta")
-    now := time.Now()
-    switch periodo {
-    case "semana": desde = now.AddDate(0,0,-6).Format("2006-01-02"); hasta = now.Format("2006-01-02")
-    case "mes":    desde = now.AddDate(0,-1,0).Format("2006-01-02"); hasta = now.Format("2006-01-02")
-    case "año":   desde = now.AddDate(-1,0,0).Format("2006-01-02"); hasta = now.Format("2006-01-02")
-    default:       if desde=="" { desde=now.AddDate(0,0,-6).Format("2006-01-02") }; if hasta=="" { hasta=now.Format("2006-01-02") }
-    }
-    tid := tenantID(r)
-    // Ventas por día
-    rowsDia, err := gw.db.Query(`SELECT DATE(created_at AT TIME ZONE 'America/Monterrey') as dia, COUNT(*) as tx, COALESCE(SUM(CASE WHEN status='completed' THEN total ELSE 0 END),0) as total, COALESCE(SUM(CASE WHEN status='cancelled' THEN 1 ELSE 0 END),0) as canceladas, COALESCE(SUM(CASE WHEN cfdi_uuid IS NOT NULL THEN 1 ELSE 0 END),0) as timbradas FROM sales WHERE DATE(created_at AT TIME ZONE 'America/Monterrey') BETWEEN $1::date AND $2::date AND (tenant_id=$3::uuid OR tenant_id IS NULL) GROUP BY dia ORDER BY dia ASC`, desde, hasta, tid)
-    if err != nil { w.WriteHeader(500); json.NewEncoder(w).Encode(map[string]string{"error":err.Error()}); return }
-    defer rowsDia.Close()
-    type DiaData struct { Dia string `json:"dia"`; Transacciones int64 `json:"transacciones"`; Total float64 `json:"total"`; Canceladas int64 `json:"canceladas"`; Timbradas int64 `json:"timbradas"` }
-    var porDia []DiaData
-    for rowsDia.Next() { var d DiaData; rowsDia.Scan(&d.Dia,&d.Transacciones,&d.Total,&d.Canceladas,&d.Timbradas); porDia=append(porDia,d) }
-    // Top productos
-    rowsProd, _ := gw.db.Query(`SELECT si.name, SUM(si.quantity) as uds, SUM(si.subtotal) as total FROM sale_items si JOIN sales s ON s.id=si.sale_id WHERE s.status='completed' AND DATE(s.created_at AT TIME ZONE 'America/Monterrey') BETWEEN $1::date AND $2::date AND (s.tenant_id=$3::uuid OR s.tenant_id IS NULL) GROUP BY si.name ORDER BY total DESC LIMIT 10`, desde, hasta, tid)
-    type ProdData struct { Nombre string `json:"nombre"`; Unidades int64 `json:"unidades"`; Total float64 `json:"total"` }
-    var topProds []ProdData
-    if rowsProd!=nil { defer rowsProd.Close(); for rowsProd.Next() { var p ProdData; rowsProd.Scan(&p.Nombre,&p.Unidades,&p.Total); topProds=append(topProds,p) } }
-    // Métodos de pago
-    rowsMet, _ := gw.db.Query(`SELECT payment_method, COUNT(*) as tx, COALESCE(SUM(CASE WHEN status='completed' THEN total ELSE 0 END),0) as total FROM sales WHERE DATE(created_at AT TIME ZONE 'America/Monterrey') BETWEEN $1::date AND $2::date AND (tenant_id=$3::uuid OR tenant_id IS NULL) GROUP BY payment_method`, desde, hasta, tid)
-    type MetData struct { Metodo string `json:"metodo"`; Transacciones int64 `json:"transacciones"`; Total float64 `json:"total"` }
-    var metodos []MetData; var grandTotal float64; var grandTx int64
-    if rowsMet!=nil { defer rowsMet.Close(); for rowsMet.Next() { var m MetData; rowsMet.Scan(&m.Metodo,&m.Transacciones,&m.Total); metodos=append(metodos,m); grandTotal+=m.Total; grandTx+=m.Transacciones } }
-    json.NewEncoder(w).Encode(map[string]interface{}{"desde":desde,"hasta":hasta,"por_dia":porDia,"top_prods":topProds,"metodos":metodos,"total":grandTotal,"transacciones":grandTx})
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+uuid+`.pdf"`)
+	w.Write(pdf)
 }
 
-func limitSlice(s []map[string]string, n int) []map[string]string {
-	if len(s) <= n { return s }
-	return s[:n]
+func emailAutofactura(to, negocio, uuid, xmlURL, pdfURL string) {
+	app := getenv("APP_URL", "https://turbopos.mx")
+	body := `<!DOCTYPE html><html><body style="font-family:sans-serif;background:#07090F;color:#EDF2FF;padding:40px">
+	<div style="max-width:520px;margin:0 auto;background:#0D1018;border:1px solid #1C2535;border-radius:16px;padding:40px">
+	<h1 style="color:#FFB547;font-size:24px">Tu factura de ` + negocio + `</h1>
+	<p style="color:#8896B0">Folio fiscal (UUID): <strong>` + uuid + `</strong></p>
+	<a href="` + app + pdfURL + `" style="display:inline-block;padding:14px 28px;background:#FFB547;color:#000;text-decoration:none;border-radius:10px;font-weight:700;margin:24px 8px 24px 0">Descargar PDF</a>
+	<a href="` + app + xmlURL + `" style="display:inline-block;padding:14px 28px;background:#131820;color:#EDF2FF;text-decoration:none;border-radius:10px;font-weight:700">Descargar XML</a>
+	</div></body></html>`
+	go sendEmail(to, "Tu factura de "+negocio, body)
 }
 
-func parseFloat(s string) float64 {
-	s = strings.ReplaceAll(strings.ReplaceAll(strings.TrimSpace(s), "$", ""), ",", "")
-	v, _ := strconv.ParseFloat(s, 64)
-	return v
+// cfdiImpreso son los datos del comprobante timbrado que lleva la representación impresa
+type cfdiImpreso struct {
+	Serie           string `xml:"Serie,attr"`
+	Folio           string `xml:"Folio,attr"`
+	Fecha           string `xml:"Fecha,attr"`
+	SubTotal        string `xml:"SubTotal,attr"`
+	Total           string `xml:"Total,attr"`
+	FormaPago       string `xml:"FormaPago,attr"`
+	MetodoPago      string `xml:"MetodoPago,attr"`
+	LugarExpedicion string `xml:"LugarExpedicion,attr"`
+	NoCertificado   string `xml:"NoCertificado,attr"`
+	Sello           string `xml:"Sello,attr"`
+	Emisor          struct {
+		Rfc           string `xml:"Rfc,attr"`
+		Nombre        string `xml:"Nombre,attr"`
+		RegimenFiscal string `xml:"RegimenFiscal,attr"`
+	} `xml:"Emisor"`
+	Receptor struct {
+		Rfc                     string `xml:"Rfc,attr"`
+		Nombre                  string `xml:"Nombre,attr"`
+		DomicilioFiscalReceptor string `xml:"DomicilioFiscalReceptor,attr"`
+		RegimenFiscalReceptor   string `xml:"RegimenFiscalReceptor,attr"`
+		UsoCFDI                 string `xml:"UsoCFDI,attr"`
+	} `xml:"Receptor"`
+	Conceptos []struct {
+		Cantidad      string `xml:"Cantidad,attr"`
+		Descripcion   string `xml:"Descripcion,attr"`
+		ValorUnitario string `xml:"ValorUnitario,attr"`
+		Importe       string `xml:"Importe,attr"`
+	} `xml:"Conceptos>Concepto"`
+	Impuestos struct {
+		TotalImpuestosTrasladados string `xml:"TotalImpuestosTrasladados,attr"`
+		TotalImpuestosRetenidos   string `xml:"TotalImpuestosRetenidos,attr"`
+		Retenciones               []struct {
+			Impuesto string `xml:"Impuesto,attr"`
+			Importe  string `xml:"Importe,attr"`
+		} `xml:"Retenciones>Retencion"`
+	} `xml:"Impuestos"`
+	Timbre struct {
+		UUID             string `xml:"UUID,attr"`
+		FechaTimbrado    string `xml:"FechaTimbrado,attr"`
+		NoCertificadoSAT string `xml:"NoCertificadoSAT,attr"`
+		SelloSAT         string `xml:"SelloSAT,attr"`
+	} `xml:"Complemento>TimbreFiscalDigital"`
 }
 
-func (gw *Gateway) handleReportesCFDI(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	q := r.URL.Query()
-	desde := q.Get("desde")
-	hasta := q.Get("hasta")
-	periodo := q.Get("periodo")
-	now := time.Now()
-	switch periodo {
-	case "semana": desde = now.AddDate(0,0,-6).Format("2006-01-02"); hasta = now.Format("2006-01-02")
-	case "mes":    desde = now.AddDate(0,-1,0).Format("2006-01-02"); hasta = now.Format("2006-01-02")
-	default:       if desde == "" { desde = now.AddDate(0,0,-6).Format("2006-01-02") }; if hasta == "" { hasta = now.Format("2006-01-02") }
+// pdfFactura arma la representación impresa del CFDI como un PDF de una página
+func pdfFactura(xmlTimbrado string) ([]byte, error) {
+	var c cfdiImpreso
+	if err := xml.Unmarshal([]byte(xmlTimbrado), &c); err != nil {
+		return nil, fmt.Errorf("parsear CFDI: %w", err)
 	}
-	tid := tenantID(r)
-	rows, err := gw.db.QueryContext(r.Context(), `
-		SELECT
-			s.id,
-			DATE(s.created_at AT TIME ZONE 'America/Monterrey') as fecha,
-			s.created_at AT TIME ZONE 'America/Monterrey' as fecha_hora,
-			s.total,
-			s.payment_method,
-			COALESCE(s.cfdi_uuid,'') as uuid,
-			COALESCE(s.cfdi_rfc_receptor, '') as rfc_receptor,
-			COALESCE(s.cfdi_nombre_receptor,'') as nombre_receptor,
-			s.status,
-			COALESCE(s.cfdi_serie,'') as serie,
-			COALESCE(s.cfdi_folio,'') as folio
-		FROM sales s
-		WHERE DATE(s.created_at AT TIME ZONE 'America/Monterrey') BETWEEN $1::date AND $2::date
-		  AND (s.tenant_id = $3::uuid OR s.tenant_id IS NULL)
-		  AND s.cfdi_uuid IS NOT NULL
-		ORDER BY s.created_at DESC
-		LIMIT 500
-	`, desde, hasta, tid)
-	if err != nil {
-		w.WriteHeader(500)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
-		return
+	var ops []string
+	y := 800.0
+	linea := func(x float64, size int, bold bool, texto string) {
+		font := "F1"
+		if bold { font = "F2" }
+		ops = append(ops, fmt.Sprintf("BT /%s %d Tf %.1f %.1f Td (%s) Tj ET", font, size, x, y, pdfEscape(texto)))
 	}
-	defer rows.Close()
-
-	type CFDIRow struct {
-		ID            string  `json:"id"`
-		Fecha         string  `json:"fecha"`
-		FechaHora     string  `json:"fecha_hora"`
-		Total         float64 `json:"total"`
-		Metodo        string  `json:"metodo"`
-		UUID          string  `json:"uuid"`
-		RFCReceptor   string  `json:"rfc_receptor"`
-		NombreReceptor string `json:"nombre_receptor"`
-		Status        string  `json:"status"`
-		Serie         string  `json:"serie"`
-		Folio         string  `json:"folio"`
+	salto := func(dy float64) { y -= dy }
+
+	linea(40, 16, true, c.Emisor.Nombre); salto(18)
+	linea(40, 9, false, "RFC: "+c.Emisor.Rfc+"   Régimen fiscal: "+c.Emisor.RegimenFiscal+"   Lugar de expedición: "+c.LugarExpedicion); salto(24)
+	titulo := "FACTURA CFDI 4.0"
+	if c.Serie+c.Folio != "" { titulo += "   Serie " + c.Serie + " Folio " + c.Folio }
+	linea(40, 11, true, titulo); salto(14)
+	linea(40, 9, false, "Folio fiscal (UUID): "+c.Timbre.UUID); salto(12)
+	linea(40, 9, false, "Fecha de emisión: "+c.Fecha+"   Fecha de certificación: "+c.Timbre.FechaTimbrado); salto(12)
+	linea(40, 9, false, "No. certificado emisor: "+c.NoCertificado+"   No. certificado SAT: "+c.Timbre.NoCertificadoSAT); salto(22)
+	linea(40, 10, true, "Receptor"); salto(13)
+	linea(40, 9, false, c.Receptor.Nombre); salto(12)
+	linea(40, 9, false, "RFC: "+c.Receptor.Rfc+"   CP: "+c.Receptor.DomicilioFiscalReceptor+"   Régimen: "+c.Receptor.RegimenFiscalReceptor+"   Uso CFDI: "+c.Receptor.UsoCFDI); salto(22)
+
+	linea(40, 9, true, "Cantidad"); linea(100, 9, true, "Descripción"); linea(400, 9, true, "Valor unitario"); linea(500, 9, true, "Importe"); salto(13)
+	for i, con := range c.Conceptos {
+		if i == 30 {
+			linea(40, 9, false, fmt.Sprintf("... y %d conceptos más (ver XML)", len(c.Conceptos)-30)); salto(12)
+			break
+		}
+		desc := con.Descripcion
+		if len([]rune(desc)) > 55 { desc = string([]rune(desc)[:55]) + "..." }
+		linea(40, 9, false, con.Cantidad); linea(100, 9, false, desc)
+		linea(400, 9, false, "$"+con.ValorUnitario); linea(500, 9, false, "$"+con.Importe); salto(12)
 	}
-	var cfdis []CFDIRow
-	var totalTimbrado float64
-	for rows.Next() {
-		var c CFDIRow
-		rows.Scan(&c.ID, &c.Fecha, &c.FechaHora, &c.Total, &c.Metodo,
-			&c.UUID, &c.RFCReceptor, &c.NombreReceptor, &c.Status, &c.Serie, &c.Folio)
-		cfdis = append(cfdis, c)
-		totalTimbrado += c.Total
+	salto(10)
+	linea(400, 9, false, "Subtotal"); linea(500, 9, false, "$"+c.SubTotal); salto(12)
+	linea(400, 9, false, "IVA 16%"); linea(500, 9, false, "$"+c.Impuestos.TotalImpuestosTrasladados); salto(12)
+	for _, ret := range c.Impuestos.Retenciones {
+		nombre := "ISR retenido"
+		if ret.Impuesto == "002" { nombre = "IVA retenido" }
+		linea(400, 9, false, nombre); linea(500, 9, false, "-$"+ret.Importe); salto(12)
 	}
-	if cfdis == nil { cfdis = []CFDIRow{} }
-
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"desde":          desde,
-		"hasta":          hasta,
-		"cfdis":          cfdis,
-		"total_timbrado": totalTimbrado,
-		"count":          len(cfdis),
-	})
+	linea(400, 10, true, "Total"); linea(500, 10, true, "$"+c.Total); salto(14)
+	linea(40, 9, false, "Forma de pago: "+c.FormaPago+"   Método de pago: "+c.MetodoPago); salto(24)
+
+	linea(40, 8, true, "Sello digital del CFDI"); salto(10)
+	for _, l := range partirTexto(c.Sello, 110) { linea(40, 6, false, l); salto(8) }
+	salto(4)
+	linea(40, 8, true, "Sello digital del SAT"); salto(10)
+	for _, l := range partirTexto(c.Timbre.SelloSAT, 110) { linea(40, 6, false, l); salto(8) }
+	salto(8)
+	linea(40, 8, false, "Este documento es una representación impresa de un CFDI. Verifica en https://verificacfdi.facturaelectronica.sat.gob.mx")
+
+	return pdfDocumento(strings.Join(ops, "\n")), nil
 }
 
-// ─── CSD Upload ────────────────────────────────────────────────────────────
-func (gw *Gateway) handleCSDUpload(w http.ResponseWriter, r *http.Request) {
-    if r.Method == http.MethodGet { gw.handleCSDInfo(w, r); return }
-    if r.Method != http.MethodPost { w.WriteHeader(http.StatusMethodNotAllowed); return }
-    if err := r.ParseMultipartForm(10 << 20); err != nil {
-        w.WriteHeader(http.StatusBadRequest)
-        json.NewEncoder(w).Encode(map[string]string{"error": "form invalido"})
-        return
-    }
-    tid := tenantID(r)
-    rfc := r.FormValue("rfc")
-    keyPass := r.FormValue("key_password")
-    if rfc == "" || keyPass == "" {
-        w.WriteHeader(http.StatusBadRequest)
-        json.NewEncoder(w).Encode(map[string]string{"error": "rfc y key_password requeridos"})
-        return
-    }
-
-    // Leer .cer
-    cerFile, _, err := r.FormFile("cer")
-    if err != nil { w.WriteHeader(400); json.NewEncoder(w).Encode(map[string]string{"error": "archivo .cer requerido"}); return }
-    defer cerFile.Close()
-    cerBytes, _ := io.ReadAll(cerFile)
-    certB64 := base64.StdEncoding.EncodeToString(cerBytes)
-
-    // Leer .key
-    keyFile, _, err := r.FormFile("key")
-    if err != nil { w.WriteHeader(400); json.NewEncoder(w).Encode(map[string]string{"error": "archivo .key requerido"}); return }
-    defer keyFile.Close()
-    keyBytes, _ := io.ReadAll(keyFile)
-
-    // Verificar que el par es válido llamando al CFDI service
-    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-    defer cancel()
-    _, err = gw.cfdiClient.Timbrar(ctx, &pb_cfdi.FacturaRequest{
-        VentaId: "00000000-0000-0000-0000-000000000000",
-        Total: 0.01, Rfc: rfc, CertB64: certB64,
-        KeyBytes: keyBytes, KeyPassword: keyPass,
-        CodigoPostalReceptor: "64000",
-    })
-    // Error esperado pero si es de cert/key lo rechazamos
-    if err != nil && (strings.Contains(err.Error(), "desencriptar") || strings.Contains(err.Error(), "cert") || strings.Contains(err.Error(), "key")) {
-        w.WriteHeader(400)
-        json.NewEncoder(w).Encode(map[string]string{"error": "certificado o llave invalidos: " + err.Error()})
-        return
-    }
-
-    // Guardar en DB
-    _, err = gw.db.Exec(`
-        INSERT INTO tenant_csds (tenant_id, rfc_emisor, cert_b64, key_bytes, key_password, updated_at)
-        VALUES ($1::uuid, $2, $3, $4, $5, NOW())
-        ON CONFLICT (tenant_id) DO UPDATE SET
-            rfc_emisor=EXCLUDED.rfc_emisor, cert_b64=EXCLUDED.cert_b64,
-            key_bytes=EXCLUDED.key_bytes, key_password=EXCLUDED.key_password, updated_at=NOW()
-    `, tid, rfc, certB64, keyBytes, keyPass)
-    if err != nil {
-        log.Printf("[BFF] Error guardando CSD: %v", err)
-        w.WriteHeader(500)
-        json.NewEncoder(w).Encode(map[string]string{"error": "error guardando certificado"})
-        return
-    }
-    log.Printf("[BFF] CSD registrado tenant=%s rfc=%s", tid, rfc)
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "rfc": rfc, "mensaje": "Certificado registrado correctamente"})
+func partirTexto(s string, n int) []string {
+	var out []string
+	for len(s) > n {
+		out = append(out, s[:n])
+		s = s[n:]
+	}
+	if s != "" { out = append(out, s) }
+	return out
 }
 
-func (gw *Gateway) handleCSDInfo(w http.ResponseWriter, r *http.Request) {
-    tid := tenantID(r)
-    var rfc string
-    var updatedAt time.Time
-    err := gw.db.QueryRow(`SELECT rfc_emisor, updated_at FROM tenant_csds WHERE tenant_id=$1::uuid`, tid).Scan(&rfc, &updatedAt)
-    w.Header().Set("Content-Type", "application/json")
-    if err != nil {
-        json.NewEncoder(w).Encode(map[string]interface{}{"tiene_csd": false})
-        return
-    }
-    json.NewEncoder(w).Encode(map[string]interface{}{"tiene_csd": true, "rfc": rfc, "actualizado": updatedAt.Format("2006-01-02 15:04")})
+// pdfEscape convierte a WinAnsi (Latin-1) y escapa los delimitadores de cadenas PDF
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\'); b.WriteByte(byte(r))
+		case r < 256:
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
 }
 
-// loadTenantCSD carga cert/key del tenant desde DB
-func (gw *Gateway) loadTenantCSD(tenantID string) (certB64 string, keyBytes []byte, keyPass string, rfc string, ok bool) {
-    err := gw.db.QueryRow(`SELECT rfc_emisor, cert_b64, key_bytes, key_password FROM tenant_csds WHERE tenant_id=$1::uuid`, tenantID).
-        Scan(&rfc, &certB64, &keyBytes, &keyPass)
-    return certB64, keyBytes, keyPass, rfc, err == nil
-}
\ No newline at end of file
+// pdfDocumento envuelve un content stream en un PDF carta de una página con Helvetica
+func pdfDocumento(contenido string) []byte {
+	objetos := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 842] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(contenido), contenido),
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objetos))
+	for i, obj := range objetos {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objetos)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objetos)+1, xref)
+	return buf.Bytes()
+}
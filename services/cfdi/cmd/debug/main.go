@@ -14,8 +14,8 @@ func main() {
         SaleID: "test-xslt",
         Fecha:  time.Date(2026, 2, 25, 12, 0, 0, 0, time.UTC),
         RFC:    "XAXX010101000",
-        Items:  []xmlgen.SaleItem{{Nombre: "Producto Test", Cantidad: 1, PrecioUnitario: 20.00, Subtotal: 20.00}},
-        Total: 20.00, FormaPago: "01", LugarExpedicion: "64000",
+        Items:  []xmlgen.SaleItem{{Nombre: "Producto Test", Cantidad: 1, PrecioUnitario: xmlgen.MoneyFromCents(2000), Subtotal: xmlgen.MoneyFromCents(2000)}},
+        Total: xmlgen.MoneyFromCents(2000), FormaPago: "01", LugarExpedicion: "64000",
     }
     xmlStr, _ := xmlgen.GenerarXML(data, certBase64, noCert)
     os.WriteFile(`C:\Users\one\AppData\Local\Temp\test_cfdi.xml`, []byte(xmlStr), 0644)
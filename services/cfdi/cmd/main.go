@@ -2,15 +2,20 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 	"context"
 
+	boveda "github.com/turbopos/turbopos/services/cfdi/internal/boveda"
+	csd "github.com/turbopos/turbopos/services/cfdi/internal/csd"
+	mockpac "github.com/turbopos/turbopos/services/cfdi/internal/mockpac"
 	finkok "github.com/turbopos/turbopos/services/cfdi/internal/finkok"
 	xmlgen "github.com/turbopos/turbopos/services/cfdi/internal/xmlgen"
 	pb "github.com/turbopos/turbopos/gen/go/proto/cfdi/v1"
@@ -18,6 +23,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"encoding/base64"
 )
 
@@ -30,29 +36,42 @@ const (
 	CertPath        = "services/cfdi/certs/test/eku9003173c9.cer"
 	KeyPath         = "services/cfdi/certs/test/eku9003173c9.key"
 	KeyPassword     = "12345678a"
-	NoCert          = "30001000000500003416"
 	FinkokHealthURL = "https://demo-facturacion.finkok.com/servicios/soap/stamp.wsdl"
 )
 
+// PAC es lo que el servidor usa de un proveedor de timbrado: Finkok o el PAC simulado
+type PAC interface {
+	Timbrar(xmlContent string) (*finkok.StampResult, error)
+	Cancelar(uuid, rfc, motivo, uuidReemplazo, certB64 string, keyBytes []byte, keyPassword string) (*finkok.CancelResult, error)
+	ConsultarEstatus(rfcEmisor, rfcReceptor, total, uuid string) (*finkok.EstatusSAT, error)
+}
+
 type CFDIServer struct {
 	pb.UnimplementedCFDIServiceServer
-	pacs           [2]*finkok.Client
+	pacs           [2]PAC
 	CurrentPAC     int
 	TotalRequests  int64
 	FailedRequests int64
 	mu             sync.RWMutex
 	certBase64     string
+	noCert         string
 	certDER        []byte
 	keyBytes       []byte
+	boveda         *boveda.Boveda
+	// cupos limita los timbrados simultáneos que se envían a cada PAC
+	cupos          [2]chan struct{}
 }
 
 func NewCFDIServer() *CFDIServer {
+	finkokEnv := getenv("FINKOK_ENV", "sandbox")
 	user := os.Getenv("FINKOK_USER")
 	pass := os.Getenv("FINKOK_PASS")
-	if user == "" { log.Fatal("[CFDI] FINKOK_USER no definido") }
-	if pass == "" { log.Fatal("[CFDI] FINKOK_PASS no definido") }
+	if finkokEnv != "mock" {
+		if user == "" { log.Fatal("[CFDI] FINKOK_USER no definido") }
+		if pass == "" { log.Fatal("[CFDI] FINKOK_PASS no definido") }
+	}
 
-	cert, _, err := finkok.LoadCertificate(CertPath)
+	cert, noCert, err := finkok.LoadCertificate(CertPath)
 	if err != nil { log.Fatalf("[CFDI] Error certificado: %v", err) }
 
 	certDER, err := os.ReadFile(CertPath)
@@ -61,9 +80,19 @@ func NewCFDIServer() *CFDIServer {
 	key, err := os.ReadFile(KeyPath)
 	if err != nil { log.Fatalf("[CFDI] Error llave: %v", err) }
 
-	s := &CFDIServer{certBase64: cert, certDER: certDER, keyBytes: key}
-	finkokEnv := getenv("FINKOK_ENV", "sandbox")
-	if finkokEnv == "produccion" {
+	bov, err := boveda.Cargar()
+	if err != nil { log.Fatalf("[CFDI] Llave maestra: %v", err) }
+
+	s := &CFDIServer{certBase64: cert, noCert: noCert, certDER: certDER, keyBytes: key, boveda: bov}
+	cupos, err := strconv.Atoi(getenv("CFDI_PAC_CONCURRENCIA", "8"))
+	if err != nil || cupos < 1 { cupos = 8 }
+	s.cupos[0], s.cupos[1] = make(chan struct{}, cupos), make(chan struct{}, cupos)
+	if finkokEnv == "mock" {
+		log.Println("[CFDI] Modo MOCK — PAC y SAT simulados en memoria")
+		mock := mockpac.New()
+		s.pacs[0] = mock
+		s.pacs[1] = mock
+	} else if finkokEnv == "produccion" {
 		log.Println("[CFDI] Modo PRODUCCION ? Finkok real")
 		s.pacs[0] = finkok.NewClient(user, pass)
 		s.pacs[1] = finkok.NewClient(user, pass)
@@ -120,25 +149,110 @@ func (s *CFDIServer) Timbrar(ctx context.Context, req *pb.FacturaRequest) (*pb.F
 	if pac == 1 { pacName = "PAC_SECUNDARIO" }
 	log.Printf("[CFDI] Timbrando venta=%s rfc=%s pac=%s", req.VentaId, req.Rfc, pacName)
 
-	items := []xmlgen.SaleItem{{
-		Nombre: "Venta POS", Cantidad: 1,
-		PrecioUnitario: float64(req.Total), Subtotal: float64(req.Total),
-	}}
+	rfc := strings.ToUpper(strings.TrimSpace(req.Rfc))
+	regimen := req.GetRegimenFiscalReceptor()
+	uso := req.GetUsoCfdi()
+	if !xmlgen.EsPublicoGeneral(rfc) {
+		if regimen == "" { regimen = xmlgen.RegimenPorDefecto(rfc) }
+		if uso == "" { uso = "G03" }
+		if err := xmlgen.ValidarReceptor(rfc, regimen, uso); err != nil {
+			s.mu.Lock(); s.FailedRequests++; s.mu.Unlock()
+			return nil, status.Errorf(codes.InvalidArgument, "receptor: %v", err)
+		}
+	}
+
+	moneda := strings.ToUpper(strings.TrimSpace(req.GetMoneda()))
+	if moneda == "" { moneda = xmlgen.MonedaNacional }
+	var tipoCambio xmlgen.Money
+	if tc := strings.TrimSpace(req.GetTipoCambio()); tc != "" && moneda != xmlgen.MonedaNacional {
+		var err error
+		if tipoCambio, err = xmlgen.ParseMoney(tc); err != nil {
+			s.mu.Lock(); s.FailedRequests++; s.mu.Unlock()
+			return nil, status.Errorf(codes.InvalidArgument, "tipo de cambio inválido: %q", tc)
+		}
+	}
+	if err := xmlgen.ValidarMoneda(moneda, tipoCambio); err != nil {
+		s.mu.Lock(); s.FailedRequests++; s.mu.Unlock()
+		return nil, status.Errorf(codes.InvalidArgument, "moneda: %v", err)
+	}
+
+	// CSD del tenant si viene en la solicitud; si no, el certificado del servidor
+	certB64, noCert, keyBytes, keyPass := s.certBase64, s.noCert, s.keyBytes, KeyPassword
+	var emisorRFC, emisorNombre string
+	if req.GetCertB64() != "" && len(req.GetKeyBytes()) > 0 {
+		tk, tp, err := s.abrirLlaveTenant(req.GetTenantId(), req.GetKeyBytes(), req.GetKeyPassword())
+		if err != nil {
+			s.mu.Lock(); s.FailedRequests++; s.mu.Unlock()
+			return nil, status.Errorf(codes.FailedPrecondition, "CSD del emisor: %v", err)
+		}
+		info, err := csd.Validar([]byte(req.GetCertB64()), tk, tp, "", time.Now())
+		if err != nil {
+			s.mu.Lock(); s.FailedRequests++; s.mu.Unlock()
+			return nil, status.Errorf(codes.FailedPrecondition, "CSD del emisor: %v", err)
+		}
+		certB64, noCert, keyBytes, keyPass = info.CertificadoBase64(), info.NoCertificado, tk, tp
+		emisorRFC, emisorNombre = info.RFC, info.Nombre
+	}
+
+	lugarExpedicion := req.GetLugarExpedicion()
+	if lugarExpedicion == "" { lugarExpedicion = "64000" }
+
+	// Retenciones: solo una persona moral retiene
+	retenciones := func(perfil string) ([]xmlgen.Retencion, error) {
+		if perfil == "" { perfil = req.GetPerfilRetencion() }
+		if perfil == "" || !xmlgen.EsPersonaMoral(rfc) { return nil, nil }
+		r, ok := xmlgen.PerfilesRetencion[perfil]
+		if !ok { return nil, fmt.Errorf("perfil de retención desconocido: %q", perfil) }
+		return r, nil
+	}
+	var items []xmlgen.SaleItem
+	for _, c := range req.GetConceptos() {
+		ret, err := retenciones(c.GetPerfilRetencion())
+		if err != nil {
+			s.mu.Lock(); s.FailedRequests++; s.mu.Unlock()
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		items = append(items, xmlgen.SaleItem{
+			Nombre: c.GetNombre(), Cantidad: c.GetCantidad(),
+			PrecioUnitario: xmlgen.NewMoney(c.GetPrecioUnitario()), Subtotal: xmlgen.NewMoney(c.GetSubtotal()),
+			Retenciones: ret,
+		})
+	}
+	if len(items) == 0 {
+		ret, err := retenciones("")
+		if err != nil {
+			s.mu.Lock(); s.FailedRequests++; s.mu.Unlock()
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		items = []xmlgen.SaleItem{{
+			Nombre: "Venta POS", Cantidad: 1,
+			PrecioUnitario: xmlgen.NewMoney(req.Total), Subtotal: xmlgen.NewMoney(req.Total),
+			Retenciones: ret,
+		}}
+	}
 	xmlStr, err := xmlgen.GenerarXML(xmlgen.SaleData{
-		SaleID: req.VentaId, Fecha: time.Now(), RFC: req.Rfc,
-		Items: items, Total: float64(req.Total),
-        FormaPago: "01", LugarExpedicion: "64000", CodigoPostalReceptor: req.GetCodigoPostalReceptor(),
-	}, s.certBase64, NoCert)
+		SaleID: req.VentaId, Fecha: time.Now(), RFC: rfc, Serie: req.GetSerie(), Folio: req.GetFolio(),
+		EmisorRFC: emisorRFC, EmisorNombre: emisorNombre, EmisorRegimen: req.GetRegimenFiscalEmisor(),
+		NombreReceptor: req.GetNombreReceptor(), RegimenFiscalReceptor: regimen, UsoCFDI: uso,
+		Items: items, Total: xmlgen.NewMoney(req.Total), Moneda: moneda, TipoCambio: tipoCambio,
+        FormaPago: "01", LugarExpedicion: lugarExpedicion, CodigoPostalReceptor: req.GetCodigoPostalReceptor(),
+	}, certB64, noCert)
 	if err != nil {
 		s.mu.Lock(); s.FailedRequests++; s.mu.Unlock()
 		return nil, status.Errorf(codes.Internal, "generar XML: %v", err)
 	}
-	xmlFirmado, err := xmlgen.FirmarXML(xmlStr, s.keyBytes, KeyPassword)
+	xmlFirmado, err := xmlgen.FirmarXML(xmlStr, keyBytes, keyPass)
 	if err != nil {
 		s.mu.Lock(); s.FailedRequests++; s.mu.Unlock()
 		return nil, status.Errorf(codes.Internal, "firmar XML: %v", err)
 	}
+	select {
+	case s.cupos[pac] <- struct{}{}:
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
 	result, err := s.pacs[pac].Timbrar(xmlFirmado)
+	<-s.cupos[pac]
 	if err != nil {
 		s.mu.Lock(); s.FailedRequests++; s.mu.Unlock()
 		go s.checkFailover()
@@ -150,13 +264,147 @@ func (s *CFDIServer) Timbrar(ctx context.Context, req *pb.FacturaRequest) (*pb.F
 		return nil, status.Errorf(codes.InvalidArgument, "Finkok: %s", result.Error)
 	}
 	log.Printf("[CFDI] Timbrado OK UUID=%s PAC=%s", result.UUID, pacName)
-	return &pb.FacturaResponse{
+	resp := &pb.FacturaResponse{
 		Status: "timbrado", Uuid: result.UUID,
 		SelloSat: result.SelloSAT, PacUsado: int32(pac),
-		Timestamp: time.Now().UnixMilli(),
+		Timestamp: time.Now().UnixMilli(), Xml: result.XML,
+	}
+	dec, _ := xmlgen.DecimalesMoneda(moneda)
+	if d, err := xmlgen.DesglosarEn(items, dec); err == nil && !d.TotalRetenidos.IsZero() {
+		resp.TotalRetenido = d.TotalRetenidos.Format(dec)
+		for _, r := range d.Retenciones {
+			switch r.Impuesto {
+			case xmlgen.ImpuestoISR: resp.IsrRetenido = r.Importe.Format(dec)
+			case xmlgen.ImpuestoIVA: resp.IvaRetenido = r.Importe.Format(dec)
+			}
+		}
+	}
+	return resp, nil
+}
+
+// TimbrarLote timbra las facturas del lote con hasta `concurrencia` comprobantes a la vez
+// (además del límite por PAC de Timbrar) y envía el resultado de cada una al terminar.
+// Los folios los aparta quien llama: cada factura trae el suyo o, con serie en el lote,
+// la factura i lleva folio_inicial+i. Un rechazo que ocurre antes de timbrar se marca
+// como tal para que quien llama pueda reutilizar el folio.
+// Si el cliente se desconecta deja de enviar comprobantes nuevos al PAC.
+func (s *CFDIServer) TimbrarLote(req *pb.TimbrarLoteRequest, stream pb.CFDIService_TimbrarLoteServer) error {
+	facturas := req.GetFacturas()
+	if len(facturas) == 0 {
+		return status.Errorf(codes.InvalidArgument, "el lote no tiene facturas")
+	}
+	if len(facturas) > 500 {
+		return status.Errorf(codes.InvalidArgument, "máximo 500 facturas por lote")
+	}
+	conc := int(req.GetConcurrencia())
+	if conc <= 0 { conc = 4 }
+	if conc > len(facturas) { conc = len(facturas) }
+	if req.GetSerie() != "" && req.GetFolioInicial() < 1 {
+		return status.Errorf(codes.InvalidArgument, "folio_inicial requerido con serie")
+	}
+	ctx := stream.Context()
+	log.Printf("[CFDI] Lote: %d facturas, concurrencia %d, serie %q", len(facturas), conc, req.GetSerie())
+
+	indices := make(chan int)
+	resultados := make(chan *pb.TimbrarLoteProgreso)
+	var wg sync.WaitGroup
+	for w := 0; w < conc; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				f := proto.Clone(facturas[i]).(*pb.FacturaRequest)
+				if req.GetSerie() != "" && f.GetFolio() == "" {
+					f.Serie, f.Folio = req.GetSerie(), strconv.FormatInt(req.GetFolioInicial()+int64(i), 10)
+				}
+				p := &pb.TimbrarLoteProgreso{Indice: int32(i), VentaId: f.GetVentaId(), Folio: f.GetFolio()}
+				res, err := s.Timbrar(ctx, f)
+				if err != nil {
+					p.Error = status.Convert(err).Message()
+					c := status.Code(err)
+					p.Rechazado = c == codes.InvalidArgument || c == codes.FailedPrecondition
+				} else {
+					p.Ok, p.Factura = true, res
+				}
+				resultados <- p
+			}
+		}()
+	}
+	go func() {
+		defer close(indices)
+		for i := range facturas {
+			select {
+			case indices <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() { wg.Wait(); close(resultados) }()
+
+	completadas, fallidas := 0, 0
+	var errEnvio error
+	for p := range resultados {
+		completadas++
+		if !p.Ok { fallidas++ }
+		p.Completadas, p.Total = int32(completadas), int32(len(facturas))
+		// Aunque el cliente ya no escuche, se consumen los resultados de lo que ya se timbró
+		if errEnvio == nil { errEnvio = stream.Send(p) }
+	}
+	log.Printf("[CFDI] Lote terminado: %d/%d procesadas, %d con error", completadas, len(facturas), fallidas)
+	if errEnvio != nil { return errEnvio }
+	return ctx.Err()
+}
+
+// ValidarCSD parsea el .cer, confirma que la llave le corresponde y que es un CSD
+// vigente del RFC emisor. El BFF lo llama antes de guardar el certificado del tenant.
+func (s *CFDIServer) ValidarCSD(ctx context.Context, req *pb.ValidarCSDRequest) (*pb.CSDInfo, error) {
+	if req.GetCertB64() == "" || len(req.GetKeyBytes()) == 0 || req.GetTenantId() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "cert_b64, key_bytes y tenant_id requeridos")
+	}
+	info, err := csd.Validar([]byte(req.GetCertB64()), req.GetKeyBytes(), req.GetKeyPassword(), req.GetRfcEmisor(), time.Now())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	// La llave sale de aquí ya cifrada y ligada al tenant: solo este servicio puede
+	// volver a abrirla, y solo para ese tenant
+	keyCifrada, err := s.boveda.Cifrar(req.GetKeyBytes(), boveda.ContextoCSD(req.GetTenantId(), "key_bytes"))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cifrar llave: %v", err)
+	}
+	passCifrada, err := s.boveda.CifrarTexto(req.GetKeyPassword(), boveda.ContextoCSD(req.GetTenantId(), "key_password"))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cifrar contraseña: %v", err)
+	}
+	log.Printf("[CFDI] CSD valido rfc=%s noCert=%s vence=%s", info.RFC, info.NoCertificado, info.ValidoHasta.Format("2006-01-02"))
+	return &pb.CSDInfo{
+		Rfc: info.RFC, Nombre: info.Nombre, NoCertificado: info.NoCertificado,
+		ValidoDesde: info.ValidoDesde.Unix(), ValidoHasta: info.ValidoHasta.Unix(),
+		KeyBytesCifrada: keyCifrada, KeyPasswordCifrada: passCifrada,
 	}, nil
 }
 
+// abrirLlaveTenant descifra la llave y contraseña del CSD que el BFF guardó cifradas;
+// una llave de otro tenant no abre. Los registros anteriores al cifrado en reposo se
+// aceptan en claro hasta que rotar_llaves los cifre.
+func (s *CFDIServer) abrirLlaveTenant(tenantID string, keyBytes []byte, keyPass string) ([]byte, string, error) {
+	if !boveda.EstaCifrado(keyBytes) {
+		log.Printf("[CFDI] ADVERTENCIA: CSD del tenant sin cifrar, ejecuta rotar_llaves")
+		return keyBytes, keyPass, nil
+	}
+	k, err := s.boveda.Descifrar(keyBytes, boveda.ContextoCSD(tenantID, "key_bytes"))
+	if err != nil {
+		return nil, "", err
+	}
+	p := keyPass
+	if boveda.EstaCifrado([]byte(keyPass)) {
+		if p, err = s.boveda.DescifrarTexto(keyPass, boveda.ContextoCSD(tenantID, "key_password")); err != nil {
+			return nil, "", err
+		}
+	}
+	return k, p, nil
+}
+
 // Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬ HTTP handler para cancelaciÃƒÂ³n Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬Ã¢â€â‚¬
 // GET/POST :50055/cancelar Ã¢â‚¬â€ llamado directamente por el BFF via HTTP
 
@@ -213,6 +461,43 @@ func (s *CFDIServer) serveCancelar(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// POST :50055/estatus — consulta el estado del CFDI en el SAT a través del PAC.
+// Lo usa la conciliación nocturna del BFF.
+func (s *CFDIServer) serveEstatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed); return
+	}
+	var req struct {
+		UUID        string `json:"uuid"`
+		RFCEmisor   string `json:"rfc_emisor"`
+		RFCReceptor string `json:"rfc_receptor"`
+		Total       string `json:"total"`
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UUID == "" || req.RFCEmisor == "" || req.RFCReceptor == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "uuid, rfc_emisor y rfc_receptor requeridos"})
+		return
+	}
+	req.UUID = strings.ToUpper(req.UUID)
+
+	s.mu.RLock(); pac := s.CurrentPAC; s.mu.RUnlock()
+	st, err := s.pacs[pac].ConsultarEstatus(req.RFCEmisor, req.RFCReceptor, req.Total, req.UUID)
+	if err != nil {
+		log.Printf("[CFDI] Error consultando estatus UUID=%s: %v", req.UUID, err)
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"uuid":                req.UUID,
+		"codigo_estatus":      st.CodigoEstatus,
+		"estado":              st.Estado,
+		"es_cancelable":       st.EsCancelable,
+		"estatus_cancelacion": st.EstatusCancelacion,
+	})
+}
+
 func main() {
 	srv := NewCFDIServer()
 
@@ -226,6 +511,7 @@ func main() {
 	// HTTP en :50055 para cancelaciÃƒÂ³n
 	mux := http.NewServeMux()
 	mux.HandleFunc("/cancelar", srv.serveCancelar)
+	mux.HandleFunc("/estatus", srv.serveEstatus)
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status":"ok"}`))
@@ -23,8 +23,8 @@ func main() {
 		SaleID: "test-raw-debug",
 		Fecha:  time.Now(),
 		RFC:    "XAXX010101000",
-		Items:  []xmlgen.SaleItem{{Nombre: "Test", Cantidad: 1, PrecioUnitario: 10.00, Subtotal: 10.00}},
-		Total: 10.00, FormaPago: "01", LugarExpedicion: "64000",
+		Items:  []xmlgen.SaleItem{{Nombre: "Test", Cantidad: 1, PrecioUnitario: xmlgen.MoneyFromCents(1000), Subtotal: xmlgen.MoneyFromCents(1000)}},
+		Total: xmlgen.MoneyFromCents(1000), FormaPago: "01", LugarExpedicion: "64000",
 	}
 	xmlStr, _ := xmlgen.GenerarXML(data, certBase64, noCert)
 	keyBytes, _ := os.ReadFile("services/cfdi/certs/test/eku9003173c9.pem")
@@ -0,0 +1,147 @@
+// rotar_llaves re-cifra los secretos fiscales de los tenants con la llave maestra vigente.
+//
+// Rotación:
+//  1. go run ./services/cfdi/cmd/rotar_llaves -nueva-llave > master.key.nueva
+//  2. TURBOPOS_MASTER_KEY_FILE=master.key.nueva TURBOPOS_MASTER_KEY_ANTERIOR_FILE=master.key \
+//     go run ./services/cfdi/cmd/rotar_llaves
+//  3. Reiniciar el CFDI service con la llave nueva y retirar la anterior.
+//
+// Los valores que sigan en texto plano (registros previos al cifrado en reposo) se
+// cifran ligados a su fila.
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/lib/pq"
+	boveda "github.com/turbopos/turbopos/services/cfdi/internal/boveda"
+)
+
+// columnasSecretas son las columnas con secretos fiscales, por tabla y llave primaria.
+// Solo el CSD se guarda cifrado; las columnas heredadas de tenants (finkok_user,
+// finkok_pass, key_pem_b64, key_password) no las lee ningún servicio y no se tocan.
+var columnasSecretas = []struct {
+	tabla, pk string
+	columnas  []string
+}{
+	{"tenant_csds", "tenant_id", []string{"key_bytes", "key_password"}},
+	{"tenant_csds_historial", "id", []string{"key_bytes", "key_password"}},
+}
+
+func main() {
+	nueva := flag.Bool("nueva-llave", false, "imprime una llave maestra nueva en base64 y termina")
+	flag.Parse()
+	if *nueva {
+		k := make([]byte, 32)
+		if _, err := rand.Read(k); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(k))
+		return
+	}
+
+	bov, err := boveda.Cargar()
+	if err != nil {
+		log.Fatalf("[Rotar] Llave maestra: %v", err)
+	}
+	dsn := "host=" + getenv("DB_HOST", "127.0.0.1") +
+		" port=" + getenv("DB_PORT", "5432") +
+		" user=" + getenv("DB_USER", "postgres") +
+		" password=" + getenv("DB_PASS", "turbopos") +
+		" dbname=" + getenv("DB_NAME", "turbopos") +
+		" sslmode=disable"
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		log.Fatalf("[Rotar] DB: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatalf("[Rotar] DB: %v", err)
+	}
+	defer tx.Rollback()
+	total := 0
+	for _, t := range columnasSecretas {
+		for _, col := range t.columnas {
+			n, err := recifrarColumna(tx, bov, t.tabla, t.pk, col)
+			if err != nil {
+				log.Fatalf("[Rotar] %s.%s: %v", t.tabla, col, err)
+			}
+			if n > 0 {
+				log.Printf("[Rotar] %s.%s: %d valores re-cifrados", t.tabla, col, n)
+			}
+			total += n
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("[Rotar] Commit: %v", err)
+	}
+	log.Printf("[Rotar] Listo: %d valores con la llave %s", total, bov.LlaveActual())
+}
+
+// recifrarColumna re-cifra una columna completa con el contexto del CSD del tenant de
+// cada fila. Las columnas que no existen en este esquema se omiten.
+func recifrarColumna(tx *sql.Tx, bov *boveda.Boveda, tabla, pk, col string) (int, error) {
+	var existe bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name=$1 AND column_name=$2)`, tabla, col).Scan(&existe)
+	if err != nil || !existe {
+		return 0, err
+	}
+	rows, err := tx.Query(fmt.Sprintf(`SELECT %s::text, tenant_id::text, %s FROM %s WHERE %s IS NOT NULL FOR UPDATE`, pk, col, tabla, col))
+	if err != nil {
+		return 0, err
+	}
+	cambios := map[string][]byte{}
+	for rows.Next() {
+		var id, tenantID string
+		var valor []byte
+		if err := rows.Scan(&id, &tenantID, &valor); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if len(valor) == 0 {
+			continue
+		}
+		nuevo, err := bov.Recifrar(valor, boveda.ContextoCSD(tenantID, col))
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("%s=%s: %w", pk, id, err)
+		}
+		if string(nuevo) != string(valor) {
+			cambios[id] = nuevo
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var tipo string
+	if err := tx.QueryRow(`SELECT data_type FROM information_schema.columns WHERE table_name=$1 AND column_name=$2`, tabla, col).Scan(&tipo); err != nil {
+		return 0, err
+	}
+	for id, nuevo := range cambios {
+		var v interface{} = string(nuevo)
+		if tipo == "bytea" {
+			v = nuevo
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s=$1 WHERE %s::text=$2`, tabla, col, pk), v, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(cambios), nil
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -25,9 +25,9 @@ func main() {
 		Fecha:  time.Date(2026, 2, 25, 12, 0, 0, 0, time.UTC),
 		RFC:    "XAXX010101000",
 		Items: []xmlgen.SaleItem{
-			{Nombre: "Producto Test", Cantidad: 1, PrecioUnitario: 20.00, Subtotal: 20.00},
+			{Nombre: "Producto Test", Cantidad: 1, PrecioUnitario: xmlgen.MoneyFromCents(2000), Subtotal: xmlgen.MoneyFromCents(2000)},
 		},
-		Total:           20.00,
+		Total:           xmlgen.MoneyFromCents(2000),
 		FormaPago:       "01",
 		LugarExpedicion: "64000",
 	}
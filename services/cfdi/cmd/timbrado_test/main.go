@@ -31,10 +31,10 @@ func main() {
 		Fecha:  time.Now(),
 		RFC:    "XAXX010101000",
 		Items: []xmlgen.SaleItem{
-			{Nombre: "Coca-Cola 600ml", Cantidad: 1, PrecioUnitario: 20.00, Subtotal: 20.00},
-			{Nombre: "Papas Sabritas", Cantidad: 1, PrecioUnitario: 18.00, Subtotal: 18.00},
+			{Nombre: "Coca-Cola 600ml", Cantidad: 1, PrecioUnitario: xmlgen.MoneyFromCents(2000), Subtotal: xmlgen.MoneyFromCents(2000)},
+			{Nombre: "Papas Sabritas", Cantidad: 1, PrecioUnitario: xmlgen.MoneyFromCents(1800), Subtotal: xmlgen.MoneyFromCents(1800)},
 		},
-		Total:           38.00,
+		Total:           xmlgen.MoneyFromCents(3800),
 		FormaPago:       "01",
 		LugarExpedicion: "64000",
 	}
@@ -0,0 +1,259 @@
+// Package boveda cifra en reposo los secretos fiscales de los tenants (llave del CSD y
+// su contraseña) con cifrado de sobre: cada valor lleva su propia
+// llave de datos AES-256-GCM, envuelta a su vez con la llave maestra. El contenido se
+// sella con el contexto del valor (tabla, fila y columna) como datos adicionales, así que
+// copiar un valor cifrado a otra fila o columna lo vuelve ilegible.
+package boveda
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// prefijo identifica un valor cifrado; lo que no lo tenga es texto plano heredado.
+const prefijo = "enc:v2:"
+
+const tamLlave = 32
+
+// Boveda guarda la llave maestra vigente y las anteriores que aún pueden descifrar.
+type Boveda struct {
+	actualID string
+	llaves   map[string][]byte
+}
+
+// Nueva crea la bóveda con la llave maestra vigente y, opcionalmente, las anteriores
+// (necesarias solo mientras se re-cifran los registros tras una rotación).
+func Nueva(maestra []byte, anteriores ...[]byte) (*Boveda, error) {
+	b := &Boveda{llaves: map[string][]byte{}}
+	for i, k := range append([][]byte{maestra}, anteriores...) {
+		if len(k) != tamLlave {
+			return nil, fmt.Errorf("la llave maestra debe medir %d bytes, mide %d", tamLlave, len(k))
+		}
+		id := idLlave(k)
+		if i == 0 {
+			b.actualID = id
+		}
+		b.llaves[id] = k
+	}
+	return b, nil
+}
+
+// Cargar lee la llave maestra de TURBOPOS_MASTER_KEY_FILE o TURBOPOS_MASTER_KEY
+// (32 bytes en base64 o hex) y la anterior de TURBOPOS_MASTER_KEY_ANTERIOR[_FILE].
+func Cargar() (*Boveda, error) {
+	maestra, err := llaveDeEntorno("TURBOPOS_MASTER_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if maestra == nil {
+		return nil, fmt.Errorf("TURBOPOS_MASTER_KEY_FILE o TURBOPOS_MASTER_KEY no definido")
+	}
+	anterior, err := llaveDeEntorno("TURBOPOS_MASTER_KEY_ANTERIOR")
+	if err != nil {
+		return nil, err
+	}
+	if anterior == nil {
+		return Nueva(maestra)
+	}
+	return Nueva(maestra, anterior)
+}
+
+// LlaveActual es el identificador de la llave maestra con la que se cifra.
+func (b *Boveda) LlaveActual() string { return b.actualID }
+
+// EstaCifrado indica si el valor ya pasó por Cifrar.
+func EstaCifrado(v []byte) bool {
+	return bytes.HasPrefix(v, []byte(prefijo))
+}
+
+// Contexto identifica dónde vive un valor cifrado: "tabla|id|columna".
+func Contexto(tabla, id, columna string) []byte {
+	return []byte(tabla + "|" + id + "|" + columna)
+}
+
+// ContextoCSD es el contexto de la llave o contraseña del CSD de un tenant. El CSD pasa
+// de tenant_csds a tenant_csds_historial (y de regreso) sin descifrarse, así que ambas
+// tablas usan la fila lógica tenant_csds|<tenant_id>|<columna>.
+func ContextoCSD(tenantID, columna string) []byte {
+	return Contexto("tenant_csds", tenantID, columna)
+}
+
+// Cifrar sella v con una llave de datos nueva envuelta con la llave maestra vigente.
+// El mismo contexto debe presentarse al descifrar. El resultado es texto ASCII apto
+// tanto para columnas TEXT como BYTEA.
+func (b *Boveda) Cifrar(v, contexto []byte) ([]byte, error) {
+	dek := make([]byte, tamLlave)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	envuelta, err := sellar(b.llaves[b.actualID], dek, nil)
+	if err != nil {
+		return nil, err
+	}
+	datos, err := sellar(dek, v, contexto)
+	if err != nil {
+		return nil, err
+	}
+	return codificar(b.actualID, envuelta, datos), nil
+}
+
+// CifrarTexto es Cifrar para valores de texto.
+func (b *Boveda) CifrarTexto(v string, contexto []byte) (string, error) {
+	out, err := b.Cifrar([]byte(v), contexto)
+	return string(out), err
+}
+
+// Descifrar abre un valor producido por Cifrar con la llave maestra que lo envolvió.
+// Falla si el contexto no es el que se usó al cifrar.
+func (b *Boveda) Descifrar(v, contexto []byte) ([]byte, error) {
+	id, envuelta, datos, err := decodificar(v)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := b.abrirDEK(id, envuelta)
+	if err != nil {
+		return nil, err
+	}
+	plano, err := abrir(dek, datos, contexto)
+	if err != nil {
+		return nil, fmt.Errorf("descifrar valor: %w", err)
+	}
+	return plano, nil
+}
+
+// DescifrarTexto es Descifrar para valores de texto.
+func (b *Boveda) DescifrarTexto(v string, contexto []byte) (string, error) {
+	out, err := b.Descifrar([]byte(v), contexto)
+	return string(out), err
+}
+
+// Recifrar deja v envuelto con la llave maestra vigente. Solo re-envuelve la llave de
+// datos, así que el contenido nunca queda en claro; los valores en texto plano heredados
+// se cifran ligados a contexto.
+func (b *Boveda) Recifrar(v, contexto []byte) ([]byte, error) {
+	if !EstaCifrado(v) {
+		return b.Cifrar(v, contexto)
+	}
+	id, envuelta, datos, err := decodificar(v)
+	if err != nil {
+		return nil, err
+	}
+	if id == b.actualID {
+		return v, nil
+	}
+	dek, err := b.abrirDEK(id, envuelta)
+	if err != nil {
+		return nil, err
+	}
+	nueva, err := sellar(b.llaves[b.actualID], dek, nil)
+	if err != nil {
+		return nil, err
+	}
+	return codificar(b.actualID, nueva, datos), nil
+}
+
+func (b *Boveda) abrirDEK(id string, envuelta []byte) ([]byte, error) {
+	kek, ok := b.llaves[id]
+	if !ok {
+		return nil, fmt.Errorf("llave maestra %s no disponible", id)
+	}
+	dek, err := abrir(kek, envuelta, nil)
+	if err != nil {
+		return nil, fmt.Errorf("desenvolver llave de datos: %w", err)
+	}
+	return dek, nil
+}
+
+// idLlave identifica una llave maestra sin revelarla.
+func idLlave(k []byte) string {
+	h := sha256.Sum256(k)
+	return hex.EncodeToString(h[:4])
+}
+
+// codificar arma "enc:v2:<id>:<llave de datos envuelta>:<datos>".
+func codificar(id string, envuelta, datos []byte) []byte {
+	return []byte(prefijo + id + ":" + base64.RawStdEncoding.EncodeToString(envuelta) + ":" + base64.RawStdEncoding.EncodeToString(datos))
+}
+
+func decodificar(v []byte) (id string, envuelta, datos []byte, err error) {
+	if !EstaCifrado(v) {
+		return "", nil, nil, fmt.Errorf("el valor no está cifrado")
+	}
+	partes := strings.Split(string(v[len(prefijo):]), ":")
+	if len(partes) != 3 {
+		return "", nil, nil, fmt.Errorf("valor cifrado malformado")
+	}
+	if envuelta, err = base64.RawStdEncoding.DecodeString(partes[1]); err != nil {
+		return "", nil, nil, fmt.Errorf("valor cifrado malformado: %w", err)
+	}
+	if datos, err = base64.RawStdEncoding.DecodeString(partes[2]); err != nil {
+		return "", nil, nil, fmt.Errorf("valor cifrado malformado: %w", err)
+	}
+	return partes[0], envuelta, datos, nil
+}
+
+// sellar cifra con AES-256-GCM, autentica contexto como datos adicionales y antepone el nonce.
+func sellar(llave, plano, contexto []byte) ([]byte, error) {
+	gcm, err := nuevoGCM(llave)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plano, contexto), nil
+}
+
+func abrir(llave, sellado, contexto []byte) ([]byte, error) {
+	gcm, err := nuevoGCM(llave)
+	if err != nil {
+		return nil, err
+	}
+	if len(sellado) < gcm.NonceSize() {
+		return nil, fmt.Errorf("texto cifrado demasiado corto")
+	}
+	return gcm.Open(nil, sellado[:gcm.NonceSize()], sellado[gcm.NonceSize():], contexto)
+}
+
+func nuevoGCM(llave []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(llave)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// llaveDeEntorno lee <nombre>_FILE (archivo) o <nombre> (valor). Devuelve nil si ninguno está definido.
+func llaveDeEntorno(nombre string) ([]byte, error) {
+	valor := os.Getenv(nombre)
+	if ruta := os.Getenv(nombre + "_FILE"); ruta != "" {
+		data, err := os.ReadFile(ruta)
+		if err != nil {
+			return nil, fmt.Errorf("leer %s_FILE: %w", nombre, err)
+		}
+		if len(data) == tamLlave {
+			return data, nil
+		}
+		valor = string(data)
+	}
+	valor = strings.TrimSpace(valor)
+	if valor == "" {
+		return nil, nil
+	}
+	if k, err := base64.StdEncoding.DecodeString(valor); err == nil && len(k) == tamLlave {
+		return k, nil
+	}
+	if k, err := hex.DecodeString(valor); err == nil && len(k) == tamLlave {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%s debe ser una llave de %d bytes en base64 o hex", nombre, tamLlave)
+}
@@ -0,0 +1,103 @@
+package boveda
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func llave(t *testing.T) []byte {
+	t.Helper()
+	k := make([]byte, tamLlave)
+	if _, err := rand.Read(k); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestCifrarDescifrar(t *testing.T) {
+	b, err := Nueva(llave(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secreto := []byte("12345678a")
+	ctx := ContextoCSD("t1", "key_password")
+	c1, err := b.Cifrar(secreto, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, _ := b.Cifrar(secreto, ctx)
+	if bytes.Contains(c1, secreto) || bytes.Equal(c1, c2) {
+		t.Error("cada cifrado debe usar su propia llave de datos y no contener el secreto")
+	}
+	if !EstaCifrado(c1) || EstaCifrado(secreto) {
+		t.Error("EstaCifrado no distingue valores cifrados")
+	}
+	plano, err := b.Descifrar(c1, ctx)
+	if err != nil || !bytes.Equal(plano, secreto) {
+		t.Fatalf("Descifrar = %q, %v", plano, err)
+	}
+
+	// Alterar un byte del contenido debe fallar la autenticación
+	alterado := []byte(string(c1))
+	alterado[len(alterado)-2] ^= 'A' ^ 'B'
+	if _, err := b.Descifrar(alterado, ctx); err == nil {
+		t.Error("esperaba error con valor alterado")
+	}
+	otra, _ := Nueva(llave(t))
+	if _, err := otra.Descifrar(c1, ctx); err == nil || !strings.Contains(err.Error(), "no disponible") {
+		t.Errorf("esperaba error de llave no disponible, err=%v", err)
+	}
+}
+
+// Un valor copiado a otra fila o columna no se puede descifrar ahí
+func TestDescifrar_OtroContexto(t *testing.T) {
+	b, _ := Nueva(llave(t))
+	llaveT1, _ := b.Cifrar([]byte("llave-t1"), ContextoCSD("t1", "key_bytes"))
+	for _, ctx := range [][]byte{
+		ContextoCSD("t2", "key_bytes"),
+		ContextoCSD("t1", "key_password"),
+		Contexto("tenant_csds_historial", "t1", "key_bytes"),
+		nil,
+	} {
+		if _, err := b.Descifrar(llaveT1, ctx); err == nil {
+			t.Errorf("contexto %q: esperaba error", ctx)
+		}
+	}
+}
+
+func TestRecifrar_RotacionDeLlaveMaestra(t *testing.T) {
+	vieja, nueva := llave(t), llave(t)
+	ctx := ContextoCSD("t1", "key_bytes")
+	antes, _ := Nueva(vieja)
+	cifrado, _ := antes.Cifrar([]byte("llave-csd"), ctx)
+
+	rotada, err := Nueva(nueva, vieja)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recifrado, err := rotada.Recifrar(cifrado, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(recifrado), rotada.LlaveActual()) {
+		t.Error("el valor recifrado debe quedar envuelto con la llave vigente")
+	}
+	// Ya sin la llave anterior el valor sigue siendo legible
+	soloNueva, _ := Nueva(nueva)
+	if plano, err := soloNueva.Descifrar(recifrado, ctx); err != nil || string(plano) != "llave-csd" {
+		t.Fatalf("Descifrar tras rotación = %q, %v", plano, err)
+	}
+	// Texto plano heredado se cifra
+	heredado, err := soloNueva.Recifrar([]byte("en-claro"), ctx)
+	if err != nil || !EstaCifrado(heredado) {
+		t.Fatalf("Recifrar texto plano = %q, %v", heredado, err)
+	}
+}
+
+func TestNueva_LlaveInvalida(t *testing.T) {
+	if _, err := Nueva([]byte("corta")); err == nil {
+		t.Error("esperaba error con llave de tamaño incorrecto")
+	}
+}
@@ -0,0 +1,182 @@
+// Package csd interpreta y valida Certificados de Sello Digital (CSD) del SAT
+package csd
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/youmark/pkcs8"
+)
+
+// oidUniqueIdentifier (x500UniqueIdentifier) es donde el SAT guarda "RFC / RFC representante".
+var oidUniqueIdentifier = asn1.ObjectIdentifier{2, 5, 4, 45}
+
+// Info describe un certificado ya validado.
+type Info struct {
+	RFC           string
+	Nombre        string
+	NoCertificado string
+	ValidoDesde   time.Time
+	ValidoHasta   time.Time
+	EsFIEL        bool
+	CertDER       []byte
+}
+
+// Vigente indica si el certificado puede sellar en el instante t.
+func (i *Info) Vigente(t time.Time) bool {
+	return !t.Before(i.ValidoDesde) && t.Before(i.ValidoHasta)
+}
+
+// CertificadoBase64 es el valor del atributo Certificado del comprobante.
+func (i *Info) CertificadoBase64() string {
+	return base64.StdEncoding.EncodeToString(i.CertDER)
+}
+
+// ParseCertificado acepta el .cer en DER, en PEM o en base64 (como se guarda en tenant_csds).
+func ParseCertificado(data []byte) (*Info, error) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		der = block.Bytes
+	} else if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err == nil {
+		der = decoded
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsear certificado: %w", err)
+	}
+	noCert, err := NumeroCertificado(cert)
+	if err != nil {
+		return nil, err
+	}
+	nombre := cert.Subject.CommonName
+	if len(cert.Subject.Organization) > 0 && cert.Subject.Organization[0] != "" {
+		nombre = cert.Subject.Organization[0]
+	}
+	return &Info{
+		RFC:           rfcDelSujeto(cert),
+		Nombre:        nombre,
+		NoCertificado: noCert,
+		ValidoDesde:   cert.NotBefore,
+		ValidoHasta:   cert.NotAfter,
+		EsFIEL:        !esSelloDigital(cert),
+		CertDER:       der,
+	}, nil
+}
+
+// NumeroCertificado obtiene el NoCertificado de 20 dígitos. El SAT codifica cada
+// dígito como un byte ASCII dentro del número de serie, así que el serial en
+// hexadecimal ("3330303031...") se decodifica a "30001...".
+func NumeroCertificado(cert *x509.Certificate) (string, error) {
+	h := cert.SerialNumber.Text(16)
+	if len(h)%2 == 1 {
+		h = "0" + h
+	}
+	raw, err := hex.DecodeString(h)
+	if err != nil {
+		return "", fmt.Errorf("serial del certificado: %w", err)
+	}
+	for _, b := range raw {
+		if b < '0' || b > '9' {
+			return "", fmt.Errorf("el número de serie %s no tiene formato SAT", h)
+		}
+	}
+	return string(raw), nil
+}
+
+// Validar comprueba que el par .cer/.key sea un CSD utilizable para rfcEsperado en el instante t:
+// la llave descifra con la contraseña y corresponde al certificado, el certificado es
+// un CSD (no la e.firma/FIEL), pertenece al RFC del emisor y no ha vencido.
+func Validar(certData, keyBytes []byte, password, rfcEsperado string, t time.Time) (*Info, error) {
+	info, err := ParseCertificado(certData)
+	if err != nil {
+		return nil, err
+	}
+	cert, _ := x509.ParseCertificate(info.CertDER)
+	key, err := ParseLlave(keyBytes, password)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("el certificado no tiene llave RSA")
+	}
+	if pub.N.Cmp(key.N) != 0 || pub.E != key.E {
+		return nil, fmt.Errorf("la llave privada no corresponde al certificado")
+	}
+	if info.EsFIEL {
+		return nil, fmt.Errorf("el certificado es una e.firma (FIEL), se requiere un CSD")
+	}
+	rfcEsperado = strings.ToUpper(strings.TrimSpace(rfcEsperado))
+	if rfcEsperado != "" && info.RFC != rfcEsperado {
+		return nil, fmt.Errorf("el certificado pertenece al RFC %s, no a %s", info.RFC, rfcEsperado)
+	}
+	if t.After(info.ValidoHasta) {
+		return nil, fmt.Errorf("el certificado venció el %s", info.ValidoHasta.Format("2006-01-02"))
+	}
+	return info, nil
+}
+
+// ParseLlave descifra la llave privada del SAT (PKCS#8 DER cifrado) o una llave PEM.
+func ParseLlave(keyBytes []byte, password string) (*rsa.PrivateKey, error) {
+	if block, _ := pem.Decode(keyBytes); block != nil {
+		der := block.Bytes
+		if x509.IsEncryptedPEMBlock(block) {
+			var err error
+			der, err = x509.DecryptPEMBlock(block, []byte(password))
+			if err != nil {
+				return nil, fmt.Errorf("descifrar llave PEM: %w", err)
+			}
+		}
+		if k, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+			if rsaKey, ok := k.(*rsa.PrivateKey); ok {
+				return rsaKey, nil
+			}
+			return nil, fmt.Errorf("la llave no es RSA")
+		}
+		if k, err := pkcs8.ParsePKCS8PrivateKey(der, []byte(password)); err == nil {
+			if rsaKey, ok := k.(*rsa.PrivateKey); ok {
+				return rsaKey, nil
+			}
+		}
+		return x509.ParsePKCS1PrivateKey(der)
+	}
+	k, err := pkcs8.ParsePKCS8PrivateKey(keyBytes, []byte(password))
+	if err != nil {
+		return nil, fmt.Errorf("descifrar llave (¿contraseña incorrecta?): %w", err)
+	}
+	rsaKey, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("la llave no es RSA")
+	}
+	return rsaKey, nil
+}
+
+// rfcDelSujeto extrae el RFC del emisor del atributo 2.5.4.45 ("RFC / RFC representante").
+func rfcDelSujeto(cert *x509.Certificate) string {
+	for _, attr := range cert.Subject.Names {
+		if attr.Type.Equal(oidUniqueIdentifier) {
+			if v, ok := attr.Value.(string); ok {
+				rfc, _, _ := strings.Cut(v, "/")
+				return strings.ToUpper(strings.TrimSpace(rfc))
+			}
+		}
+	}
+	return ""
+}
+
+// esSelloDigital distingue un CSD de la FIEL: el CSD solo se usa para firmar
+// (digitalSignature + nonRepudiation); la FIEL además permite cifrado y acuerdo de llaves.
+func esSelloDigital(cert *x509.Certificate) bool {
+	ku := cert.KeyUsage
+	if ku&(x509.KeyUsageKeyEncipherment|x509.KeyUsageDataEncipherment|x509.KeyUsageKeyAgreement) != 0 {
+		return false
+	}
+	return ku&x509.KeyUsageDigitalSignature != 0 && ku&x509.KeyUsageContentCommitment != 0
+}
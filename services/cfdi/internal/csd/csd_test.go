@@ -0,0 +1,101 @@
+package csd
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youmark/pkcs8"
+)
+
+// certPrueba genera un par .cer/.key con la forma de los del SAT.
+func certPrueba(t *testing.T, rfc string, ku x509.KeyUsage, desde, hasta time.Time) ([]byte, []byte, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := new(big.Int).SetBytes([]byte("30001000000500003416"))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   "ESCUELA KEMPER URGATE SA DE CV",
+			Organization: []string{"ESCUELA KEMPER URGATE SA DE CV"},
+			ExtraNames: []pkix.AttributeTypeAndValue{
+				{Type: oidUniqueIdentifier, Value: rfc + " / XIQB891116QE4"},
+			},
+		},
+		NotBefore: desde,
+		NotAfter:  hasta,
+		KeyUsage:  ku,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := pkcs8.MarshalPrivateKey(key, []byte("12345678a"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der, keyDER, key
+}
+
+func TestValidar_CSDCorrecto(t *testing.T) {
+	ahora := time.Now()
+	cer, key, _ := certPrueba(t, "EKU9003173C9", x509.KeyUsageDigitalSignature|x509.KeyUsageContentCommitment,
+		ahora.Add(-time.Hour), ahora.AddDate(4, 0, 0))
+	info, err := Validar(cer, key, "12345678a", "eku9003173c9", ahora)
+	if err != nil {
+		t.Fatalf("Validar: %v", err)
+	}
+	if info.NoCertificado != "30001000000500003416" {
+		t.Errorf("NoCertificado = %q", info.NoCertificado)
+	}
+	if info.RFC != "EKU9003173C9" {
+		t.Errorf("RFC = %q", info.RFC)
+	}
+	if !info.Vigente(ahora) {
+		t.Error("esperaba certificado vigente")
+	}
+	// El mismo .cer en base64 (como viene de tenant_csds) debe funcionar igual
+	if _, err := ParseCertificado([]byte(info.CertificadoBase64())); err != nil {
+		t.Errorf("ParseCertificado base64: %v", err)
+	}
+}
+
+func TestValidar_Rechazos(t *testing.T) {
+	ahora := time.Now()
+	csdKU := x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment
+	cer, key, _ := certPrueba(t, "EKU9003173C9", csdKU, ahora.Add(-time.Hour), ahora.AddDate(4, 0, 0))
+	_, otraKey, _ := certPrueba(t, "EKU9003173C9", csdKU, ahora.Add(-time.Hour), ahora.AddDate(4, 0, 0))
+	fiel, fielKey, _ := certPrueba(t, "EKU9003173C9",
+		csdKU|x509.KeyUsageKeyEncipherment|x509.KeyUsageDataEncipherment|x509.KeyUsageKeyAgreement,
+		ahora.Add(-time.Hour), ahora.AddDate(4, 0, 0))
+	vencido, vencidoKey, _ := certPrueba(t, "EKU9003173C9", csdKU, ahora.AddDate(-4, 0, 0), ahora.AddDate(0, 0, -1))
+
+	casos := []struct {
+		nombre   string
+		cer, key []byte
+		pass     string
+		rfc      string
+		contiene string
+	}{
+		{"contraseña incorrecta", cer, key, "otra", "EKU9003173C9", "contraseña"},
+		{"llave de otro certificado", cer, otraKey, "12345678a", "EKU9003173C9", "no corresponde"},
+		{"FIEL en lugar de CSD", fiel, fielKey, "12345678a", "EKU9003173C9", "FIEL"},
+		{"RFC de otro tenant", cer, key, "12345678a", "XIQB891116QE4", "pertenece al RFC"},
+		{"certificado vencido", vencido, vencidoKey, "12345678a", "EKU9003173C9", "venció"},
+		{"basura", []byte("no es un certificado"), key, "12345678a", "", "parsear"},
+	}
+	for _, c := range casos {
+		_, err := Validar(c.cer, c.key, c.pass, c.rfc, ahora)
+		if err == nil || !strings.Contains(err.Error(), c.contiene) {
+			t.Errorf("%s: err=%v, esperaba que contuviera %q", c.nombre, err, c.contiene)
+		}
+	}
+}
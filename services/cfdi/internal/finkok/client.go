@@ -15,6 +15,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/turbopos/turbopos/services/cfdi/internal/csd"
 	"github.com/youmark/pkcs8"
 )
 
@@ -160,6 +161,61 @@ func (c *Client) Cancelar(uuid, rfc, motivo, uuidReemplazo, certB64 string, keyB
 	return parseCancelResponse(string(body))
 }
 
+// EstatusSAT es la respuesta del servicio de consulta de estatus del SAT
+type EstatusSAT struct {
+	CodigoEstatus      string
+	Estado             string // Vigente, Cancelado, No Encontrado
+	EsCancelable       string
+	EstatusCancelacion string // En proceso, Cancelado con aceptación, Solicitud rechazada, Plazo vencido...
+}
+
+// ConsultarEstatus consulta en el SAT (vía Finkok get_sat_status) el estado de un CFDI
+func (c *Client) ConsultarEstatus(rfcEmisor, rfcReceptor, total, uuid string) (*EstatusSAT, error) {
+	soapBody := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:canc="http://facturacion.finkok.com/cancel">
+   <soapenv:Header/>
+   <soapenv:Body>
+      <canc:get_sat_status>
+         <canc:username>%s</canc:username>
+         <canc:password>%s</canc:password>
+         <canc:taxpayer_id>%s</canc:taxpayer_id>
+         <canc:rtaxpayer_id>%s</canc:rtaxpayer_id>
+         <canc:uuid>%s</canc:uuid>
+         <canc:total>%s</canc:total>
+      </canc:get_sat_status>
+   </soapenv:Body>
+</soapenv:Envelope>`, c.Username, c.Password, rfcEmisor, rfcReceptor, uuid, total)
+
+	req, err := http.NewRequest("POST", c.CancelEndpoint, bytes.NewBufferString(soapBody))
+	if err != nil { return nil, fmt.Errorf("crear request estatus: %w", err) }
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", "get_sat_status")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil { return nil, fmt.Errorf("enviar SOAP estatus: %w", err) }
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil { return nil, fmt.Errorf("leer respuesta estatus: %w", err) }
+
+	result := &EstatusSAT{
+		CodigoEstatus:      extractTag(string(body), "CodigoEstatus"),
+		Estado:             extractTag(string(body), "Estado"),
+		EsCancelable:       extractTag(string(body), "EsCancelable"),
+		EstatusCancelacion: extractTag(string(body), "EstatusCancelacion"),
+	}
+	if result.Estado == "" && result.CodigoEstatus == "" {
+		if fault := extractTag(string(body), "faultstring"); fault != "" {
+			return nil, fmt.Errorf("SOAP fault: %s", fault)
+		}
+		if e := extractTag(string(body), "error"); e != "" {
+			return nil, fmt.Errorf("estatus SAT: %s", e)
+		}
+		return nil, fmt.Errorf("respuesta de estatus vacía")
+	}
+	return result, nil
+}
+
 // prepararCertKey convierte cert DER y key PKCS8 encriptado al formato PEM en base64 que espera Finkok
 func prepararCertKey(certDER []byte, keyBytes []byte, password string) (certB64, keyB64 string, err error) {
 	// Si certDER ya viene como base64, decodificar
@@ -223,11 +279,9 @@ func parseCancelResponse(responseXML string) (*CancelResult, error) {
 func LoadCertificate(path string) (string, string, error) {
 	data, err := os.ReadFile(path)
 	if err != nil { return "", "", fmt.Errorf("leer cert: %w", err) }
-	cert, err := x509.ParseCertificate(data)
+	info, err := csd.ParseCertificado(data)
 	if err != nil { return "", "", fmt.Errorf("parsear cert: %w", err) }
-	noCert := string(cert.SerialNumber.Bytes())
-	// handled above
-	return base64.StdEncoding.EncodeToString(data), noCert, nil
+	return info.CertificadoBase64(), info.NoCertificado, nil
 }
 
 func parseStampResponse(responseXML string) (*StampResult, error) {
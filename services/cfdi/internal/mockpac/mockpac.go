@@ -0,0 +1,208 @@
+// Package mockpac simula un PAC y el servicio de estatus del SAT para desarrollo local
+// (FINKOK_ENV=mock). Timbra sin salir a internet, y las cancelaciones recorren los
+// mismos estados que en el SAT: directas, pendientes de aceptación del receptor,
+// aceptadas, rechazadas o canceladas por plazo vencido.
+package mockpac
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/turbopos/turbopos/services/cfdi/internal/finkok"
+)
+
+// Estados y estatus de cancelación tal como los devuelve el SAT
+const (
+	EstadoVigente      = "Vigente"
+	EstadoCancelado    = "Cancelado"
+	EstadoNoEncontrado = "No Encontrado"
+
+	CancelacionEnProceso     = "En proceso"
+	CancelacionSinAceptacion = "Cancelado sin aceptación"
+	CancelacionConAceptacion = "Cancelado con aceptación"
+	CancelacionPlazoVencido  = "Plazo vencido"
+	CancelacionRechazada     = "Solicitud rechazada"
+)
+
+// montoSinAceptacion es el total hasta el que el SAT permite cancelar sin aceptación del receptor
+const montoSinAceptacion = 1000.0
+
+type comprobante struct {
+	rfcEmisor, rfcReceptor string
+	total                  float64
+	estado                 string
+	estatusCancelacion     string
+	solicitud              time.Time
+}
+
+// PAC es un PAC en memoria.
+type PAC struct {
+	mu           sync.Mutex
+	comprobantes map[string]*comprobante
+
+	// Ahora permite controlar el reloj en pruebas.
+	Ahora func() time.Time
+	// Respuesta es lo que tarda el receptor simulado en aceptar o rechazar una cancelación.
+	Respuesta time.Duration
+	// PlazoAceptacion es el plazo tras el cual el SAT cancela si el receptor no responde (72 h).
+	PlazoAceptacion time.Duration
+}
+
+// New crea el PAC simulado.
+func New() *PAC {
+	return &PAC{
+		comprobantes:    map[string]*comprobante{},
+		Ahora:           time.Now,
+		Respuesta:       time.Minute,
+		PlazoAceptacion: 72 * time.Hour,
+	}
+}
+
+var (
+	reEmisor   = regexp.MustCompile(`<cfdi:Emisor[^>]*\sRfc="([^"]*)"`)
+	reReceptor = regexp.MustCompile(`<cfdi:Receptor[^>]*\sRfc="([^"]*)"`)
+	reTotal    = regexp.MustCompile(`<cfdi:Comprobante[^>]*\sTotal="([^"]*)"`)
+	reSello    = regexp.MustCompile(`\sSello="([^"]*)"`)
+)
+
+// Timbrar asigna un UUID y agrega el TimbreFiscalDigital al comprobante.
+func (p *PAC) Timbrar(xmlContent string) (*finkok.StampResult, error) {
+	emisor := submatch(reEmisor, xmlContent)
+	receptor := submatch(reReceptor, xmlContent)
+	total, err := strconv.ParseFloat(submatch(reTotal, xmlContent), 64)
+	if emisor == "" || receptor == "" || err != nil {
+		return &finkok.StampResult{Error: "XML mal formado: faltan Emisor, Receptor o Total"}, nil
+	}
+	uuid := nuevoUUID()
+	fecha := p.Ahora().Format("2006-01-02T15:04:05")
+	selloSAT := base64.StdEncoding.EncodeToString(aleatorio(256))
+	timbre := fmt.Sprintf(`  <cfdi:Complemento>
+    <tfd:TimbreFiscalDigital xmlns:tfd="http://www.sat.gob.mx/TimbreFiscalDigital" Version="1.1" UUID="%s" FechaTimbrado="%s" RfcProvCertif="SPR190613I52" SelloCFD="%s" NoCertificadoSAT="30001000000500003456" SelloSAT="%s"/>
+  </cfdi:Complemento>
+</cfdi:Comprobante>`, uuid, fecha, submatch(reSello, xmlContent), selloSAT)
+
+	p.mu.Lock()
+	p.comprobantes[uuid] = &comprobante{rfcEmisor: emisor, rfcReceptor: receptor, total: total, estado: EstadoVigente}
+	p.mu.Unlock()
+
+	return &finkok.StampResult{
+		UUID: uuid, SelloSAT: selloSAT, NoCertSAT: "30001000000500003456", FechaTimbrado: fecha,
+		CodEstatus: "Comprobante timbrado satisfactoriamente",
+		XML:        strings.Replace(xmlContent, "</cfdi:Comprobante>", timbre, 1),
+	}, nil
+}
+
+// Cancelar cancela de inmediato los comprobantes a público en general o de hasta
+// $1,000; los demás quedan "En proceso" hasta que el receptor responde.
+func (p *PAC) Cancelar(uuid, rfc, motivo, uuidReemplazo, certB64 string, keyBytes []byte, keyPassword string) (*finkok.CancelResult, error) {
+	uuid = strings.ToUpper(uuid)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.comprobantes[uuid]
+	if !ok {
+		return &finkok.CancelResult{Error: "205 - UUID no existe"}, nil
+	}
+	if c.estado == EstadoCancelado {
+		return &finkok.CancelResult{Error: "202 - UUID previamente cancelado"}, nil
+	}
+	if rfc != "" && !strings.EqualFold(rfc, c.rfcEmisor) {
+		return &finkok.CancelResult{Error: "203 - UUID no corresponde al emisor"}, nil
+	}
+	p.resolver(c, uuid)
+	if c.estatusCancelacion == CancelacionEnProceso {
+		return &finkok.CancelResult{Error: "La cancelación ya está en proceso"}, nil
+	}
+	if c.rfcReceptor == "XAXX010101000" || c.total <= montoSinAceptacion {
+		c.estado, c.estatusCancelacion = EstadoCancelado, CancelacionSinAceptacion
+	} else {
+		c.estatusCancelacion = CancelacionEnProceso
+		c.solicitud = p.Ahora()
+	}
+	return &finkok.CancelResult{
+		Status: c.estatusCancelacion, Mensaje: c.estatusCancelacion,
+		Acuse:  "<Acuse Fecha=\"" + p.Ahora().Format("2006-01-02T15:04:05") + "\"/>",
+		Folios: []finkok.FolioResult{{UUID: uuid, EstatusUUID: "201", EstatusCancelacion: c.estatusCancelacion}},
+	}, nil
+}
+
+// ConsultarEstatus responde como el servicio de consulta de CFDI del SAT.
+func (p *PAC) ConsultarEstatus(rfcEmisor, rfcReceptor, total, uuid string) (*finkok.EstatusSAT, error) {
+	uuid = strings.ToUpper(uuid)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.comprobantes[uuid]
+	if !ok || !strings.EqualFold(rfcEmisor, c.rfcEmisor) || !strings.EqualFold(rfcReceptor, c.rfcReceptor) {
+		return &finkok.EstatusSAT{CodigoEstatus: "N - 602: Comprobante no encontrado.", Estado: EstadoNoEncontrado}, nil
+	}
+	p.resolver(c, uuid)
+	cancelable := "Cancelable con aceptación"
+	switch {
+	case c.estado == EstadoCancelado:
+		cancelable = "No cancelable"
+	case c.rfcReceptor == "XAXX010101000" || c.total <= montoSinAceptacion:
+		cancelable = "Cancelable sin aceptación"
+	}
+	return &finkok.EstatusSAT{
+		CodigoEstatus:      "S - Comprobante obtenido satisfactoriamente.",
+		Estado:             c.estado,
+		EsCancelable:       cancelable,
+		EstatusCancelacion: c.estatusCancelacion,
+	}, nil
+}
+
+// Fijar fuerza el estado de un comprobante, por ejemplo para simular que el
+// receptor rechazó la cancelación o que el CFDI se canceló por fuera del sistema.
+func (p *PAC) Fijar(uuid, rfcEmisor, rfcReceptor string, total float64, estado, estatusCancelacion string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.comprobantes[strings.ToUpper(uuid)] = &comprobante{
+		rfcEmisor: rfcEmisor, rfcReceptor: rfcReceptor, total: total,
+		estado: estado, estatusCancelacion: estatusCancelacion, solicitud: p.Ahora(),
+	}
+}
+
+// resolver avanza una cancelación en proceso. La respuesta del receptor simulado depende
+// del último dígito del UUID: 0-9 acepta, a-c rechaza, d-f no responde y vence el plazo.
+func (p *PAC) resolver(c *comprobante, uuid string) {
+	if c.estatusCancelacion != CancelacionEnProceso {
+		return
+	}
+	transcurrido := p.Ahora().Sub(c.solicitud)
+	ultimo := strings.ToLower(uuid[len(uuid)-1:])
+	switch {
+	case strings.Contains("0123456789", ultimo) && transcurrido >= p.Respuesta:
+		c.estado, c.estatusCancelacion = EstadoCancelado, CancelacionConAceptacion
+	case strings.Contains("abc", ultimo) && transcurrido >= p.Respuesta:
+		c.estatusCancelacion = CancelacionRechazada
+	case transcurrido >= p.PlazoAceptacion:
+		c.estado, c.estatusCancelacion = EstadoCancelado, CancelacionPlazoVencido
+	}
+}
+
+func submatch(re *regexp.Regexp, s string) string {
+	if m := re.FindStringSubmatch(s); len(m) > 1 {
+		return m[1]
+	}
+	return ""
+}
+
+func aleatorio(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func nuevoUUID() string {
+	b := aleatorio(16)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	h := strings.ToUpper(hex.EncodeToString(b))
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
@@ -0,0 +1,84 @@
+package mockpac
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const xmlPrueba = `<cfdi:Comprobante Version="4.0" Sello="abc" Total="%TOTAL%">
+  <cfdi:Emisor Rfc="EKU9003173C9" Nombre="ESCUELA KEMPER URGATE" RegimenFiscal="601"/>
+  <cfdi:Receptor Rfc="%RECEPTOR%" Nombre="X" UsoCFDI="G03"/>
+</cfdi:Comprobante>`
+
+func timbrar(t *testing.T, p *PAC, receptor, total string) string {
+	t.Helper()
+	x := strings.NewReplacer("%TOTAL%", total, "%RECEPTOR%", receptor).Replace(xmlPrueba)
+	res, err := p.Timbrar(x)
+	if err != nil || res.Error != "" {
+		t.Fatalf("Timbrar: %v %s", err, res.Error)
+	}
+	if !strings.Contains(res.XML, `UUID="`+res.UUID+`"`) {
+		t.Fatal("el XML timbrado no lleva el TimbreFiscalDigital")
+	}
+	return res.UUID
+}
+
+func TestCancelacion_SinAceptacion(t *testing.T) {
+	p := New()
+	uuid := timbrar(t, p, "XAXX010101000", "5000.00")
+	res, _ := p.Cancelar(uuid, "EKU9003173C9", "02", "", "", nil, "")
+	if res.Status != CancelacionSinAceptacion {
+		t.Fatalf("Status = %q", res.Status)
+	}
+	st, _ := p.ConsultarEstatus("EKU9003173C9", "XAXX010101000", "5000.00", uuid)
+	if st.Estado != EstadoCancelado {
+		t.Errorf("Estado = %q, esperaba Cancelado", st.Estado)
+	}
+}
+
+func TestCancelacion_ConAceptacionDelReceptor(t *testing.T) {
+	ahora := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	p := New()
+	p.Ahora = func() time.Time { return ahora }
+
+	casos := map[string]string{ // último dígito del UUID → estatus final
+		"0": CancelacionConAceptacion,
+		"B": CancelacionRechazada,
+		"E": CancelacionPlazoVencido,
+	}
+	for digito := range casos {
+		uuid := "11111111-2222-4333-8444-55555555555" + digito
+		p.Fijar(uuid, "EKU9003173C9", "XIQB891116QE4", 5000, EstadoVigente, "")
+		if res, _ := p.Cancelar(uuid, "EKU9003173C9", "02", "", "", nil, ""); res.Status != CancelacionEnProceso {
+			t.Fatalf("%s: Status = %q, esperaba En proceso", digito, res.Status)
+		}
+		st, _ := p.ConsultarEstatus("EKU9003173C9", "XIQB891116QE4", "5000.00", uuid)
+		if st.Estado != EstadoVigente || st.EstatusCancelacion != CancelacionEnProceso {
+			t.Fatalf("%s: recién solicitada = %+v", digito, st)
+		}
+	}
+
+	ahora = ahora.Add(73 * time.Hour)
+	for digito, esperado := range casos {
+		uuid := "11111111-2222-4333-8444-55555555555" + digito
+		st, _ := p.ConsultarEstatus("EKU9003173C9", "XIQB891116QE4", "5000.00", uuid)
+		if st.EstatusCancelacion != esperado {
+			t.Errorf("%s: EstatusCancelacion = %q, esperaba %q", digito, st.EstatusCancelacion, esperado)
+		}
+		if cancelado := st.Estado == EstadoCancelado; cancelado == (esperado == CancelacionRechazada) {
+			t.Errorf("%s: Estado = %q con estatus %q", digito, st.Estado, esperado)
+		}
+	}
+}
+
+func TestConsultarEstatus_NoEncontrado(t *testing.T) {
+	p := New()
+	uuid := timbrar(t, p, "XIQB891116QE4", "100.00")
+	if st, _ := p.ConsultarEstatus("EKU9003173C9", "XIQB891116QE4", "100.00", "00000000-0000-4000-8000-000000000000"); st.Estado != EstadoNoEncontrado {
+		t.Errorf("UUID desconocido: Estado = %q", st.Estado)
+	}
+	if st, _ := p.ConsultarEstatus("EKU9003173C9", "OTRO010101AAA", "100.00", uuid); st.Estado != EstadoNoEncontrado {
+		t.Errorf("receptor distinto: Estado = %q", st.Estado)
+	}
+}
@@ -0,0 +1,189 @@
+package xmlgen
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RFCPublicoGeneral es el RFC genérico para ventas a público en general.
+const RFCPublicoGeneral = "XAXX010101000"
+
+const (
+	personaFisica = 1 << iota
+	personaMoral
+)
+
+// regimenesFiscales es el catálogo c_RegimenFiscal con el tipo de persona al que aplica cada régimen.
+var regimenesFiscales = map[string]int{
+	"601": personaMoral,                 // General de Ley Personas Morales
+	"603": personaMoral,                 // Personas Morales con Fines no Lucrativos
+	"605": personaFisica,                // Sueldos y Salarios
+	"606": personaFisica,                // Arrendamiento
+	"607": personaFisica,                // Enajenación o Adquisición de Bienes
+	"608": personaFisica,                // Demás ingresos
+	"610": personaFisica | personaMoral, // Residentes en el Extranjero
+	"611": personaFisica,                // Dividendos
+	"612": personaFisica,                // Actividades Empresariales y Profesionales
+	"614": personaFisica,                // Ingresos por intereses
+	"615": personaFisica,                // Obtención de premios
+	"616": personaFisica,                // Sin obligaciones fiscales
+	"620": personaMoral,                 // Sociedades Cooperativas de Producción
+	"621": personaFisica,                // Incorporación Fiscal
+	"622": personaMoral,                 // Actividades Agrícolas, Ganaderas, Silvícolas y Pesqueras
+	"623": personaMoral,                 // Opcional para Grupos de Sociedades
+	"624": personaMoral,                 // Coordinados
+	"625": personaFisica,                // Plataformas Tecnológicas
+	"626": personaFisica | personaMoral, // Régimen Simplificado de Confianza
+}
+
+var (
+	regimenesActividad = []string{"601", "603", "606", "612", "620", "621", "622", "623", "624", "625", "626"}
+	regimenesDeduccion = []string{"605", "606", "607", "608", "611", "612", "614", "615", "625"}
+	regimenesTodos     = []string{"601", "603", "605", "606", "607", "608", "610", "611", "612", "614", "615", "616", "620", "621", "622", "623", "624", "625", "626"}
+)
+
+// usosCFDI es el catálogo c_UsoCFDI con los regímenes del receptor que admite cada uso.
+var usosCFDI = map[string][]string{
+	"G01": regimenesActividad, "G02": regimenesActividad, "G03": regimenesActividad,
+	"I01": regimenesActividad, "I02": regimenesActividad, "I03": regimenesActividad, "I04": regimenesActividad,
+	"I05": regimenesActividad, "I06": regimenesActividad, "I07": regimenesActividad, "I08": regimenesActividad,
+	"D01": regimenesDeduccion, "D02": regimenesDeduccion, "D03": regimenesDeduccion, "D04": regimenesDeduccion,
+	"D05": regimenesDeduccion, "D06": regimenesDeduccion, "D07": regimenesDeduccion, "D08": regimenesDeduccion,
+	"D09": regimenesDeduccion, "D10": regimenesDeduccion,
+	"S01":  regimenesTodos,
+	"CP01": regimenesTodos,
+	"CN01": {"605"},
+}
+
+// Claves del catálogo c_Impuesto.
+const (
+	ImpuestoISR = "001"
+	ImpuestoIVA = "002"
+)
+
+// tasaIVARetenido son las dos terceras partes del IVA al 16% que retiene una persona moral.
+const tasaIVARetenido Money = 106_667
+
+// PerfilesRetencion son las retenciones que hace una persona moral según el servicio que
+// le factura el emisor (LISR arts. 106, 116 y 113-J; LIVA art. 1-A y RLIVA art. 3).
+var PerfilesRetencion = map[string][]Retencion{
+	"honorarios":      {{ImpuestoISR, 100_000}, {ImpuestoIVA, tasaIVARetenido}},
+	"arrendamiento":   {{ImpuestoISR, 100_000}, {ImpuestoIVA, tasaIVARetenido}},
+	"resico":          {{ImpuestoISR, 12_500}, {ImpuestoIVA, tasaIVARetenido}},
+	"fletes":          {{ImpuestoIVA, 40_000}},
+	"subcontratacion": {{ImpuestoIVA, 60_000}},
+}
+
+// EsPersonaMoral indica si el RFC es de persona moral (12 caracteres).
+func EsPersonaMoral(rfc string) bool {
+	rfc = normalizarRFC(rfc)
+	return !EsPublicoGeneral(rfc) && len([]rune(rfc)) == 12
+}
+
+// MonedaNacional es la moneda del comprobante cuando la venta no indica otra.
+const MonedaNacional = "MXN"
+
+// monedaSinOperacion es la clave c_Moneda para comprobantes sin operación monetaria.
+const monedaSinOperacion = "XXX"
+
+// monedas es un extracto del catálogo c_Moneda con los decimales que admite cada moneda.
+var monedas = map[string]int{
+	"MXN": 2, "USD": 2, "EUR": 2, "CAD": 2, "GBP": 2, "CHF": 2, "CNY": 2,
+	"JPY": 0, "KRW": 0, "XXX": 0,
+}
+
+// DecimalesMoneda devuelve los decimales que admite la moneda según c_Moneda.
+func DecimalesMoneda(moneda string) (int, error) {
+	d, ok := monedas[moneda]
+	if !ok {
+		return 0, fmt.Errorf("moneda desconocida: %q", moneda)
+	}
+	return d, nil
+}
+
+// ValidarMoneda verifica la congruencia entre Moneda y TipoCambio (CFDI40115-CFDI40117):
+// MXN admite tipo de cambio vacío o 1, XXX no lleva tipo de cambio y cualquier otra
+// moneda lo requiere.
+func ValidarMoneda(moneda string, tipoCambio Money) error {
+	if _, err := DecimalesMoneda(moneda); err != nil {
+		return err
+	}
+	switch moneda {
+	case MonedaNacional:
+		if !tipoCambio.IsZero() && tipoCambio != MoneyFromInt(1) {
+			return fmt.Errorf("con moneda MXN el tipo de cambio debe ser 1, no %s", tipoCambio.Format(6))
+		}
+	case monedaSinOperacion:
+		if !tipoCambio.IsZero() {
+			return fmt.Errorf("con moneda XXX no se registra tipo de cambio")
+		}
+	default:
+		if tipoCambio.Sign() <= 0 {
+			return fmt.Errorf("la moneda %s requiere tipo de cambio", moneda)
+		}
+	}
+	return nil
+}
+
+var rfcPattern = regexp.MustCompile(`^[A-ZÑ&]{3,4}[0-9]{6}[A-Z0-9]{3}$`)
+
+// EsPublicoGeneral indica si el RFC corresponde a una venta sin receptor identificado.
+func EsPublicoGeneral(rfc string) bool {
+	return rfc == "" || rfc == RFCPublicoGeneral
+}
+
+// RegimenPorDefecto devuelve el régimen que se asume cuando el receptor no lo indica:
+// 612 para personas físicas (RFC de 13) y 601 para morales (RFC de 12).
+func RegimenPorDefecto(rfc string) string {
+	if EsPublicoGeneral(rfc) {
+		return "616"
+	}
+	if len([]rune(rfc)) == 13 {
+		return "612"
+	}
+	return "601"
+}
+
+// ValidarReceptor verifica que RFC, régimen y uso CFDI sean congruentes entre sí
+// según los catálogos del SAT (errores CFDI40157/CFDI40158/CFDI40161).
+func ValidarReceptor(rfc, regimen, uso string) error {
+	if EsPublicoGeneral(rfc) {
+		if regimen != "" && regimen != "616" {
+			return fmt.Errorf("público en general requiere régimen 616, no %s", regimen)
+		}
+		if uso != "" && uso != "S01" {
+			return fmt.Errorf("público en general requiere uso CFDI S01, no %s", uso)
+		}
+		return nil
+	}
+	if !rfcPattern.MatchString(rfc) {
+		return fmt.Errorf("RFC del receptor inválido: %s", rfc)
+	}
+	tipo, tipoNombre := personaMoral, "moral"
+	if len([]rune(rfc)) == 13 {
+		tipo, tipoNombre = personaFisica, "física"
+	}
+	aplica, ok := regimenesFiscales[regimen]
+	if !ok {
+		return fmt.Errorf("régimen fiscal del receptor desconocido: %q", regimen)
+	}
+	if aplica&tipo == 0 {
+		return fmt.Errorf("el régimen %s no aplica a persona %s (RFC %s)", regimen, tipoNombre, rfc)
+	}
+	permitidos, ok := usosCFDI[uso]
+	if !ok {
+		return fmt.Errorf("uso CFDI desconocido: %q", uso)
+	}
+	for _, r := range permitidos {
+		if r == regimen {
+			return nil
+		}
+	}
+	return fmt.Errorf("el uso CFDI %s no es válido para el régimen %s", uso, regimen)
+}
+
+// normalizarRFC deja el RFC en mayúsculas y sin espacios.
+func normalizarRFC(rfc string) string {
+	return strings.ToUpper(strings.TrimSpace(rfc))
+}
@@ -0,0 +1,63 @@
+package xmlgen
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidarReceptor(t *testing.T) {
+	casos := []struct {
+		rfc, regimen, uso string
+		ok                bool
+	}{
+		{"XAXX010101000", "616", "S01", true},
+		{"XAXX010101000", "601", "G03", false},
+		{"EKU9003173C9", "601", "G03", true},
+		{"EKU9003173C9", "612", "G03", false}, // 612 es solo persona física
+		{"EKU9003173C9", "601", "D01", false}, // deducciones personales no aplican a morales
+		{"EKU9003173C9", "626", "G01", true},  // RESICO aplica a ambos
+		{"XIQB891116QE4", "612", "D01", true},
+		{"XIQB891116QE4", "601", "G03", false},
+		{"XIQB891116QE4", "605", "G03", false}, // sueldos y salarios no puede G03
+		{"XIQB891116QE4", "605", "CN01", true},
+		{"XIQB891116QE4", "999", "G03", false},
+		{"XIQB891116QE4", "612", "Z99", false},
+		{"NO-ES-RFC", "612", "G03", false},
+	}
+	for _, c := range casos {
+		err := ValidarReceptor(c.rfc, c.regimen, c.uso)
+		if (err == nil) != c.ok {
+			t.Errorf("ValidarReceptor(%s, %s, %s): err=%v, esperaba ok=%v", c.rfc, c.regimen, c.uso, err, c.ok)
+		}
+	}
+}
+
+func TestGenerarXML_ReceptorUsaRegimenYUso(t *testing.T) {
+	data := SaleData{
+		Fecha: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), RFC: "xiqb891116qe4",
+		NombreReceptor: "Juan Pérez", RegimenFiscalReceptor: "612", UsoCFDI: "D01",
+		Items: []SaleItem{item("Consulta", 1, 58000)}, CodigoPostalReceptor: "64000",
+	}
+	xmlStr, err := GenerarXML(data, "", "")
+	if err != nil {
+		t.Fatalf("GenerarXML: %v", err)
+	}
+	if !strings.Contains(xmlStr, `Rfc="XIQB891116QE4" Nombre="JUAN PÉREZ" DomicilioFiscalReceptor="64000" RegimenFiscalReceptor="612" UsoCFDI="D01"`) {
+		t.Errorf("receptor no refleja régimen/uso solicitados:\n%s", xmlStr)
+	}
+
+	data.RegimenFiscalReceptor = "601"
+	if _, err := GenerarXML(data, "", ""); err == nil {
+		t.Error("esperaba error por régimen de persona moral en RFC de persona física")
+	}
+
+	data.RegimenFiscalReceptor, data.UsoCFDI = "", ""
+	xmlStr, err = GenerarXML(data, "", "")
+	if err != nil {
+		t.Fatalf("GenerarXML con valores por defecto: %v", err)
+	}
+	if !strings.Contains(xmlStr, `RegimenFiscalReceptor="612" UsoCFDI="G03"`) {
+		t.Errorf("esperaba régimen 612 y uso G03 por defecto para persona física")
+	}
+}
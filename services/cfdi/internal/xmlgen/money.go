@@ -0,0 +1,159 @@
+package xmlgen
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Money es un importe decimal exacto expresado en millonésimas (6 decimales),
+// la máxima precisión que admite el CFDI 4.0. Evita los errores de float64 al
+// desglosar IVA y al sumar conceptos.
+type Money int64
+
+const moneyScale = 1_000_000
+
+// TasaIVA16 es la tasa general de IVA (0.160000).
+const TasaIVA16 Money = 160_000
+
+// NewMoney convierte un float64 (p.ej. un double de protobuf) redondeando a 6 decimales.
+func NewMoney(v float64) Money {
+	return Money(math.Round(v * moneyScale))
+}
+
+// MoneyFromCents crea un importe a partir de centavos.
+func MoneyFromCents(cents int64) Money {
+	return Money(cents * (moneyScale / 100))
+}
+
+// MoneyFromInt crea un importe entero (p.ej. una cantidad de piezas).
+func MoneyFromInt(n int64) Money {
+	return Money(n * moneyScale)
+}
+
+// ParseMoney interpreta un decimal como "123.45" o "-0.160000" sin pasar por float64.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("importe vacío")
+	}
+	neg := false
+	switch s[0] {
+	case '-':
+		neg = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" {
+		return 0, fmt.Errorf("importe inválido: %q", s)
+	}
+	if len(fracPart) > 6 {
+		return 0, fmt.Errorf("importe con más de 6 decimales: %q", s)
+	}
+	var v int64
+	for _, c := range intPart + fracPart + strings.Repeat("0", 6-len(fracPart)) {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("importe inválido: %q", s)
+		}
+		v = v*10 + int64(c-'0')
+	}
+	if neg {
+		v = -v
+	}
+	return Money(v), nil
+}
+
+func (m Money) Add(o Money) Money { return m + o }
+func (m Money) Sub(o Money) Money { return m - o }
+func (m Money) Neg() Money        { return -m }
+func (m Money) IsZero() bool      { return m == 0 }
+
+// Sign devuelve -1, 0 o 1.
+func (m Money) Sign() int {
+	switch {
+	case m < 0:
+		return -1
+	case m > 0:
+		return 1
+	}
+	return 0
+}
+
+// MulInt multiplica por un entero sin perder precisión.
+func (m Money) MulInt(n int64) Money { return m * Money(n) }
+
+// Mul multiplica dos decimales (p.ej. Base × TasaOCuota) redondeando a 6 decimales.
+func (m Money) Mul(o Money) Money {
+	p := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(int64(o)))
+	return Money(divRound(p, big.NewInt(moneyScale)))
+}
+
+// Div divide dos decimales (p.ej. precio con IVA ÷ 1.16) redondeando a 6 decimales.
+func (m Money) Div(o Money) Money {
+	if o == 0 {
+		panic("xmlgen: división de Money entre cero")
+	}
+	n := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(moneyScale))
+	return Money(divRound(n, big.NewInt(int64(o))))
+}
+
+// Round redondea a la cantidad de decimales indicada (0-6) con la regla del SAT:
+// a partir de 5 se sube, alejándose de cero.
+func (m Money) Round(decimals int) Money {
+	if decimals >= 6 {
+		return m
+	}
+	if decimals < 0 {
+		decimals = 0
+	}
+	unit := int64(math.Pow10(6 - decimals))
+	return Money(divRound(big.NewInt(int64(m)), big.NewInt(unit)) * unit)
+}
+
+// Format imprime el importe con exactamente `decimals` decimales, redondeando si hace falta.
+func (m Money) Format(decimals int) string {
+	if decimals > 6 {
+		decimals = 6
+	}
+	if decimals < 0 {
+		decimals = 0
+	}
+	r := int64(m.Round(decimals))
+	sign := ""
+	if r < 0 {
+		sign = "-"
+		r = -r
+	}
+	ent := r / moneyScale
+	if decimals == 0 {
+		return fmt.Sprintf("%s%d", sign, ent)
+	}
+	frac := fmt.Sprintf("%06d", r%moneyScale)[:decimals]
+	return fmt.Sprintf("%s%d.%s", sign, ent, frac)
+}
+
+// String formatea a 2 decimales (pesos y centavos).
+func (m Money) String() string { return m.Format(2) }
+
+// Float64 sirve solo para interoperar con protobuf/JSON; no usar para cálculos.
+func (m Money) Float64() float64 { return float64(m) / moneyScale }
+
+// divRound divide n/d redondeando la mitad alejándose de cero.
+func divRound(n, d *big.Int) int64 {
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+	if r.Sign() != 0 {
+		r2 := new(big.Int).Abs(r)
+		r2.Lsh(r2, 1)
+		if r2.Cmp(new(big.Int).Abs(d)) >= 0 {
+			if (n.Sign() < 0) != (d.Sign() < 0) {
+				q.Sub(q, big.NewInt(1))
+			} else {
+				q.Add(q, big.NewInt(1))
+			}
+		}
+	}
+	return q.Int64()
+}
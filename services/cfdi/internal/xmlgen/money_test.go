@@ -0,0 +1,68 @@
+package xmlgen
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	casos := []struct {
+		in   string
+		want Money
+	}{
+		{"0", 0},
+		{"20", 20_000_000},
+		{"20.5", 20_500_000},
+		{"0.160000", 160_000},
+		{"-1.01", -1_010_000},
+		{".5", 500_000},
+	}
+	for _, c := range casos {
+		got, err := ParseMoney(c.in)
+		if err != nil {
+			t.Errorf("ParseMoney(%q): error inesperado %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("ParseMoney(%q) = %d, esperaba %d", c.in, got, c.want)
+		}
+	}
+	for _, malo := range []string{"", "abc", "1.2345678", "1,50", "-"} {
+		if _, err := ParseMoney(malo); err == nil {
+			t.Errorf("ParseMoney(%q): esperaba error", malo)
+		}
+	}
+}
+
+func TestMoney_RoundSAT(t *testing.T) {
+	casos := []struct {
+		in       string
+		decimals int
+		want     string
+	}{
+		{"1.005", 2, "1.01"},
+		{"1.004999", 2, "1.00"},
+		{"-1.005", 2, "-1.01"},
+		{"2.5", 0, "3"},
+		{"17.241379", 2, "17.24"},
+	}
+	for _, c := range casos {
+		m, err := ParseMoney(c.in)
+		if err != nil {
+			t.Fatalf("ParseMoney(%q): %v", c.in, err)
+		}
+		if got := m.Format(c.decimals); got != c.want {
+			t.Errorf("%s.Format(%d) = %s, esperaba %s", c.in, c.decimals, got, c.want)
+		}
+	}
+}
+
+func TestMoney_MulDiv(t *testing.T) {
+	base := MoneyFromCents(1724)
+	if got := base.Mul(TasaIVA16).Format(6); got != "2.758400" {
+		t.Errorf("17.24 × 0.16 = %s, esperaba 2.758400", got)
+	}
+	if got := MoneyFromCents(2000).Div(factorIVA16).Format(6); got != "17.241379" {
+		t.Errorf("20 ÷ 1.16 = %s, esperaba 17.241379", got)
+	}
+	if got := NewMoney(0.1).Add(NewMoney(0.2)); got != MoneyFromCents(30) {
+		t.Errorf("0.1 + 0.2 = %s, esperaba exactamente 0.30", got.Format(6))
+	}
+}
@@ -13,25 +13,175 @@ import (
     "fmt"
     "strings"
     "time"
+    "unicode/utf8"
 )
 
 type SaleData struct {
 	SaleID          string
 	Fecha           time.Time
+	// Serie y Folio internos del emisor, asignados por la serie configurada del tenant.
+	// Son opcionales; si vienen vacíos no se incluyen en el comprobante.
+	Serie           string
+	Folio           string
+	// Emisor del comprobante (RFC y nombre del CSD del tenant); si viene vacío se
+	// usa el emisor de pruebas del SAT.
+	EmisorRFC       string
+	EmisorNombre    string
+	EmisorRegimen   string
 	RFC             string
 	NombreReceptor  string
+	// RegimenFiscalReceptor y UsoCFDI del receptor identificado; si vienen vacíos se
+	// asume el régimen por tipo de RFC (RegimenPorDefecto) y uso G03.
+	RegimenFiscalReceptor string
+	UsoCFDI               string
 	Items           []SaleItem
-	Total           float64
+	Total           Money
+	// Moneda (c_Moneda) en la que se cobró la venta; vacía es MXN. Para otras monedas
+	// TipoCambio es el tipo de cambio a pesos (DOF) del día de la operación.
+	Moneda          string
+	TipoCambio      Money
 	FormaPago       string
 	LugarExpedicion string
 	CodigoPostalReceptor string
 }
 
+// SaleItem es una línea del ticket. PrecioUnitario y Subtotal incluyen IVA,
+// tal como se cobran en caja.
 type SaleItem struct {
 	Nombre         string
 	Cantidad       int32
-	PrecioUnitario float64
-	Subtotal       float64
+	PrecioUnitario Money
+	Subtotal       Money
+	// Retenciones que el receptor hace sobre la base del concepto (ver PerfilesRetencion)
+	Retenciones []Retencion
+}
+
+// Retencion es un impuesto (c_Impuesto) retenido a la tasa indicada sobre la base.
+type Retencion struct {
+	Impuesto string
+	Tasa     Money
+}
+
+// RetencionDesglose es una retención ya calculada. En el desglose global Base queda en cero.
+type RetencionDesglose struct {
+	Impuesto string
+	Tasa     Money
+	Base     Money
+	Importe  Money
+}
+
+// decimalesMXN es la cantidad de decimales que admite el peso mexicano.
+const decimalesMXN = 2
+
+// factorIVA16 es 1 + TasaIVA16, usado para extraer la base de un precio con IVA.
+const factorIVA16 = moneyScale + TasaIVA16
+
+// ConceptoDesglose contiene los importes ya redondeados de un concepto.
+type ConceptoDesglose struct {
+	Item          SaleItem
+	ValorUnitario Money
+	Importe       Money
+	Base          Money
+	IVA           Money
+	Retenciones   []RetencionDesglose
+}
+
+// Desglose es el cálculo de un comprobante siguiendo las reglas de redondeo del SAT:
+// cada concepto se redondea a los decimales de la moneda, el IVA del concepto se
+// calcula sobre su Base ya redondeada, y los totales son la suma exacta de los
+// conceptos, de modo que el Traslado global coincide con la suma de los traslados.
+// Las retenciones se calculan igual, sobre la Base de cada concepto, y se restan del Total.
+type Desglose struct {
+	Conceptos        []ConceptoDesglose
+	SubTotal         Money
+	TotalTrasladados Money
+	Retenciones      []RetencionDesglose
+	TotalRetenidos   Money
+	Total            Money
+}
+
+// Desglosar separa base e IVA de cada línea con IVA incluido, en pesos.
+func Desglosar(items []SaleItem) (Desglose, error) {
+	return DesglosarEn(items, decimalesMXN)
+}
+
+// DesglosarEn es Desglosar para una moneda con la cantidad de decimales indicada.
+func DesglosarEn(items []SaleItem, decimales int) (Desglose, error) {
+	var d Desglose
+	if len(items) == 0 {
+		return d, fmt.Errorf("el comprobante no tiene conceptos")
+	}
+	for i, item := range items {
+		if item.Cantidad <= 0 {
+			return d, fmt.Errorf("concepto %d: cantidad debe ser mayor a 0", i+1)
+		}
+		bruto := item.Subtotal
+		if bruto.IsZero() {
+			bruto = item.PrecioUnitario.MulInt(int64(item.Cantidad))
+		}
+		bruto = bruto.Round(decimales)
+		if bruto.Sign() <= 0 {
+			return d, fmt.Errorf("concepto %d: importe debe ser mayor a 0", i+1)
+		}
+		base, iva := separarIVA(bruto, decimales)
+		concepto := ConceptoDesglose{
+			Item:          item,
+			ValorUnitario: base.Div(MoneyFromInt(int64(item.Cantidad))),
+			Importe:       base,
+			Base:          base,
+			IVA:           iva,
+		}
+		for _, r := range item.Retenciones {
+			if r.Impuesto != ImpuestoISR && r.Impuesto != ImpuestoIVA {
+				return d, fmt.Errorf("concepto %d: impuesto retenido desconocido %q", i+1, r.Impuesto)
+			}
+			if r.Tasa.Sign() <= 0 || (r.Impuesto == ImpuestoIVA && r.Tasa > TasaIVA16) {
+				return d, fmt.Errorf("concepto %d: tasa de retención inválida %s", i+1, r.Tasa.Format(6))
+			}
+			importe := base.Mul(r.Tasa).Round(decimales)
+			concepto.Retenciones = append(concepto.Retenciones, RetencionDesglose{Impuesto: r.Impuesto, Tasa: r.Tasa, Base: base, Importe: importe})
+			d.TotalRetenidos = d.TotalRetenidos.Add(importe)
+		}
+		d.Conceptos = append(d.Conceptos, concepto)
+		d.SubTotal = d.SubTotal.Add(base)
+		d.TotalTrasladados = d.TotalTrasladados.Add(iva)
+	}
+	// Retenciones globales: una por impuesto, en orden de clave (ISR y luego IVA)
+	for _, imp := range []string{ImpuestoISR, ImpuestoIVA} {
+		var suma Money
+		hay := false
+		for _, c := range d.Conceptos {
+			for _, r := range c.Retenciones {
+				if r.Impuesto == imp {
+					suma, hay = suma.Add(r.Importe), true
+				}
+			}
+		}
+		if hay {
+			d.Retenciones = append(d.Retenciones, RetencionDesglose{Impuesto: imp, Importe: suma})
+		}
+	}
+	d.Total = d.SubTotal.Add(d.TotalTrasladados).Sub(d.TotalRetenidos)
+	return d, nil
+}
+
+// separarIVA obtiene la base redondeada de un importe con IVA y su IVA
+// (Base × 0.16 redondeado). Si redondear la base una unidad mínima de la moneda
+// (un centavo en MXN) arriba o abajo hace que Base + IVA sea exactamente el importe
+// cobrado, se prefiere ese valor para que el Total del CFDI cuadre con el ticket.
+func separarIVA(bruto Money, decimales int) (base, iva Money) {
+	unidad := MoneyFromInt(1)
+	for i := 0; i < decimales; i++ {
+		unidad /= 10
+	}
+	base = bruto.Div(factorIVA16).Round(decimales)
+	for _, candidato := range []Money{base, base.Add(unidad), base.Sub(unidad)} {
+		ivaCandidato := candidato.Mul(TasaIVA16).Round(decimales)
+		if candidato.Add(ivaCandidato) == bruto {
+			return candidato, ivaCandidato
+		}
+	}
+	return base, base.Mul(TasaIVA16).Round(decimales)
 }
 
 // --- Structs para parsear CFDI 4.0 ---
@@ -39,6 +189,8 @@ type SaleItem struct {
 type Comprobante struct {
 	XMLName           xml.Name           `xml:"Comprobante"`
 	Version           string             `xml:"Version,attr"`
+	Serie             string             `xml:"Serie,attr"`
+	Folio             string             `xml:"Folio,attr"`
 	Fecha             string             `xml:"Fecha,attr"`
 	FormaPago         string             `xml:"FormaPago,attr"`
 	NoCertificado     string             `xml:"NoCertificado,attr"`
@@ -153,31 +305,71 @@ func GenerarXML(data SaleData, certBase64, noCert string) (string, error) {
     cpReceptor := data.CodigoPostalReceptor
     if cpReceptor == "" { cpReceptor = lugar }
 
-	var subtotal float64
-	for _, item := range data.Items { subtotal += item.Subtotal }
-	baseIVA := subtotal / 1.16
-	ivaTotal := subtotal - baseIVA
+	serieFolio, err := atributosSerieFolio(data.Serie, data.Folio)
+	if err != nil {
+		return "", err
+	}
+
+	moneda := strings.ToUpper(strings.TrimSpace(data.Moneda))
+	if moneda == "" { moneda = MonedaNacional }
+	if err := ValidarMoneda(moneda, data.TipoCambio); err != nil {
+		return "", err
+	}
+	dec, _ := DecimalesMoneda(moneda)
+	var tipoCambioAttr string
+	if moneda != MonedaNacional && moneda != monedaSinOperacion {
+		tipoCambioAttr = ` TipoCambio="` + data.TipoCambio.Format(6) + `"`
+	}
+
+	desglose, err := DesglosarEn(data.Items, dec)
+	if err != nil {
+		return "", err
+	}
 
 	var conceptosXML []string
-	for _, item := range data.Items {
-		baseItem := item.Subtotal / 1.16
-		ivaItem := item.Subtotal - baseItem
+	for _, c := range desglose.Conceptos {
+		var retencionesXML string
+		if len(c.Retenciones) > 0 {
+			retencionesXML = `        <cfdi:Retenciones>` + "\n"
+			for _, r := range c.Retenciones {
+				retencionesXML += fmt.Sprintf(`          <cfdi:Retencion Base="%s" Impuesto="%s" TipoFactor="Tasa" TasaOCuota="%s" Importe="%s"/>`+"\n",
+					r.Base.Format(dec), r.Impuesto, r.Tasa.Format(6), r.Importe.Format(dec))
+			}
+			retencionesXML += `        </cfdi:Retenciones>` + "\n"
+		}
 		concepto := fmt.Sprintf(
-			`    <cfdi:Concepto ClaveProdServ="78101803" ClaveUnidad="E48" Cantidad="%.6f" Descripcion="%s" ValorUnitario="%.6f" Importe="%.6f" ObjetoImp="02">`+"\n"+
+			`    <cfdi:Concepto ClaveProdServ="78101803" ClaveUnidad="E48" Cantidad="%.6f" Descripcion="%s" ValorUnitario="%s" Importe="%s" ObjetoImp="02">`+"\n"+
 				`      <cfdi:Impuestos>`+"\n"+
 				`        <cfdi:Traslados>`+"\n"+
-				`          <cfdi:Traslado Base="%.2f" Impuesto="002" TipoFactor="Tasa" TasaOCuota="0.160000" Importe="%.2f"/>`+"\n"+
+				`          <cfdi:Traslado Base="%s" Impuesto="002" TipoFactor="Tasa" TasaOCuota="%s" Importe="%s"/>`+"\n"+
 				`        </cfdi:Traslados>`+"\n"+
+				`%s`+
 				`      </cfdi:Impuestos>`+"\n"+
 				`    </cfdi:Concepto>`,
-			float64(item.Cantidad), escapeXML(item.Nombre),
-			item.PrecioUnitario/1.16, baseItem, baseItem, ivaItem)
+			float64(c.Item.Cantidad), escapeXML(c.Item.Nombre),
+			c.ValorUnitario.Format(6), c.Importe.Format(dec),
+			c.Base.Format(dec), TasaIVA16.Format(6), c.IVA.Format(dec),
+			retencionesXML)
 		conceptosXML = append(conceptosXML, concepto)
 	}
 
 	// ── Determinar tipo de receptor ──────────────────────────────────────────
-	rfcReceptor := data.RFC
-	esPublicoGeneral := rfcReceptor == "" || rfcReceptor == "XAXX010101000"
+	rfcReceptor := normalizarRFC(data.RFC)
+	esPublicoGeneral := EsPublicoGeneral(rfcReceptor)
+	if esPublicoGeneral && len(desglose.Retenciones) > 0 {
+		return "", fmt.Errorf("las ventas a público en general no llevan retenciones")
+	}
+
+	// Impuestos globales: retenciones (si hay) antes de traslados, como pide el esquema
+	var totalRetenidosAttr, retencionesGlobalesXML string
+	if len(desglose.Retenciones) > 0 {
+		totalRetenidosAttr = ` TotalImpuestosRetenidos="` + desglose.TotalRetenidos.Format(dec) + `"`
+		retencionesGlobalesXML = `    <cfdi:Retenciones>` + "\n"
+		for _, r := range desglose.Retenciones {
+			retencionesGlobalesXML += fmt.Sprintf(`      <cfdi:Retencion Impuesto="%s" Importe="%s"/>`+"\n", r.Impuesto, r.Importe.Format(dec))
+		}
+		retencionesGlobalesXML += `    </cfdi:Retenciones>` + "\n"
+	}
 
 	var receptorXML, infoGlobalXML string
 
@@ -198,12 +390,25 @@ func GenerarXML(data SaleData, certBase64, noCert string) (string, error) {
 			nombreReceptor = "ESCUELA KEMPER URGATE"
 		}
 		if nombreReceptor == "" { nombreReceptor = rfcReceptor }
+		regimen := data.RegimenFiscalReceptor
+		if regimen == "" { regimen = RegimenPorDefecto(rfcReceptor) }
+		uso := data.UsoCFDI
+		if uso == "" { uso = "G03" }
+		if err := ValidarReceptor(rfcReceptor, regimen, uso); err != nil {
+			return "", err
+		}
 		infoGlobalXML = ""
 		receptorXML = fmt.Sprintf(
-			`  <cfdi:Receptor Rfc="%s" Nombre="%s" DomicilioFiscalReceptor="%s" RegimenFiscalReceptor="601" UsoCFDI="G03"/>`,
-			rfcReceptor, escapeXML(nombreReceptor), cpReceptor)
+			`  <cfdi:Receptor Rfc="%s" Nombre="%s" DomicilioFiscalReceptor="%s" RegimenFiscalReceptor="%s" UsoCFDI="%s"/>`,
+			escapeXML(rfcReceptor), escapeXML(strings.ToUpper(nombreReceptor)), cpReceptor, regimen, uso)
 	}
 
+	emisorRFC, emisorNombre, emisorRegimen := normalizarRFC(data.EmisorRFC), strings.ToUpper(data.EmisorNombre), data.EmisorRegimen
+	if emisorRFC == "" {
+		emisorRFC, emisorNombre, emisorRegimen = "EKU9003173C9", "ESCUELA KEMPER URGATE", "601"
+	}
+	if emisorRegimen == "" { emisorRegimen = RegimenPorDefecto(emisorRFC) }
+
 	// Construir XML
 	var infoGlobalLine string
 	if infoGlobalXML != "" {
@@ -212,29 +417,49 @@ func GenerarXML(data SaleData, certBase64, noCert string) (string, error) {
 
 	xmlStr := fmt.Sprintf(
 		`<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
-			`<cfdi:Comprobante xmlns:cfdi="http://www.sat.gob.mx/cfd/4" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.sat.gob.mx/cfd/4 http://www.sat.gob.mx/sitio_internet/cfd/4/cfdv40.xsd" Version="4.0" Fecha="%s" Sello="" NoCertificado="%s" Certificado="%s" SubTotal="%.2f" Total="%.2f" Moneda="MXN" TipoDeComprobante="I" MetodoPago="PUE" FormaPago="%s" LugarExpedicion="%s" Exportacion="01">`+"\n"+
+			`<cfdi:Comprobante xmlns:cfdi="http://www.sat.gob.mx/cfd/4" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.sat.gob.mx/cfd/4 http://www.sat.gob.mx/sitio_internet/cfd/4/cfdv40.xsd" Version="4.0"%s Fecha="%s" Sello="" NoCertificado="%s" Certificado="%s" SubTotal="%s" Total="%s" Moneda="%s"%s TipoDeComprobante="I" MetodoPago="PUE" FormaPago="%s" LugarExpedicion="%s" Exportacion="01">`+"\n"+
 			`%s`+
-			`  <cfdi:Emisor Rfc="EKU9003173C9" Nombre="ESCUELA KEMPER URGATE" RegimenFiscal="601"/>`+"\n"+
+			`  <cfdi:Emisor Rfc="%s" Nombre="%s" RegimenFiscal="%s"/>`+"\n"+
 			`%s`+"\n"+
 			`  <cfdi:Conceptos>`+"\n"+
 			`%s`+"\n"+
 			`  </cfdi:Conceptos>`+"\n"+
-			`  <cfdi:Impuestos TotalImpuestosTrasladados="%.2f">`+"\n"+
+			`  <cfdi:Impuestos%s TotalImpuestosTrasladados="%s">`+"\n"+
+			`%s`+
 			`    <cfdi:Traslados>`+"\n"+
-			`      <cfdi:Traslado Base="%.2f" Impuesto="002" TipoFactor="Tasa" TasaOCuota="0.160000" Importe="%.2f"/>`+"\n"+
+			`      <cfdi:Traslado Base="%s" Impuesto="002" TipoFactor="Tasa" TasaOCuota="%s" Importe="%s"/>`+"\n"+
 			`    </cfdi:Traslados>`+"\n"+
 			`  </cfdi:Impuestos>`+"\n"+
 			`</cfdi:Comprobante>`,
-		fecha, noCert, certBase64,
-		baseIVA, subtotal, formaPago, lugar,
+		serieFolio, fecha, noCert, certBase64,
+		desglose.SubTotal.Format(dec), desglose.Total.Format(dec), moneda, tipoCambioAttr, formaPago, lugar,
 		infoGlobalLine,
+		escapeXML(emisorRFC), escapeXML(emisorNombre), emisorRegimen,
 		receptorXML,
 		strings.Join(conceptosXML, "\n"),
-		ivaTotal, baseIVA, ivaTotal)
+		totalRetenidosAttr, desglose.TotalTrasladados.Format(dec),
+		retencionesGlobalesXML,
+		desglose.SubTotal.Format(dec), TasaIVA16.Format(6), desglose.TotalTrasladados.Format(dec))
 
 	return xmlStr, nil
 }
 
+// atributosSerieFolio valida Serie y Folio contra el esquema del SAT (1 a 25 y 1 a 40
+// caracteres, sin "|") y devuelve los atributos a insertar en el Comprobante.
+func atributosSerieFolio(serie, folio string) (string, error) {
+	serie, folio = strings.TrimSpace(serie), strings.TrimSpace(folio)
+	if utf8.RuneCountInString(serie) > 25 || strings.Contains(serie, "|") {
+		return "", fmt.Errorf("serie %q inválida: máximo 25 caracteres y sin \"|\"", serie)
+	}
+	if utf8.RuneCountInString(folio) > 40 || strings.Contains(folio, "|") {
+		return "", fmt.Errorf("folio %q inválido: máximo 40 caracteres y sin \"|\"", folio)
+	}
+	var attrs string
+	if serie != "" { attrs += ` Serie="` + escapeXML(serie) + `"` }
+	if folio != "" { attrs += ` Folio="` + escapeXML(folio) + `"` }
+	return attrs, nil
+}
+
 func FirmarXML(xmlStr string, keyBytes []byte, password string) (string, error) {
 	privateKey, err := parsePrivateKey(keyBytes, password)
 	if err != nil {
@@ -289,7 +514,8 @@ func parsePrivateKey(keyBytes []byte, password string) (*rsa.PrivateKey, error)
     return rsaKey, nil
 }
 
-func generarCadenaOriginal(xmlStr string) (string, error) {
+// parseComprobante lee un CFDI generado por GenerarXML en la estructura Comprobante.
+func parseComprobante(xmlStr string) (*Comprobante, error) {
 	normalized := strings.ReplaceAll(xmlStr, "cfdi:", "")
 	normalized = strings.ReplaceAll(normalized, `xmlns:cfdi="http://www.sat.gob.mx/cfd/4"`, "")
 	normalized = strings.ReplaceAll(normalized, "AÃ±o=", "Ano=")
@@ -297,7 +523,15 @@ func generarCadenaOriginal(xmlStr string) (string, error) {
 
 	var c Comprobante
 	if err := xml.Unmarshal([]byte(normalized), &c); err != nil {
-		return "", fmt.Errorf("parsear XML: %w", err)
+		return nil, fmt.Errorf("parsear XML: %w", err)
+	}
+	return &c, nil
+}
+
+func generarCadenaOriginal(xmlStr string) (string, error) {
+	c, err := parseComprobante(xmlStr)
+	if err != nil {
+		return "", err
 	}
 
 	var campos []string
@@ -306,6 +540,8 @@ func generarCadenaOriginal(xmlStr string) (string, error) {
 	}
 
 	add(c.Version)
+	add(c.Serie)
+	add(c.Folio)
 	add(c.Fecha)
 	add(c.FormaPago)
 	add(c.NoCertificado)
@@ -360,15 +596,16 @@ func generarCadenaOriginal(xmlStr string) (string, error) {
 		}
 	}
 
+	// Orden del XSLT del SAT: retenciones, total retenido, traslados, total trasladado
 	if c.Impuestos != nil {
+		for _, r := range c.Impuestos.Retenciones {
+			add(r.Impuesto); add(r.Importe)
+		}
+		add(c.Impuestos.TotalImpuestosRetenidos)
 		for _, t := range c.Impuestos.Traslados {
 			add(t.Base); add(t.Impuesto); add(t.TipoFactor); add(t.TasaOCuota); add(t.Importe)
 		}
-		add(c.Impuestos.TotalImpuestosRetenidos)
 		add(c.Impuestos.TotalImpuestosTrasladados)
-		for _, r := range c.Impuestos.Retenciones {
-			add(r.Impuesto); add(r.Importe)
-		}
 	}
 
 	return "||" + strings.Join(campos, "|") + "||", nil
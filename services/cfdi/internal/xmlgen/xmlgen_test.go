@@ -0,0 +1,263 @@
+package xmlgen
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func item(nombre string, cantidad int32, precioCentavos int64) SaleItem {
+	precio := MoneyFromCents(precioCentavos)
+	return SaleItem{Nombre: nombre, Cantidad: cantidad, PrecioUnitario: precio, Subtotal: precio.MulInt(int64(cantidad))}
+}
+
+func mustMoney(t *testing.T, s string) Money {
+	t.Helper()
+	m, err := ParseMoney(s)
+	if err != nil {
+		t.Fatalf("ParseMoney(%q): %v", s, err)
+	}
+	return m
+}
+
+func TestGenerarXML_TotalesCuadranConConceptos(t *testing.T) {
+	casos := []struct {
+		nombre      string
+		items       []SaleItem
+		totalTicket string
+	}{
+		{"un peso", []SaleItem{item("Chicle", 1, 100)}, "1.00"},
+		{"un centavo", []SaleItem{item("Redondeo", 1, 1)}, "0.01"},
+		{"refresco y papas", []SaleItem{item("Coca-Cola 600ml", 1, 2000), item("Papas Sabritas", 1, 1800)}, "38.00"},
+		{"tres líneas de 19.99", []SaleItem{item("A", 1, 1999), item("B", 1, 1999), item("C", 1, 1999)}, "59.97"},
+		{"cantidad con precio periódico", []SaleItem{item("Tortilla kg", 3, 3333)}, "99.99"},
+		{"muchas líneas de 10.50", func() []SaleItem {
+			var out []SaleItem
+			for i := 0; i < 37; i++ {
+				out = append(out, item("Pan", 1, 1050))
+			}
+			return out
+		}(), "388.50"},
+		{"mezcla con centavos impares", []SaleItem{
+			item("Leche", 2, 2745), item("Huevo", 1, 4399), item("Jabón", 5, 1187), item("Café", 1, 13999),
+		}, "298.23"},
+		{"ticket grande", []SaleItem{item("Pantalla", 1, 1299999), item("Soporte", 2, 45050)}, "13901.00"},
+	}
+
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			xmlStr, err := GenerarXML(SaleData{
+				SaleID: "test", Fecha: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+				Items: c.items, FormaPago: "01", LugarExpedicion: "64000",
+			}, "", "30001000000500003416")
+			if err != nil {
+				t.Fatalf("GenerarXML: %v", err)
+			}
+			comp, err := parseComprobante(xmlStr)
+			if err != nil {
+				t.Fatalf("parsear: %v", err)
+			}
+
+			var sumaImportes, sumaBases, sumaIVA Money
+			for i, con := range comp.Conceptos.Conceptos {
+				importe := mustMoney(t, con.Importe)
+				vu := mustMoney(t, con.ValorUnitario)
+				cant := mustMoney(t, con.Cantidad)
+				// Cantidad × ValorUnitario debe caer dentro de la tolerancia del SAT
+				diff := cant.Mul(vu).Sub(importe)
+				tolerancia := cant.Add(vu).Mul(NewMoney(0.0000005)).Add(MoneyFromCents(1).Div(MoneyFromInt(2)))
+				if diff.Sign() < 0 {
+					diff = diff.Neg()
+				}
+				if diff > tolerancia {
+					t.Errorf("concepto %d: Cantidad×ValorUnitario difiere de Importe en %s", i, diff.Format(6))
+				}
+				if len(con.Impuestos.Traslados) != 1 {
+					t.Fatalf("concepto %d: esperaba 1 traslado", i)
+				}
+				tr := con.Impuestos.Traslados[0]
+				base := mustMoney(t, tr.Base)
+				iva := mustMoney(t, tr.Importe)
+				if base != importe {
+					t.Errorf("concepto %d: Base %s != Importe %s", i, tr.Base, con.Importe)
+				}
+				if want := base.Mul(TasaIVA16).Round(2); iva != want {
+					t.Errorf("concepto %d: IVA %s, esperaba %s", i, tr.Importe, want)
+				}
+				sumaImportes = sumaImportes.Add(importe)
+				sumaBases = sumaBases.Add(base)
+				sumaIVA = sumaIVA.Add(iva)
+			}
+
+			subTotal := mustMoney(t, comp.SubTotal)
+			total := mustMoney(t, comp.Total)
+			totalTras := mustMoney(t, comp.Impuestos.TotalImpuestosTrasladados)
+			if len(comp.Impuestos.Traslados) != 1 {
+				t.Fatalf("esperaba 1 traslado global, hay %d", len(comp.Impuestos.Traslados))
+			}
+			global := comp.Impuestos.Traslados[0]
+
+			if subTotal != sumaImportes {
+				t.Errorf("SubTotal %s != suma de importes %s", comp.SubTotal, sumaImportes)
+			}
+			if mustMoney(t, global.Base) != sumaBases {
+				t.Errorf("Base global %s != suma de bases %s", global.Base, sumaBases)
+			}
+			if mustMoney(t, global.Importe) != sumaIVA {
+				t.Errorf("Traslado global %s != suma de traslados %s", global.Importe, sumaIVA)
+			}
+			if totalTras != sumaIVA {
+				t.Errorf("TotalImpuestosTrasladados %s != suma de traslados %s", comp.Impuestos.TotalImpuestosTrasladados, sumaIVA)
+			}
+			if total != subTotal.Add(totalTras) {
+				t.Errorf("Total %s != SubTotal + IVA %s", comp.Total, subTotal.Add(totalTras))
+			}
+			// El total timbrado no debe alejarse del ticket más de un centavo por concepto
+			diff := total.Sub(mustMoney(t, c.totalTicket))
+			if diff.Sign() < 0 {
+				diff = diff.Neg()
+			}
+			if diff > MoneyFromCents(int64(len(c.items))) {
+				t.Errorf("Total %s se aleja del ticket %s", comp.Total, c.totalTicket)
+			}
+		})
+	}
+}
+
+func TestSepararIVA_PrefiereCuadrarConElTicket(t *testing.T) {
+	for centavos := int64(1); centavos <= 5000; centavos++ {
+		bruto := MoneyFromCents(centavos)
+		base, iva := separarIVA(bruto, 2)
+		if iva != base.Mul(TasaIVA16).Round(2) {
+			t.Fatalf("%s: IVA %s no es Base×0.16 redondeado", bruto, iva)
+		}
+		diff := base.Add(iva).Sub(bruto)
+		if diff.Sign() < 0 {
+			diff = diff.Neg()
+		}
+		if diff > MoneyFromCents(1) {
+			t.Fatalf("%s: Base+IVA = %s se aleja más de un centavo", bruto, base.Add(iva))
+		}
+	}
+}
+
+func TestDesglosar_Errores(t *testing.T) {
+	if _, err := Desglosar(nil); err == nil {
+		t.Error("esperaba error sin conceptos")
+	}
+	if _, err := Desglosar([]SaleItem{{Nombre: "X", Cantidad: 0, Subtotal: MoneyFromCents(100)}}); err == nil {
+		t.Error("esperaba error con cantidad 0")
+	}
+	if _, err := Desglosar([]SaleItem{{Nombre: "X", Cantidad: 1}}); err == nil {
+		t.Error("esperaba error con importe 0")
+	}
+}
+
+func TestGenerarXML_SerieYFolio(t *testing.T) {
+	data := SaleData{
+		SaleID: "test", Fecha: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		Items: []SaleItem{item("Chicle", 1, 100)}, Serie: "MTY-A", Folio: "1042",
+	}
+	xmlStr, err := GenerarXML(data, "", "30001000000500003416")
+	if err != nil {
+		t.Fatalf("GenerarXML: %v", err)
+	}
+	comp, err := parseComprobante(xmlStr)
+	if err != nil {
+		t.Fatalf("parsear: %v", err)
+	}
+	if comp.Serie != "MTY-A" || comp.Folio != "1042" {
+		t.Errorf("Serie/Folio = %q/%q", comp.Serie, comp.Folio)
+	}
+	cadena, err := generarCadenaOriginal(xmlStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(cadena, "||4.0|MTY-A|1042|2026-03-01T12:00:00|") {
+		t.Errorf("la cadena original debe llevar Serie y Folio tras la versión: %s", cadena[:60])
+	}
+
+	data.Serie = "SERIE-DEMASIADO-LARGA-PARA-EL-SAT"
+	if _, err := GenerarXML(data, "", "30001000000500003416"); err == nil {
+		t.Error("esperaba error con serie de más de 25 caracteres")
+	}
+}
+
+func TestGenerarXML_MonedaExtranjera(t *testing.T) {
+	data := SaleData{
+		SaleID: "test", Fecha: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		Items: []SaleItem{item("Llanta", 2, 5999)}, Moneda: "USD", TipoCambio: mustMoney(t, "17.0525"),
+	}
+	xmlStr, err := GenerarXML(data, "", "30001000000500003416")
+	if err != nil {
+		t.Fatalf("GenerarXML: %v", err)
+	}
+	comp, err := parseComprobante(xmlStr)
+	if err != nil {
+		t.Fatalf("parsear: %v", err)
+	}
+	if comp.Moneda != "USD" || comp.TipoCambio != "17.052500" || comp.Total != "119.98" {
+		t.Errorf("Moneda/TipoCambio/Total = %s/%s/%s", comp.Moneda, comp.TipoCambio, comp.Total)
+	}
+
+	// El yen no tiene decimales: los importes se redondean a enteros
+	data.Moneda, data.TipoCambio = "JPY", mustMoney(t, "0.1153")
+	data.Items = []SaleItem{item("Llanta", 1, 1250000)}
+	xmlStr, err = GenerarXML(data, "", "30001000000500003416")
+	if err != nil {
+		t.Fatalf("GenerarXML JPY: %v", err)
+	}
+	comp, _ = parseComprobante(xmlStr)
+	if comp.SubTotal != "10776" || comp.Total != "12500" {
+		t.Errorf("JPY SubTotal/Total = %s/%s", comp.SubTotal, comp.Total)
+	}
+
+	data.TipoCambio = 0
+	if _, err := GenerarXML(data, "", "30001000000500003416"); err == nil {
+		t.Error("esperaba error sin tipo de cambio en moneda extranjera")
+	}
+	data.Moneda = "ZZZ"
+	if _, err := GenerarXML(data, "", "30001000000500003416"); err == nil {
+		t.Error("esperaba error con moneda fuera del catálogo")
+	}
+}
+
+func TestGenerarXML_RetencionesHonorarios(t *testing.T) {
+	honorarios := item("Consultoría", 1, 116000)
+	honorarios.Retenciones = PerfilesRetencion["honorarios"]
+	data := SaleData{
+		SaleID: "test", Fecha: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		RFC: "EKU9003173C9", RegimenFiscalReceptor: "601", UsoCFDI: "G03", CodigoPostalReceptor: "64000",
+		Items: []SaleItem{honorarios, item("Viáticos", 1, 11600)},
+	}
+	xmlStr, err := GenerarXML(data, "", "30001000000500003416")
+	if err != nil {
+		t.Fatalf("GenerarXML: %v", err)
+	}
+	comp, err := parseComprobante(xmlStr)
+	if err != nil {
+		t.Fatalf("parsear: %v", err)
+	}
+	// Base 1,000 + 100: IVA 176; retenciones solo sobre la consultoría: ISR 100 e IVA 106.67
+	if comp.Impuestos.TotalImpuestosRetenidos != "206.67" || comp.Total != "1069.33" {
+		t.Errorf("TotalImpuestosRetenidos/Total = %s/%s", comp.Impuestos.TotalImpuestosRetenidos, comp.Total)
+	}
+	if r := comp.Conceptos.Conceptos[0].Impuestos.Retenciones; len(r) != 2 || r[1].TasaOCuota != "0.106667" || r[1].Importe != "106.67" {
+		t.Errorf("retenciones del concepto = %+v", r)
+	}
+	if len(comp.Conceptos.Conceptos[1].Impuestos.Retenciones) != 0 {
+		t.Error("el concepto sin perfil no debe llevar retenciones")
+	}
+	if g := comp.Impuestos.Retenciones; len(g) != 2 || g[0].Impuesto != ImpuestoISR || g[0].Importe != "100.00" {
+		t.Errorf("retenciones globales = %+v", g)
+	}
+	cadena, _ := generarCadenaOriginal(xmlStr)
+	if !strings.HasSuffix(cadena, "|001|100.00|002|106.67|206.67|1100.00|002|Tasa|0.160000|176.00|176.00||") {
+		t.Errorf("orden de impuestos globales en la cadena original: %s", cadena[len(cadena)-80:])
+	}
+
+	data.RFC, data.RegimenFiscalReceptor, data.UsoCFDI = "XAXX010101000", "", ""
+	if _, err := GenerarXML(data, "", "30001000000500003416"); err == nil {
+		t.Error("esperaba error con retenciones a público en general")
+	}
+}
@@ -2,15 +2,20 @@
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"log"
-	"math"
+	"math/big"
 	"net"
 	"os"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	pb "github.com/turbopos/turbopos/gen/go/proto/loyalty/v1"
+	"github.com/turbopos/turbopos/services/loyalty/internal/duplicados"
+	"github.com/turbopos/turbopos/services/loyalty/internal/lotes"
+	"github.com/turbopos/turbopos/services/loyalty/internal/programa"
 	"google.golang.org/grpc"
 	"fmt"
     "google.golang.org/grpc/codes"
@@ -20,13 +25,11 @@ import (
 
 const Port = ":50054"
 
-// Reglas de puntos
-const (
-	PointsPerPeso   = 1.0  // 1 punto por cada peso gastado
-	TierSilver      = 500  // puntos acumulados para tier silver
-	TierGold        = 2000 // puntos acumulados para tier gold
-	TierPlatinum    = 5000 // puntos acumulados para tier platinum
-)
+// zonaNegocio es la zona horaria con la que se evalúan las promociones por fecha
+var zonaNegocio = func() *time.Location {
+	if loc, err := time.LoadLocation("America/Monterrey"); err == nil { return loc }
+	return time.UTC
+}()
 
 type LoyaltyServer struct {
 	pb.UnimplementedLoyaltyServiceServer
@@ -37,21 +40,27 @@ func NewLoyaltyServer(db *sql.DB) *LoyaltyServer {
 	return &LoyaltyServer{db: db}
 }
 
-// GetAccount obtiene o crea una cuenta de lealtad por teléfono
+// GetAccount obtiene la cuenta de lealtad del teléfono en el programa del tenant
 func (s *LoyaltyServer) GetAccount(ctx context.Context, req *pb.GetAccountRequest) (*pb.AccountResponse, error) {
     if req.Phone == "" {
         return nil, status.Errorf(codes.InvalidArgument, "phone requerido")
     }
+    programaID, err := s.tenantPrograma(ctx, req.TenantId)
+    if err != nil {
+        return nil, err
+    }
+    req.Phone = s.telefonoPrincipal(ctx, programaID, req.Phone)
 
     var id, name, tier, rfc, cp, regimenFiscal, nombreFiscal string
     var points int32
     var totalSpent float64
-    err := s.db.QueryRowContext(ctx, `
+    var gracia sql.NullTime
+    err = s.db.QueryRowContext(ctx, `
         SELECT id, COALESCE(name,''), points, total_spent, tier,
                COALESCE(rfc,''), COALESCE(cp,''),
-               COALESCE(regimen_fiscal,''), COALESCE(nombre_fiscal,'')
-        FROM loyalty_accounts WHERE phone = $1
-    `, req.Phone).Scan(&id, &name, &points, &totalSpent, &tier, &rfc, &cp, &regimenFiscal, &nombreFiscal)
+               COALESCE(regimen_fiscal,''), COALESCE(nombre_fiscal,''), tier_gracia_hasta
+        FROM loyalty_accounts WHERE tenant_id = $1::uuid AND phone = $2
+    `, programaID, req.Phone).Scan(&id, &name, &points, &totalSpent, &tier, &rfc, &cp, &regimenFiscal, &nombreFiscal, &gracia)
 
     if err == sql.ErrNoRows {
         return nil, status.Errorf(codes.NotFound, "cliente no encontrado: %s", req.Phone)
@@ -60,7 +69,7 @@ func (s *LoyaltyServer) GetAccount(ctx context.Context, req *pb.GetAccountReques
         return nil, status.Errorf(codes.Internal, "buscar cuenta: %v", err)
     }
 
-    return &pb.AccountResponse{
+    resp := &pb.AccountResponse{
         AccountId:     id,
         Phone:         req.Phone,
         Name:          name,
@@ -71,7 +80,19 @@ func (s *LoyaltyServer) GetAccount(ctx context.Context, req *pb.GetAccountReques
         Cp:            cp,
         RegimenFiscal: regimenFiscal,
         NombreFiscal:  nombreFiscal,
-    }, nil
+    }
+    // Beneficios del tier para que la caja los aplique al cobrar
+    prog, err := s.cargarPrograma(ctx, programaID)
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "programa de lealtad: %v", err)
+    }
+    nivel := estadoNivel{Nivel: prog.NivelPorClave(tier)}
+    if gracia.Valid { nivel.Gracia = &gracia.Time }
+    if nivel.Calificacion, err = calificacion(ctx, s.db, id, totalSpent, prog, time.Now()); err != nil {
+        return nil, status.Errorf(codes.Internal, "calificar tier: %v", err)
+    }
+    nivel.llenar(resp)
+    return resp, nil
 }
 
 // EarnPoints agrega puntos por una venta
@@ -83,8 +104,19 @@ func (s *LoyaltyServer) EarnPoints(ctx context.Context, req *pb.EarnPointsReques
 		return nil, status.Errorf(codes.InvalidArgument, "total debe ser mayor a 0")
 	}
 
-	// Calcular puntos: 1 punto por peso, redondeado hacia abajo
-	points := int32(math.Floor(req.Total * PointsPerPeso))
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	req.Phone = s.telefonoPrincipal(ctx, programaID, req.Phone)
+	prog, err := s.cargarPrograma(ctx, programaID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "programa de lealtad: %v", err)
+	}
+	partidas, err := s.partidasVenta(ctx, req.Items)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "categorías de productos: %v", err)
+	}
 
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -93,60 +125,251 @@ func (s *LoyaltyServer) EarnPoints(ctx context.Context, req *pb.EarnPointsReques
 	defer tx.Rollback()
 
 	// Obtener o crear cuenta
-	var accountID string
-	var currentPoints int32
+	var accountID, currentTier string
+	var currentPoints, deuda int32
 	var totalSpent float64
 	err = tx.QueryRowContext(ctx, `
-		INSERT INTO loyalty_accounts (phone, name, points, total_spent)
-		VALUES ($1, $2, 0, 0)
-		ON CONFLICT (phone) DO UPDATE SET
+		INSERT INTO loyalty_accounts (tenant_id, phone, name, points, total_spent)
+		VALUES ($3::uuid, $1, $2, 0, 0)
+		ON CONFLICT (tenant_id, phone) DO UPDATE SET
 			name = CASE WHEN $2 != '' THEN $2 ELSE loyalty_accounts.name END,
 			updated_at = NOW()
-		RETURNING id, points, total_spent
-	`, req.Phone, req.Name).Scan(&accountID, &currentPoints, &totalSpent)
+		RETURNING id, points, total_spent, tier, deuda_puntos
+	`, req.Phone, req.Name, programaID).Scan(&accountID, &currentPoints, &totalSpent, &currentTier, &deuda)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "upsert cuenta: %v", err)
 	}
 
-	// Sumar puntos y gasto
-	newPoints := currentPoints + points
+	// Los puntos usan el multiplicador del tier que el cliente tenía antes de esta compra.
+	// Si hay deuda de una devolución anterior, se paga primero con estos puntos.
+	points := prog.Puntos(req.Total, partidas, currentTier, time.Now().In(zonaNegocio))
+	neto, nuevaDeuda := programa.PagarDeuda(points, deuda)
+	newPoints := currentPoints + neto
 	newTotalSpent := totalSpent + req.Total
-	newTier := calculateTier(newTotalSpent)
+
+	// Registrar transacción con el tenant (sucursal) donde se hizo la compra. El índice
+	// único (account_id, sale_id) hace que un reintento de la misma venta no inserte nada.
+	saleID := sql.NullString{String: req.SaleId, Valid: req.SaleId != ""}
+	var txID string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO loyalty_transactions (account_id, tenant_id, sale_id, type, points, monto, description)
+		VALUES ($1, $2::uuid, $3, 'earn', $4, $5, $6)
+		ON CONFLICT (account_id, sale_id) WHERE type = 'earn' DO NOTHING
+		RETURNING id
+	`, accountID, req.TenantId, saleID, points, req.Total, fmt.Sprintf("Compra $%.2f → +%d puntos", req.Total, points)).Scan(&txID)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return s.earnRepetido(ctx, accountID, req)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "registrar transacción: %v", err)
+	}
 
 	_, err = tx.ExecContext(ctx, `
 		UPDATE loyalty_accounts
-		SET points = $1, total_spent = $2, tier = $3, updated_at = NOW()
+		SET points = $1, total_spent = $2, deuda_puntos = $3, updated_at = NOW()
 		WHERE id = $4
-	`, newPoints, newTotalSpent, newTier, accountID)
+	`, newPoints, newTotalSpent, nuevaDeuda, accountID)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "actualizar puntos: %v", err)
 	}
+	if pagados := points - neto; pagados > 0 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO loyalty_transactions (account_id, tenant_id, sale_id, type, points, description)
+			VALUES ($1, $2::uuid, $3, 'deuda', $4, $5)
+		`, accountID, req.TenantId, saleID, -pagados, fmt.Sprintf("Pago de %d puntos por devoluciones anteriores", pagados))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "registrar pago de deuda: %v", err)
+		}
+	}
 
-	// Registrar transacción
-	saleID := sql.NullString{String: req.SaleId, Valid: req.SaleId != ""}
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO loyalty_transactions (account_id, sale_id, type, points, description)
-		VALUES ($1, $2, 'earn', $3, $4)
-	`, accountID, saleID, points, fmt.Sprintf("Compra $%.2f → +%d puntos", req.Total, points))
+	// Los puntos de la compra forman un lote que vence según la vigencia del programa.
+	// Con saldo negativo, el lote solo lleva lo que queda por encima de cero.
+	lotePuntos := neto
+	if newPoints < lotePuntos { lotePuntos = newPoints }
+	if lotePuntos > 0 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO loyalty_lotes (account_id, tenant_id, transaction_id, puntos, restantes, expires_at)
+			VALUES ($1, $2::uuid, $3, $4, $4, $5)
+		`, accountID, programaID, txID, lotePuntos, lotes.Vencimiento(time.Now(), prog.VigenciaMeses, zonaNegocio))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "registrar lote: %v", err)
+		}
+	}
+
+	// La primera compra de un cliente referido paga (o rechaza) los bonos del referido
+	bono, err := completarReferido(ctx, tx, accountID, programaID, saleID, req.Total, prog)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "registrar transacción: %v", err)
+		return nil, status.Errorf(codes.Internal, "bono de referido: %v", err)
+	}
+	if bono > 0 {
+		if err := tx.QueryRowContext(ctx, `SELECT points FROM loyalty_accounts WHERE id = $1`, accountID).Scan(&newPoints); err != nil {
+			return nil, status.Errorf(codes.Internal, "leer saldo: %v", err)
+		}
+	}
+
+	nivel, err := actualizarNivel(ctx, tx, accountID, prog, "compra")
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "calificar tier: %v", err)
 	}
 
 	if err := tx.Commit(); err != nil {
 		return nil, status.Errorf(codes.Internal, "commit: %v", err)
 	}
 
-	log.Printf("[Loyalty] EarnPoints phone=%s sale=%s total=%.2f puntos=+%d total_pts=%d tier=%s",
-		req.Phone, req.SaleId, req.Total, points, newPoints, newTier)
+	log.Printf("[Loyalty] EarnPoints tenant=%s phone=%s sale=%s total=%.2f puntos=+%d total_pts=%d tier=%s",
+		req.TenantId, req.Phone, req.SaleId, req.Total, points, newPoints, nivel.Nivel.Clave)
 
-	return &pb.AccountResponse{
-		AccountId:  accountID,
-		Phone:      req.Phone,
-		Name:       req.Name,
-		Points:     newPoints,
-		TotalSpent: newTotalSpent,
-		Tier:       newTier,
-	}, nil
+	resp := &pb.AccountResponse{
+		AccountId:    accountID,
+		Phone:        req.Phone,
+		Name:         req.Name,
+		Points:       newPoints,
+		TotalSpent:   newTotalSpent,
+		PointsEarned: points,
+		ReferralBonus: bono,
+	}
+	nivel.llenar(resp)
+	return resp, nil
+}
+
+// earnRepetido responde un EarnPoints repetido con la cuenta actual y los puntos que la
+// venta dio la primera vez, sin volver a sumarlos.
+func (s *LoyaltyServer) earnRepetido(ctx context.Context, accountID string, req *pb.EarnPointsRequest) (*pb.AccountResponse, error) {
+	resp := &pb.AccountResponse{AccountId: accountID, Phone: req.Phone, AlreadyEarned: true}
+	var programaID string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT a.tenant_id::text, COALESCE(a.name,''), a.points, a.total_spent, a.tier, t.points
+		FROM loyalty_accounts a JOIN loyalty_transactions t ON t.account_id = a.id
+		WHERE a.id = $1 AND t.sale_id::text = $2 AND t.type = 'earn'
+	`, accountID, req.SaleId).Scan(&programaID, &resp.Name, &resp.Points, &resp.TotalSpent, &resp.Tier, &resp.PointsEarned)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "buscar compra registrada: %v", err)
+	}
+	if prog, err := s.cargarPrograma(ctx, programaID); err == nil {
+		n := prog.NivelPorClave(resp.Tier)
+		resp.TierName, resp.TierDiscountPct, resp.TierMultiplier = n.Nombre, n.DescuentoPct, n.Multiplicador
+	}
+	log.Printf("[Loyalty] EarnPoints repetido tenant=%s phone=%s sale=%s: ya dio %d puntos", req.TenantId, req.Phone, req.SaleId, resp.PointsEarned)
+	return resp, nil
+}
+
+// tenantPrograma devuelve el tenant dueño del programa de lealtad de tenantID: el propio
+// tenant o, para sucursales que comparten el programa de su matriz, el de la matriz.
+// Las cuentas de clientes se guardan bajo ese tenant.
+func (s *LoyaltyServer) tenantPrograma(ctx context.Context, tenantID string) (string, error) {
+	if tenantID == "" {
+		return "", status.Errorf(codes.InvalidArgument, "tenant_id requerido")
+	}
+	var programaID string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(programa_lealtad_id, id)::text FROM tenants WHERE id::text = $1
+	`, tenantID).Scan(&programaID)
+	if err == sql.ErrNoRows {
+		return tenantID, nil
+	}
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "buscar tenant: %v", err)
+	}
+	return programaID, nil
+}
+
+// telefonoPrincipal traduce el teléfono de una cuenta fusionada al de la cuenta que la
+// absorbió; cualquier otro teléfono se devuelve igual
+func (s *LoyaltyServer) telefonoPrincipal(ctx context.Context, programaID, phone string) string {
+	var principal string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT a.phone FROM loyalty_telefonos_alternos t JOIN loyalty_accounts a ON a.id = t.account_id
+		WHERE t.tenant_id = $1::uuid AND t.phone = $2
+	`, programaID, phone).Scan(&principal)
+	if err != nil || principal == "" { return phone }
+	return principal
+}
+
+// cargarPrograma lee las reglas de lealtad del tenant. Lo que el tenant no haya
+// configurado (o un tenant vacío) usa programa.Predeterminado.
+func (s *LoyaltyServer) cargarPrograma(ctx context.Context, tenantID string) (programa.Programa, error) {
+	prog := programa.Predeterminado()
+	if tenantID == "" { return prog, nil }
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT puntos_por_peso, redondeo, COALESCE(vigencia_meses, 0), politica_reversion,
+		       base_nivel, COALESCE(ventana_meses, 0), gracia_dias,
+		       referido_puntos_referente, referido_puntos_referido, referido_compra_minima, referido_tope_mensual
+		FROM loyalty_programas WHERE tenant_id::text = $1
+	`, tenantID).Scan(&prog.PuntosPorPeso, &prog.Redondeo, &prog.VigenciaMeses, &prog.PoliticaReversion,
+		&prog.BaseNivel, &prog.VentanaMeses, &prog.GraciaDias,
+		&prog.Referidos.PuntosReferente, &prog.Referidos.PuntosReferido, &prog.Referidos.CompraMinima, &prog.Referidos.TopeMensual)
+	if err != nil && err != sql.ErrNoRows { return prog, err }
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT clave, nombre, gasto_minimo, multiplicador, descuento_pct FROM loyalty_niveles
+		WHERE tenant_id::text = $1 ORDER BY gasto_minimo
+	`, tenantID)
+	if err != nil { return prog, err }
+	var niveles []programa.Nivel
+	for rows.Next() {
+		var n programa.Nivel
+		if err := rows.Scan(&n.Clave, &n.Nombre, &n.GastoMinimo, &n.Multiplicador, &n.DescuentoPct); err != nil {
+			rows.Close()
+			return prog, err
+		}
+		niveles = append(niveles, n)
+	}
+	rows.Close()
+	if len(niveles) > 0 { prog.Niveles = niveles }
+
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT tipo, valor, multiplicador FROM loyalty_multiplicadores WHERE tenant_id::text = $1
+	`, tenantID)
+	if err != nil { return prog, err }
+	prog.PorCategoria, prog.PorProducto = map[string]float64{}, map[string]float64{}
+	for rows.Next() {
+		var tipo, valor string
+		var mult float64
+		if err := rows.Scan(&tipo, &valor, &mult); err != nil {
+			rows.Close()
+			return prog, err
+		}
+		if tipo == "producto" { prog.PorProducto[valor] = mult } else { prog.PorCategoria[valor] = mult }
+	}
+	rows.Close()
+
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT nombre, desde, hasta, multiplicador FROM loyalty_promociones
+		WHERE tenant_id::text = $1 AND hasta >= CURRENT_DATE - 1
+	`, tenantID)
+	if err != nil { return prog, err }
+	defer rows.Close()
+	for rows.Next() {
+		var pr programa.Promocion
+		if err := rows.Scan(&pr.Nombre, &pr.Desde, &pr.Hasta, &pr.Multiplicador); err != nil { return prog, err }
+		prog.Promociones = append(prog.Promociones, pr)
+	}
+	return prog, rows.Err()
+}
+
+// partidasVenta completa las partidas de la venta con la categoría de cada producto
+func (s *LoyaltyServer) partidasVenta(ctx context.Context, items []*pb.EarnItem) ([]programa.Partida, error) {
+	if len(items) == 0 { return nil, nil }
+	ids := make([]string, 0, len(items))
+	for _, it := range items { ids = append(ids, it.ProductId) }
+	categorias := map[string]string{}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id::text, COALESCE(category,'') FROM products WHERE id::text = ANY($1)
+	`, pq.Array(ids))
+	if err != nil { return nil, err }
+	defer rows.Close()
+	for rows.Next() {
+		var id, cat string
+		if err := rows.Scan(&id, &cat); err != nil { return nil, err }
+		categorias[id] = cat
+	}
+	partidas := make([]programa.Partida, 0, len(items))
+	for _, it := range items {
+		partidas = append(partidas, programa.Partida{ProductoID: it.ProductId, Categoria: categorias[it.ProductId], Subtotal: it.Subtotal})
+	}
+	return partidas, rows.Err()
 }
 
 // RedeemPoints canjea puntos por una recompensa
@@ -157,6 +380,11 @@ func (s *LoyaltyServer) RedeemPoints(ctx context.Context, req *pb.RedeemPointsRe
 	if req.Points <= 0 {
 		return nil, status.Errorf(codes.InvalidArgument, "points debe ser mayor a 0")
 	}
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	req.Phone = s.telefonoPrincipal(ctx, programaID, req.Phone)
 
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -168,16 +396,25 @@ func (s *LoyaltyServer) RedeemPoints(ctx context.Context, req *pb.RedeemPointsRe
 	var accountID string
 	var currentPoints int32
 	err = tx.QueryRowContext(ctx, `
-		SELECT id, points FROM loyalty_accounts WHERE phone = $1 FOR UPDATE
-	`, req.Phone).Scan(&accountID, &currentPoints)
+		SELECT id, points FROM loyalty_accounts WHERE tenant_id = $1::uuid AND phone = $2 FOR UPDATE
+	`, programaID, req.Phone).Scan(&accountID, &currentPoints)
 	if err == sql.ErrNoRows {
 		return nil, status.Errorf(codes.NotFound, "cuenta no encontrada para %s", req.Phone)
 	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "buscar cuenta: %v", err)
 	}
+	vencidos, err := vencerLotes(ctx, tx, accountID, programaID, currentPoints)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "vencer puntos: %v", err)
+	}
+	currentPoints -= vencidos
 
 	if currentPoints < req.Points {
+		// El vencimiento se conserva aunque el canje no proceda
+		if err := tx.Commit(); err != nil {
+			return nil, status.Errorf(codes.Internal, "commit: %v", err)
+		}
 		return &pb.RedeemResponse{
 			Success:          false,
 			PointsRemaining:  currentPoints,
@@ -185,20 +422,23 @@ func (s *LoyaltyServer) RedeemPoints(ctx context.Context, req *pb.RedeemPointsRe
 		}, nil
 	}
 
-	// Descontar puntos
+	// Descontar puntos, primero de los lotes más antiguos
 	newPoints := currentPoints - req.Points
 	_, err = tx.ExecContext(ctx, `
 		UPDATE loyalty_accounts SET points = $1, updated_at = NOW() WHERE id = $2
 	`, newPoints, accountID)
+	if err == nil {
+		err = consumirLotes(ctx, tx, accountID, req.Points)
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "descontar puntos: %v", err)
 	}
 
 	// Registrar transacción
 	_, err = tx.ExecContext(ctx, `
-		INSERT INTO loyalty_transactions (account_id, type, points, description)
-		VALUES ($1, 'redeem', $2, $3)
-	`, accountID, -req.Points, fmt.Sprintf("Canje de %d puntos", req.Points))
+		INSERT INTO loyalty_transactions (account_id, tenant_id, type, points, description)
+		VALUES ($1, $2::uuid, 'redeem', $3, $4)
+	`, accountID, req.TenantId, -req.Points, descripcionCanje(req))
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "registrar canje: %v", err)
 	}
@@ -207,7 +447,7 @@ func (s *LoyaltyServer) RedeemPoints(ctx context.Context, req *pb.RedeemPointsRe
 		return nil, status.Errorf(codes.Internal, "commit: %v", err)
 	}
 
-	log.Printf("[Loyalty] RedeemPoints phone=%s puntos=-%d restantes=%d", req.Phone, req.Points, newPoints)
+	log.Printf("[Loyalty] RedeemPoints tenant=%s phone=%s puntos=-%d restantes=%d", req.TenantId, req.Phone, req.Points, newPoints)
 
 	return &pb.RedeemResponse{
 		Success:         true,
@@ -217,12 +457,314 @@ func (s *LoyaltyServer) RedeemPoints(ctx context.Context, req *pb.RedeemPointsRe
 	}, nil
 }
 
+// vigenciaCanje es el tiempo que tiene el cliente para usar el código de una recompensa
+const vigenciaCanje = 30 * 24 * time.Hour
+
+// alfabetoCanje omite caracteres que se confunden al dictarlos (0/O, 1/I/L)
+const alfabetoCanje = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+func codigoCanje() (string, error) { return codigoAleatorio(8) }
+
+func codigoAleatorio(n int) (string, error) {
+	// rand.Int reparte uniforme sobre el alfabeto; un byte módulo su tamaño favorecería a los primeros caracteres
+	b := make([]byte, n)
+	tope := big.NewInt(int64(len(alfabetoCanje)))
+	for i := range b {
+		k, err := rand.Int(rand.Reader, tope)
+		if err != nil {
+			return "", err
+		}
+		b[i] = alfabetoCanje[k.Int64()]
+	}
+	return string(b), nil
+}
+
+const columnasReward = `id, tenant_id::text, name, COALESCE(description,''), points_required,
+	COALESCE(stock, -1), COALESCE(valido_desde::text,''), COALESCE(valido_hasta::text,''),
+	COALESCE(product_id::text,''), COALESCE(descuento_tipo,''), COALESCE(descuento_valor,0), active`
+
+type escaneable interface{ Scan(dest ...interface{}) error }
+
+func scanReward(row escaneable) (*pb.Reward, error) {
+	rw := &pb.Reward{}
+	err := row.Scan(&rw.Id, &rw.TenantId, &rw.Name, &rw.Description, &rw.PointsRequired,
+		&rw.Stock, &rw.ValidFrom, &rw.ValidUntil, &rw.ProductId, &rw.DiscountType, &rw.DiscountValue, &rw.Active)
+	return rw, err
+}
+
+// validarReward revisa los datos de una recompensa antes de guardarla
+func validarReward(rw *pb.Reward) error {
+	if rw.Name == "" || rw.PointsRequired <= 0 {
+		return status.Errorf(codes.InvalidArgument, "name y points_required (> 0) requeridos")
+	}
+	if rw.Stock < -1 {
+		return status.Errorf(codes.InvalidArgument, "stock inválido (-1 = sin límite)")
+	}
+	for _, f := range []string{rw.ValidFrom, rw.ValidUntil} {
+		if _, err := time.Parse("2006-01-02", f); f != "" && err != nil {
+			return status.Errorf(codes.InvalidArgument, "fecha inválida %q (YYYY-MM-DD)", f)
+		}
+	}
+	if rw.ValidFrom != "" && rw.ValidUntil != "" && rw.ValidUntil < rw.ValidFrom {
+		return status.Errorf(codes.InvalidArgument, "valid_until antes de valid_from")
+	}
+	switch rw.DiscountType {
+	case "":
+		rw.DiscountValue = 0
+	case "monto":
+		if rw.DiscountValue <= 0 {
+			return status.Errorf(codes.InvalidArgument, "discount_value debe ser mayor a 0")
+		}
+	case "porcentaje":
+		if rw.DiscountValue <= 0 || rw.DiscountValue > 100 {
+			return status.Errorf(codes.InvalidArgument, "el porcentaje de descuento debe estar entre 0 y 100")
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "discount_type debe ser monto o porcentaje")
+	}
+	return nil
+}
+
+// ListRewards lista el catálogo del programa del tenant; por defecto solo lo canjeable hoy
+func (s *LoyaltyServer) ListRewards(ctx context.Context, req *pb.ListRewardsRequest) (*pb.ListRewardsResponse, error) {
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+columnasReward+` FROM loyalty_rewards
+		WHERE tenant_id = $1::uuid
+		  AND ($2 OR (active AND COALESCE(stock, 1) > 0
+		       AND COALESCE(valido_desde, CURRENT_DATE) <= CURRENT_DATE
+		       AND COALESCE(valido_hasta, CURRENT_DATE) >= CURRENT_DATE))
+		ORDER BY active DESC, points_required, name
+	`, programaID, req.IncludeInactive)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "recompensas: %v", err)
+	}
+	defer rows.Close()
+	resp := &pb.ListRewardsResponse{}
+	for rows.Next() {
+		rw, err := scanReward(rows)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "recompensas: %v", err)
+		}
+		resp.Rewards = append(resp.Rewards, rw)
+	}
+	return resp, rows.Err()
+}
+
+// CreateReward agrega una recompensa al catálogo del programa del tenant
+func (s *LoyaltyServer) CreateReward(ctx context.Context, req *pb.Reward) (*pb.Reward, error) {
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	if err := validarReward(req); err != nil {
+		return nil, err
+	}
+	rw, err := scanReward(s.db.QueryRowContext(ctx, `
+		INSERT INTO loyalty_rewards (tenant_id, name, description, points_required, stock, valido_desde, valido_hasta,
+		                             product_id, descuento_tipo, descuento_valor, active)
+		VALUES ($1::uuid, $2, NULLIF($3,''), $4, NULLIF($5, -1), NULLIF($6,'')::date, NULLIF($7,'')::date,
+		        NULLIF($8,'')::uuid, NULLIF($9,''), NULLIF($10, 0), true)
+		RETURNING `+columnasReward,
+		programaID, req.Name, req.Description, req.PointsRequired, req.Stock, req.ValidFrom, req.ValidUntil,
+		req.ProductId, req.DiscountType, req.DiscountValue))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "crear recompensa: %v", err)
+	}
+	log.Printf("[Loyalty] Recompensa creada tenant=%s id=%s %q %d pts", programaID, rw.Id, rw.Name, rw.PointsRequired)
+	return rw, nil
+}
+
+// UpdateReward reemplaza los datos de una recompensa (active permite reactivarla)
+func (s *LoyaltyServer) UpdateReward(ctx context.Context, req *pb.Reward) (*pb.Reward, error) {
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	if req.Id == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "id requerido")
+	}
+	if err := validarReward(req); err != nil {
+		return nil, err
+	}
+	rw, err := scanReward(s.db.QueryRowContext(ctx, `
+		UPDATE loyalty_rewards SET name=$3, description=NULLIF($4,''), points_required=$5, stock=NULLIF($6, -1),
+		       valido_desde=NULLIF($7,'')::date, valido_hasta=NULLIF($8,'')::date, product_id=NULLIF($9,'')::uuid,
+		       descuento_tipo=NULLIF($10,''), descuento_valor=NULLIF($11, 0), active=$12, updated_at=NOW()
+		WHERE id::text = $1 AND tenant_id = $2::uuid
+		RETURNING `+columnasReward,
+		req.Id, programaID, req.Name, req.Description, req.PointsRequired, req.Stock, req.ValidFrom, req.ValidUntil,
+		req.ProductId, req.DiscountType, req.DiscountValue, req.Active))
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "recompensa no encontrada")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "actualizar recompensa: %v", err)
+	}
+	return rw, nil
+}
+
+// RetireReward saca la recompensa del catálogo; los códigos ya emitidos siguen valiendo
+func (s *LoyaltyServer) RetireReward(ctx context.Context, req *pb.RetireRewardRequest) (*pb.Reward, error) {
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	rw, err := scanReward(s.db.QueryRowContext(ctx, `
+		UPDATE loyalty_rewards SET active=false, updated_at=NOW()
+		WHERE id::text = $1 AND tenant_id = $2::uuid
+		RETURNING `+columnasReward, req.RewardId, programaID))
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "recompensa no encontrada")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "retirar recompensa: %v", err)
+	}
+	log.Printf("[Loyalty] Recompensa retirada tenant=%s id=%s", programaID, rw.Id)
+	return rw, nil
+}
+
+// RedeemReward canjea una recompensa del catálogo. En una sola transacción valida que
+// esté activa, vigente y con stock, descuenta los puntos y una unidad de stock y emite
+// el código de canje. Igual que RedeemPoints, los puntos insuficientes o una recompensa
+// no disponible no son error: se responde success=false con el motivo.
+func (s *LoyaltyServer) RedeemReward(ctx context.Context, req *pb.RedeemRewardRequest) (*pb.RedeemRewardResponse, error) {
+	if req.Phone == "" || req.RewardId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "phone y reward_id requeridos")
+	}
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	req.Phone = s.telefonoPrincipal(ctx, programaID, req.Phone)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "iniciar transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	var accountID string
+	var currentPoints int32
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, points FROM loyalty_accounts WHERE tenant_id = $1::uuid AND phone = $2 FOR UPDATE
+	`, programaID, req.Phone).Scan(&accountID, &currentPoints)
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "cuenta no encontrada para %s", req.Phone)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "buscar cuenta: %v", err)
+	}
+	vencidos, err := vencerLotes(ctx, tx, accountID, programaID, currentPoints)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "vencer puntos: %v", err)
+	}
+	currentPoints -= vencidos
+
+	rw, err := scanReward(tx.QueryRowContext(ctx, `
+		SELECT `+columnasReward+` FROM loyalty_rewards
+		WHERE id::text = $1 AND tenant_id = $2::uuid FOR UPDATE
+	`, req.RewardId, programaID))
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "recompensa no encontrada")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "buscar recompensa: %v", err)
+	}
+
+	hoy := time.Now().In(zonaNegocio).Format("2006-01-02")
+	rechazo := ""
+	switch {
+	case !rw.Active:
+		rechazo = "La recompensa ya no está disponible"
+	case rw.ValidFrom != "" && hoy < rw.ValidFrom:
+		rechazo = "La recompensa estará disponible a partir del " + rw.ValidFrom
+	case rw.ValidUntil != "" && hoy > rw.ValidUntil:
+		rechazo = "La recompensa venció el " + rw.ValidUntil
+	case rw.Stock == 0:
+		rechazo = "La recompensa está agotada"
+	case currentPoints < rw.PointsRequired:
+		rechazo = fmt.Sprintf("Puntos insuficientes: tienes %d, necesitas %d", currentPoints, rw.PointsRequired)
+	}
+	if rechazo != "" {
+		// El vencimiento se conserva aunque el canje no proceda
+		if err := tx.Commit(); err != nil {
+			return nil, status.Errorf(codes.Internal, "commit: %v", err)
+		}
+		return &pb.RedeemRewardResponse{Success: false, Message: rechazo, PointsRemaining: currentPoints, Reward: rw}, nil
+	}
+
+	newPoints := currentPoints - rw.PointsRequired
+	if _, err = tx.ExecContext(ctx, `
+		UPDATE loyalty_accounts SET points = $1, updated_at = NOW() WHERE id = $2
+	`, newPoints, accountID); err != nil {
+		return nil, status.Errorf(codes.Internal, "descontar puntos: %v", err)
+	}
+	if err = consumirLotes(ctx, tx, accountID, rw.PointsRequired); err != nil {
+		return nil, status.Errorf(codes.Internal, "descontar puntos: %v", err)
+	}
+	if rw.Stock > 0 {
+		if _, err = tx.ExecContext(ctx, `UPDATE loyalty_rewards SET stock = stock - 1 WHERE id = $1`, rw.Id); err != nil {
+			return nil, status.Errorf(codes.Internal, "descontar stock: %v", err)
+		}
+		rw.Stock--
+	}
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO loyalty_transactions (account_id, tenant_id, type, points, description)
+		VALUES ($1, $2::uuid, 'redeem', $3, $4)
+	`, accountID, req.TenantId, -rw.PointsRequired, "Canje: "+rw.Name); err != nil {
+		return nil, status.Errorf(codes.Internal, "registrar canje: %v", err)
+	}
+	codigo, err := codigoCanje()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "código de canje: %v", err)
+	}
+	expira := time.Now().Add(vigenciaCanje)
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO loyalty_canjes (tenant_id, account_id, reward_id, codigo, puntos, expires_at)
+		VALUES ($1::uuid, $2, $3, $4, $5, $6)
+	`, req.TenantId, accountID, rw.Id, codigo, rw.PointsRequired, expira); err != nil {
+		return nil, status.Errorf(codes.Internal, "registrar código: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, status.Errorf(codes.Internal, "commit: %v", err)
+	}
+
+	log.Printf("[Loyalty] RedeemReward tenant=%s phone=%s reward=%s puntos=-%d restantes=%d codigo=%s",
+		req.TenantId, req.Phone, rw.Id, rw.PointsRequired, newPoints, codigo)
+	return &pb.RedeemRewardResponse{
+		Success:         true,
+		Message:         fmt.Sprintf("Canjeaste %s por %d puntos", rw.Name, rw.PointsRequired),
+		Code:            codigo,
+		PointsUsed:      rw.PointsRequired,
+		PointsRemaining: newPoints,
+		Reward:          rw,
+		ExpiresAt:       expira.Format(time.RFC3339),
+	}, nil
+}
+
+func descripcionCanje(req *pb.RedeemPointsRequest) string {
+	if req.Reason != "" {
+		return fmt.Sprintf("Canje de %d puntos: %s", req.Points, req.Reason)
+	}
+	return fmt.Sprintf("Canje de %d puntos", req.Points)
+}
+
 // GetHistory obtiene el historial de transacciones
 func (s *LoyaltyServer) GetHistory(ctx context.Context, req *pb.GetHistoryRequest) (*pb.HistoryResponse, error) {
 	if req.Phone == "" {
 		return nil, status.Errorf(codes.InvalidArgument, "phone requerido")
 	}
 
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	req.Phone = s.telefonoPrincipal(ctx, programaID, req.Phone)
+
 	limit := req.Limit
 	if limit <= 0 || limit > 50 {
 		limit = 20
@@ -230,9 +772,9 @@ func (s *LoyaltyServer) GetHistory(ctx context.Context, req *pb.GetHistoryReques
 
 	var accountID string
 	var points int32
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, points FROM loyalty_accounts WHERE phone = $1
-	`, req.Phone).Scan(&accountID, &points)
+	err = s.db.QueryRowContext(ctx, `
+		SELECT id, points FROM loyalty_accounts WHERE tenant_id = $1::uuid AND phone = $2
+	`, programaID, req.Phone).Scan(&accountID, &points)
 	if err == sql.ErrNoRows {
 		return nil, status.Errorf(codes.NotFound, "cuenta no encontrada")
 	}
@@ -263,51 +805,1246 @@ func (s *LoyaltyServer) GetHistory(ctx context.Context, req *pb.GetHistoryReques
 		transactions = append(transactions, &t)
 	}
 
-	return &pb.HistoryResponse{
+	resp := &pb.HistoryResponse{
 		AccountId:    accountID,
 		Points:       points,
 		Transactions: transactions,
-	}, nil
+	}
+	var proximo sql.NullTime
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(restantes) FILTER (WHERE expires_at <= NOW() + INTERVAL '30 days'), 0), MIN(expires_at)
+		FROM loyalty_lotes WHERE account_id = $1 AND restantes > 0 AND expires_at > NOW()
+	`, accountID).Scan(&resp.PointsExpiring, &proximo)
+	if err == nil && proximo.Valid {
+		resp.NextExpiration = proximo.Time.In(zonaNegocio).Format("2006-01-02")
+	}
+	return resp, nil
 }
 
-func (s *LoyaltyServer) findOrCreateAccount(ctx context.Context, phone, name, rfc string) (*pb.AccountResponse, error) {
-    var id, accName, tier, accRfc, accCp string
-	var points int32
-	var totalSpent float64
+// consumirLotes descuenta un canje de los lotes vigentes de la cuenta, del más antiguo al
+// más reciente. Corre dentro de la transacción del canje, con la cuenta ya bloqueada.
+func consumirLotes(ctx context.Context, tx *sql.Tx, accountID string, puntos int32) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, restantes, created_at, expires_at FROM loyalty_lotes
+		WHERE account_id = $1 AND restantes > 0
+		ORDER BY created_at, id
+		FOR UPDATE
+	`, accountID)
+	if err != nil {
+		return err
+	}
+	var disponibles []lotes.Lote
+	for rows.Next() {
+		var l lotes.Lote
+		var vence sql.NullTime
+		if err := rows.Scan(&l.ID, &l.Restantes, &l.CreadoEn, &vence); err != nil {
+			rows.Close()
+			return err
+		}
+		if vence.Valid { l.Vence = &vence.Time }
+		disponibles = append(disponibles, l)
+	}
+	rows.Close()
 
-	err := s.db.QueryRowContext(ctx, `
-		INSERT INTO loyalty_accounts (phone, name, rfc)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (phone) DO UPDATE SET updated_at = NOW()
-        RETURNING id, COALESCE(name,''), points, total_spent, tier, COALESCE(rfc,''), COALESCE(cp,'')
-    `, phone, name, rfc).Scan(&id, &accName, &points, &totalSpent, &tier, &accRfc, &accCp)
+	consumos, faltan := lotes.Consumir(disponibles, puntos, time.Now())
+	for _, c := range consumos {
+		if _, err := tx.ExecContext(ctx, `UPDATE loyalty_lotes SET restantes = restantes - $1 WHERE id = $2`, c.Puntos, c.LoteID); err != nil {
+			return err
+		}
+	}
+	if faltan > 0 {
+		// El saldo de la cuenta manda; los canjes vencen antes los lotes, así que un faltante
+		// indica puntos que no quedaron en ningún lote
+		log.Printf("[Loyalty] ADVERTENCIA: cuenta %s canjeó %d puntos sin lote", accountID, faltan)
+	}
+	return nil
+}
+
+// vencerPuntos vence los lotes cuya fecha ya pasó: los deja en cero, descuenta el saldo
+// de la cuenta y registra un movimiento 'expire' por cuenta. Devuelve los puntos vencidos.
+func (s *LoyaltyServer) vencerPuntos(ctx context.Context) (int64, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT account_id FROM loyalty_lotes WHERE restantes > 0 AND expires_at <= NOW()
+	`)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	var cuentas []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil { cuentas = append(cuentas, id) }
 	}
+	rows.Close()
 
-	return &pb.AccountResponse{
-		AccountId:  id,
-		Phone:      phone,
-		Name:       accName,
-		Points:     points,
-		TotalSpent: totalSpent,
-        Tier:       tier,
-        Rfc:        accRfc,
-        Cp:         accCp,
-	}, nil
+	var total int64
+	for _, accountID := range cuentas {
+		n, err := s.vencerCuenta(ctx, accountID)
+		if err != nil {
+			log.Printf("[Loyalty] Error venciendo puntos de %s: %v", accountID, err)
+			continue
+		}
+		total += int64(n)
+	}
+	return total, nil
 }
 
-func calculateTier(totalSpent float64) string {
-	switch {
-	case totalSpent >= TierPlatinum:
-		return "platinum"
-	case totalSpent >= TierGold:
-		return "gold"
-	case totalSpent >= TierSilver:
-		return "silver"
-	default:
-		return "bronze"
+func (s *LoyaltyServer) vencerCuenta(ctx context.Context, accountID string) (int32, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var tenantID string
+	var points int32
+	if err := tx.QueryRowContext(ctx, `SELECT tenant_id::text, points FROM loyalty_accounts WHERE id = $1 FOR UPDATE`, accountID).
+		Scan(&tenantID, &points); err != nil {
+		return 0, err
+	}
+	vencidos, err := vencerLotes(ctx, tx, accountID, tenantID, points)
+	if err != nil {
+		return 0, err
+	}
+	return vencidos, tx.Commit()
+}
+
+// vencerLotes vence los lotes de la cuenta cuya fecha ya pasó dentro de la transacción
+// tx, con la cuenta ya bloqueada y su saldo en points. Los canjes la llaman antes de
+// revisar el saldo para no gastar puntos vencidos que el job aún no procesa.
+func vencerLotes(ctx context.Context, tx *sql.Tx, accountID, tenantID string, points int32) (int32, error) {
+	// NOW() es el inicio de la transacción: las dos sentencias ven los mismos lotes vencidos
+	var vencidos int32
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(restantes), 0) FROM loyalty_lotes
+		WHERE account_id = $1 AND restantes > 0 AND expires_at <= NOW()
+	`, accountID).Scan(&vencidos); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE loyalty_lotes SET restantes = 0
+		WHERE account_id = $1 AND restantes > 0 AND expires_at <= NOW()
+	`, accountID); err != nil {
+		return 0, err
+	}
+	if vencidos > points { vencidos = points }
+	if vencidos <= 0 {
+		return 0, nil
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE loyalty_accounts SET points = points - $1, updated_at = NOW() WHERE id = $2`, vencidos, accountID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO loyalty_transactions (account_id, tenant_id, type, points, description)
+		VALUES ($1, $2::uuid, 'expire', $3, $4)
+	`, accountID, tenantID, -vencidos, fmt.Sprintf("Vencieron %d puntos", vencidos)); err != nil {
+		return 0, err
+	}
+	return vencidos, nil
+}
+
+// consultor es lo que comparten *sql.DB y *sql.Tx para leer una fila
+type consultor interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// calificacion suma lo que cuenta para el tier de la cuenta: gasto o puntos ganados
+// en la ventana del programa, descontando cancelaciones y devoluciones
+func calificacion(ctx context.Context, q consultor, accountID string, totalSpent float64, prog programa.Programa, ahora time.Time) (float64, error) {
+	if prog.BaseNivel != programa.BasePuntos && prog.VentanaMeses <= 0 { return totalSpent, nil }
+	columna := "COALESCE(monto, 0)"
+	if prog.BaseNivel == programa.BasePuntos { columna = "ABS(points)" }
+	var valor float64
+	err := q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(CASE WHEN type = 'earn' THEN `+columna+` ELSE -`+columna+` END), 0)
+		FROM loyalty_transactions
+		WHERE account_id = $1 AND type IN ('earn','reverse') AND created_at >= $2
+	`, accountID, prog.InicioVentana(ahora)).Scan(&valor)
+	if valor < 0 { valor = 0 }
+	return valor, err
+}
+
+// estadoNivel es el tier de una cuenta con lo que acumula y su periodo de gracia
+type estadoNivel struct {
+	Nivel        programa.Nivel
+	Calificacion float64
+	Gracia       *time.Time
+}
+
+func (e estadoNivel) llenar(resp *pb.AccountResponse) {
+	resp.Tier, resp.TierName = e.Nivel.Clave, e.Nivel.Nombre
+	resp.TierDiscountPct, resp.TierMultiplier = e.Nivel.DescuentoPct, e.Nivel.Multiplicador
+	resp.TierQualifying = e.Calificacion
+	if e.Gracia != nil { resp.TierGraceUntil = e.Gracia.In(zonaNegocio).Format("2006-01-02") }
+}
+
+// actualizarNivel recalifica el tier de una cuenta ya bloqueada en tx. Si cambia, lo
+// registra en el historial; el BFF le avisa al cliente de los cambios pendientes.
+func actualizarNivel(ctx context.Context, tx *sql.Tx, accountID string, prog programa.Programa, motivo string) (estadoNivel, error) {
+	var tenantID, actual string
+	var totalSpent float64
+	var gracia sql.NullTime
+	err := tx.QueryRowContext(ctx, `
+		SELECT tenant_id::text, tier, total_spent, tier_gracia_hasta FROM loyalty_accounts WHERE id = $1
+	`, accountID).Scan(&tenantID, &actual, &totalSpent, &gracia)
+	if err != nil {
+		return estadoNivel{}, err
+	}
+	ahora := time.Now()
+	valor, err := calificacion(ctx, tx, accountID, totalSpent, prog, ahora)
+	if err != nil {
+		return estadoNivel{}, err
+	}
+	var graciaActual *time.Time
+	if gracia.Valid { graciaActual = &gracia.Time }
+	nivel, nuevaGracia := prog.Calificar(actual, valor, graciaActual, ahora)
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE loyalty_accounts SET tier = $1, tier_gracia_hasta = $2,
+		       tier_desde = CASE WHEN tier <> $1 OR tier_desde IS NULL THEN NOW() ELSE tier_desde END
+		WHERE id = $3
+	`, nivel.Clave, nuevaGracia, accountID)
+	if err == nil && nivel.Clave != actual {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO loyalty_tier_historial (account_id, tenant_id, anterior, nuevo, nombre_nuevo, subio, motivo, calificacion)
+			VALUES ($1, $2::uuid, $3, $4, $5, $6, $7, $8)
+		`, accountID, tenantID, actual, nivel.Clave, nivel.Nombre, nivel.GastoMinimo > prog.NivelPorClave(actual).GastoMinimo, motivo, valor)
+	}
+	return estadoNivel{Nivel: nivel, Calificacion: valor, Gracia: nuevaGracia}, err
+}
+
+// recalificarNiveles revisa las cuentas arriba del tier inicial de los programas con
+// ventana móvil: al salir compras de la ventana, empiezan su gracia o bajan de tier.
+func (s *LoyaltyServer) recalificarNiveles(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT tenant_id::text FROM loyalty_programas WHERE ventana_meses IS NOT NULL`)
+	if err != nil {
+		return 0, err
+	}
+	var programas []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil { programas = append(programas, id) }
+	}
+	rows.Close()
+
+	cambios := 0
+	for _, programaID := range programas {
+		prog, err := s.cargarPrograma(ctx, programaID)
+		if err != nil {
+			log.Printf("[Loyalty] Recalificar %s: %v", programaID, err)
+			continue
+		}
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT id FROM loyalty_accounts WHERE tenant_id = $1::uuid AND (tier <> $2 OR tier_gracia_hasta IS NOT NULL)
+		`, programaID, prog.Nivel(0).Clave)
+		if err != nil {
+			log.Printf("[Loyalty] Recalificar %s: %v", programaID, err)
+			continue
+		}
+		var cuentas []string
+		for rows.Next() {
+			var id string
+			if rows.Scan(&id) == nil { cuentas = append(cuentas, id) }
+		}
+		rows.Close()
+		for _, accountID := range cuentas {
+			cambio, err := s.recalificarCuenta(ctx, accountID, prog)
+			if err != nil {
+				log.Printf("[Loyalty] Error recalificando %s: %v", accountID, err)
+				continue
+			}
+			if cambio { cambios++ }
+		}
+	}
+	return cambios, nil
+}
+
+func (s *LoyaltyServer) recalificarCuenta(ctx context.Context, accountID string, prog programa.Programa) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var antes string
+	if err := tx.QueryRowContext(ctx, `SELECT tier FROM loyalty_accounts WHERE id = $1 FOR UPDATE`, accountID).Scan(&antes); err != nil {
+		return false, err
+	}
+	nivel, err := actualizarNivel(ctx, tx, accountID, prog, "recalificacion")
+	if err != nil {
+		return false, err
+	}
+	return nivel.Nivel.Clave != antes, tx.Commit()
+}
+
+// startVencimientoCron vence los puntos y recalifica los tiers todos los días a las 02:00
+// hora de Monterrey
+func (s *LoyaltyServer) startVencimientoCron() {
+	go func() {
+		for {
+			ahora := time.Now().In(zonaNegocio)
+			siguiente := time.Date(ahora.Year(), ahora.Month(), ahora.Day(), 2, 0, 0, 0, zonaNegocio)
+			if !siguiente.After(ahora) { siguiente = siguiente.AddDate(0, 0, 1) }
+			time.Sleep(siguiente.Sub(ahora))
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			if n, err := s.vencerPuntos(ctx); err != nil {
+				log.Printf("[Loyalty] Vencimiento de puntos: %v", err)
+			} else {
+				log.Printf("[Loyalty] Vencimiento de puntos: %d puntos vencidos", n)
+			}
+			if n, err := s.recalificarNiveles(ctx); err != nil {
+				log.Printf("[Loyalty] Recalificación de tiers: %v", err)
+			} else {
+				log.Printf("[Loyalty] Recalificación de tiers: %d cuentas cambiaron de tier", n)
+			}
+			cancel()
+		}
+	}()
+}
+
+// GetExpiringPoints lista las cuentas del programa con puntos que vencen en los próximos
+// `days` días, con el primer vencimiento, para avisarles antes de que los pierdan
+func (s *LoyaltyServer) GetExpiringPoints(ctx context.Context, req *pb.ExpiringPointsRequest) (*pb.ExpiringPointsResponse, error) {
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	dias := req.Days
+	if dias <= 0 { dias = 30 }
+	if dias > 365 {
+		return nil, status.Errorf(codes.InvalidArgument, "days máximo 365")
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT a.id, a.phone, COALESCE(a.name,''), COALESCE(a.email,''), a.points,
+		       SUM(l.restantes), MIN(l.expires_at)
+		FROM loyalty_lotes l JOIN loyalty_accounts a ON a.id = l.account_id
+		WHERE a.tenant_id = $1::uuid AND l.restantes > 0
+		  AND l.expires_at > NOW() AND l.expires_at <= NOW() + make_interval(days => $2)
+		GROUP BY a.id
+		ORDER BY MIN(l.expires_at), SUM(l.restantes) DESC
+	`, programaID, dias)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "puntos por vencer: %v", err)
+	}
+	defer rows.Close()
+	resp := &pb.ExpiringPointsResponse{}
+	for rows.Next() {
+		a := &pb.ExpiringAccount{}
+		var vence time.Time
+		if err := rows.Scan(&a.AccountId, &a.Phone, &a.Name, &a.Email, &a.Points, &a.PointsExpiring, &vence); err != nil {
+			return nil, status.Errorf(codes.Internal, "puntos por vencer: %v", err)
+		}
+		a.ExpiresAt = vence.In(zonaNegocio).Format("2006-01-02")
+		resp.Accounts = append(resp.Accounts, a)
+	}
+	return resp, rows.Err()
+}
+
+// ReversePoints quita los puntos y el gasto que dio una venta cancelada o devuelta, en
+// proporción al monto devuelto. Cada referencia se aplica una sola vez, así que la caja
+// puede reintentar sin descontar de más. Si la venta completó un referido y deja de
+// calificar, también se quitan los dos bonos.
+func (s *LoyaltyServer) ReversePoints(ctx context.Context, req *pb.ReversePointsRequest) (*pb.ReversePointsResponse, error) {
+	if req.SaleId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "sale_id requerido")
+	}
+	if req.Amount < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "amount no puede ser negativo")
+	}
+	referencia := strings.TrimSpace(req.Reference)
+	if referencia == "" { referencia = "cancelacion" }
+	if len(referencia) > 64 {
+		return nil, status.Errorf(codes.InvalidArgument, "reference máximo 64 caracteres")
+	}
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := s.cargarPrograma(ctx, programaID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "programa de lealtad: %v", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "iniciar transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Lo que ganó la venta; una venta sin puntos no tiene nada que reversar
+	var accountID string
+	var ganados int32
+	var monto float64
+	err = tx.QueryRowContext(ctx, `
+		SELECT t.account_id, SUM(t.points), COALESCE(SUM(t.monto), 0)
+		FROM loyalty_transactions t JOIN loyalty_accounts a ON a.id = t.account_id
+		WHERE t.sale_id::text = $1 AND t.type = 'earn' AND a.tenant_id = $2::uuid
+		GROUP BY t.account_id LIMIT 1
+	`, req.SaleId, programaID).Scan(&accountID, &ganados, &monto)
+	if err == sql.ErrNoRows {
+		return &pb.ReversePointsResponse{Message: "La venta no generó puntos"}, nil
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "buscar compra: %v", err)
+	}
+
+	// Con la cuenta bloqueada, las reversiones de la venta no corren en paralelo
+	resp := &pb.ReversePointsResponse{}
+	var totalSpent float64
+	err = tx.QueryRowContext(ctx, `
+		SELECT phone, points, deuda_puntos, total_spent, tier FROM loyalty_accounts WHERE id = $1 FOR UPDATE
+	`, accountID).Scan(&resp.Phone, &resp.Points, &resp.PointsDebt, &totalSpent, &resp.Tier)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "buscar cuenta: %v", err)
+	}
+	resp.TierName = prog.NivelPorClave(resp.Tier).Nombre
+
+	var yaPuntos int32
+	var yaMonto float64
+	var repetida bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(-SUM(points), 0), COALESCE(SUM(monto), 0), COALESCE(BOOL_OR(referencia = $2), false)
+		FROM loyalty_transactions WHERE sale_id::text = $1 AND type = 'reverse'
+	`, req.SaleId, referencia).Scan(&yaPuntos, &yaMonto, &repetida)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "reversiones previas: %v", err)
+	}
+	if repetida {
+		resp.Message = fmt.Sprintf("La reversión %s ya se había aplicado", referencia)
+		return resp, nil
+	}
+	puntos, gasto := programa.PuntosAReversar(ganados, yaPuntos, monto, yaMonto, req.Amount)
+	if puntos <= 0 && gasto <= 0 {
+		resp.Message = "La venta ya no tiene puntos por reversar"
+		return resp, nil
+	}
+
+	nuevoSaldo, nuevaDeuda, tomados := programa.AplicarReversion(resp.Points, resp.PointsDebt, puntos, prog.PoliticaReversion)
+	nuevoGasto := totalSpent - gasto
+	if nuevoGasto < 0 { nuevoGasto = 0 }
+
+	if err := quitarLotesVenta(ctx, tx, accountID, req.SaleId, "earn", tomados); err != nil {
+		return nil, status.Errorf(codes.Internal, "descontar lotes: %v", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE loyalty_accounts SET points = $1, deuda_puntos = $2, total_spent = $3, updated_at = NOW()
+		WHERE id = $4
+	`, nuevoSaldo, nuevaDeuda, nuevoGasto, accountID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "actualizar cuenta: %v", err)
+	}
+
+	descripcion := fmt.Sprintf("Devolución $%.2f → -%d puntos", gasto, puntos)
+	if req.Amount <= 0 { descripcion = fmt.Sprintf("Venta cancelada → -%d puntos", puntos) }
+	if faltante := puntos - tomados; faltante > 0 && prog.PoliticaReversion == programa.ReversionDeuda {
+		descripcion += fmt.Sprintf(" (%d a deuda)", faltante)
+	} else if faltante > 0 && prog.PoliticaReversion == programa.ReversionAbsorber {
+		descripcion += fmt.Sprintf(" (%d absorbidos)", faltante)
+	}
+	if req.Reason != "" { descripcion += ": " + req.Reason }
+	if len(descripcion) > 255 { descripcion = descripcion[:255] }
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO loyalty_transactions (account_id, tenant_id, sale_id, type, points, monto, referencia, description)
+		VALUES ($1, $2::uuid, $3::uuid, 'reverse', $4, $5, $6, $7)
+	`, accountID, req.TenantId, req.SaleId, -puntos, gasto, referencia, descripcion)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "registrar reversión: %v", err)
+	}
+	// Una cancelación, o una devolución que deja la venta bajo la compra mínima, anula el
+	// referido que esa venta completó
+	if restante := monto - yaMonto - gasto; req.Amount <= 0 || restante < 0.005 || restante+1e-9 < prog.Referidos.CompraMinima {
+		revertido, err := revertirReferido(ctx, tx, accountID, req.TenantId, req.SaleId, prog)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "revertir referido: %v", err)
+		}
+		if revertido {
+			err = tx.QueryRowContext(ctx, `SELECT points, deuda_puntos FROM loyalty_accounts WHERE id = $1`, accountID).Scan(&nuevoSaldo, &nuevaDeuda)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "leer saldo: %v", err)
+			}
+		}
+	}
+	nivel, err := actualizarNivel(ctx, tx, accountID, prog, "reversion")
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "calificar tier: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, status.Errorf(codes.Internal, "commit: %v", err)
+	}
+
+	log.Printf("[Loyalty] ReversePoints tenant=%s sale=%s ref=%s puntos=-%d gasto=-%.2f saldo=%d deuda=%d tier=%s",
+		req.TenantId, req.SaleId, referencia, puntos, gasto, nuevoSaldo, nuevaDeuda, nivel.Nivel.Clave)
+
+	resp.Reversed = true
+	resp.Message = fmt.Sprintf("Se quitaron %d puntos", puntos)
+	resp.PointsReversed, resp.SpentReversed = puntos, gasto
+	resp.Points, resp.PointsDebt = nuevoSaldo, nuevaDeuda
+	resp.Tier, resp.TierName = nivel.Nivel.Clave, nivel.Nivel.Nombre
+	return resp, nil
+}
+
+// quitarLotesVenta descuenta una reversión primero del lote que generó la propia venta
+// (el de su transacción de tipo `tipo`) y lo que falte de los demás lotes, del más
+// antiguo al más reciente.
+func quitarLotesVenta(ctx context.Context, tx *sql.Tx, accountID, saleID, tipo string, puntos int32) error {
+	if puntos <= 0 { return nil }
+	var loteID string
+	var restantes int32
+	err := tx.QueryRowContext(ctx, `
+		SELECT l.id, l.restantes FROM loyalty_lotes l
+		JOIN loyalty_transactions t ON t.id = l.transaction_id
+		WHERE l.account_id = $1 AND t.sale_id::text = $2 AND t.type = $3 AND l.restantes > 0
+		ORDER BY l.created_at LIMIT 1
+		FOR UPDATE OF l
+	`, accountID, saleID, tipo).Scan(&loteID, &restantes)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil {
+		if restantes > puntos { restantes = puntos }
+		if _, err := tx.ExecContext(ctx, `UPDATE loyalty_lotes SET restantes = restantes - $1 WHERE id = $2`, restantes, loteID); err != nil {
+			return err
+		}
+		puntos -= restantes
+	}
+	if puntos <= 0 { return nil }
+	return consumirLotes(ctx, tx, accountID, puntos)
+}
+
+// revertirReferido anula el referido completado con la venta del cliente accountID: quita
+// los bonos 'referido' que esa venta pagó a las dos cuentas, según la política de
+// reversión, y el referido vuelve a quedar pendiente para que otra compra lo complete.
+// Devuelve si había un referido que revertir.
+func revertirReferido(ctx context.Context, tx *sql.Tx, accountID, tenantID, saleID string, prog programa.Programa) (bool, error) {
+	var refID, referenteID string
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, referente_id FROM loyalty_referidos
+		WHERE referido_id = $1 AND sale_id::text = $2 AND estado = 'completado'
+		FOR UPDATE
+	`, accountID, saleID).Scan(&refID, &referenteID)
+	if err == sql.ErrNoRows { return false, nil }
+	if err != nil { return false, err }
+
+	// Se bloquea primero la cuenta del cliente (ya bloqueada) y luego la del referente,
+	// en el mismo orden que completarReferido
+	var quitados [2]int32
+	for i, cuenta := range []string{accountID, referenteID} {
+		var bono, saldo, deuda int32
+		err := tx.QueryRowContext(ctx, `SELECT points, deuda_puntos FROM loyalty_accounts WHERE id = $1 FOR UPDATE`, cuenta).Scan(&saldo, &deuda)
+		if err != nil { return false, err }
+		err = tx.QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(points), 0) FROM loyalty_transactions
+			WHERE account_id = $1 AND sale_id::text = $2 AND type = 'referido'
+		`, cuenta, saleID).Scan(&bono)
+		if err != nil { return false, err }
+		if bono <= 0 { continue }
+		nuevoSaldo, nuevaDeuda, tomados := programa.AplicarReversion(saldo, deuda, bono, prog.PoliticaReversion)
+		if err := quitarLotesVenta(ctx, tx, cuenta, saleID, "referido", tomados); err != nil { return false, err }
+		_, err = tx.ExecContext(ctx, `
+			UPDATE loyalty_accounts SET points = $2, deuda_puntos = $3, updated_at = NOW() WHERE id = $1
+		`, cuenta, nuevoSaldo, nuevaDeuda)
+		if err != nil { return false, err }
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO loyalty_transactions (account_id, tenant_id, sale_id, type, points, description)
+			VALUES ($1, $2::uuid, $3::uuid, 'referido_revertido', $4, $5)
+		`, cuenta, tenantID, saleID, -bono, fmt.Sprintf("Bono de referido revertido por cancelación o devolución → -%d puntos", bono))
+		if err != nil { return false, err }
+		quitados[i] = bono
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE loyalty_referidos SET estado = 'pendiente', motivo = NULL, sale_id = NULL, puntos_referente = 0,
+		       puntos_referido = 0, resuelto_at = NULL
+		WHERE id = $1
+	`, refID)
+	if err != nil { return false, err }
+	log.Printf("[Loyalty] Referido revertido cuenta=%s -%d referente=%s -%d venta=%s", accountID, quitados[0], referenteID, quitados[1], saleID)
+	return true, nil
+}
+
+// CreateAccount da de alta un cliente en el programa del tenant sin registrar compra.
+// Si el teléfono ya existe devuelve la cuenta y solo completa los datos vacíos.
+func (s *LoyaltyServer) CreateAccount(ctx context.Context, req *pb.CreateAccountRequest) (*pb.AccountResponse, error) {
+	phone := strings.TrimSpace(req.Phone)
+	if phone == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "phone requerido")
+	}
+	if len(phone) > 20 {
+		return nil, status.Errorf(codes.InvalidArgument, "phone máximo 20 caracteres")
+	}
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	phone = s.telefonoPrincipal(ctx, programaID, phone)
+	prog, err := s.cargarPrograma(ctx, programaID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "programa de lealtad: %v", err)
+	}
+
+	resp := &pb.AccountResponse{Phone: phone}
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO loyalty_accounts (tenant_id, phone, name, email, rfc, tier)
+		VALUES ($1::uuid, $2, NULLIF($3,''), NULLIF($4,''), NULLIF($5,''), $6)
+		ON CONFLICT (tenant_id, phone) DO UPDATE SET
+			name  = COALESCE(NULLIF(loyalty_accounts.name,''), EXCLUDED.name),
+			email = COALESCE(NULLIF(loyalty_accounts.email,''), EXCLUDED.email),
+			rfc   = COALESCE(NULLIF(loyalty_accounts.rfc,''), EXCLUDED.rfc),
+			updated_at = NOW()
+		RETURNING id, COALESCE(name,''), points, total_spent, tier, COALESCE(rfc,''), COALESCE(cp,''), (xmax = 0)
+	`, programaID, phone, strings.TrimSpace(req.Name), strings.TrimSpace(req.Email), strings.ToUpper(strings.TrimSpace(req.Rfc)),
+		prog.Nivel(0).Clave).Scan(&resp.AccountId, &resp.Name, &resp.Points, &resp.TotalSpent, &resp.Tier, &resp.Rfc, &resp.Cp, &resp.Created)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "crear cuenta: %v", err)
+	}
+	estadoNivel{Nivel: prog.NivelPorClave(resp.Tier)}.llenar(resp)
+	if resp.Created {
+		log.Printf("[Loyalty] CreateAccount tenant=%s phone=%s", req.TenantId, phone)
+	}
+	return resp, nil
+}
+
+// ---------------------------------------------------------------------------
+// Referidos
+// ---------------------------------------------------------------------------
+
+// intentosCodigoReferido son los códigos que se prueban antes de rendirse por choques
+const intentosCodigoReferido = 5
+
+// codigoReferido devuelve el código de referido de la cuenta y lo genera si no tiene
+func (s *LoyaltyServer) codigoReferido(ctx context.Context, accountID string) (string, error) {
+	for i := 0; i < intentosCodigoReferido; i++ {
+		var codigo string
+		err := s.db.QueryRowContext(ctx, `SELECT COALESCE(codigo_referido,'') FROM loyalty_accounts WHERE id = $1`, accountID).Scan(&codigo)
+		if err != nil || codigo != "" { return codigo, err }
+		if codigo, err = codigoAleatorio(6); err != nil { return "", err }
+		res, err := s.db.ExecContext(ctx, `
+			UPDATE loyalty_accounts SET codigo_referido = $2 WHERE id = $1 AND codigo_referido IS NULL
+		`, accountID, codigo)
+		if err == nil {
+			// Si otra petición le asignó código primero, la siguiente vuelta lo lee
+			if n, _ := res.RowsAffected(); n == 1 { return codigo, nil }
+			continue
+		}
+		if e, ok := err.(*pq.Error); !ok || e.Code != "23505" { return "", err }
+	}
+	return "", fmt.Errorf("no se pudo generar un código único")
+}
+
+// cuentaReferidos busca la cuenta por id o teléfono dentro del programa
+func (s *LoyaltyServer) cuentaReferidos(ctx context.Context, programaID, accountID, phone string) (string, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id FROM loyalty_accounts
+		WHERE tenant_id = $1::uuid AND (($2 <> '' AND id::text = $2) OR ($2 = '' AND phone = $3))
+	`, programaID, accountID, phone).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", status.Errorf(codes.NotFound, "cliente no encontrado")
+	}
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "buscar cuenta: %v", err)
+	}
+	return id, nil
+}
+
+// GetReferralCode devuelve el código que el cliente comparte para referir
+func (s *LoyaltyServer) GetReferralCode(ctx context.Context, req *pb.GetReferralCodeRequest) (*pb.ReferralCodeResponse, error) {
+	if req.Phone == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "phone requerido")
+	}
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	req.Phone = s.telefonoPrincipal(ctx, programaID, req.Phone)
+	accountID, err := s.cuentaReferidos(ctx, programaID, "", req.Phone)
+	if err != nil {
+		return nil, err
+	}
+	codigo, err := s.codigoReferido(ctx, accountID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "código de referido: %v", err)
+	}
+	return &pb.ReferralCodeResponse{AccountId: accountID, Code: codigo}, nil
+}
+
+// RegisterReferral registra un cliente nuevo con el código de otro. Un teléfono que ya
+// tiene compras, o que ya fue referido, no puede usar un código; tampoco uno que comparte
+// teléfono o correo con quien refiere, ni un correo que ya usa otra cuenta del programa.
+func (s *LoyaltyServer) RegisterReferral(ctx context.Context, req *pb.RegisterReferralRequest) (*pb.RegisterReferralResponse, error) {
+	phone, email := strings.TrimSpace(req.Phone), strings.TrimSpace(req.Email)
+	codigo := strings.ToUpper(strings.TrimSpace(req.Code))
+	if phone == "" || codigo == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "phone y code requeridos")
+	}
+	if len(phone) > 20 {
+		return nil, status.Errorf(codes.InvalidArgument, "phone máximo 20 caracteres")
+	}
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	phone = s.telefonoPrincipal(ctx, programaID, phone)
+	prog, err := s.cargarPrograma(ctx, programaID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "programa de lealtad: %v", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "iniciar transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	var referenteID, referenteNombre string
+	var referente programa.Contacto
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, COALESCE(name,''), phone, COALESCE(email,'') FROM loyalty_accounts
+		WHERE tenant_id = $1::uuid AND codigo_referido = $2
+	`, programaID, codigo).Scan(&referenteID, &referenteNombre, &referente.Telefono, &referente.Email)
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "código de referido no válido")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "buscar código: %v", err)
+	}
+	switch prog.Referidos.Rechazo(referente, programa.Contacto{Telefono: phone, Email: email}) {
+	case programa.RechazoInactivo:
+		return nil, status.Errorf(codes.FailedPrecondition, "el negocio no tiene programa de referidos")
+	case programa.RechazoMismoTelefono, programa.RechazoMismoEmail:
+		return nil, status.Errorf(codes.FailedPrecondition, "no puedes usar tu propio código de referido")
+	}
+	if email != "" {
+		var otra bool
+		tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM loyalty_accounts WHERE tenant_id = $1::uuid AND LOWER(email) = LOWER($2) AND phone <> $3)
+		`, programaID, email, phone).Scan(&otra)
+		if otra {
+			return nil, status.Errorf(codes.FailedPrecondition, "ese correo ya está registrado con otro teléfono")
+		}
+	}
+	if prog.Referidos.TopeMensual > 0 {
+		var pagados int
+		tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM loyalty_referidos WHERE referente_id = $1 AND estado = 'completado' AND resuelto_at >= $2
+		`, referenteID, inicioMes(time.Now())).Scan(&pagados)
+		if pagados >= prog.Referidos.TopeMensual {
+			return nil, status.Errorf(codes.FailedPrecondition, "%s ya alcanzó el tope de referidos de este mes", referenteNombre)
+		}
+	}
+
+	acc := &pb.AccountResponse{Phone: phone}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO loyalty_accounts (tenant_id, phone, name, email, tier)
+		VALUES ($1::uuid, $2, NULLIF($3,''), NULLIF($4,''), $5)
+		ON CONFLICT (tenant_id, phone) DO UPDATE SET
+			name  = COALESCE(NULLIF(loyalty_accounts.name,''), EXCLUDED.name),
+			email = COALESCE(NULLIF(loyalty_accounts.email,''), EXCLUDED.email),
+			updated_at = NOW()
+		RETURNING id, COALESCE(name,''), points, total_spent, tier, (xmax = 0)
+	`, programaID, phone, strings.TrimSpace(req.Name), email, prog.Nivel(0).Clave).Scan(
+		&acc.AccountId, &acc.Name, &acc.Points, &acc.TotalSpent, &acc.Tier, &acc.Created)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "crear cuenta: %v", err)
+	}
+	if !acc.Created {
+		var compras bool
+		tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM loyalty_transactions WHERE account_id = $1 AND type = 'earn')
+		`, acc.AccountId).Scan(&compras)
+		if compras {
+			return nil, status.Errorf(codes.FailedPrecondition, "el cliente ya tiene compras; los códigos de referido son para clientes nuevos")
+		}
+	}
+	if acc.AccountId == referenteID {
+		return nil, status.Errorf(codes.FailedPrecondition, "no puedes usar tu propio código de referido")
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO loyalty_referidos (tenant_id, referente_id, referido_id, codigo)
+		VALUES ($1::uuid, $2, $3, $4) ON CONFLICT (referido_id) DO NOTHING
+	`, programaID, referenteID, acc.AccountId, codigo)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "registrar referido: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, status.Errorf(codes.AlreadyExists, "el cliente ya fue referido")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, status.Errorf(codes.Internal, "commit: %v", err)
+	}
+	estadoNivel{Nivel: prog.NivelPorClave(acc.Tier)}.llenar(acc)
+
+	log.Printf("[Loyalty] RegisterReferral tenant=%s phone=%s código=%s referente=%s", req.TenantId, phone, codigo, referenteID)
+	return &pb.RegisterReferralResponse{
+		Account:         acc,
+		ReferrerName:    referenteNombre,
+		BonusPoints:     prog.Referidos.PuntosReferido,
+		MinimumPurchase: prog.Referidos.CompraMinima,
+		Message: fmt.Sprintf("Recibirás %d puntos con tu primera compra de $%.2f o más",
+			prog.Referidos.PuntosReferido, prog.Referidos.CompraMinima),
+	}, nil
+}
+
+// inicioMes es el primer instante del mes en la zona del negocio, para el tope mensual
+func inicioMes(ahora time.Time) time.Time {
+	a := ahora.In(zonaNegocio)
+	return time.Date(a.Year(), a.Month(), 1, 0, 0, 0, 0, zonaNegocio)
+}
+
+// completarReferido resuelve el referido pendiente de la cuenta con su primera compra:
+// paga los dos bonos o lo rechaza con el motivo. Devuelve el bono que recibió la cuenta.
+func completarReferido(ctx context.Context, tx *sql.Tx, accountID, programaID string, saleID sql.NullString, total float64, prog programa.Programa) (int32, error) {
+	var refID, referenteID string
+	var referente, referido programa.Contacto
+	err := tx.QueryRowContext(ctx, `
+		SELECT r.id, r.referente_id, ref.phone, COALESCE(ref.email,''), a.phone, COALESCE(a.email,'')
+		FROM loyalty_referidos r
+		JOIN loyalty_accounts ref ON ref.id = r.referente_id
+		JOIN loyalty_accounts a ON a.id = r.referido_id
+		WHERE r.referido_id = $1 AND r.estado = 'pendiente'
+		FOR UPDATE OF r, ref
+	`, accountID).Scan(&refID, &referenteID, &referente.Telefono, &referente.Email, &referido.Telefono, &referido.Email)
+	if err == sql.ErrNoRows { return 0, nil }
+	if err != nil { return 0, err }
+
+	// El bloqueo de la cuenta del referente serializa el conteo del tope mensual
+	motivo := prog.Referidos.Rechazo(referente, referido)
+	if motivo == "" {
+		var pagados int
+		err = tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM loyalty_referidos WHERE referente_id = $1 AND estado = 'completado' AND resuelto_at >= $2
+		`, referenteID, inicioMes(time.Now())).Scan(&pagados)
+		if err != nil { return 0, err }
+		motivo = prog.Referidos.RechazoCompra(total, pagados)
+	}
+	if motivo != "" {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE loyalty_referidos SET estado = 'rechazado', motivo = $2, sale_id = $3, resuelto_at = NOW() WHERE id = $1
+		`, refID, motivo, saleID)
+		log.Printf("[Loyalty] Referido rechazado cuenta=%s referente=%s: %s", accountID, referenteID, motivo)
+		return 0, err
+	}
+
+	r := prog.Referidos
+	if err := abonarBono(ctx, tx, accountID, programaID, saleID, r.PuntosReferido, fmt.Sprintf("Bono de bienvenida por referido → +%d puntos", r.PuntosReferido), prog); err != nil {
+		return 0, err
+	}
+	if err := abonarBono(ctx, tx, referenteID, programaID, saleID, r.PuntosReferente, fmt.Sprintf("Bono por referir a un cliente → +%d puntos", r.PuntosReferente), prog); err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE loyalty_referidos SET estado = 'completado', sale_id = $2, puntos_referente = $3, puntos_referido = $4,
+		       resuelto_at = NOW()
+		WHERE id = $1
+	`, refID, saleID, r.PuntosReferente, r.PuntosReferido)
+	if err != nil { return 0, err }
+	log.Printf("[Loyalty] Referido completado cuenta=%s +%d referente=%s +%d", accountID, r.PuntosReferido, referenteID, r.PuntosReferente)
+	return r.PuntosReferido, nil
+}
+
+// abonarBono suma puntos de un bono a la cuenta como una transacción 'referido' con su
+// lote. Igual que una compra, primero paga la deuda de reversiones.
+func abonarBono(ctx context.Context, tx *sql.Tx, accountID, programaID string, saleID sql.NullString, puntos int32, descripcion string, prog programa.Programa) error {
+	if puntos <= 0 { return nil }
+	var saldo, deuda int32
+	err := tx.QueryRowContext(ctx, `SELECT points, deuda_puntos FROM loyalty_accounts WHERE id = $1 FOR UPDATE`, accountID).Scan(&saldo, &deuda)
+	if err != nil { return err }
+	neto, nuevaDeuda := programa.PagarDeuda(puntos, deuda)
+
+	var txID string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO loyalty_transactions (account_id, tenant_id, sale_id, type, points, description)
+		VALUES ($1, $2::uuid, $3, 'referido', $4, $5) RETURNING id
+	`, accountID, programaID, saleID, puntos, descripcion).Scan(&txID)
+	if err != nil { return err }
+	_, err = tx.ExecContext(ctx, `
+		UPDATE loyalty_accounts SET points = points + $2, deuda_puntos = $3, updated_at = NOW() WHERE id = $1
+	`, accountID, neto, nuevaDeuda)
+	if err != nil { return err }
+	if pagados := puntos - neto; pagados > 0 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO loyalty_transactions (account_id, tenant_id, sale_id, type, points, description)
+			VALUES ($1, $2::uuid, $3, 'deuda', $4, $5)
+		`, accountID, programaID, saleID, -pagados, fmt.Sprintf("Pago de %d puntos por devoluciones anteriores", pagados))
+		if err != nil { return err }
+	}
+	lotePuntos := neto
+	if saldo+neto < lotePuntos { lotePuntos = saldo + neto }
+	if lotePuntos <= 0 { return nil }
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO loyalty_lotes (account_id, tenant_id, transaction_id, puntos, restantes, expires_at)
+		VALUES ($1, $2::uuid, $3, $4, $4, $5)
+	`, accountID, programaID, txID, lotePuntos, lotes.Vencimiento(time.Now(), prog.VigenciaMeses, zonaNegocio))
+	return err
+}
+
+// GetReferralStats resume los referidos de una cuenta: su código, quién la refirió y el
+// estado de los clientes que ha referido
+func (s *LoyaltyServer) GetReferralStats(ctx context.Context, req *pb.ReferralStatsRequest) (*pb.ReferralStatsResponse, error) {
+	if req.AccountId == "" && req.Phone == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "account_id o phone requerido")
+	}
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := s.cargarPrograma(ctx, programaID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "programa de lealtad: %v", err)
+	}
+	accountID, err := s.cuentaReferidos(ctx, programaID, req.AccountId, req.Phone)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ReferralStatsResponse{MonthlyCap: int32(prog.Referidos.TopeMensual)}
+	if prog.Referidos.Activo() {
+		if resp.Code, err = s.codigoReferido(ctx, accountID); err != nil {
+			return nil, status.Errorf(codes.Internal, "código de referido: %v", err)
+		}
+	}
+	s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(ref.name,''), r.estado FROM loyalty_referidos r
+		JOIN loyalty_accounts ref ON ref.id = r.referente_id WHERE r.referido_id = $1
+	`, accountID).Scan(&resp.ReferredBy, &resp.ReferredStatus)
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE estado = 'pendiente'), COUNT(*) FILTER (WHERE estado = 'completado'),
+		       COUNT(*) FILTER (WHERE estado = 'rechazado'), COALESCE(SUM(puntos_referente), 0),
+		       COUNT(*) FILTER (WHERE estado = 'completado' AND resuelto_at >= $2)
+		FROM loyalty_referidos WHERE referente_id = $1
+	`, accountID, inicioMes(time.Now())).Scan(&resp.Pending, &resp.Completed, &resp.Rejected, &resp.PointsEarned, &resp.ThisMonth)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "resumen de referidos: %v", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.id, COALESCE(a.name,''), a.phone, r.estado, COALESCE(r.motivo,''), r.puntos_referente,
+		       r.created_at, r.resuelto_at
+		FROM loyalty_referidos r JOIN loyalty_accounts a ON a.id = r.referido_id
+		WHERE r.referente_id = $1 ORDER BY r.created_at DESC LIMIT 50
+	`, accountID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "listar referidos: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ref := &pb.Referral{}
+		var creado time.Time
+		var resuelto sql.NullTime
+		if err := rows.Scan(&ref.Id, &ref.Name, &ref.Phone, &ref.Status, &ref.Reason, &ref.Points, &creado, &resuelto); err != nil {
+			return nil, status.Errorf(codes.Internal, "leer referido: %v", err)
+		}
+		ref.CreatedAt = creado.Format(time.RFC3339)
+		if resuelto.Valid && ref.Status == "completado" { ref.CompletedAt = resuelto.Time.Format(time.RFC3339) }
+		resp.Referrals = append(resp.Referrals, ref)
+	}
+	return resp, rows.Err()
+}
+
+// ---------------------------------------------------------------------------
+// Duplicados y fusión de cuentas
+// ---------------------------------------------------------------------------
+
+// FindDuplicates sugiere grupos de cuentas del programa que parecen el mismo cliente
+func (s *LoyaltyServer) FindDuplicates(ctx context.Context, req *pb.FindDuplicatesRequest) (*pb.FindDuplicatesResponse, error) {
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := s.cargarPrograma(ctx, programaID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "programa de lealtad: %v", err)
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, phone, COALESCE(name,''), COALESCE(email,''), COALESCE(rfc,''), points, total_spent, tier
+		FROM loyalty_accounts WHERE tenant_id = $1::uuid AND anonimizado_at IS NULL ORDER BY created_at
+	`, programaID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "listar cuentas: %v", err)
+	}
+	defer rows.Close()
+	var cuentas []duplicados.Cuenta
+	porID := map[string]*pb.AccountResponse{}
+	for rows.Next() {
+		var email string
+		a := &pb.AccountResponse{}
+		if err := rows.Scan(&a.AccountId, &a.Phone, &a.Name, &email, &a.Rfc, &a.Points, &a.TotalSpent, &a.Tier); err != nil {
+			return nil, status.Errorf(codes.Internal, "leer cuenta: %v", err)
+		}
+		a.TierName = prog.NivelPorClave(a.Tier).Nombre
+		porID[a.AccountId] = a
+		cuentas = append(cuentas, duplicados.Cuenta{ID: a.AccountId, Nombre: a.Name, Email: email, RFC: a.Rfc})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, status.Errorf(codes.Internal, "listar cuentas: %v", err)
+	}
+
+	resp := &pb.FindDuplicatesResponse{}
+	for _, g := range duplicados.Buscar(cuentas) {
+		grupo := &pb.DuplicateGroup{Reasons: g.Motivos}
+		incluye := req.AccountId == ""
+		for _, id := range g.IDs {
+			grupo.Accounts = append(grupo.Accounts, porID[id])
+			if id == req.AccountId { incluye = true }
+		}
+		if incluye { resp.Groups = append(resp.Groups, grupo) }
+	}
+	return resp, nil
+}
+
+// cuentaFusion son los datos de una cuenta absorbida que pasan a la superviviente
+type cuentaFusion struct {
+	id, phone, name, email, rfc, cp, regimen, nombreFiscal, uso, codigo string
+	points, deuda                                                       int32
+	totalSpent                                                          float64
+}
+
+// MergeAccounts fusiona merged_ids en survivor_id dentro de una sola transacción. Cada
+// cuenta absorbida queda en loyalty_fusiones con su foto previa y se borra; su teléfono
+// se vuelve alterno de la superviviente.
+func (s *LoyaltyServer) MergeAccounts(ctx context.Context, req *pb.MergeAccountsRequest) (*pb.MergeAccountsResponse, error) {
+	if req.SurvivorId == "" || len(req.MergedIds) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "survivor_id y merged_ids requeridos")
+	}
+	vistos := map[string]bool{req.SurvivorId: true}
+	for _, id := range req.MergedIds {
+		if vistos[id] {
+			return nil, status.Errorf(codes.InvalidArgument, "cuenta repetida o igual a la superviviente: %s", id)
+		}
+		vistos[id] = true
+	}
+	programaID, err := s.tenantPrograma(ctx, req.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := s.cargarPrograma(ctx, programaID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "programa de lealtad: %v", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "iniciar transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Bloquear todas las cuentas en orden de id para no cruzarse con otra fusión
+	ids := append([]string{req.SurvivorId}, req.MergedIds...)
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id::text, phone, COALESCE(name,''), COALESCE(email,''), COALESCE(rfc,''), COALESCE(cp,''),
+		       COALESCE(regimen_fiscal,''), COALESCE(nombre_fiscal,''), COALESCE(uso_cfdi,''),
+		       COALESCE(codigo_referido,''), points, deuda_puntos, total_spent
+		FROM loyalty_accounts WHERE tenant_id = $1::uuid AND id::text = ANY($2)
+		  AND anonimizado_at IS NULL
+		ORDER BY id FOR UPDATE
+	`, programaID, pq.Array(ids))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "bloquear cuentas: %v", err)
+	}
+	cuentas := map[string]cuentaFusion{}
+	for rows.Next() {
+		var c cuentaFusion
+		if err := rows.Scan(&c.id, &c.phone, &c.name, &c.email, &c.rfc, &c.cp, &c.regimen, &c.nombreFiscal, &c.uso,
+			&c.codigo, &c.points, &c.deuda, &c.totalSpent); err != nil {
+			rows.Close()
+			return nil, status.Errorf(codes.Internal, "leer cuenta: %v", err)
+		}
+		cuentas[c.id] = c
+	}
+	rows.Close()
+	if len(cuentas) != len(ids) {
+		return nil, status.Errorf(codes.NotFound, "alguna de las cuentas no existe en el programa o está anonimizada")
+	}
+
+	resp := &pb.MergeAccountsResponse{}
+	sup := cuentas[req.SurvivorId]
+	for _, id := range req.MergedIds {
+		m := cuentas[id]
+		var fusionID string
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO loyalty_fusiones (tenant_id, superviviente_id, fusionada_id, fusionada, puntos, motivo, usuario)
+			SELECT $1::uuid, $2::uuid, a.id, to_jsonb(a), a.points, NULLIF($3,''), NULLIF($4,'')
+			FROM loyalty_accounts a WHERE a.id = $5::uuid
+			RETURNING id
+		`, programaID, sup.id, strings.TrimSpace(req.Reason), req.User, m.id).Scan(&fusionID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "bitácora de fusión: %v", err)
+		}
+		movidas, dup, err := moverCuenta(ctx, tx, m.id, sup.id)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "mover movimientos de %s: %v", m.phone, err)
+		}
+		puntos := m.points - dup.puntos
+		if _, err := tx.ExecContext(ctx, `UPDATE loyalty_fusiones SET transacciones = $2, puntos = $3 WHERE id = $1`, fusionID, movidas, puntos); err != nil {
+			return nil, status.Errorf(codes.Internal, "bitácora de fusión: %v", err)
+		}
+		// El teléfono de la absorbida (y sus alternos) pasan a la superviviente
+		_, err = tx.ExecContext(ctx, `UPDATE loyalty_telefonos_alternos SET account_id = $2 WHERE account_id = $1`, m.id, sup.id)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `DELETE FROM loyalty_accounts WHERE id = $1`, m.id)
+		}
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO loyalty_telefonos_alternos (tenant_id, phone, account_id, fusion_id) VALUES ($1::uuid, $2, $3, $4)
+				ON CONFLICT (tenant_id, phone) DO UPDATE SET account_id = EXCLUDED.account_id, fusion_id = EXCLUDED.fusion_id
+			`, programaID, m.phone, sup.id, fusionID)
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "teléfono alterno %s: %v", m.phone, err)
+		}
+
+		sup.points += m.points
+		sup.deuda += m.deuda
+		sup.totalSpent += m.totalSpent - dup.monto
+		if sup.totalSpent < 0 { sup.totalSpent = 0 }
+		// Los puntos ganados dos veces por la misma venta y los bonos de un referido a sí
+		// mismo se descuentan como una reversión; lo que ya no estaba en sus lotes sale de
+		// los más antiguos
+		if quitar := dup.puntos + dup.referido; quitar > 0 {
+			var tomados int32
+			sup.points, sup.deuda, tomados = programa.AplicarReversion(sup.points, sup.deuda, quitar, prog.PoliticaReversion)
+			if tomados > dup.lotes {
+				if err := consumirLotes(ctx, tx, sup.id, tomados-dup.lotes); err != nil {
+					return nil, status.Errorf(codes.Internal, "descontar lotes duplicados de %s: %v", m.phone, err)
+				}
+			}
+		}
+		for _, campo := range [][2]*string{{&sup.name, &m.name}, {&sup.email, &m.email}, {&sup.rfc, &m.rfc}, {&sup.cp, &m.cp},
+			{&sup.regimen, &m.regimen}, {&sup.nombreFiscal, &m.nombreFiscal}, {&sup.uso, &m.uso}, {&sup.codigo, &m.codigo}} {
+			if *campo[0] == "" { *campo[0] = *campo[1] }
+		}
+		resp.MergeIds = append(resp.MergeIds, fusionID)
+		resp.PointsMoved += puntos
+		resp.TransactionsMoved += int32(movidas)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE loyalty_accounts
+		SET points = $2, deuda_puntos = $3, total_spent = $4, name = NULLIF($5,''), email = NULLIF($6,''),
+		    rfc = NULLIF($7,''), cp = NULLIF($8,''), regimen_fiscal = NULLIF($9,''), nombre_fiscal = NULLIF($10,''),
+		    uso_cfdi = NULLIF($11,''), codigo_referido = NULLIF($12,''), updated_at = NOW()
+		WHERE id = $1
+	`, sup.id, sup.points, sup.deuda, sup.totalSpent, sup.name, sup.email, sup.rfc, sup.cp, sup.regimen,
+		sup.nombreFiscal, sup.uso, sup.codigo)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "actualizar cuenta superviviente: %v", err)
+	}
+	if _, err := actualizarNivel(ctx, tx, sup.id, prog, "recalificacion"); err != nil {
+		return nil, status.Errorf(codes.Internal, "calificar tier: %v", err)
+	}
+	prows, err := tx.QueryContext(ctx, `SELECT phone FROM loyalty_telefonos_alternos WHERE account_id = $1 ORDER BY created_at`, sup.id)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "teléfonos alternos: %v", err)
+	}
+	for prows.Next() {
+		var phone string
+		if prows.Scan(&phone) == nil { resp.Phones = append(resp.Phones, phone) }
+	}
+	prows.Close()
+	if err := tx.Commit(); err != nil {
+		return nil, status.Errorf(codes.Internal, "commit: %v", err)
+	}
+
+	log.Printf("[Loyalty] MergeAccounts tenant=%s superviviente=%s absorbidas=%v puntos=+%d movimientos=%d",
+		req.TenantId, sup.id, req.MergedIds, resp.PointsMoved, resp.TransactionsMoved)
+	if resp.Account, err = s.GetAccount(ctx, &pb.GetAccountRequest{TenantId: req.TenantId, Phone: sup.phone}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// duplicadosFusion son los puntos que la cuenta absorbida ganó por ventas que también
+// le dieron puntos a la superviviente (con el monto de esas ventas) y los bonos que
+// cualquiera de las dos recibió por un referido entre ellas: se quitan del saldo y del
+// gasto fusionados. lotes es lo que quedaba de esos puntos en sus lotes, que ya se
+// descontó.
+type duplicadosFusion struct {
+	puntos, referido, lotes int32
+	monto                   float64
+}
+
+// moverCuenta pasa a `destino` todo lo que cuelga de la cuenta `origen` y devuelve
+// cuántos movimientos se movieron y lo que no debe sumarse a la superviviente
+func moverCuenta(ctx context.Context, tx *sql.Tx, origen, destino string) (int64, duplicadosFusion, error) {
+	// Si las dos cuentas ganaron puntos por la misma venta, el earn de la absorbida se
+	// conserva como duplicado para no romper el índice de un earn por venta, y sus lotes
+	// se vacían porque esos puntos no pasan a la superviviente
+	var dup duplicadosFusion
+	err := tx.QueryRowContext(ctx, `
+		WITH dup AS (
+			UPDATE loyalty_transactions SET type = 'earn_duplicado'
+			WHERE account_id = $1 AND type = 'earn' AND sale_id IN (
+				SELECT sale_id FROM loyalty_transactions WHERE account_id = $2 AND type = 'earn' AND sale_id IS NOT NULL)
+			RETURNING id, points, monto
+		), lotes AS (
+			UPDATE loyalty_lotes l SET restantes = 0
+			FROM loyalty_lotes previo
+			WHERE previo.id = l.id AND l.transaction_id IN (SELECT id FROM dup) AND l.restantes > 0
+			RETURNING previo.restantes
+		)
+		SELECT (SELECT COALESCE(SUM(points), 0) FROM dup), (SELECT COALESCE(SUM(monto), 0) FROM dup),
+		       (SELECT COALESCE(SUM(restantes), 0) FROM lotes)
+	`, origen, destino).Scan(&dup.puntos, &dup.monto, &dup.lotes)
+	if err != nil { return 0, dup, err }
+	// Un referido entre las dos cuentas era el mismo cliente refiriéndose a sí mismo: los
+	// bonos que pagó se anulan igual que un earn duplicado
+	var lotesBono int32
+	err = tx.QueryRowContext(ctx, `
+		WITH bonos AS (
+			UPDATE loyalty_transactions t SET type = 'referido_anulado'
+			FROM loyalty_referidos r
+			WHERE ((r.referente_id = $1 AND r.referido_id = $2) OR (r.referente_id = $2 AND r.referido_id = $1))
+			  AND r.estado = 'completado' AND t.type = 'referido' AND t.sale_id = r.sale_id
+			  AND t.account_id IN ($1, $2)
+			RETURNING t.id, t.points
+		), lotes AS (
+			UPDATE loyalty_lotes l SET restantes = 0
+			FROM loyalty_lotes previo
+			WHERE previo.id = l.id AND l.transaction_id IN (SELECT id FROM bonos) AND l.restantes > 0
+			RETURNING previo.restantes
+		)
+		SELECT (SELECT COALESCE(SUM(points), 0) FROM bonos), (SELECT COALESCE(SUM(restantes), 0) FROM lotes)
+	`, origen, destino).Scan(&dup.referido, &lotesBono)
+	if err != nil { return 0, dup, err }
+	dup.lotes += lotesBono
+	res, err := tx.ExecContext(ctx, `UPDATE loyalty_transactions SET account_id = $2 WHERE account_id = $1`, origen, destino)
+	if err != nil { return 0, dup, err }
+	movidas, _ := res.RowsAffected()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE sales SET customer_id = $2 WHERE customer_id = $1`, origen, destino); err != nil {
+		return 0, dup, err
+	}
+	for _, tabla := range []string{"loyalty_lotes", "loyalty_canjes", "loyalty_tier_historial"} {
+		if _, err := tx.ExecContext(ctx, `UPDATE `+tabla+` SET account_id = $2 WHERE account_id = $1`, origen, destino); err != nil {
+			return 0, dup, err
+		}
+	}
+	// El crédito (fiado) de la absorbida se suma al de la superviviente en cada tienda:
+	// se deben los dos saldos y se queda el límite mayor
+	for _, q := range []string{
+		`INSERT INTO creditos_clientes (tenant_id, account_id, limite, saldo, dias_plazo, dias_tolerancia, bloqueado, notas)
+		 SELECT tenant_id, $2, limite, saldo, dias_plazo, dias_tolerancia, bloqueado, notas FROM creditos_clientes WHERE account_id = $1
+		 ON CONFLICT (tenant_id, account_id) DO UPDATE SET
+		   limite = GREATEST(creditos_clientes.limite, EXCLUDED.limite), saldo = creditos_clientes.saldo + EXCLUDED.saldo,
+		   dias_plazo = LEAST(creditos_clientes.dias_plazo, EXCLUDED.dias_plazo),
+		   dias_tolerancia = LEAST(creditos_clientes.dias_tolerancia, EXCLUDED.dias_tolerancia),
+		   bloqueado = creditos_clientes.bloqueado OR EXCLUDED.bloqueado,
+		   notas = COALESCE(creditos_clientes.notas, EXCLUDED.notas), updated_at = NOW()`,
+		`UPDATE credito_movimientos SET account_id = $2 WHERE account_id = $1`,
+		`DELETE FROM creditos_clientes WHERE account_id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, origen, destino); err != nil { return 0, dup, err }
+	}
+	// El referido entre las dos cuentas, ya sin bonos, se borra
+	for _, q := range []string{
+		`DELETE FROM loyalty_referidos WHERE (referente_id = $1 AND referido_id = $2) OR (referente_id = $2 AND referido_id = $1)`,
+		`UPDATE loyalty_referidos SET referente_id = $2 WHERE referente_id = $1`,
+		`UPDATE loyalty_referidos SET referido_id = $2 WHERE referido_id = $1
+		   AND NOT EXISTS (SELECT 1 FROM loyalty_referidos WHERE referido_id = $2)`,
+		`DELETE FROM loyalty_referidos WHERE referido_id = $1`,
+		`DELETE FROM loyalty_portal_sesiones WHERE account_id = $1`,
+		`DELETE FROM loyalty_portal_codigos WHERE account_id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, origen, destino); err != nil { return 0, dup, err }
 	}
+	return movidas, dup, nil
 }
 
 func main() {
@@ -332,12 +2069,12 @@ func main() {
 	}
 
 	srv := grpc.NewServer()
-	pb.RegisterLoyaltyServiceServer(srv, NewLoyaltyServer(db))
+	loyalty := NewLoyaltyServer(db)
+	loyalty.startVencimientoCron()
+	pb.RegisterLoyaltyServiceServer(srv, loyalty)
 	reflection.Register(srv)
 
-	log.Printf("[Loyalty] Servidor en %s — 1 punto por peso gastado", Port)
-	log.Printf("[Loyalty] Tiers: Bronze→Silver(%d pts)→Gold(%d pts)→Platinum(%d pts)",
-		TierSilver, TierGold, TierPlatinum)
+	log.Printf("[Loyalty] Servidor en %s — reglas de puntos por tenant (predeterminado: 1 punto por peso)", Port)
 
 	if err := srv.Serve(lis); err != nil {
 		log.Fatalf("serve: %v", err)
@@ -0,0 +1,195 @@
+// Package duplicados detecta cuentas de lealtad que parecen ser el mismo cliente con
+// distintos teléfonos: mismo RFC, mismo correo o nombres casi iguales. Solo sugiere
+// grupos; la fusión la confirma el negocio.
+package duplicados
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Motivos por los que dos cuentas se consideran duplicadas
+const (
+	MotivoRFC    = "rfc"
+	MotivoEmail  = "email"
+	MotivoNombre = "nombre"
+)
+
+// UmbralNombre es la similitud mínima entre dos nombres para sugerirlos como el mismo
+// cliente
+const UmbralNombre = 0.88
+
+// maxBloque limita las comparaciones de nombres dentro de un mismo bloque para que un
+// nombre muy común no vuelva cuadrática la búsqueda
+const maxBloque = 300
+
+// Cuenta son los datos de una cuenta que sirven para detectar duplicados
+type Cuenta struct {
+	ID     string
+	Nombre string
+	Email  string
+	RFC    string
+}
+
+// Grupo son cuentas que parecen del mismo cliente y los motivos que las unen
+type Grupo struct {
+	IDs     []string
+	Motivos []string
+}
+
+// rfcGenericos son los RFC de público en general y extranjeros, que comparten muchos clientes
+var rfcGenericos = map[string]bool{"XAXX010101000": true, "XEXX010101000": true}
+
+// NormalizarNombre quita acentos, signos y mayúsculas y ordena las palabras, para que
+// "Pérez López, Juan" y "juan perez lopez" queden iguales
+func NormalizarNombre(nombre string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(nombre)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	palabras := strings.Fields(b.String())
+	sort.Strings(palabras)
+	return strings.Join(palabras, " ")
+}
+
+// NormalizarEmail ignora mayúsculas y las etiquetas "+algo" del usuario
+func NormalizarEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at <= 0 { return "" }
+	usuario := email[:at]
+	if i := strings.Index(usuario, "+"); i >= 0 { usuario = usuario[:i] }
+	return usuario + email[at:]
+}
+
+// Similitud compara dos nombres normalizados: 1 son iguales, 0 no se parecen en nada.
+// Es 1 menos la distancia de edición entre la longitud del más largo.
+func Similitud(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 { return 1 }
+	largo := len(ra)
+	if len(rb) > largo { largo = len(rb) }
+	return 1 - float64(distancia(ra, rb))/float64(largo)
+}
+
+func distancia(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev { prev[j] = j }
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			costo := 1
+			if a[i-1] == b[j-1] { costo = 0 }
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+costo)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// nombreComparable indica si el nombre tiene suficiente información para compararse:
+// al menos nombre y apellido. "Juan" o "Cliente" solos unirían a medio padrón.
+func nombreComparable(normalizado string) bool {
+	return len(strings.Fields(normalizado)) >= 2 && len(normalizado) >= 8
+}
+
+// Buscar agrupa las cuentas que comparten RFC (que no sea genérico) o correo, o cuyos
+// nombres tienen una similitud de al menos UmbralNombre. Los grupos salen ordenados por
+// su primera cuenta en el orden recibido; las cuentas sin duplicados no aparecen.
+func Buscar(cuentas []Cuenta) []Grupo {
+	padre := make([]int, len(cuentas))
+	for i := range padre { padre[i] = i }
+	var raiz func(int) int
+	raiz = func(i int) int {
+		if padre[i] != i { padre[i] = raiz(padre[i]) }
+		return padre[i]
+	}
+	motivos, duplicada := map[[2]int]map[string]bool{}, map[int]bool{}
+	unir := func(i, j int, motivo string) {
+		if i > j { i, j = j, i }
+		k := [2]int{i, j}
+		if motivos[k] == nil { motivos[k] = map[string]bool{} }
+		motivos[k][motivo] = true
+		duplicada[i], duplicada[j] = true, true
+		if ri, rj := raiz(i), raiz(j); ri != rj {
+			if ri < rj { padre[rj] = ri } else { padre[ri] = rj }
+		}
+	}
+
+	porRFC, porEmail, porBloque := map[string][]int{}, map[string][]int{}, map[string][]int{}
+	nombres := make([]string, len(cuentas))
+	for i, c := range cuentas {
+		if rfc := strings.ToUpper(strings.TrimSpace(c.RFC)); rfc != "" && !rfcGenericos[rfc] {
+			porRFC[rfc] = append(porRFC[rfc], i)
+		}
+		if email := NormalizarEmail(c.Email); email != "" {
+			porEmail[email] = append(porEmail[email], i)
+		}
+		nombres[i] = NormalizarNombre(c.Nombre)
+		if nombreComparable(nombres[i]) {
+			// Los nombres parecidos casi siempre comparten alguna palabra completa; cada
+			// cuenta entra al bloque de sus dos primeras palabras en orden alfabético
+			palabras := strings.Fields(nombres[i])
+			for _, p := range palabras[:2] { porBloque[p] = append(porBloque[p], i) }
+		}
+	}
+	for _, ids := range porRFC {
+		for _, j := range ids[1:] { unir(ids[0], j, MotivoRFC) }
+	}
+	for _, ids := range porEmail {
+		for _, j := range ids[1:] { unir(ids[0], j, MotivoEmail) }
+	}
+	for _, ids := range porBloque {
+		if len(ids) > maxBloque { ids = ids[:maxBloque] }
+		for a := 0; a < len(ids); a++ {
+			for b := a + 1; b < len(ids); b++ {
+				if Similitud(nombres[ids[a]], nombres[ids[b]]) >= UmbralNombre {
+					unir(ids[a], ids[b], MotivoNombre)
+				}
+			}
+		}
+	}
+
+	porRaiz := map[int]*Grupo{}
+	var orden []int
+	for i, c := range cuentas {
+		if !duplicada[i] { continue }
+		r := raiz(i)
+		g := porRaiz[r]
+		if g == nil {
+			g = &Grupo{}
+			porRaiz[r] = g
+			orden = append(orden, r)
+		}
+		g.IDs = append(g.IDs, c.ID)
+	}
+	for k, ms := range motivos {
+		g := porRaiz[raiz(k[0])]
+		for m := range ms {
+			if !contiene(g.Motivos, m) { g.Motivos = append(g.Motivos, m) }
+		}
+	}
+	grupos := make([]Grupo, 0, len(orden))
+	for _, r := range orden {
+		sort.Strings(porRaiz[r].Motivos)
+		grupos = append(grupos, *porRaiz[r])
+	}
+	return grupos
+}
+
+func contiene(lista []string, s string) bool {
+	for _, x := range lista {
+		if x == s { return true }
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package duplicados
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizarNombre(t *testing.T) {
+	casos := map[string]string{
+		"Pérez López, Juan":   "juan lopez perez",
+		"  JUAN  perez lopez": "juan lopez perez",
+		"Ñoño Muñoz":          "munoz nono",
+		"":                    "",
+	}
+	for in, want := range casos {
+		if got := NormalizarNombre(in); got != want {
+			t.Errorf("NormalizarNombre(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNormalizarEmail(t *testing.T) {
+	if got := NormalizarEmail(" Ana+tienda@Correo.MX "); got != "ana@correo.mx" {
+		t.Errorf("NormalizarEmail = %q", got)
+	}
+	if got := NormalizarEmail("sin-arroba"); got != "" {
+		t.Errorf("correo inválido = %q", got)
+	}
+}
+
+func TestSimilitud(t *testing.T) {
+	if s := Similitud("juan lopez perez", "juan lopez perez"); s != 1 {
+		t.Errorf("iguales = %v", s)
+	}
+	if s := Similitud("juan lopez perez", "juan lopes perez"); s < UmbralNombre {
+		t.Errorf("una letra distinta = %v", s)
+	}
+	if s := Similitud("juan lopez perez", "juan martinez ruiz"); s >= UmbralNombre {
+		t.Errorf("distintos = %v", s)
+	}
+}
+
+func TestBuscar(t *testing.T) {
+	cuentas := []Cuenta{
+		{ID: "a", Nombre: "Juan Pérez López", RFC: "PELJ800101AB1"},
+		{ID: "b", Nombre: "J. Perez", RFC: "pelj800101ab1"},
+		{ID: "c", Nombre: "María González", Email: "maria@correo.mx"},
+		{ID: "d", Nombre: "Mari Gonzales", Email: "MARIA+pos@correo.mx"},
+		{ID: "e", Nombre: "Roberto Sánchez Díaz"},
+		{ID: "f", Nombre: "Roberto Sanches Diaz"},
+		{ID: "g", Nombre: "Público en general", RFC: "XAXX010101000"},
+		{ID: "h", Nombre: "Otro cliente", RFC: "XAXX010101000"},
+		{ID: "i", Nombre: "Juan"},
+		{ID: "j", Nombre: "Juan"},
+	}
+	want := []Grupo{
+		{IDs: []string{"a", "b"}, Motivos: []string{MotivoRFC}},
+		{IDs: []string{"c", "d"}, Motivos: []string{MotivoEmail}},
+		{IDs: []string{"e", "f"}, Motivos: []string{MotivoNombre}},
+	}
+	if got := Buscar(cuentas); !reflect.DeepEqual(got, want) {
+		t.Errorf("Buscar =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestBuscar_Transitivo(t *testing.T) {
+	// a y b comparten RFC, b y c correo: las tres son el mismo cliente
+	cuentas := []Cuenta{
+		{ID: "a", Nombre: "Ana", RFC: "AAAA800101AB1"},
+		{ID: "b", Nombre: "Ana R", RFC: "AAAA800101AB1", Email: "ana@correo.mx"},
+		{ID: "c", Nombre: "Anita", Email: "ana@correo.mx"},
+	}
+	got := Buscar(cuentas)
+	if len(got) != 1 || !reflect.DeepEqual(got[0].IDs, []string{"a", "b", "c"}) ||
+		!reflect.DeepEqual(got[0].Motivos, []string{MotivoEmail, MotivoRFC}) {
+		t.Errorf("Buscar = %+v", got)
+	}
+}
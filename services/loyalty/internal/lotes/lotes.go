@@ -0,0 +1,50 @@
+// Package lotes lleva los puntos ganados como lotes con fecha de vencimiento. Los canjes
+// consumen primero los lotes más antiguos (FIFO) y lo que queda de un lote vencido se
+// pierde.
+package lotes
+
+import "time"
+
+// Lote son los puntos de una compra. Vence nil = no vence.
+type Lote struct {
+	ID        string
+	Restantes int32
+	CreadoEn  time.Time
+	Vence     *time.Time
+}
+
+// Consumo es lo que un canje toma de un lote
+type Consumo struct {
+	LoteID string
+	Puntos int32
+}
+
+// Vigente indica si al lote le quedan puntos utilizables en el instante dado
+func (l Lote) Vigente(ahora time.Time) bool {
+	return l.Restantes > 0 && (l.Vence == nil || l.Vence.After(ahora))
+}
+
+// Consumir reparte `puntos` entre los lotes vigentes empezando por el más antiguo. Los
+// lotes deben venir ordenados por CreadoEn. Devuelve los consumos y los puntos que no
+// alcanzaron a cubrirse con lotes (0 si el saldo por lotes era suficiente).
+func Consumir(lotes []Lote, puntos int32, ahora time.Time) ([]Consumo, int32) {
+	var consumos []Consumo
+	for _, l := range lotes {
+		if puntos <= 0 { break }
+		if !l.Vigente(ahora) { continue }
+		toma := l.Restantes
+		if toma > puntos { toma = puntos }
+		consumos = append(consumos, Consumo{LoteID: l.ID, Puntos: toma})
+		puntos -= toma
+	}
+	return consumos, puntos
+}
+
+// Vencimiento calcula la fecha en que vencen los puntos ganados en `ganado` con una
+// vigencia de `meses` (0 = no vencen). Vencen al terminar el día, en la zona del negocio.
+func Vencimiento(ganado time.Time, meses int, zona *time.Location) *time.Time {
+	if meses <= 0 { return nil }
+	d := ganado.In(zona).AddDate(0, meses, 0)
+	fin := time.Date(d.Year(), d.Month(), d.Day(), 23, 59, 59, 0, zona)
+	return &fin
+}
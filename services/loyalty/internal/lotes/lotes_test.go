@@ -0,0 +1,51 @@
+package lotes
+
+import (
+	"testing"
+	"time"
+)
+
+func fecha(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestConsumir_FIFO(t *testing.T) {
+	vencido := fecha("2026-01-31")
+	vigente := fecha("2027-01-31")
+	lotes := []Lote{
+		{ID: "enero", Restantes: 100, CreadoEn: fecha("2025-01-31"), Vence: &vencido},
+		{ID: "marzo", Restantes: 50, CreadoEn: fecha("2026-03-01"), Vence: &vigente},
+		{ID: "abril", Restantes: 80, CreadoEn: fecha("2026-04-01"), Vence: &vigente},
+		{ID: "saldo", Restantes: 30, CreadoEn: fecha("2026-04-02")}, // sin vencimiento
+	}
+	ahora := fecha("2026-05-01")
+
+	consumos, faltan := Consumir(lotes, 70, ahora)
+	if faltan != 0 || len(consumos) != 2 {
+		t.Fatalf("consumos = %+v, faltan %d", consumos, faltan)
+	}
+	if consumos[0] != (Consumo{"marzo", 50}) || consumos[1] != (Consumo{"abril", 20}) {
+		t.Errorf("el canje no tomó primero el lote vigente más antiguo: %+v", consumos)
+	}
+
+	consumos, faltan = Consumir(lotes, 200, ahora)
+	if faltan != 40 {
+		t.Errorf("faltan = %d, esperaba 40 (el lote vencido no cuenta)", faltan)
+	}
+	if len(consumos) != 3 || consumos[2] != (Consumo{"saldo", 30}) {
+		t.Errorf("consumos = %+v", consumos)
+	}
+}
+
+func TestVencimiento(t *testing.T) {
+	zona := time.FixedZone("CST", -6*3600)
+	ganado := time.Date(2026, 2, 15, 20, 0, 0, 0, zona)
+	v := Vencimiento(ganado, 12, zona)
+	if v == nil || v.Format("2006-01-02 15:04") != "2027-02-15 23:59" {
+		t.Fatalf("Vencimiento = %v", v)
+	}
+	if Vencimiento(ganado, 0, zona) != nil {
+		t.Error("sin vigencia los puntos no vencen")
+	}
+}
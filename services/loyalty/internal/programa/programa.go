@@ -0,0 +1,367 @@
+// Package programa define las reglas de acumulación de puntos de un negocio: puntos por
+// peso, redondeo, multiplicadores por categoría y producto, productos excluidos, niveles
+// por gasto (o puntos) acumulado en una ventana móvil con su propio multiplicador y
+// descuento, y promociones de puntos por fecha.
+package programa
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Modos de redondeo de los puntos de una compra
+const (
+	RedondeoAbajo   = "abajo"
+	RedondeoCercano = "cercano"
+	RedondeoArriba  = "arriba"
+)
+
+// Bases para calificar el tier de un cliente
+const (
+	BaseGasto  = "gasto"  // pesos gastados
+	BasePuntos = "puntos" // puntos ganados
+)
+
+// Nivel es un tier del programa. El cliente lo alcanza cuando lo que acumula en la
+// ventana del programa (gasto o puntos, según BaseNivel) llega a GastoMinimo;
+// Multiplicador escala los puntos de sus compras (1 = sin cambio) y DescuentoPct es el
+// descuento que la caja le puede aplicar.
+type Nivel struct {
+	Clave         string  `json:"clave"`
+	Nombre        string  `json:"nombre"`
+	GastoMinimo   float64 `json:"gasto_minimo"`
+	Multiplicador float64 `json:"multiplicador"`
+	DescuentoPct  float64 `json:"descuento_pct"`
+}
+
+// Promocion multiplica los puntos de las compras hechas entre Desde y Hasta (fechas
+// inclusivas, en la zona horaria del negocio)
+type Promocion struct {
+	Nombre        string    `json:"nombre"`
+	Desde         time.Time `json:"desde"`
+	Hasta         time.Time `json:"hasta"`
+	Multiplicador float64   `json:"multiplicador"`
+}
+
+// Partida es un renglón de la venta; Categoria es la del producto en el catálogo
+type Partida struct {
+	ProductoID string
+	Categoria  string
+	Subtotal   float64
+}
+
+// Programa son las reglas de un tenant. En PorProducto un multiplicador 0 excluye el
+// producto; el de producto tiene prioridad sobre el de su categoría.
+type Programa struct {
+	PuntosPorPeso float64
+	Redondeo      string
+	Niveles       []Nivel // ordenados por GastoMinimo ascendente
+	PorCategoria  map[string]float64
+	PorProducto   map[string]float64
+	Promociones   []Promocion
+	VigenciaMeses int // meses que duran los puntos ganados; 0 = no vencen
+	// PoliticaReversion decide qué pasa si una cancelación quita más puntos de los que hay
+	PoliticaReversion string
+	BaseNivel         string // gasto o puntos
+	VentanaMeses      int    // meses hacia atrás que cuentan para el tier; 0 = todo el historial
+	GraciaDias        int    // días que el cliente conserva su tier después de dejar de calificar
+	Referidos         Referidos
+}
+
+// Predeterminado es el programa de los negocios que no han configurado el suyo:
+// 1 punto por peso redondeado hacia abajo y los tiers históricos de TurboPOS.
+func Predeterminado() Programa {
+	return Programa{
+		PuntosPorPeso: 1,
+		Redondeo:      RedondeoAbajo,
+		Niveles:       NivelesPredeterminados(),
+		PoliticaReversion: ReversionDeuda,
+		BaseNivel:         BaseGasto,
+	}
+}
+
+// NivelesPredeterminados son bronze, silver (500), gold (2000) y platinum (5000)
+func NivelesPredeterminados() []Nivel {
+	return []Nivel{
+		{Clave: "bronze", Nombre: "Bronce", GastoMinimo: 0, Multiplicador: 1},
+		{Clave: "silver", Nombre: "Plata", GastoMinimo: 500, Multiplicador: 1},
+		{Clave: "gold", Nombre: "Oro", GastoMinimo: 2000, Multiplicador: 1},
+		{Clave: "platinum", Nombre: "Platino", GastoMinimo: 5000, Multiplicador: 1},
+	}
+}
+
+// Validar revisa que las reglas tengan sentido antes de guardarlas
+func (p Programa) Validar() error {
+	if p.PuntosPorPeso < 0 || p.PuntosPorPeso > 1000 {
+		return fmt.Errorf("puntos_por_peso fuera de rango: %v", p.PuntosPorPeso)
+	}
+	switch p.Redondeo {
+	case RedondeoAbajo, RedondeoCercano, RedondeoArriba:
+	default:
+		return fmt.Errorf("redondeo inválido %q (abajo, cercano o arriba)", p.Redondeo)
+	}
+	switch p.PoliticaReversion {
+	case ReversionDeuda, ReversionNegativo, ReversionAbsorber:
+	default:
+		return fmt.Errorf("politica_reversion inválida %q (deuda, negativo o absorber)", p.PoliticaReversion)
+	}
+	if p.BaseNivel != BaseGasto && p.BaseNivel != BasePuntos {
+		return fmt.Errorf("base_nivel inválida %q (gasto o puntos)", p.BaseNivel)
+	}
+	if p.VentanaMeses < 0 || p.VentanaMeses > 120 || p.GraciaDias < 0 || p.GraciaDias > 365 {
+		return fmt.Errorf("ventana_meses (0-120) o gracia_dias (0-365) fuera de rango")
+	}
+	if err := p.Referidos.Validar(); err != nil {
+		return err
+	}
+	if len(p.Niveles) == 0 {
+		return fmt.Errorf("el programa necesita al menos un nivel")
+	}
+	claves := map[string]bool{}
+	ceroInicial := false
+	for _, n := range p.Niveles {
+		if n.Clave == "" || len(n.Clave) > 20 || strings.TrimSpace(n.Nombre) == "" {
+			return fmt.Errorf("cada nivel necesita clave (máx. 20) y nombre")
+		}
+		if claves[n.Clave] {
+			return fmt.Errorf("nivel repetido: %s", n.Clave)
+		}
+		claves[n.Clave] = true
+		if n.GastoMinimo < 0 || n.Multiplicador < 0 {
+			return fmt.Errorf("nivel %s: gasto mínimo y multiplicador no pueden ser negativos", n.Clave)
+		}
+		if n.DescuentoPct < 0 || n.DescuentoPct > 100 {
+			return fmt.Errorf("nivel %s: descuento_pct debe estar entre 0 y 100", n.Clave)
+		}
+		if n.GastoMinimo == 0 { ceroInicial = true }
+	}
+	if !ceroInicial {
+		return fmt.Errorf("un nivel debe empezar en gasto 0")
+	}
+	return nil
+}
+
+// Nivel devuelve el tier que corresponde a un gasto acumulado
+func (p Programa) Nivel(gasto float64) Nivel {
+	niveles := append([]Nivel(nil), p.Niveles...)
+	if len(niveles) == 0 { niveles = NivelesPredeterminados() }
+	sort.SliceStable(niveles, func(i, j int) bool { return niveles[i].GastoMinimo < niveles[j].GastoMinimo })
+	actual := niveles[0]
+	for _, n := range niveles {
+		if gasto >= n.GastoMinimo { actual = n }
+	}
+	return actual
+}
+
+// InicioVentana es desde cuándo cuenta lo acumulado para el tier; el tiempo cero si el
+// programa califica con todo el historial
+func (p Programa) InicioVentana(ahora time.Time) time.Time {
+	if p.VentanaMeses <= 0 { return time.Time{} }
+	return ahora.AddDate(0, -p.VentanaMeses, 0)
+}
+
+// Calificar decide el tier de una cuenta que hoy está en `actual` y acumula `valor` en la
+// ventana. Subir es inmediato; bajar espera GraciaDias desde que la cuenta dejó de
+// calificar. Devuelve el tier y el fin del periodo de gracia en curso (nil si no hay).
+func (p Programa) Calificar(actual string, valor float64, gracia *time.Time, ahora time.Time) (Nivel, *time.Time) {
+	objetivo, vigente := p.Nivel(valor), p.NivelPorClave(actual)
+	if objetivo.GastoMinimo >= vigente.GastoMinimo || p.GraciaDias <= 0 {
+		return objetivo, nil
+	}
+	if gracia == nil {
+		fin := ahora.AddDate(0, 0, p.GraciaDias)
+		return vigente, &fin
+	}
+	if ahora.Before(*gracia) { return vigente, gracia }
+	return objetivo, nil
+}
+
+// NivelPorClave busca un tier por su clave; si ya no existe en el programa se trata
+// como el nivel inicial.
+func (p Programa) NivelPorClave(clave string) Nivel {
+	for _, n := range p.Niveles {
+		if n.Clave == clave { return n }
+	}
+	return p.Nivel(0)
+}
+
+// MultiplicadorPromocion es el de la mejor promoción vigente en la fecha (1 si no hay)
+func (p Programa) MultiplicadorPromocion(fecha time.Time) float64 {
+	dia := fecha.Format("2006-01-02")
+	mult := 1.0
+	for _, pr := range p.Promociones {
+		if dia >= pr.Desde.Format("2006-01-02") && dia <= pr.Hasta.Format("2006-01-02") && pr.Multiplicador > mult {
+			mult = pr.Multiplicador
+		}
+	}
+	return mult
+}
+
+// Puntos calcula los puntos de una compra por `total` pesos. Con partidas, cada una
+// aporta según el multiplicador de su producto o categoría y los montos se prorratean
+// al total cobrado (descuentos incluidos); sin partidas cuenta el total completo.
+// nivel es la clave del tier del cliente antes de la compra.
+func (p Programa) Puntos(total float64, partidas []Partida, nivel string, fecha time.Time) int32 {
+	if total <= 0 { return 0 }
+	base := total
+	if len(partidas) > 0 {
+		var suma, ponderado float64
+		for _, it := range partidas {
+			suma += it.Subtotal
+			ponderado += it.Subtotal * p.multiplicadorPartida(it)
+		}
+		if suma > 0 { base = ponderado * total / suma }
+	}
+	puntos := base * p.PuntosPorPeso * p.NivelPorClave(nivel).Multiplicador * p.MultiplicadorPromocion(fecha)
+	// El margen evita que 99.99999 por error de punto flotante se redondee a 99
+	switch p.Redondeo {
+	case RedondeoCercano:
+		puntos = math.Round(puntos)
+	case RedondeoArriba:
+		puntos = math.Ceil(puntos - 1e-6)
+	default:
+		puntos = math.Floor(puntos + 1e-6)
+	}
+	if puntos < 0 { return 0 }
+	return int32(puntos)
+}
+
+func (p Programa) multiplicadorPartida(it Partida) float64 {
+	if m, ok := p.PorProducto[it.ProductoID]; ok { return m }
+	if m, ok := p.PorCategoria[it.Categoria]; ok { return m }
+	return 1
+}
+
+// Políticas para reversar puntos que el cliente ya canjeó
+const (
+	ReversionDeuda    = "deuda"    // el saldo queda en 0 y el faltante se cobra de compras futuras
+	ReversionNegativo = "negativo" // el saldo puede quedar negativo
+	ReversionAbsorber = "absorber" // el saldo queda en 0 y el negocio absorbe el faltante
+)
+
+// PuntosAReversar calcula cuántos puntos y cuánto gasto se quitan por una devolución de
+// `reembolso` pesos de una venta que dio `ganados` puntos por `monto` pesos, de la que ya
+// se reversaron `yaPuntos` y `yaMonto`. reembolso <= 0 (o mayor a lo que queda) reversa
+// todo lo pendiente, como en una cancelación.
+func PuntosAReversar(ganados, yaPuntos int32, monto, yaMonto, reembolso float64) (int32, float64) {
+	pendientes, montoPendiente := ganados-yaPuntos, monto-yaMonto
+	if pendientes < 0 { pendientes = 0 }
+	if montoPendiente < 0 { montoPendiente = 0 }
+	if reembolso <= 0 || reembolso >= montoPendiente || monto <= 0 {
+		return pendientes, montoPendiente
+	}
+	puntos := int32(math.Round(float64(ganados) * reembolso / monto))
+	if puntos > pendientes { puntos = pendientes }
+	return puntos, reembolso
+}
+
+// AplicarReversion descuenta `puntos` de un saldo según la política del programa.
+// Devuelve el saldo y la deuda nuevos y cuántos puntos salieron del saldo.
+func AplicarReversion(saldo, deuda, puntos int32, politica string) (nuevoSaldo, nuevaDeuda, tomados int32) {
+	if politica == ReversionNegativo || puntos <= saldo {
+		tomados = puntos
+		if tomados > saldo { tomados = saldo }
+		if tomados < 0 { tomados = 0 }
+		return saldo - puntos, deuda, tomados
+	}
+	tomados = saldo
+	if tomados < 0 { tomados = 0 }
+	faltante := puntos - tomados
+	if politica == ReversionAbsorber { faltante = 0 }
+	return saldo - tomados, deuda + faltante, tomados
+}
+
+// PagarDeuda aplica los puntos de una compra a la deuda de reversiones pendiente.
+// Devuelve los puntos que quedan para el cliente y la deuda restante.
+func PagarDeuda(puntos, deuda int32) (int32, int32) {
+	if deuda <= 0 { return puntos, 0 }
+	if puntos >= deuda { return puntos - deuda, 0 }
+	return 0, deuda - puntos
+}
+
+// Referidos son los bonos del programa de referidos. Cuando un cliente nuevo se registra
+// con el código de otro y su primera compra llega a CompraMinima, el que lo refirió gana
+// PuntosReferente y el nuevo PuntosReferido. TopeMensual limita cuántos referidos se le
+// pagan a un mismo cliente por mes (0 = sin tope).
+type Referidos struct {
+	PuntosReferente int32   `json:"puntos_referente"`
+	PuntosReferido  int32   `json:"puntos_referido"`
+	CompraMinima    float64 `json:"compra_minima"`
+	TopeMensual     int     `json:"tope_mensual"`
+}
+
+// Motivos por los que un referido no paga bonos
+const (
+	RechazoInactivo      = "programa_inactivo"
+	RechazoMismoTelefono = "mismo_telefono"
+	RechazoMismoEmail    = "mismo_email"
+	RechazoCompraMinima  = "compra_minima"
+	RechazoTopeMensual   = "tope_mensual"
+)
+
+// Contacto es el teléfono y correo de una cuenta, para detectar auto-referidos
+type Contacto struct {
+	Telefono string
+	Email    string
+}
+
+// Activo indica si el programa da bonos por referidos
+func (r Referidos) Activo() bool { return r.PuntosReferente > 0 || r.PuntosReferido > 0 }
+
+// Validar revisa los bonos y límites del programa de referidos
+func (r Referidos) Validar() error {
+	if r.PuntosReferente < 0 || r.PuntosReferido < 0 || r.PuntosReferente > 100000 || r.PuntosReferido > 100000 {
+		return fmt.Errorf("puntos de referidos fuera de rango (0-100000)")
+	}
+	if r.CompraMinima < 0 || r.TopeMensual < 0 || r.TopeMensual > 1000 {
+		return fmt.Errorf("compra_minima no puede ser negativa y tope_mensual debe estar entre 0 y 1000")
+	}
+	return nil
+}
+
+// Rechazo revisa si `referido` puede ganar bonos con el código de `referente`: el
+// programa debe estar activo y no pueden compartir teléfono ni correo. Devuelve el
+// motivo del rechazo o "" si procede.
+func (r Referidos) Rechazo(referente, referido Contacto) string {
+	switch {
+	case !r.Activo():
+		return RechazoInactivo
+	case telefonoBase(referente.Telefono) != "" && telefonoBase(referente.Telefono) == telefonoBase(referido.Telefono):
+		return RechazoMismoTelefono
+	case emailBase(referente.Email) != "" && emailBase(referente.Email) == emailBase(referido.Email):
+		return RechazoMismoEmail
+	}
+	return ""
+}
+
+// RechazoCompra revisa la primera compra del referido (`total` pesos) cuando el que lo
+// refirió ya cobró `pagadosMes` referidos este mes
+func (r Referidos) RechazoCompra(total float64, pagadosMes int) string {
+	if total+1e-9 < r.CompraMinima { return RechazoCompraMinima }
+	if r.TopeMensual > 0 && pagadosMes >= r.TopeMensual { return RechazoTopeMensual }
+	return ""
+}
+
+// telefonoBase deja los últimos 10 dígitos para que +52 81... y 81... cuenten igual
+func telefonoBase(tel string) string {
+	var b strings.Builder
+	for _, c := range tel {
+		if c >= '0' && c <= '9' { b.WriteRune(c) }
+	}
+	d := b.String()
+	if len(d) > 10 { d = d[len(d)-10:] }
+	return d
+}
+
+// emailBase ignora mayúsculas y las etiquetas "+algo" del usuario
+func emailBase(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at <= 0 { return email }
+	usuario := email[:at]
+	if i := strings.Index(usuario, "+"); i >= 0 { usuario = usuario[:i] }
+	return usuario + email[at:]
+}
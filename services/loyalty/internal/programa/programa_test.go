@@ -0,0 +1,195 @@
+package programa
+
+import (
+	"testing"
+	"time"
+)
+
+var martes = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+func TestPuntos_Predeterminado(t *testing.T) {
+	p := Predeterminado()
+	if got := p.Puntos(149.99, nil, "bronze", martes); got != 149 {
+		t.Errorf("Puntos = %d, esperaba 149", got)
+	}
+	if got := p.Nivel(2000).Clave; got != "gold" {
+		t.Errorf("Nivel(2000) = %s, esperaba gold", got)
+	}
+}
+
+func TestPuntos_MultiplicadoresYExclusiones(t *testing.T) {
+	p := Predeterminado()
+	p.PuntosPorPeso = 0.1
+	p.PorCategoria = map[string]float64{"Bebidas": 2}
+	p.PorProducto = map[string]float64{"cigarros": 0, "coca-lata": 1}
+	partidas := []Partida{
+		{ProductoID: "coca-600", Categoria: "Bebidas", Subtotal: 100}, // 200 pesos ponderados
+		{ProductoID: "coca-lata", Categoria: "Bebidas", Subtotal: 50}, // el producto gana a la categoría
+		{ProductoID: "cigarros", Categoria: "Tabaco", Subtotal: 80},   // excluido
+		{ProductoID: "pan", Categoria: "Panadería", Subtotal: 20},
+	}
+	// (200 + 50 + 0 + 20) × 0.1 = 27
+	if got := p.Puntos(250, partidas, "bronze", martes); got != 27 {
+		t.Errorf("Puntos = %d, esperaba 27", got)
+	}
+	// Con 10% de descuento en el cobro las partidas se prorratean: 270 × 0.9 × 0.1 = 24.3
+	if got := p.Puntos(225, partidas, "bronze", martes); got != 24 {
+		t.Errorf("Puntos con descuento = %d, esperaba 24", got)
+	}
+}
+
+func TestPuntos_NivelPromocionYRedondeo(t *testing.T) {
+	p := Predeterminado()
+	p.Redondeo = RedondeoArriba
+	p.Niveles[2].Multiplicador = 1.5 // gold
+	p.Promociones = []Promocion{{
+		Nombre: "Doble puntos", Multiplicador: 2,
+		Desde: time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC), Hasta: time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC),
+	}}
+	// 10.10 × 1.5 × 2 = 30.3 → 31
+	if got := p.Puntos(10.10, nil, "gold", martes); got != 31 {
+		t.Errorf("Puntos = %d, esperaba 31", got)
+	}
+	if got := p.Puntos(10.10, nil, "gold", martes.AddDate(0, 0, 1)); got != 16 {
+		t.Errorf("Puntos fuera de la promoción = %d, esperaba 16", got)
+	}
+	// Un nivel que ya no existe en el programa cuenta como el inicial
+	if got := p.Puntos(10, nil, "diamante", martes); got != 20 {
+		t.Errorf("Puntos con nivel desconocido = %d, esperaba 20", got)
+	}
+}
+
+func TestValidar(t *testing.T) {
+	p := Predeterminado()
+	if err := p.Validar(); err != nil {
+		t.Fatalf("el programa predeterminado no valida: %v", err)
+	}
+	p.Niveles = []Nivel{{Clave: "vip", Nombre: "VIP", GastoMinimo: 100, Multiplicador: 1}}
+	if p.Validar() == nil {
+		t.Error("sin nivel en gasto 0 debería fallar")
+	}
+	p = Predeterminado()
+	p.Redondeo = "banquero"
+	if p.Validar() == nil {
+		t.Error("redondeo desconocido debería fallar")
+	}
+}
+
+func TestPuntosAReversar(t *testing.T) {
+	// Venta de $500 que dio 50 puntos; devolución parcial de $100
+	if p, m := PuntosAReversar(50, 0, 500, 0, 100); p != 10 || m != 100 {
+		t.Errorf("parcial = %d, %.2f; esperaba 10, 100", p, m)
+	}
+	// Cancelar después de esa devolución reversa solo lo pendiente
+	if p, m := PuntosAReversar(50, 10, 500, 100, 0); p != 40 || m != 400 {
+		t.Errorf("cancelación = %d, %.2f; esperaba 40, 400", p, m)
+	}
+	// Una devolución mayor a lo que queda se limita a lo pendiente
+	if p, m := PuntosAReversar(50, 40, 500, 400, 300); p != 10 || m != 100 {
+		t.Errorf("excedente = %d, %.2f; esperaba 10, 100", p, m)
+	}
+}
+
+func TestAplicarReversion(t *testing.T) {
+	casos := []struct {
+		politica                 string
+		saldo, deuda, puntos     int32
+		wSaldo, wDeuda, wTomados int32
+	}{
+		{ReversionDeuda, 100, 0, 30, 70, 0, 30},
+		{ReversionDeuda, 20, 5, 30, 0, 15, 20},
+		{ReversionNegativo, 20, 0, 30, -10, 0, 20},
+		{ReversionAbsorber, 20, 0, 30, 0, 0, 20},
+	}
+	for _, c := range casos {
+		s, d, tom := AplicarReversion(c.saldo, c.deuda, c.puntos, c.politica)
+		if s != c.wSaldo || d != c.wDeuda || tom != c.wTomados {
+			t.Errorf("%s(%d, %d, %d) = %d, %d, %d", c.politica, c.saldo, c.deuda, c.puntos, s, d, tom)
+		}
+	}
+	if p, d := PagarDeuda(25, 15); p != 10 || d != 0 {
+		t.Errorf("PagarDeuda = %d, %d", p, d)
+	}
+}
+
+func TestCalificar(t *testing.T) {
+	p := Predeterminado()
+	p.GraciaDias = 30
+	ahora := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Subir es inmediato
+	if n, g := p.Calificar("silver", 2500, nil, ahora); n.Clave != "gold" || g != nil {
+		t.Errorf("subida = %s, %v", n.Clave, g)
+	}
+	// Al dejar de calificar conserva el tier y empieza la gracia
+	n, g := p.Calificar("gold", 100, nil, ahora)
+	if n.Clave != "gold" || g == nil || !g.Equal(ahora.AddDate(0, 0, 30)) {
+		t.Fatalf("inicio de gracia = %s, %v", n.Clave, g)
+	}
+	// Si vuelve a calificar durante la gracia, la gracia se cancela
+	if n, g := p.Calificar("gold", 2100, g, ahora.AddDate(0, 0, 10)); n.Clave != "gold" || g != nil {
+		t.Errorf("recalifica = %s, %v", n.Clave, g)
+	}
+	// Vencida la gracia baja al tier que corresponde
+	if n, g := p.Calificar("gold", 600, g, ahora.AddDate(0, 0, 31)); n.Clave != "silver" || g != nil {
+		t.Errorf("bajada = %s, %v", n.Clave, g)
+	}
+	// Sin gracia baja de inmediato
+	p.GraciaDias = 0
+	if n, _ := p.Calificar("platinum", 0, nil, ahora); n.Clave != "bronze" {
+		t.Errorf("sin gracia = %s", n.Clave)
+	}
+}
+
+func TestInicioVentana(t *testing.T) {
+	p := Predeterminado()
+	ahora := time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)
+	if !p.InicioVentana(ahora).IsZero() {
+		t.Error("sin ventana debería contar todo el historial")
+	}
+	p.VentanaMeses = 12
+	if got := p.InicioVentana(ahora); !got.Equal(time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("InicioVentana = %v", got)
+	}
+}
+
+func TestReferidosRechazo(t *testing.T) {
+	r := Referidos{PuntosReferente: 200, PuntosReferido: 100, CompraMinima: 300, TopeMensual: 5}
+	ana := Contacto{Telefono: "8112345678", Email: "ana@correo.mx"}
+	casos := []struct {
+		nombre   string
+		referido Contacto
+		want     string
+	}{
+		{"otro cliente", Contacto{Telefono: "8187654321", Email: "beto@correo.mx"}, ""},
+		{"mismo teléfono con lada", Contacto{Telefono: "+52 81 1234 5678"}, RechazoMismoTelefono},
+		{"mismo correo en mayúsculas", Contacto{Telefono: "8100000000", Email: "ANA@correo.mx"}, RechazoMismoEmail},
+		{"mismo correo con etiqueta", Contacto{Telefono: "8100000000", Email: "ana+2@correo.mx"}, RechazoMismoEmail},
+		{"sin correo", Contacto{Telefono: "8100000000"}, ""},
+	}
+	for _, c := range casos {
+		if got := r.Rechazo(ana, c.referido); got != c.want {
+			t.Errorf("%s: Rechazo = %q, want %q", c.nombre, got, c.want)
+		}
+	}
+	if got := (Referidos{}).Rechazo(ana, casos[0].referido); got != RechazoInactivo {
+		t.Errorf("programa inactivo: Rechazo = %q", got)
+	}
+}
+
+func TestReferidosRechazoCompra(t *testing.T) {
+	r := Referidos{PuntosReferente: 200, CompraMinima: 300, TopeMensual: 2}
+	if got := r.RechazoCompra(299.99, 0); got != RechazoCompraMinima {
+		t.Errorf("compra menor: %q", got)
+	}
+	if got := r.RechazoCompra(300, 1); got != "" {
+		t.Errorf("compra exacta: %q", got)
+	}
+	if got := r.RechazoCompra(500, 2); got != RechazoTopeMensual {
+		t.Errorf("tope: %q", got)
+	}
+	r.TopeMensual = 0
+	if got := r.RechazoCompra(500, 50); got != "" {
+		t.Errorf("sin tope: %q", got)
+	}
+}
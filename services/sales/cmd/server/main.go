@@ -3,38 +3,69 @@
 import (
     "context"
     "database/sql"
+    "errors"
     "fmt"
     "log"
+    "math"
     "net"
     "os"
     "time"
 
     _ "github.com/lib/pq"
     salesv1 "github.com/turbopos/turbopos/gen/go/proto/sales/v1"
+    "github.com/turbopos/turbopos/services/sales/internal/credito"
     "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
     "google.golang.org/grpc/reflection"
+    "google.golang.org/grpc/status"
 )
 
+// metodoCredito es el payment_method de las ventas a crédito (fiado)
+const metodoCredito = "credit"
+
+// zonaTienda es la zona horaria con la que vencen los cargos a crédito
+var zonaTienda = func() *time.Location {
+    if loc, err := time.LoadLocation("America/Monterrey"); err == nil {
+        return loc
+    }
+    return time.UTC
+}()
+
+const insertarVenta = `INSERT INTO sales (cashier_id, total, payment_method, tenant_id, customer_id)
+         VALUES ($1::uuid, $2, $3, NULLIF($4,'')::uuid, NULLIF($5,'')::uuid)
+         RETURNING id`
+
 type server struct {
     salesv1.UnimplementedSalesServiceServer
     db *sql.DB
 }
 
+// consultor es lo que comparten *sql.DB y *sql.Tx
+type consultor interface {
+    QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+    QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+    ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
 func (s *server) CreateSale(ctx context.Context, req *salesv1.CreateSaleRequest) (*salesv1.CreateSaleResponse, error) {
     if s.db == nil {
         return nil, fmt.Errorf("db no disponible")
     }
 
-    // Insertar la venta principal
+    // Insertar la venta principal. A crédito, la venta y su cargo se registran juntos
     var saleID string
-    err := s.db.QueryRowContext(ctx,
-        `INSERT INTO sales (cashier_id, total, payment_method)
-         VALUES ($1::uuid, $2, $3)
-         RETURNING id`,
-        req.GetCashierId(), req.GetTotal(), req.GetPaymentMethod(),
-    ).Scan(&saleID)
-    if err != nil {
-        return nil, fmt.Errorf("crear venta: %w", err)
+    var err error
+    if req.GetPaymentMethod() == metodoCredito {
+        if saleID, err = s.ventaACredito(ctx, req); err != nil {
+            return nil, err
+        }
+    } else {
+        err = s.db.QueryRowContext(ctx, insertarVenta,
+            req.GetCashierId(), req.GetTotal(), req.GetPaymentMethod(), req.GetTenantId(), req.GetCustomerId(),
+        ).Scan(&saleID)
+        if err != nil {
+            return nil, fmt.Errorf("crear venta: %w", err)
+        }
     }
 
     // Insertar cada item
@@ -153,19 +184,35 @@ func (s *server) CancelSale(ctx context.Context, req *salesv1.CancelSaleRequest)
         return nil, fmt.Errorf("db no disponible")
     }
 
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, fmt.Errorf("cancelar venta: %w", err)
+    }
+    defer tx.Rollback()
+
     now := time.Now()
-    result, err := s.db.ExecContext(ctx,
+    var metodo string
+    err = tx.QueryRowContext(ctx,
         `UPDATE sales SET status = 'cancelled', cancelled_at = $1, cancel_reason = $2
-          WHERE id = $3::uuid AND status != 'cancelled'`,
+          WHERE id = $3::uuid AND status != 'cancelled'
+          RETURNING payment_method`,
         now, req.GetReason(), req.GetSaleId(),
-    )
+    ).Scan(&metodo)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("venta no encontrada o ya cancelada: %s", req.GetSaleId())
+    }
     if err != nil {
         return nil, fmt.Errorf("cancelar venta: %w", err)
     }
 
-    rows, _ := result.RowsAffected()
-    if rows == 0 {
-        return nil, fmt.Errorf("venta no encontrada o ya cancelada: %s", req.GetSaleId())
+    // Una venta a crédito deja de deberse
+    if metodo == metodoCredito {
+        if err := cancelarCargo(ctx, tx, req.GetSaleId()); err != nil {
+            return nil, fmt.Errorf("cancelar cargo a crédito: %w", err)
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        return nil, fmt.Errorf("cancelar venta: %w", err)
     }
 
     log.Printf("✓ Venta cancelada: %s — Motivo: %s", req.GetSaleId(), req.GetReason())
@@ -176,3 +223,445 @@ func (s *server) CancelSale(ctx context.Context, req *salesv1.CancelSaleRequest)
         CancelledAt: now.Format(time.RFC3339),
     }, nil
 }
+
+// ── Crédito (fiado) ───────────────────────────────────────────────────────────
+
+// selectCuentas lee las cuentas de crédito de una tienda con los datos del cliente
+const selectCuentas = `SELECT c.account_id, COALESCE(a.name,''), a.phone, COALESCE(a.email,''),
+           c.limite, c.saldo, c.dias_plazo, c.dias_tolerancia, c.bloqueado, COALESCE(c.notas,'')
+      FROM creditos_clientes c JOIN loyalty_accounts a ON a.id = c.account_id
+     WHERE c.tenant_id = $1::uuid`
+
+type scanner interface {
+    Scan(dest ...interface{}) error
+}
+
+func escanearCuenta(row scanner) (*salesv1.CreditAccount, credito.Cuenta, error) {
+    c := &salesv1.CreditAccount{}
+    var cuenta credito.Cuenta
+    err := row.Scan(&c.CustomerId, &c.CustomerName, &c.CustomerPhone, &c.CustomerEmail,
+        &cuenta.Limite, &cuenta.Saldo, &c.TermDays, &cuenta.DiasTolerancia, &cuenta.Bloqueada, &c.Notes)
+    return c, cuenta, err
+}
+
+// armarCuenta completa una cuenta con su antigüedad de saldos y su estado
+func armarCuenta(c *salesv1.CreditAccount, cuenta credito.Cuenta, cargos []credito.Cargo, hoy time.Time) {
+    ant := credito.CalcularAntiguedad(cargos, hoy)
+    c.Limit, c.Balance, c.GraceDays, c.Blocked = cuenta.Limite, cuenta.Saldo, int32(cuenta.DiasTolerancia), cuenta.Bloqueada
+    c.Available = math.Max(credito.Redondear(cuenta.Limite-cuenta.Saldo), 0)
+    c.Aging = &salesv1.CreditAging{Current: ant.Corriente, Days_1_30: ant.D1a30, Days_31_60: ant.D31a60,
+        Days_61_90: ant.D61a90, Over_90: ant.Mas90}
+    c.Overdue = ant.Vencido()
+    c.Status = "al_corriente"
+    if c.Overdue > 0 {
+        c.Status = "vencido"
+    }
+    // Bloqueada a mano o por un atraso mayor a la tolerancia: no se le vende a crédito
+    if err := credito.Autorizar(cuenta, cargos, 0, hoy); errors.Is(err, credito.ErrBloqueada) || errors.Is(err, credito.ErrAtraso) {
+        c.Status = "bloqueado"
+    }
+}
+
+// cuentaCredito lee las condiciones de crédito del cliente. Con bloquear, la fila queda
+// tomada hasta el fin de la transacción para que dos cajas no rebasen juntas el límite.
+func cuentaCredito(ctx context.Context, q consultor, tenantID, clienteID string, bloquear bool) (credito.Cuenta, int, error) {
+    query := `SELECT limite, saldo, dias_plazo, dias_tolerancia, bloqueado
+                FROM creditos_clientes WHERE tenant_id = $1::uuid AND account_id = $2::uuid`
+    if bloquear {
+        query += ` FOR UPDATE`
+    }
+    var c credito.Cuenta
+    var plazo int
+    err := q.QueryRowContext(ctx, query, tenantID, clienteID).Scan(&c.Limite, &c.Saldo, &plazo, &c.DiasTolerancia, &c.Bloqueada)
+    return c, plazo, err
+}
+
+// cargosPendientes son las ventas a crédito del cliente que aún no se terminan de pagar
+func cargosPendientes(ctx context.Context, q consultor, tenantID, clienteID string) ([]credito.Cargo, error) {
+    rows, err := q.QueryContext(ctx,
+        `SELECT id, created_at, vence_at, monto, pendiente FROM credito_movimientos
+          WHERE tenant_id = $1::uuid AND account_id = $2::uuid AND tipo = 'cargo' AND pendiente > 0
+          ORDER BY created_at`, tenantID, clienteID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var cargos []credito.Cargo
+    for rows.Next() {
+        var c credito.Cargo
+        if err := rows.Scan(&c.ID, &c.Fecha, &c.Vence, &c.Monto, &c.Pendiente); err != nil {
+            return nil, err
+        }
+        cargos = append(cargos, c)
+    }
+    return cargos, rows.Err()
+}
+
+// aplicarPago liquida cargos pendientes con `monto`, del más antiguo al más reciente, y
+// devuelve a qué ventas se aplicó
+func aplicarPago(ctx context.Context, tx *sql.Tx, cargos []credito.Cargo, monto float64) ([]*salesv1.CreditApplication, error) {
+    aplicaciones, _ := credito.Aplicar(cargos, monto)
+    var resp []*salesv1.CreditApplication
+    for _, a := range aplicaciones {
+        var saleID string
+        err := tx.QueryRowContext(ctx,
+            `UPDATE credito_movimientos SET pendiente = GREATEST(pendiente - $2, 0) WHERE id = $1::uuid
+             RETURNING COALESCE(sale_id::text,'')`, a.CargoID, a.Monto).Scan(&saleID)
+        if err != nil {
+            return nil, err
+        }
+        resp = append(resp, &salesv1.CreditApplication{SaleId: saleID, Amount: a.Monto})
+    }
+    return resp, nil
+}
+
+func actualizarSaldo(ctx context.Context, tx *sql.Tx, tenantID, clienteID string, saldo float64) error {
+    _, err := tx.ExecContext(ctx,
+        `UPDATE creditos_clientes SET saldo = $3, updated_at = NOW() WHERE tenant_id = $1::uuid AND account_id = $2::uuid`,
+        tenantID, clienteID, saldo)
+    return err
+}
+
+// ventaACredito registra la venta y el cargo a la cuenta del cliente en una sola
+// transacción. Si la cuenta no autoriza el monto, la venta no se registra.
+func (s *server) ventaACredito(ctx context.Context, req *salesv1.CreateSaleRequest) (string, error) {
+    if req.GetTenantId() == "" || req.GetCustomerId() == "" {
+        return "", status.Error(codes.InvalidArgument, "la venta a crédito requiere tenant y cliente")
+    }
+    if req.GetTotal() <= 0 {
+        return "", status.Error(codes.InvalidArgument, "el total de una venta a crédito debe ser mayor a cero")
+    }
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return "", fmt.Errorf("crear venta: %w", err)
+    }
+    defer tx.Rollback()
+
+    cuenta, plazo, err := cuentaCredito(ctx, tx, req.GetTenantId(), req.GetCustomerId(), true)
+    if err == sql.ErrNoRows {
+        return "", status.Error(codes.FailedPrecondition, "el cliente no tiene crédito autorizado")
+    }
+    if err != nil {
+        return "", fmt.Errorf("cuenta de crédito: %w", err)
+    }
+    cargos, err := cargosPendientes(ctx, tx, req.GetTenantId(), req.GetCustomerId())
+    if err != nil {
+        return "", fmt.Errorf("cargos pendientes: %w", err)
+    }
+    ahora := time.Now().In(zonaTienda)
+    if err := credito.Autorizar(cuenta, cargos, req.GetTotal(), ahora); err != nil {
+        return "", status.Error(codes.FailedPrecondition, err.Error())
+    }
+
+    var saleID string
+    err = tx.QueryRowContext(ctx, insertarVenta,
+        req.GetCashierId(), req.GetTotal(), req.GetPaymentMethod(), req.GetTenantId(), req.GetCustomerId(),
+    ).Scan(&saleID)
+    if err != nil {
+        return "", fmt.Errorf("crear venta: %w", err)
+    }
+    // Un saldo a favor paga primero el cargo nuevo
+    monto := credito.Redondear(req.GetTotal())
+    saldo := credito.Redondear(cuenta.Saldo + monto)
+    pendiente := math.Max(math.Min(monto, saldo), 0)
+    _, err = tx.ExecContext(ctx,
+        `INSERT INTO credito_movimientos (tenant_id, account_id, tipo, monto, sale_id, saldo_despues, vence_at, pendiente)
+         VALUES ($1::uuid, $2::uuid, 'cargo', $3, $4::uuid, $5, $6::date, $7)`,
+        req.GetTenantId(), req.GetCustomerId(), monto, saleID, saldo,
+        credito.Vencimiento(ahora, plazo).Format("2006-01-02"), pendiente)
+    if err != nil {
+        return "", fmt.Errorf("cargo a crédito: %w", err)
+    }
+    if err := actualizarSaldo(ctx, tx, req.GetTenantId(), req.GetCustomerId(), saldo); err != nil {
+        return "", fmt.Errorf("saldo de crédito: %w", err)
+    }
+    if err := tx.Commit(); err != nil {
+        return "", fmt.Errorf("crear venta: %w", err)
+    }
+
+    log.Printf("✓ Cargo a crédito: venta %s · cliente %s · saldo $%.2f", saleID, req.GetCustomerId(), saldo)
+    return saleID, nil
+}
+
+// cancelarCargo quita de la cuenta el cargo de una venta cancelada. Lo que ya se había
+// abonado a ese cargo se aplica a los demás pendientes o queda como saldo a favor.
+func cancelarCargo(ctx context.Context, tx *sql.Tx, saleID string) error {
+    var tenantID, clienteID string
+    err := tx.QueryRowContext(ctx,
+        `SELECT tenant_id, account_id FROM credito_movimientos WHERE sale_id = $1::uuid AND tipo = 'cargo'`,
+        saleID).Scan(&tenantID, &clienteID)
+    if err == sql.ErrNoRows {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    cuenta, _, err := cuentaCredito(ctx, tx, tenantID, clienteID, true)
+    if err != nil {
+        return err
+    }
+
+    var cargoID string
+    var monto, pendiente float64
+    err = tx.QueryRowContext(ctx,
+        `SELECT id, monto, pendiente FROM credito_movimientos WHERE sale_id = $1::uuid AND tipo = 'cargo'`,
+        saleID).Scan(&cargoID, &monto, &pendiente)
+    if err != nil {
+        return err
+    }
+    if _, err := tx.ExecContext(ctx, `UPDATE credito_movimientos SET pendiente = 0 WHERE id = $1::uuid`, cargoID); err != nil {
+        return err
+    }
+    saldo := credito.Redondear(cuenta.Saldo - monto)
+    _, err = tx.ExecContext(ctx,
+        `INSERT INTO credito_movimientos (tenant_id, account_id, tipo, monto, sale_id, saldo_despues)
+         VALUES ($1::uuid, $2::uuid, 'cancelacion', $3, $4::uuid, $5)`,
+        tenantID, clienteID, monto, saleID, saldo)
+    if err != nil {
+        return err
+    }
+    if pagado := credito.Redondear(monto - pendiente); pagado > 0 {
+        cargos, err := cargosPendientes(ctx, tx, tenantID, clienteID)
+        if err != nil {
+            return err
+        }
+        if _, err := aplicarPago(ctx, tx, cargos, pagado); err != nil {
+            return err
+        }
+    }
+    return actualizarSaldo(ctx, tx, tenantID, clienteID, saldo)
+}
+
+// cuentaCliente es la cuenta de crédito del cliente con su antigüedad de saldos al día de hoy
+func (s *server) cuentaCliente(ctx context.Context, tenantID, clienteID string) (*salesv1.CreditAccount, error) {
+    c, cuenta, err := escanearCuenta(s.db.QueryRowContext(ctx, selectCuentas+` AND c.account_id = $2::uuid`, tenantID, clienteID))
+    if err == sql.ErrNoRows {
+        return nil, status.Error(codes.NotFound, "el cliente no tiene cuenta de crédito")
+    }
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "cuenta de crédito: %v", err)
+    }
+    cargos, err := cargosPendientes(ctx, s.db, tenantID, clienteID)
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "cargos pendientes: %v", err)
+    }
+    armarCuenta(c, cuenta, cargos, time.Now().In(zonaTienda))
+    return c, nil
+}
+
+// UpsertCreditAccount abre o actualiza el crédito de un cliente. Un plazo nuevo aplica a
+// los cargos que vienen; los que ya existen conservan su vencimiento.
+func (s *server) UpsertCreditAccount(ctx context.Context, req *salesv1.UpsertCreditAccountRequest) (*salesv1.CreditAccount, error) {
+    if s.db == nil {
+        return nil, fmt.Errorf("db no disponible")
+    }
+    if req.GetTenantId() == "" || req.GetCustomerId() == "" {
+        return nil, status.Error(codes.InvalidArgument, "tenant_id y customer_id son obligatorios")
+    }
+    if req.GetLimit() < 0 || req.GetTermDays() < 0 || req.GetGraceDays() < 0 {
+        return nil, status.Error(codes.InvalidArgument, "el límite y los días no pueden ser negativos")
+    }
+    plazo := req.GetTermDays()
+    if plazo == 0 {
+        plazo = 30
+    }
+    _, err := s.db.ExecContext(ctx,
+        `INSERT INTO creditos_clientes (tenant_id, account_id, limite, dias_plazo, dias_tolerancia, bloqueado, notas)
+         VALUES ($1::uuid, $2::uuid, $3, $4, $5, $6, NULLIF($7,''))
+         ON CONFLICT (tenant_id, account_id) DO UPDATE SET
+           limite = EXCLUDED.limite, dias_plazo = EXCLUDED.dias_plazo, dias_tolerancia = EXCLUDED.dias_tolerancia,
+           bloqueado = EXCLUDED.bloqueado, notas = EXCLUDED.notas, updated_at = NOW()`,
+        req.GetTenantId(), req.GetCustomerId(), credito.Redondear(req.GetLimit()), plazo, req.GetGraceDays(),
+        req.GetBlocked(), req.GetNotes())
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "guardar cuenta de crédito: %v", err)
+    }
+
+    log.Printf("✓ Crédito cliente %s: límite $%.2f · plazo %d días · bloqueado=%v", req.GetCustomerId(), req.GetLimit(), plazo, req.GetBlocked())
+    return s.cuentaCliente(ctx, req.GetTenantId(), req.GetCustomerId())
+}
+
+// ListCreditAccounts lista las cuentas de crédito de la tienda, las que más deben primero
+func (s *server) ListCreditAccounts(ctx context.Context, req *salesv1.ListCreditAccountsRequest) (*salesv1.ListCreditAccountsResponse, error) {
+    if s.db == nil {
+        return nil, fmt.Errorf("db no disponible")
+    }
+    rows, err := s.db.QueryContext(ctx, selectCuentas+` ORDER BY c.saldo DESC, a.name`, req.GetTenantId())
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "cuentas de crédito: %v", err)
+    }
+    var cuentas []*salesv1.CreditAccount
+    condiciones := map[string]credito.Cuenta{}
+    for rows.Next() {
+        c, cuenta, err := escanearCuenta(rows)
+        if err != nil {
+            rows.Close()
+            return nil, status.Errorf(codes.Internal, "cuentas de crédito: %v", err)
+        }
+        cuentas = append(cuentas, c)
+        condiciones[c.CustomerId] = cuenta
+    }
+    rows.Close()
+
+    // Los cargos pendientes de toda la tienda en una sola consulta
+    crows, err := s.db.QueryContext(ctx,
+        `SELECT account_id, id, created_at, vence_at, monto, pendiente FROM credito_movimientos
+          WHERE tenant_id = $1::uuid AND tipo = 'cargo' AND pendiente > 0`, req.GetTenantId())
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "cargos pendientes: %v", err)
+    }
+    cargos := map[string][]credito.Cargo{}
+    for crows.Next() {
+        var clienteID string
+        var c credito.Cargo
+        if crows.Scan(&clienteID, &c.ID, &c.Fecha, &c.Vence, &c.Monto, &c.Pendiente) == nil {
+            cargos[clienteID] = append(cargos[clienteID], c)
+        }
+    }
+    crows.Close()
+
+    hoy := time.Now().In(zonaTienda)
+    resp := &salesv1.ListCreditAccountsResponse{}
+    for _, c := range cuentas {
+        armarCuenta(c, condiciones[c.CustomerId], cargos[c.CustomerId], hoy)
+        if req.GetOverdueOnly() && c.Overdue == 0 {
+            continue
+        }
+        resp.Accounts = append(resp.Accounts, c)
+    }
+    return resp, nil
+}
+
+// GetCreditStatement arma el estado de cuenta del cliente para un periodo: saldo inicial,
+// movimientos, totales y la antigüedad de saldos al día de hoy
+func (s *server) GetCreditStatement(ctx context.Context, req *salesv1.CreditStatementRequest) (*salesv1.CreditStatement, error) {
+    if s.db == nil {
+        return nil, fmt.Errorf("db no disponible")
+    }
+    for _, f := range []string{req.GetFrom(), req.GetTo()} {
+        if _, err := time.Parse("2006-01-02", f); f != "" && err != nil {
+            return nil, status.Errorf(codes.InvalidArgument, "fecha inválida %q, se espera AAAA-MM-DD", f)
+        }
+    }
+    cuenta, err := s.cuentaCliente(ctx, req.GetTenantId(), req.GetCustomerId())
+    if err != nil {
+        return nil, err
+    }
+    resp := &salesv1.CreditStatement{Account: cuenta, From: req.GetFrom(), To: req.GetTo()}
+    if resp.To == "" {
+        resp.To = time.Now().In(zonaTienda).Format("2006-01-02")
+    }
+
+    // El saldo inicial es el que dejó el último movimiento antes del periodo
+    if resp.From != "" {
+        err := s.db.QueryRowContext(ctx,
+            `SELECT saldo_despues FROM credito_movimientos
+              WHERE tenant_id = $1::uuid AND account_id = $2::uuid
+                AND (created_at AT TIME ZONE 'America/Monterrey')::date < $3::date
+              ORDER BY created_at DESC LIMIT 1`,
+            req.GetTenantId(), req.GetCustomerId(), resp.From).Scan(&resp.OpeningBalance)
+        if err != nil && err != sql.ErrNoRows {
+            return nil, status.Errorf(codes.Internal, "saldo inicial: %v", err)
+        }
+    }
+    resp.ClosingBalance = resp.OpeningBalance
+
+    rows, err := s.db.QueryContext(ctx,
+        `SELECT id, tipo, monto, COALESCE(sale_id::text,''), COALESCE(metodo_pago,''), COALESCE(referencia,''),
+                saldo_despues, COALESCE(to_char(vence_at, 'YYYY-MM-DD'),''), pendiente, created_at
+           FROM credito_movimientos
+          WHERE tenant_id = $1::uuid AND account_id = $2::uuid
+            AND (NULLIF($3,'')::date IS NULL OR (created_at AT TIME ZONE 'America/Monterrey')::date >= NULLIF($3,'')::date)
+            AND (created_at AT TIME ZONE 'America/Monterrey')::date <= $4::date
+          ORDER BY created_at`,
+        req.GetTenantId(), req.GetCustomerId(), resp.From, resp.To)
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "movimientos: %v", err)
+    }
+    defer rows.Close()
+    for rows.Next() {
+        m := &salesv1.CreditMovement{}
+        var fecha time.Time
+        if err := rows.Scan(&m.Id, &m.Type, &m.Amount, &m.SaleId, &m.PaymentMethod, &m.Reference,
+            &m.BalanceAfter, &m.DueDate, &m.Pending, &fecha); err != nil {
+            return nil, status.Errorf(codes.Internal, "movimientos: %v", err)
+        }
+        m.CreatedAt = fecha.In(zonaTienda).Format(time.RFC3339)
+        switch m.Type {
+        case "cargo":
+            resp.Charges += m.Amount
+        case "abono":
+            resp.Payments += m.Amount
+        case "cancelacion":
+            resp.Cancellations += m.Amount
+        }
+        resp.ClosingBalance = m.BalanceAfter
+        resp.Movements = append(resp.Movements, m)
+    }
+    resp.Charges = credito.Redondear(resp.Charges)
+    resp.Payments = credito.Redondear(resp.Payments)
+    resp.Cancellations = credito.Redondear(resp.Cancellations)
+    return resp, nil
+}
+
+// RegisterCreditPayment registra un abono a la cuenta del cliente. Se aplica a los cargos
+// más antiguos primero; lo que exceda el saldo queda a favor del cliente.
+func (s *server) RegisterCreditPayment(ctx context.Context, req *salesv1.CreditPaymentRequest) (*salesv1.CreditPaymentResponse, error) {
+    if s.db == nil {
+        return nil, fmt.Errorf("db no disponible")
+    }
+    if req.GetTenantId() == "" || req.GetCustomerId() == "" {
+        return nil, status.Error(codes.InvalidArgument, "tenant_id y customer_id son obligatorios")
+    }
+    monto := credito.Redondear(req.GetAmount())
+    if monto <= 0 {
+        return nil, status.Error(codes.InvalidArgument, "el abono debe ser mayor a cero")
+    }
+    metodo := req.GetPaymentMethod()
+    if metodo == "" {
+        metodo = "cash"
+    }
+    if metodo == metodoCredito {
+        return nil, status.Error(codes.InvalidArgument, "un abono no se puede pagar a crédito")
+    }
+
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "abono: %v", err)
+    }
+    defer tx.Rollback()
+    cuenta, _, err := cuentaCredito(ctx, tx, req.GetTenantId(), req.GetCustomerId(), true)
+    if err == sql.ErrNoRows {
+        return nil, status.Error(codes.NotFound, "el cliente no tiene cuenta de crédito")
+    }
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "cuenta de crédito: %v", err)
+    }
+    cargos, err := cargosPendientes(ctx, tx, req.GetTenantId(), req.GetCustomerId())
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "cargos pendientes: %v", err)
+    }
+    resp := &salesv1.CreditPaymentResponse{Balance: credito.Redondear(cuenta.Saldo - monto)}
+    if resp.Applications, err = aplicarPago(ctx, tx, cargos, monto); err != nil {
+        return nil, status.Errorf(codes.Internal, "aplicar abono: %v", err)
+    }
+    err = tx.QueryRowContext(ctx,
+        `INSERT INTO credito_movimientos (tenant_id, account_id, tipo, monto, metodo_pago, usuario, referencia, saldo_despues)
+         VALUES ($1::uuid, $2::uuid, 'abono', $3, $4, NULLIF($5,''), NULLIF($6,''), $7)
+         RETURNING id`,
+        req.GetTenantId(), req.GetCustomerId(), monto, metodo, req.GetUser(), req.GetReference(), resp.Balance,
+    ).Scan(&resp.MovementId)
+    if err != nil {
+        return nil, status.Errorf(codes.Internal, "registrar abono: %v", err)
+    }
+    if err := actualizarSaldo(ctx, tx, req.GetTenantId(), req.GetCustomerId(), resp.Balance); err != nil {
+        return nil, status.Errorf(codes.Internal, "saldo de crédito: %v", err)
+    }
+    if err := tx.Commit(); err != nil {
+        return nil, status.Errorf(codes.Internal, "abono: %v", err)
+    }
+    resp.CreditInFavor = math.Max(-resp.Balance, 0)
+
+    log.Printf("✓ Abono a crédito: cliente %s · $%.2f %s · saldo $%.2f", req.GetCustomerId(), monto, metodo, resp.Balance)
+    return resp, nil
+}
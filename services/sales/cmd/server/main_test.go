@@ -53,3 +53,27 @@ func TestGetenv_Sales_Fallback(t *testing.T) {
         t.Errorf("esperaba 'default_sales', got '%s'", val)
     }
 }
+
+func TestRegisterCreditPayment_Con_DB_Nil(t *testing.T) {
+    s := &server{db: nil}
+    req := &salesv1.CreditPaymentRequest{
+        TenantId:   "00000000-0000-0000-0000-000000000001",
+        CustomerId: "71817a09-8616-4681-b300-b0ddc0995269",
+        Amount:     150,
+    }
+    _, err := s.RegisterCreditPayment(context.Background(), req)
+    if err == nil {
+        t.Error("esperaba error por DB nil")
+    }
+    t.Logf("Error esperado: %v", err)
+}
+
+func TestGetCreditStatement_Con_DB_Nil(t *testing.T) {
+    s := &server{db: nil}
+    req := &salesv1.CreditStatementRequest{CustomerId: "71817a09-8616-4681-b300-b0ddc0995269"}
+    _, err := s.GetCreditStatement(context.Background(), req)
+    if err == nil {
+        t.Error("esperaba error por DB nil")
+    }
+    t.Logf("Error esperado: %v", err)
+}
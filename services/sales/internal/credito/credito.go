@@ -0,0 +1,138 @@
+// Package credito lleva las cuentas de crédito (fiado) de los clientes. Cada venta a
+// crédito es un cargo que vence a los días de plazo de la cuenta; los abonos liquidan
+// primero los cargos más antiguos. De los cargos pendientes salen la antigüedad de
+// saldos y el bloqueo por atraso.
+package credito
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Motivos por los que no se autoriza una venta a crédito
+var (
+	ErrBloqueada = errors.New("la cuenta de crédito está bloqueada")
+	ErrAtraso    = errors.New("el cliente tiene cargos vencidos")
+	ErrLimite    = errors.New("la venta excede el crédito disponible")
+)
+
+// Cargo es una venta a crédito y lo que falta por pagar de ella
+type Cargo struct {
+	ID        string
+	Fecha     time.Time
+	Vence     time.Time
+	Monto     float64
+	Pendiente float64
+}
+
+// Aplicacion es la parte de un abono que liquida un cargo
+type Aplicacion struct {
+	CargoID string
+	Monto   float64
+}
+
+// Cuenta son las condiciones de crédito de un cliente. DiasTolerancia son los días de
+// atraso que se permiten antes de bloquear nuevas ventas a crédito.
+type Cuenta struct {
+	Limite         float64
+	Saldo          float64
+	DiasTolerancia int
+	Bloqueada      bool
+}
+
+// Antiguedad reparte el saldo pendiente según los días de atraso de cada cargo
+type Antiguedad struct {
+	Corriente float64 `json:"corriente"` // aún no vence
+	D1a30     float64 `json:"dias_1_30"`
+	D31a60    float64 `json:"dias_31_60"`
+	D61a90    float64 `json:"dias_61_90"`
+	Mas90     float64 `json:"mas_90"`
+}
+
+// Vencido es todo lo que ya pasó de su fecha de vencimiento
+func (a Antiguedad) Vencido() float64 {
+	return Redondear(a.D1a30 + a.D31a60 + a.D61a90 + a.Mas90)
+}
+
+// Redondear deja un monto en centavos
+func Redondear(monto float64) float64 { return math.Round(monto*100) / 100 }
+
+// Vencimiento es la fecha en que vence un cargo hecho en `fecha` con `diasPlazo`
+func Vencimiento(fecha time.Time, diasPlazo int) time.Time {
+	return dia(fecha).AddDate(0, 0, diasPlazo)
+}
+
+// DiasAtraso son los días naturales entre el vencimiento y hoy (0 si no ha vencido)
+func DiasAtraso(vence, hoy time.Time) int {
+	d := int(dia(hoy).Sub(dia(vence)).Hours() / 24)
+	if d < 0 { return 0 }
+	return d
+}
+
+// dia quita la hora para comparar fechas en la zona en que vienen
+func dia(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// ordenar deja los cargos del más antiguo al más reciente
+func ordenar(cargos []Cargo) []Cargo {
+	c := append([]Cargo(nil), cargos...)
+	sort.SliceStable(c, func(i, j int) bool { return c[i].Fecha.Before(c[j].Fecha) })
+	return c
+}
+
+// Aplicar reparte un abono entre los cargos pendientes, el más antiguo primero.
+// Devuelve lo que se aplicó a cada cargo y lo que sobra como saldo a favor.
+func Aplicar(cargos []Cargo, monto float64) ([]Aplicacion, float64) {
+	restante := Redondear(monto)
+	var aplicaciones []Aplicacion
+	for _, c := range ordenar(cargos) {
+		if restante <= 0 { break }
+		if c.Pendiente <= 0 { continue }
+		parte := math.Min(c.Pendiente, restante)
+		aplicaciones = append(aplicaciones, Aplicacion{CargoID: c.ID, Monto: Redondear(parte)})
+		restante = Redondear(restante - parte)
+	}
+	return aplicaciones, restante
+}
+
+// CalcularAntiguedad agrupa lo pendiente de los cargos por días de atraso al día `hoy`
+func CalcularAntiguedad(cargos []Cargo, hoy time.Time) Antiguedad {
+	var a Antiguedad
+	for _, c := range cargos {
+		if c.Pendiente <= 0 { continue }
+		switch d := DiasAtraso(c.Vence, hoy); {
+		case d == 0:
+			a.Corriente += c.Pendiente
+		case d <= 30:
+			a.D1a30 += c.Pendiente
+		case d <= 60:
+			a.D31a60 += c.Pendiente
+		case d <= 90:
+			a.D61a90 += c.Pendiente
+		default:
+			a.Mas90 += c.Pendiente
+		}
+	}
+	a.Corriente, a.D1a30, a.D31a60 = Redondear(a.Corriente), Redondear(a.D1a30), Redondear(a.D31a60)
+	a.D61a90, a.Mas90 = Redondear(a.D61a90), Redondear(a.Mas90)
+	return a
+}
+
+// Autorizar revisa si la cuenta puede cargar `monto` hoy: que no esté bloqueada, que
+// ningún cargo lleve más de DiasTolerancia días vencido y que no pase del límite.
+func Autorizar(c Cuenta, cargos []Cargo, monto float64, hoy time.Time) error {
+	if c.Bloqueada { return ErrBloqueada }
+	for _, cg := range cargos {
+		if cg.Pendiente > 0 && DiasAtraso(cg.Vence, hoy) > c.DiasTolerancia {
+			return fmt.Errorf("%w desde el %s", ErrAtraso, cg.Vence.Format("2006-01-02"))
+		}
+	}
+	if disponible := Redondear(c.Limite - c.Saldo); Redondear(monto) > disponible {
+		return fmt.Errorf("%w ($%.2f)", ErrLimite, math.Max(disponible, 0))
+	}
+	return nil
+}
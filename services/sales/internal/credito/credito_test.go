@@ -0,0 +1,81 @@
+package credito
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func fecha(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func cargos() []Cargo {
+	return []Cargo{
+		{ID: "marzo", Fecha: fecha("2026-03-01"), Vence: fecha("2026-03-31"), Monto: 300, Pendiente: 300},
+		{ID: "enero", Fecha: fecha("2026-01-10"), Vence: fecha("2026-02-09"), Monto: 200, Pendiente: 50},
+		{ID: "abril", Fecha: fecha("2026-04-20"), Vence: fecha("2026-05-20"), Monto: 100, Pendiente: 100},
+		{ID: "pagado", Fecha: fecha("2026-01-01"), Vence: fecha("2026-01-31"), Monto: 80, Pendiente: 0},
+	}
+}
+
+func TestAplicar_FIFO(t *testing.T) {
+	got, sobra := Aplicar(cargos(), 400)
+	want := []Aplicacion{{"enero", 50}, {"marzo", 300}, {"abril", 50}}
+	if !reflect.DeepEqual(got, want) || sobra != 0 {
+		t.Errorf("Aplicar = %v, sobra %v", got, sobra)
+	}
+}
+
+func TestAplicar_SaldoAFavor(t *testing.T) {
+	got, sobra := Aplicar(cargos(), 450.10)
+	if len(got) != 3 || sobra != 0.10 {
+		t.Errorf("Aplicar = %v, sobra %v", got, sobra)
+	}
+}
+
+func TestCalcularAntiguedad(t *testing.T) {
+	a := CalcularAntiguedad(cargos(), fecha("2026-04-25"))
+	want := Antiguedad{Corriente: 100, D1a30: 300, D61a90: 50}
+	if a != want {
+		t.Errorf("Antiguedad = %+v, want %+v", a, want)
+	}
+	if a.Vencido() != 350 {
+		t.Errorf("Vencido = %v", a.Vencido())
+	}
+}
+
+func TestDiasAtraso(t *testing.T) {
+	vence := fecha("2026-03-31")
+	if d := DiasAtraso(vence, time.Date(2026, 3, 31, 23, 59, 0, 0, time.UTC)); d != 0 {
+		t.Errorf("el día del vencimiento = %d", d)
+	}
+	if d := DiasAtraso(vence, fecha("2026-04-02")); d != 2 {
+		t.Errorf("dos días después = %d", d)
+	}
+	if got := Vencimiento(time.Date(2026, 1, 31, 18, 0, 0, 0, time.UTC), 30); !got.Equal(fecha("2026-03-02")) {
+		t.Errorf("Vencimiento = %v", got)
+	}
+}
+
+func TestAutorizar(t *testing.T) {
+	hoy := fecha("2026-04-05")
+	al := []Cargo{{ID: "a", Fecha: fecha("2026-03-01"), Vence: fecha("2026-03-31"), Monto: 300, Pendiente: 300}}
+	cuenta := Cuenta{Limite: 1000, Saldo: 300, DiasTolerancia: 7}
+
+	if err := Autorizar(cuenta, al, 700, hoy); err != nil {
+		t.Errorf("dentro del límite y la tolerancia: %v", err)
+	}
+	if err := Autorizar(cuenta, al, 700.01, hoy); !errors.Is(err, ErrLimite) {
+		t.Errorf("excede el límite: %v", err)
+	}
+	if err := Autorizar(cuenta, al, 10, fecha("2026-04-08")); !errors.Is(err, ErrAtraso) {
+		t.Errorf("ocho días vencido: %v", err)
+	}
+	cuenta.Bloqueada = true
+	if err := Autorizar(cuenta, al, 10, hoy); !errors.Is(err, ErrBloqueada) {
+		t.Errorf("bloqueada: %v", err)
+	}
+}
@@ -21,7 +21,7 @@ Start-Sleep 2
 Write-Host "         OK - Puertos liberados" -ForegroundColor Green
 Write-Host "  [2/4] Compilando servicios..." -ForegroundColor Cyan
 $builds = @(
-    @{ name="auth";    src="./services/auth/cmd/";         out="auth.exe"    },
+    @{ name="auth";    src="./services/auth/cmd/server/"; out="auth.exe"    },
     @{ name="sales";   src="./services/sales/cmd/server/"; out="sales.exe"   },
     @{ name="cfdi";    src="./services/cfdi/cmd/";         out="cfdi.exe"    },
     @{ name="loyalty"; src="./services/loyalty/cmd/";      out="loyalty.exe" },